@@ -0,0 +1,724 @@
+package analytics
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// maxRangeDays caps how many days a single dashboard request may load.
+const maxRangeDays = 92
+
+// rangeCacheSize is how many merged multi-day aggregates are kept around.
+const rangeCacheSize = 16
+
+// defaultDayCacheSize is how many single day aggregates are kept around
+// unless DayCacheSize says otherwise.
+const defaultDayCacheSize = 32
+
+// defaultDataCacheSize and defaultDataCacheBytes bound the days read from
+// disk that are kept in memory when DataCacheSize and DataCacheBytes aren't
+// set.
+const (
+	defaultDataCacheSize  = 8
+	defaultDataCacheBytes = 64 << 20
+)
+
+// Dashboard periods, selected with ?period=.
+const (
+	periodWeek  = "week"
+	periodMonth = "month"
+)
+
+// aggregate is the summary of one or more days of actions that the dashboard
+// renders. Aggregates of single days are merged to build ranges.
+type aggregate struct {
+	sessions  int
+	views     int
+	bytes     int64
+	truncated int
+	dropped   int
+	urlHits   map[string]map[string]*urlCounter
+	durations map[string][]time.Duration
+	clicks    map[Link]int
+	queries   map[string]map[queryKey]int
+	searches  map[string]searchCount
+	variants  map[experimentKey]variantCount
+	protocols protocolCounts
+	asns      asnCounts
+	goals     []int
+	funnel    []int
+	days      []DaySessions
+	hours     [24]int
+	visitors  []VisitorSummary
+	sketch    *sketch
+	// anonymized are the days whose visitors AnonymizeAfterDays deleted.
+	anonymized []string
+}
+
+// urlCounter accumulates a URL's stats. lastVisitor is the ordinal of the
+// last visitor counted towards Visitors, which counts unique visitors without
+// keeping a set per URL since a day's actions are walked visitor by visitor.
+// raw counts the views of each RawPage recorded under the URL.
+type urlCounter struct {
+	URLStats
+	lastVisitor int
+	raw         map[string]int
+	engaged     engagement
+}
+
+func newAggregate() *aggregate {
+	return &aggregate{
+		urlHits:   map[string]map[string]*urlCounter{},
+		durations: map[string][]time.Duration{},
+		clicks:    map[Link]int{},
+		queries:   map[string]map[queryKey]int{},
+		searches:  map[string]searchCount{},
+		variants:  map[experimentKey]variantCount{},
+		protocols: newProtocolCounts(),
+		asns:      asnCounts{},
+	}
+}
+
+// aggregateDay aggregates a day's actions. dropped is how many actions of each
+// visitor went over MaxActionsPerVisitorPerDay.
+func (a analytics) aggregateDay(date time.Time, data map[string][]Action, dropped map[string]int) *aggregate {
+	f := a.newDayFold(date, dropped)
+	for key, actions := range data {
+		f.add(key, actions)
+	}
+	return f.done(a.daySketch(date, data))
+}
+
+// dayFold aggregates a day one visitor at a time, so a day can be aggregated
+// as it's read. Only the most recent visitors are kept along the way.
+type dayFold struct {
+	a       analytics
+	ag      *aggregate
+	date    time.Time
+	dropped map[string]int
+	visitor int
+}
+
+func (a analytics) newDayFold(date time.Time, dropped map[string]int) *dayFold {
+	ag := newAggregate()
+	ag.goals = make([]int, len(a.goals))
+	ag.funnel = make([]int, len(a.funnel))
+	return &dayFold{a: a, ag: ag, date: date, dropped: dropped}
+}
+
+// hasPageView tells whether actions are a session: visitors that only sent
+// beacons, like clicks, didn't view a page.
+func hasPageView(actions []Action) bool {
+	for _, act := range actions {
+		if len(act.Event) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *dayFold) add(key string, actions []Action) {
+	a, ag := f.a, f.ag
+	f.visitor++
+	if hasPageView(actions) {
+		ag.sessions++
+	}
+	a.completedGoals(ag.goals, actions)
+	countFunnel(ag.funnel, a.funnel, actions)
+	if variants := visitorVariants(actions); len(variants) > 0 {
+		tallyVariants(ag.variants, variants, a.goalsDone(actions), 1)
+	}
+	if len(actions) > 0 {
+		ag.protocols.add(actions[0])
+		ag.asns.add(actions[0], 1)
+	}
+	vs := summarizeVisitor(key, f.date, actions)
+	vs.Dropped = f.dropped[key]
+	ag.visitors = append(ag.visitors, vs)
+	if len(ag.visitors) >= 2*recentVisitors {
+		ag.visitors = latestVisitors(ag.visitors)
+	}
+	for _, act := range actions {
+		if len(act.Event) > 0 {
+			ag.clicks[Link{Event: act.Event, URL: act.Target}]++
+			continue
+		}
+		groupBy, dataEntry := a.urlKey(act.Page)
+		_, ok := ag.urlHits[groupBy]
+		if !ok {
+			ag.urlHits[groupBy] = map[string]*urlCounter{}
+		}
+
+		stats := ag.urlHits[groupBy][dataEntry]
+		if stats == nil {
+			stats = &urlCounter{}
+			ag.urlHits[groupBy][dataEntry] = stats
+		}
+		stats.Views++
+		stats.Bytes += act.Bytes
+		stats.engaged.add(act, 1)
+		if len(act.RawPage) > 0 {
+			if stats.raw == nil {
+				stats.raw = map[string]int{}
+			}
+			stats.raw[act.RawPage]++
+		}
+		if stats.lastVisitor != f.visitor {
+			stats.lastVisitor = f.visitor
+			stats.Visitors++
+		}
+		ag.views++
+		ag.bytes += act.Bytes
+		if len(a.queryReports) > 0 {
+			if ag.queries[groupBy] == nil {
+				ag.queries[groupBy] = map[queryKey]int{}
+			}
+			a.countQueries(ag.queries[groupBy], act.Page, act.Query)
+		}
+		a.countSearches(ag.searches, act)
+		if act.Duration > 0 {
+			ag.durations[groupBy] = append(ag.durations[groupBy], act.Duration)
+		}
+		if act.Timestamp > 0 {
+			ag.hours[time.UnixMilli(act.Timestamp).In(f.date.Location()).Hour()]++
+		}
+	}
+}
+
+// done returns the aggregate of the visitors added, whose sketch is s.
+func (f *dayFold) done(s *sketch) *aggregate {
+	ag := f.ag
+	ag.days = []DaySessions{{Date: f.a.dayKey(f.date), Sessions: ag.sessions}}
+	ag.sketch = s
+	for _, n := range f.dropped {
+		ag.truncated++
+		ag.dropped += n
+	}
+	ag.visitors = latestVisitors(ag.visitors)
+	return ag
+}
+
+// streamDay aggregates a saved day as its file is read, without loading it,
+// for days that aren't in memory, cached or merged with other instances'.
+func (a analytics) streamDay(date time.Time) *aggregate {
+	f := a.newDayFold(date, a.readDropped(date))
+	keys := newSketch(a.sketchPrecision)
+	err := a.streamDayFile(a.dayFileName(date), func(key string, actions []Action) {
+		if binaryKey(key) {
+			key = hex.EncodeToString([]byte(key))
+		}
+		f.add(key, actions)
+		keys.add(keyHash(key))
+	})
+	if err != nil && !os.IsNotExist(err) {
+		a.log.Error("%v", err)
+	}
+	s := a.storedSketch(date)
+	if s == nil {
+		s = keys
+	}
+	return f.done(s)
+}
+
+// Groups of pages with fewer segments than GroupByURLSegment.
+const (
+	groupRoot  = "(root)"
+	groupOther = "(other)"
+)
+
+// urlKey splits a page into the group and entry it's counted under. With
+// GroupRules the group is that of the first matching rule and the whole page
+// the entry. Otherwise paths too short for GroupByURLSegment go to groupRoot
+// if they are empty or all slashes and groupOther otherwise, and ones too
+// short for EntriesByURLSegment are counted whole.
+func (a analytics) urlKey(page string) (string, string) {
+	if len(a.groupRules) > 0 {
+		return ruleGroup(a.groupRules, page), page
+	}
+	pParts := strings.Split(page, "/")
+	group := groupOther
+	if strings.Trim(page, "/") == "" {
+		group = groupRoot
+	}
+	if a.groupBy >= 0 && a.groupBy < len(pParts) {
+		group = pParts[a.groupBy]
+	}
+	entry := page
+	if a.entriesBy >= 0 && a.entriesBy < len(pParts) {
+		entry = strings.Join(pParts[a.entriesBy:], "/")
+	}
+	return group, entry
+}
+
+// aggregateRange aggregates every day from from to to inclusive. Visitor keys
+// are per day, so sessions of a range are the sum of each day's sessions.
+// Days before today can't change any more, so that part of a range is cached
+// and only today (and any later days) are aggregated on every call.
+func (a analytics) aggregateRange(from, to time.Time) *aggregate {
+	if a.dayKey(from) == a.dayKey(to) {
+		return a.dayAggregate(from)
+	}
+	today := a.today()
+	through := from.AddDate(0, 0, -1)
+	for d := from; !d.After(to) && a.dayKey(d) < today; d = d.AddDate(0, 0, 1) {
+		through = d
+	}
+
+	ag := newAggregate()
+	if !through.Before(from) {
+		ag.merge(a.historicalRange(from, through))
+	}
+	for d := through.AddDate(0, 0, 1); !d.After(to); d = d.AddDate(0, 0, 1) {
+		ag.merge(a.dayAggregate(d))
+	}
+	return ag
+}
+
+// cachedDay is a day aggregate in the day cache. Days before today never
+// expire, today's does after TodayCacheSeconds.
+type cachedDay struct {
+	ag      *aggregate
+	expires time.Time
+}
+
+// dayAggregate aggregates a single day through the day cache. Like the range
+// cache's, the returned aggregate is shared and must not be modified.
+func (a analytics) dayAggregate(date time.Time) *aggregate {
+	key := a.dayKey(date)
+	today := key == a.today()
+	// Today is counted as it's recorded, unless it's merged with other
+	// instances' days.
+	if today && len(a.peers) == 0 {
+		return a.todayAggregate(date)
+	}
+	if today && a.todayCache <= 0 {
+		return a.aggregateDay(date, a.loadDay(date), a.loadDropped(date))
+	}
+	if c, ok := a.dayCache.get(key); ok {
+		cd := c.(cachedDay)
+		if cd.expires.IsZero() || a.now().Before(cd.expires) {
+			return cd.ag
+		}
+	}
+	cd := cachedDay{ag: a.aggregateSavedDay(date)}
+	if today {
+		cd.expires = a.now().Add(a.todayCache)
+	}
+	a.dayCache.add(key, cd)
+	return cd.ag
+}
+
+// aggregateSavedDay aggregates a day missing from the day cache, streaming
+// it from its file unless it's already in memory or anonymized.
+func (a analytics) aggregateSavedDay(date time.Time) *aggregate {
+	key := a.dayKey(date)
+	if a.memoryOnly || len(a.peers) > 0 || a.isToday(date) || a.isOpen(key) {
+		return a.aggregateDay(date, a.loadDay(date), a.loadDropped(date))
+	}
+	if ag, ok := a.anonymizedAggregate(date); ok {
+		return ag
+	}
+	if data, ok := a.dataCache.get(key); ok {
+		return a.aggregateDay(date, data.(map[string][]Action), a.loadDropped(date))
+	}
+	return a.streamDay(date)
+}
+
+// invalidateDay drops the cached data and aggregates of date and of every
+// cached range containing it. Anything rewriting a day that isn't today must
+// call it.
+func (a analytics) invalidateDay(date time.Time) {
+	day := a.dayKey(date)
+	a.dataCache.remove(day)
+	a.dayCache.remove(day)
+	a.rangeCache.removeIf(func(key string) bool {
+		bounds := strings.SplitN(key, "/", 2)
+		return len(bounds) == 2 && bounds[0] <= day && day <= bounds[1]
+	})
+}
+
+// purgeCaches drops every cached day and aggregate, for changes affecting
+// all of them.
+func (a analytics) purgeCaches() {
+	a.dataCache.purge()
+	a.dayCache.purge()
+	a.rangeCache.purge()
+}
+
+// historicalRange aggregates days before today through the range cache. The
+// returned aggregate is shared and must only be merged into another one.
+func (a analytics) historicalRange(from, through time.Time) *aggregate {
+	key := a.dayKey(from) + "/" + a.dayKey(through)
+	if ag, ok := a.rangeCache.get(key); ok {
+		return ag.(*aggregate)
+	}
+	ag := newAggregate()
+	for d := from; !d.After(through); d = d.AddDate(0, 0, 1) {
+		ag.merge(a.dayAggregate(d))
+	}
+	a.rangeCache.add(key, ag)
+	return ag
+}
+
+// periodRange returns the first and last day of the ISO week or calendar
+// month containing date.
+func periodRange(period string, date time.Time) (time.Time, time.Time, error) {
+	switch period {
+	case periodWeek:
+		from := date.AddDate(0, 0, -((int(date.Weekday()) + 6) % 7))
+		return from, from.AddDate(0, 0, 6), nil
+	case periodMonth:
+		from := date.AddDate(0, 0, 1-date.Day())
+		return from, from.AddDate(0, 1, -1), nil
+	}
+	return date, date, fmt.Errorf("unknown period %q, expected week or month", period)
+}
+
+// loadDay returns a snapshot of the data of today and the other days in
+// memory, and any other day from disk, through the data cache. Either way the result is shared and must
+// not be modified. The days of AggregateNames are merged in, today's as far
+// as their instances have written it.
+func (a analytics) loadDay(date time.Time) map[string][]Action {
+	if a.isToday(date) || a.isOpen(a.dayKey(date)) {
+		return a.withPeers(a.dayEntries(a.dayKey(date)), date)
+	}
+	return a.savedDay(date)
+}
+
+// savedDay reads a day before today through the data cache, so views over
+// the same days don't decompress their files again. The result is shared and
+// must not be modified, readSavedData returns a copy of its own.
+func (a analytics) savedDay(date time.Time) map[string][]Action {
+	key := a.dayKey(date)
+	if data, ok := a.dataCache.get(key); ok {
+		return data.(map[string][]Action)
+	}
+	data := a.withPeers(a.readSavedData(date), date)
+	a.dataCache.addSized(key, data, dataSize(data))
+	return data
+}
+
+// dataSize estimates the memory a loaded day takes up.
+func dataSize(data map[string][]Action) int64 {
+	size := int64(0)
+	for visitor, actions := range data {
+		size += int64(len(visitor)) + int64(unsafe.Sizeof(actions))
+		for _, act := range actions {
+			size += int64(unsafe.Sizeof(act)) + int64(len(act.Page)+len(act.Query)+len(act.Event)+len(act.Target)+len(act.Referrer)+len(act.UserAgent)+len(act.TraceID)+len(act.RawPage)+len(act.Experiment)+len(act.Variant)+len(act.TLS)+len(act.Proto)+len(act.ASNOrg)+len(act.Hostname))
+		}
+	}
+	return size
+}
+
+func (ag *aggregate) merge(o *aggregate) {
+	ag.sessions += o.sessions
+	ag.views += o.views
+	ag.bytes += o.bytes
+	ag.truncated += o.truncated
+	ag.dropped += o.dropped
+	for group, urls := range o.urlHits {
+		if _, ok := ag.urlHits[group]; !ok {
+			ag.urlHits[group] = map[string]*urlCounter{}
+		}
+		for u, s := range urls {
+			stats := ag.urlHits[group][u]
+			if stats == nil {
+				stats = &urlCounter{}
+				ag.urlHits[group][u] = stats
+			}
+			stats.Views += s.Views
+			stats.Visitors += s.Visitors
+			stats.Bytes += s.Bytes
+			stats.engaged.merge(s.engaged)
+			if len(s.raw) > 0 && stats.raw == nil {
+				stats.raw = make(map[string]int, len(s.raw))
+			}
+			for p, n := range s.raw {
+				stats.raw[p] += n
+			}
+		}
+	}
+	for group, ds := range o.durations {
+		ag.durations[group] = append(ag.durations[group], ds...)
+	}
+	for l, n := range o.clicks {
+		ag.clicks[l] += n
+	}
+	for i, n := range o.goals {
+		if i >= len(ag.goals) {
+			ag.goals = append(ag.goals, 0)
+		}
+		ag.goals[i] += n
+	}
+	for i, n := range o.funnel {
+		if i >= len(ag.funnel) {
+			ag.funnel = append(ag.funnel, 0)
+		}
+		ag.funnel[i] += n
+	}
+	for group, qs := range o.queries {
+		if _, ok := ag.queries[group]; !ok {
+			ag.queries[group] = map[queryKey]int{}
+		}
+		for k, n := range qs {
+			ag.queries[group][k] += n
+		}
+	}
+	for term, c := range o.searches {
+		n := ag.searches[term]
+		n.site += c.site
+		n.referred += c.referred
+		ag.searches[term] = n
+	}
+	for k, c := range o.variants {
+		n := ag.variants[k]
+		n.sessions += c.sessions
+		if len(n.goals) < len(c.goals) {
+			n.goals = append(n.goals, make([]int, len(c.goals)-len(n.goals))...)
+		}
+		for i, g := range c.goals {
+			n.goals[i] += g
+		}
+		ag.variants[k] = n
+	}
+	ag.protocols.merge(o.protocols)
+	for asn, n := range o.asns {
+		ag.asns[asn] += n
+	}
+	for h, n := range o.hours {
+		ag.hours[h] += n
+	}
+	ag.days = append(ag.days, o.days...)
+	ag.anonymized = append(ag.anonymized, o.anonymized...)
+	ag.visitors = latestVisitors(append(ag.visitors, o.visitors...))
+	if o.sketch != nil && ag.sketch == nil {
+		ag.sketch = o.sketch.clone()
+	} else if o.sketch != nil {
+		ag.sketch = ag.sketch.union(o.sketch)
+	}
+}
+
+// URL table orderings, selected with ?sort= and ?order=.
+const (
+	SortViews    = "views"
+	SortVisitors = "visitors"
+	SortURL      = "url"
+	OrderAsc     = "asc"
+	OrderDesc    = "desc"
+)
+
+// urlView selects the URLs of each group a report lists: those containing
+// filter (case insensitively), perPage at a time, all of them if perPage is 0,
+// ordered by sort and order.
+type urlView struct {
+	filter  string
+	page    int
+	perPage int
+	sort    string
+	order   string
+}
+
+// newURLView falls back to views descending for an unknown sort and to the
+// sort's natural order (ascending for URLs, descending for counts) for an
+// unknown order.
+func newURLView(filter string, page, perPage int, sortBy, order string) urlView {
+	if sortBy != SortVisitors && sortBy != SortURL {
+		sortBy = SortViews
+	}
+	if order != OrderAsc && order != OrderDesc {
+		order = OrderDesc
+		if sortBy == SortURL {
+			order = OrderAsc
+		}
+	}
+	return urlView{filter: filter, page: page, perPage: perPage, sort: sortBy, order: order}
+}
+
+// less orders URL hits by the view's sort, breaking ties by URL.
+func (v urlView) less(a, b URLHit) bool {
+	var x, y int
+	switch v.sort {
+	case SortURL:
+		if v.order == OrderDesc {
+			return a.URL > b.URL
+		}
+		return a.URL < b.URL
+	case SortVisitors:
+		x, y = a.Visitors, b.Visitors
+	default:
+		x, y = a.Views, b.Views
+	}
+	if x != y {
+		if v.order == OrderAsc {
+			return x < y
+		}
+		return x > y
+	}
+	return a.URL < b.URL
+}
+
+// report turns the aggregate into what the dashboard template renders. It
+// doesn't modify the aggregate, which may be shared through a cache.
+// Groups are ordered by their total views and each group's URLs by the view.
+// Group totals only count the URLs matching the view's filter.
+func (ag *aggregate) report(view urlView) DashboardData {
+	filter := strings.ToLower(view.filter)
+	if view.page < 1 {
+		view.page = 1
+	}
+	groups := make([]URLGroup, 0, len(ag.urlHits))
+	for group, urls := range ag.urlHits {
+		g := URLGroup{Group: group, URLs: make([]URLHit, 0, len(urls))}
+		for u, stats := range urls {
+			if len(filter) > 0 && !strings.Contains(strings.ToLower(u), filter) {
+				continue
+			}
+			g.URLs = append(g.URLs, URLHit{URL: u, URLStats: stats.URLStats, RawPaths: rankRawPaths(stats.raw), Engagement: stats.engaged.report()})
+			g.Views += stats.Views
+			g.Bytes += stats.Bytes
+		}
+		if len(g.URLs) == 0 {
+			continue
+		}
+		sort.Slice(g.URLs, func(i, j int) bool { return view.less(g.URLs[i], g.URLs[j]) })
+		if ag.views > 0 {
+			g.Percent = float64(g.Views) * 100 / float64(ag.views)
+			cumulative := 0
+			for i := range g.URLs {
+				cumulative += g.URLs[i].Views
+				g.URLs[i].Percent = float64(g.URLs[i].Views) * 100 / float64(ag.views)
+				g.URLs[i].Cumulative = float64(cumulative) * 100 / float64(ag.views)
+			}
+		}
+		g.Total = len(g.URLs)
+		if view.perPage > 0 {
+			start := (view.page - 1) * view.perPage
+			if start > len(g.URLs) {
+				start = len(g.URLs)
+			}
+			end := start + view.perPage
+			if end > len(g.URLs) {
+				end = len(g.URLs)
+			}
+			g.Prev = start > 0
+			g.Next = end < len(g.URLs)
+			g.URLs = g.URLs[start:end]
+		}
+		g.Hidden = g.Total - len(g.URLs)
+		if qs := ag.queries[group]; len(qs) > 0 {
+			g.Queries = rankQueries(qs)
+		}
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Views != groups[j].Views {
+			return groups[i].Views > groups[j].Views
+		}
+		return groups[i].Group < groups[j].Group
+	})
+
+	latency := make(map[string]Latencies, len(ag.durations))
+	for group, ds := range ag.durations {
+		ds = append([]time.Duration(nil), ds...)
+		sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+		latency[group] = Latencies{P50: percentile(ds, 50), P95: percentile(ds, 95), P99: percentile(ds, 99)}
+	}
+
+	outbound := make([]LinkClicks, 0, len(ag.clicks))
+	for l, n := range ag.clicks {
+		outbound = append(outbound, LinkClicks{Link: l, Clicks: n})
+	}
+	sort.Slice(outbound, func(i, j int) bool {
+		if outbound[i].Clicks != outbound[j].Clicks {
+			return outbound[i].Clicks > outbound[j].Clicks
+		}
+		return outbound[i].URL < outbound[j].URL
+	})
+
+	busiest := 0
+	for _, n := range ag.hours {
+		if n > busiest {
+			busiest = n
+		}
+	}
+	hours := make([]HourViews, len(ag.hours))
+	for h, n := range ag.hours {
+		hours[h] = HourViews{Hour: h, Views: n}
+		if busiest > 0 {
+			hours[h].Percent = n * 100 / busiest
+		}
+	}
+
+	dd := DashboardData{
+		Filter:       view.filter,
+		Page:         view.page,
+		PerPage:      view.perPage,
+		Sort:         view.sort,
+		Order:        view.order,
+		SessionCount: ag.sessions,
+		PageViews:    ag.views,
+		Bytes:        ag.bytes,
+		Truncated:    ag.truncated,
+		Dropped:      ag.dropped,
+		URLHits:      groups,
+		Latency:      latency,
+		Outbound:     outbound,
+		Days:         ag.days,
+		Visitors:     ag.visitors,
+		Hours:        hours,
+	}
+	dd.AnonymizedDays = ag.anonymized
+	if len(ag.searches) > 0 {
+		dd.SearchTerms = rankSearches(ag.searches, topSearchTerms)
+	}
+	// Single days count their visitors exactly.
+	if len(ag.days) > 1 && ag.sketch != nil {
+		dd.UniqueVisitors = int(math.Round(ag.sketch.estimate()))
+		dd.UniqueVisitorsError = ag.sketch.stdError() * 100
+	}
+	return dd
+}
+
+// percentile returns the nearest-rank percentile p of the sorted durations.
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// parseRange parses the from and to query values of a range request as days
+// in loc.
+func parseRange(from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	f, err := time.ParseInLocation(dayLayout, from, loc)
+	if err != nil {
+		return f, f, fmt.Errorf("invalid from date %q, expected YYYY-MM-DD", from)
+	}
+	t, err := time.ParseInLocation(dayLayout, to, loc)
+	if err != nil {
+		return f, t, fmt.Errorf("invalid to date %q, expected YYYY-MM-DD", to)
+	}
+	return f, t, checkRange(f, t)
+}
+
+// checkRange rejects ranges ending before they start or longer than
+// maxRangeDays.
+func checkRange(from, to time.Time) error {
+	if from.After(to) {
+		return fmt.Errorf("from date %s is after to date %s", from.Format(dayLayout), to.Format(dayLayout))
+	}
+	if days := int(to.Sub(from).Hours()/24) + 1; days > maxRangeDays {
+		return fmt.Errorf("date range of %d days exceeds the maximum of %d", days, maxRangeDays)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package analytics
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestURLKey(t *testing.T) {
+	const deep = "/a/b/c/d/e/f"
+	// The group and entry of each page by GroupByURLSegment and
+	// EntriesByURLSegment of 0, 1 and 5.
+	cases := []struct {
+		page    string
+		groups  [3]string
+		entries [3]string
+	}{
+		{"/", [3]string{"", "", groupRoot}, [3]string{"/", "", "/"}},
+		{"/a", [3]string{"", "a", groupOther}, [3]string{"/a", "a", "/a"}},
+		{deep, [3]string{"", "a", "e"}, [3]string{deep, "a/b/c/d/e/f", "e/f"}},
+	}
+	segments := [3]int{0, 1, 5}
+	for _, tc := range cases {
+		for gi, groupBy := range segments {
+			for ei, entriesBy := range segments {
+				t.Run(fmt.Sprintf("%s/%d/%d", tc.page, groupBy, entriesBy), func(t *testing.T) {
+					a := analytics{groupBy: groupBy, entriesBy: entriesBy}
+					group, entry := a.urlKey(tc.page)
+					if group != tc.groups[gi] || entry != tc.entries[ei] {
+						t.Errorf("urlKey(%q) = %q, %q, want %q, %q", tc.page, group, entry, tc.groups[gi], tc.entries[ei])
+					}
+				})
+			}
+		}
+	}
+}
+
+// TestShortPathsDontPanic records paths shorter than the segments grouped by
+// and renders them.
+func TestShortPathsDontPanic(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{GroupByURLSegment: 5, EntriesByURLSegment: 5})
+	for _, page := range []string{"/", "/a", "/a/b/c/d/e/f"} {
+		a.InsertRequest(visit("192.0.2.1:4000", page))
+	}
+	dd, err := a.Stats(a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string]int{}
+	for _, g := range dd.URLHits {
+		groups[g.Group] = g.Views
+	}
+	if groups[groupRoot] != 1 || groups[groupOther] != 1 || groups["e"] != 1 {
+		t.Errorf("got groups %v, want one view each in %s, %s and e", groups, groupRoot, groupOther)
+	}
+}
@@ -0,0 +1,247 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxAlertMinutes is the longest window an AlertRule can look back on,
+	// it's how much traffic is kept for the rules.
+	maxAlertMinutes = 60
+	// alertInterval is how often the rules are checked.
+	alertInterval = time.Minute
+	// defaultAlertCooldownMinutes is how long a rule stays quiet after it
+	// fired when CooldownMinutes isn't set.
+	defaultAlertCooldownMinutes = 60
+	// webhookAttempts is how often an alert is posted before giving up.
+	webhookAttempts = 3
+	webhookTimeout  = 10 * time.Second
+)
+
+// AlertRule is checked every minute against the recent traffic of every
+// site, posting an Alert to AlertWebhook when it fires. With MaxSessions it
+// fires once more than that many visitors were seen in the last
+// WindowMinutes, with Quiet once no request was recorded for that long.
+type AlertRule struct {
+	Name          string
+	WindowMinutes int
+	MaxSessions   int
+	Quiet         bool
+	// ActiveFromHour and ActiveToHour limit the rule to the hours from
+	// ActiveFromHour up to but excluding ActiveToHour in the configured
+	// Timezone, all day if both are 0. A range like 22 to 6 wraps midnight.
+	ActiveFromHour int
+	ActiveToHour   int
+	// CooldownMinutes is how long the rule doesn't fire again for a site
+	// after it did, 60 by default.
+	CooldownMinutes int
+}
+
+// Alert is the JSON body posted to AlertWebhook.
+type Alert struct {
+	Rule          string    `json:"rule"`
+	Site          string    `json:"site"`
+	Kind          string    `json:"kind"`
+	WindowMinutes int       `json:"window_minutes"`
+	Sessions      int       `json:"sessions"`
+	Requests      int       `json:"requests"`
+	MaxSessions   int       `json:"max_sessions,omitempty"`
+	Time          time.Time `json:"time"`
+}
+
+// Alert kinds.
+const (
+	AlertSpike = "spike"
+	AlertQuiet = "quiet"
+)
+
+func validAlerts(webhook string, rules []AlertRule) ([]AlertRule, error) {
+	if len(rules) > 0 && len(webhook) == 0 {
+		return nil, fmt.Errorf("Alerts need an AlertWebhook to post to")
+	}
+	valid := make([]AlertRule, len(rules))
+	for i, rule := range rules {
+		if len(rule.Name) == 0 {
+			return nil, fmt.Errorf("alert rule %d has no Name", i)
+		}
+		if rule.WindowMinutes <= 0 || rule.WindowMinutes > maxAlertMinutes {
+			return nil, fmt.Errorf("alert rule %q: WindowMinutes must be between 1 and %d, got %d", rule.Name, maxAlertMinutes, rule.WindowMinutes)
+		}
+		if (rule.MaxSessions > 0) == rule.Quiet {
+			return nil, fmt.Errorf("alert rule %q needs either MaxSessions or Quiet", rule.Name)
+		}
+		if rule.ActiveFromHour < 0 || rule.ActiveFromHour > 23 || rule.ActiveToHour < 0 || rule.ActiveToHour > 24 {
+			return nil, fmt.Errorf("alert rule %q: active hours must be between 0 and 24", rule.Name)
+		}
+		if rule.CooldownMinutes <= 0 {
+			rule.CooldownMinutes = defaultAlertCooldownMinutes
+		}
+		valid[i] = rule
+	}
+	return valid, nil
+}
+
+// active reports whether the rule applies at hour.
+func (rule AlertRule) active(hour int) bool {
+	from, to := rule.ActiveFromHour, rule.ActiveToHour
+	if from == 0 && to == 0 {
+		return true
+	}
+	if from <= to {
+		return from <= hour && hour < to
+	}
+	return hour >= from || hour < to
+}
+
+// trafficWindow counts the requests and visitors of each of the last
+// maxAlertMinutes minutes for the alert rules.
+type trafficWindow struct {
+	mu      sync.Mutex
+	minutes [maxAlertMinutes]trafficMinute
+}
+
+type trafficMinute struct {
+	minute   int64
+	requests int
+	visitors map[string]struct{}
+}
+
+func (tw *trafficWindow) add(visitor string, t time.Time) {
+	minute := t.Unix() / 60
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	m := &tw.minutes[minute%maxAlertMinutes]
+	if m.minute != minute || m.visitors == nil {
+		*m = trafficMinute{minute: minute, visitors: map[string]struct{}{}}
+	}
+	m.requests++
+	m.visitors[visitor] = struct{}{}
+}
+
+// count returns the requests and distinct visitors of the last minutes
+// minutes up to now, the current one included.
+func (tw *trafficWindow) count(now time.Time, minutes int) (requests, sessions int) {
+	last := now.Unix() / 60
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	seen := map[string]struct{}{}
+	for _, m := range tw.minutes {
+		if m.minute <= last-int64(minutes) || m.minute > last {
+			continue
+		}
+		requests += m.requests
+		for v := range m.visitors {
+			seen[v] = struct{}{}
+		}
+	}
+	return requests, len(seen)
+}
+
+// alerter remembers when each rule last fired for each site.
+type alerter struct {
+	webhook string
+	rules   []AlertRule
+	started time.Time
+	client  *http.Client
+
+	mu    sync.Mutex
+	fired map[string]time.Time
+}
+
+// scheduleAlerts checks the alert rules of every site each alertInterval.
+func (a analytics) scheduleAlerts() {
+	ticker := time.NewTicker(alertInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				for _, s := range a.sites {
+					s.checkAlerts(s.now())
+				}
+			case <-a.quit:
+				return
+			}
+		}
+	}()
+}
+
+// checkAlerts fires the rules that apply to the site's recent traffic and
+// aren't cooling down. Webhooks are posted in the background so a slow
+// endpoint doesn't hold up the other checks.
+func (a analytics) checkAlerts(now time.Time) {
+	for _, rule := range a.alerts.rules {
+		if !rule.active(now.Hour()) {
+			continue
+		}
+		window := time.Duration(rule.WindowMinutes) * time.Minute
+		requests, sessions := a.traffic.count(now, rule.WindowMinutes)
+		alert := Alert{Rule: rule.Name, Site: a.Name, WindowMinutes: rule.WindowMinutes, Sessions: sessions, Requests: requests, Time: now}
+		switch {
+		case rule.MaxSessions > 0 && sessions > rule.MaxSessions:
+			alert.Kind = AlertSpike
+			alert.MaxSessions = rule.MaxSessions
+		case rule.Quiet && requests == 0 && now.Sub(a.alerts.started) >= window:
+			alert.Kind = AlertQuiet
+		default:
+			continue
+		}
+		if !a.alerts.cooledDown(a.Name+"/"+rule.Name, now, time.Duration(rule.CooldownMinutes)*time.Minute) {
+			continue
+		}
+		go a.postAlert(alert)
+	}
+}
+
+// cooledDown reports whether the rule may fire again, remembering that it
+// does if so.
+func (al *alerter) cooledDown(key string, now time.Time, cooldown time.Duration) bool {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if last, ok := al.fired[key]; ok && now.Sub(last) < cooldown {
+		return false
+	}
+	al.fired[key] = now
+	return true
+}
+
+// postAlert posts an alert to the webhook, retrying with a growing delay up
+// to webhookAttempts times.
+func (a analytics) postAlert(alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		a.log.Error("encoding alert: %v", err)
+		return
+	}
+	delay := time.Second
+	for attempt := 1; ; attempt++ {
+		err = a.alerts.post(body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			a.log.Error("posting alert %q for %s: %v", alert.Rule, alert.Site, err)
+			return
+		}
+		a.log.Warn("posting alert %q for %s, retrying: %v", alert.Rule, alert.Site, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (al *alerter) post(body []byte) error {
+	resp, err := al.client.Post(al.webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
@@ -1,260 +1,1451 @@
 package analytics
 
 import (
+	"bufio"
 	"bytes"
 	"compress/zlib"
-	"crypto/sha256"
+	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
-	"io/ioutil"
 	"net/http"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
+// Analyzer records requests and serves the numbers, it's a Recorder, a
+// Presenter and an Admin.
 type Analyzer interface {
-	Dashboard(w http.ResponseWriter, r *http.Request)
+	Recorder
+	Presenter
+	Admin
+}
+
+// Recorder is the ingestion side of an Analyzer, for handlers that only
+// record requests and events.
+type Recorder interface {
 	InsertRequest(r *http.Request)
+	InsertRequestContext(ctx context.Context, r *http.Request)
+	Middleware(next http.Handler) http.Handler
+	RouteMiddleware(route func(r *http.Request) string) func(http.Handler) http.Handler
+	RecordRoute(r *http.Request, route string, bytes int64, duration time.Duration)
+	Beacon(w http.ResponseWriter, r *http.Request)
+	ShouldTrack(r *http.Request) bool
+}
+
+// Admin manages the stored data of an Analyzer: writing and archiving it,
+// anonymizing and erasing visitors, changing the configuration and shutting
+// down.
+type Admin interface {
+	Flush() error
+	Close() error
+	Archive(month time.Time) error
+	AnonymizeNow() (int, error)
+	DeleteVisitor(ipOrHash string, from, to time.Time) (int, error)
+	Erase(w http.ResponseWriter, r *http.Request)
+	UpdateConfig(u ConfigUpdate) error
+}
+
+// Presenter is the reporting side of an Analyzer: the dashboard, the JSON and
+// CSV endpoints and their Go equivalents.
+type Presenter interface {
+	Dashboard(w http.ResponseWriter, r *http.Request)
+	StatsJSON(w http.ResponseWriter, r *http.Request)
+	Export(w http.ResponseWriter, r *http.Request)
+	ExportRange(w io.Writer, from, to time.Time) error
+	ExportVisitor(ipOrHash string, from, to time.Time) (VisitorExport, error)
+	SubjectAccess(w http.ResponseWriter, r *http.Request)
+	Live(w http.ResponseWriter, r *http.Request)
+	Metrics(w http.ResponseWriter, r *http.Request)
+	Health(w http.ResponseWriter, r *http.Request)
+	ReportNow() error
+	Stats(date time.Time) (DashboardData, error)
+	StatsRange(from, to time.Time) (DashboardData, error)
 }
 
 type AnalyticsConfiguration struct {
-	HashIPSecret         string
-	GroupByURLSegment    int
-	EntriesByURLSegment  int
-	WriteScheduleSeconds int
-	Name                 string
-	Password             string
-	Directory            string
-	UserAgentBlackList   []string
+	HashIPSecret                  string
+	GroupByURLSegment             int
+	EntriesByURLSegment           int
+	WriteScheduleSeconds          int
+	Name                          string
+	Password                      string
+	Directory                     string
+	UserAgentBlackList            []string
+	TopURLs                       int
+	TemplatePath                  string
+	TrackBots                     bool
+	MaxBotActionsPerDay           int
+	DayCacheSize                  int
+	TodayCacheSeconds             int
+	Sites                         []string
+	SiteResolver                  SiteResolver
+	Timezone                      string
+	QueryReports                  []QueryReport
+	RedactQueryParams             []string
+	Goals                         []GoalConfig
+	Funnel                        []string
+	WidgetToken                   string
+	MaxActionsPerVisitorPerDay    int
+	PasswordVerifier              func(password string) bool
+	PasswordHash                  string
+	DisableQueryKey               bool
+	SessionKey                    string
+	SessionSeconds                int
+	ManualFlush                   bool
+	DataCacheSize                 int
+	DataCacheBytes                int64
+	DisableBotFiltering           bool
+	MaxDayFileBytes               int64
+	OnPersistFailure              func(err error, failures int)
+	PersistFailureThreshold       int
+	AlertWebhook                  string
+	Alerts                        []AlertRule
+	Report                        *ReportConfig
+	DisablePersistence            bool
+	DisableDashboard              bool
+	HashFunc                      HashFunc
+	HashScheme                    string
+	TraceID                       func(ctx context.Context) string
+	Observer                      RequestObserver
+	AggregateNames                []string
+	URLNormalization              URLNormalization
+	PathTemplates                 []string
+	KeepRawPaths                  bool
+	GroupRules                    []GroupRule
+	IgnorePaths                   []string
+	IgnoreRules                   []IgnoreRule
+	MaxActionsPerVisitorPerMinute int
+	TemplateDir                   string
+	SketchPrecision               int
+	Shards                        int
+	MaxConcurrentAggregations     int
+	DuplicateWindowSeconds        int
+	RecordPrefetch                bool
+	IPAnonymization               string
+	ConsentFunc                   func(r *http.Request) bool
+	RotatingSecret                bool
+	RetentionDays                 int
+	AnonymizeAfterDays            int
+	ReferrerSpamList              []string
+	DropReferrerSpam              bool
+	GroupLabels                   map[string]string
+	ReturningLookbackDays         int
+	SiteSearchPaths               []string
+	VariantFunc                   VariantFunc
+	ClickMapPages                 []string
+	MaxClicksPerPagePerDay        int
+	TLSVersionHeader              string
+	IgnoreStaticAssets            bool
+	StaticExtensions              []string
+	AddStaticExtensions           []string
+	RemoveStaticExtensions        []string
+	ASNDatabase                   string
+	ASNLookup                     ASNLookup
+	ReverseDNSPerSecond           int
+	IgnoreASNs                    []uint32
 }
 
+// defaultMaxDayBytes is how much JSON a day file may decompress to when
+// MaxDayFileBytes isn't set.
+const defaultMaxDayBytes = 1 << 30
+
+// defaultTopURLs is how many URLs each dashboard table shows when
+// AnalyticsConfiguration.TopURLs isn't set.
+const defaultTopURLs = 100
+
 type analytics struct {
-	HashIPSecret         string
-	groupBy              int
-	entriesBy            int
-	WriteScheduleSeconds int
-	Password             string
-	Name                 string
-	Directory            string
-	Mux                  *sync.RWMutex
-	logger               func(...interface{}) (int, error)
-	UserAgentBlackList   []string
-	IPEntries            map[string]map[string][]action
+	HashIPSecret     string
+	groupBy          int
+	entriesBy        int
+	flushInterval    time.Duration
+	Password         string
+	Name             string
+	Directory        string
+	Mux              *sync.RWMutex
+	writeMux         *sync.Mutex
+	log              Logger
+	topURLs          int
+	rangeCache       *lru
+	dayCache         *lru
+	dataCache        *lru
+	todayCache       time.Duration
+	template         *template.Template
+	builtin          *template.Template
+	live             *liveWindow
+	shards           shards
+	openDays         map[string]bool
+	trackBots        bool
+	maxBotActions    int
+	BotEntries       map[string]map[string][]Action
+	botActions       map[string]int
+	defaultSite      string
+	siteResolver     SiteResolver
+	sites            map[string]*analytics
+	location         *time.Location
+	queryReports     []QueryReport
+	goals            []GoalConfig
+	funnel           []string
+	widgetToken      string
+	clock            Clock
+	tuning           *tuning
+	verifyPassword   func(password string) bool
+	passwordHash     string
+	disableQueryKey  bool
+	queryKeyWarned   *int32
+	sessionKey       []byte
+	sessionLifetime  time.Duration
+	logins           *loginLimiter
+	maxDayBytes      int64
+	health           *persistHealth
+	metrics          *siteMetrics
+	alerts           *alerter
+	traffic          *trafficWindow
+	report           *reporter
+	memoryOnly       bool
+	manualFlush      bool
+	peers            []string
+	normalize        URLNormalization
+	pathTemplates    []pathTemplate
+	keepRawPaths     bool
+	groupRules       []groupRule
+	rateLimit        *rateLimiter
+	disableDashboard bool
+	hash             HashFunc
+	hashScheme       string
+	truncateIPs      bool
+	consent          func(r *http.Request) bool
+	salts            *daySalts
+	schemes          map[string]string
+	sketchPrecision  int
+	traceID          func(ctx context.Context) string
+	observer         RequestObserver
+	closed           *int32
+	quit             chan struct{}
+	onPersistFailure func(err error, failures int)
+	failureThreshold int
+	aggregations     chan struct{}
+	duplicateWindow  time.Duration
+	recordPrefetch   bool
+	anonymizeAfter   int
+	groupLabels      map[string]string
+	returningDays    int
+	siteSearch       map[string]bool
+	variant          VariantFunc
+	clickPages       []string
+	maxClicks        int
+	clickMaps        map[string]map[string][]clickPoint
+	tlsHeader        string
+	staticExts       map[string]bool
+	enricher         *enricher
 }
 
+// NewAnalytics is NewAnalyticsWithError for callers that can't handle an
+// error; it panics if the configuration can't be used.
 func NewAnalytics(config AnalyticsConfiguration, logger func(...interface{}) (int, error)) Analyzer {
-	if logger == nil {
-		logger = fmt.Println
+	ana, err := NewAnalyticsWithError(config, logger)
+	if err != nil {
+		panic(err)
 	}
-	ana := &analytics{
-		Name:                 config.Name,
-		Password:             config.Password,
-		groupBy:              config.GroupByURLSegment,
-		entriesBy:            config.EntriesByURLSegment,
-		HashIPSecret:         config.HashIPSecret,
-		WriteScheduleSeconds: config.WriteScheduleSeconds,
-		Directory:            config.Directory,
-		UserAgentBlackList:   config.UserAgentBlackList,
-		Mux:                  &sync.RWMutex{},
-		logger:               logger,
-	}
-	ana.IPEntries = map[string]map[string][]action{}
-	ana.IPEntries[time.Now().Local().Format("2006-01-02")] = ana.readSavedData(time.Now().Local())
-	ana.scheduleWrite()
 	return ana
 }
 
+// NewAnalyticsWithError checks the configuration, creates an Analyzer, loads
+// today's saved data and, unless ManualFlush is set, starts writing it to disk
+// on WriteScheduleSeconds. It returns an error describing the first
+// configuration problem found. It's New with WithConfiguration and
+// WithLogger.
+func NewAnalyticsWithError(config AnalyticsConfiguration, logger func(...interface{}) (int, error)) (Analyzer, error) {
+	return New(config.Name, WithConfiguration(config), WithLogger(logger))
+}
+
+func newAnalytics(s settings) (Analyzer, error) {
+	log := s.log
+	if log == nil {
+		log = FuncLogger(nil)
+	}
+	config, err := validate(s.config)
+	if err != nil {
+		return nil, err
+	}
+	if s.flushInterval < 0 {
+		return nil, fmt.Errorf("flush interval must be positive, got %s", s.flushInterval)
+	}
+	flushInterval := s.flushInterval
+	if flushInterval == 0 {
+		flushInterval = time.Duration(config.WriteScheduleSeconds) * time.Second
+	}
+	location := time.Local
+	if len(config.Timezone) > 0 {
+		loc, err := time.LoadLocation(config.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("loading timezone: %w", err)
+		}
+		location = loc
+	}
+	goals, err := validGoals(config.Goals)
+	if err != nil {
+		return nil, err
+	}
+	pathTemplates, err := validPathTemplates(config.PathTemplates)
+	if err != nil {
+		return nil, err
+	}
+	groupRules, err := validGroupRules(config.GroupRules)
+	if err != nil {
+		return nil, err
+	}
+	staticExts, err := validStaticExtensions(config)
+	if err != nil {
+		return nil, err
+	}
+	enricher, err := newEnricher(config)
+	if err != nil {
+		return nil, fmt.Errorf("opening ASNDatabase: %w", err)
+	}
+	tuning, err := newTuning(config)
+	if err != nil {
+		return nil, err
+	}
+	alerts, err := validAlerts(config.AlertWebhook, config.Alerts)
+	if err != nil {
+		return nil, err
+	}
+	report, err := validReport(config.Report, config.ManualFlush)
+	if err != nil {
+		return nil, err
+	}
+	hash, hashScheme, err := visitorHash(config)
+	if err != nil {
+		return nil, err
+	}
+	sessionKey, err := sessionSecret(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating session key: %w", err)
+	}
+	dayCacheSize := config.DayCacheSize
+	if dayCacheSize <= 0 {
+		dayCacheSize = defaultDayCacheSize
+	}
+	dataCache := newLRU(config.DataCacheSize)
+	if dataCache.max <= 0 {
+		dataCache.max = defaultDataCacheSize
+	}
+	dataCache.maxBytes = config.DataCacheBytes
+	if dataCache.maxBytes <= 0 {
+		dataCache.maxBytes = defaultDataCacheBytes
+	}
+	if s.clock == nil {
+		s.clock = realClock{}
+	}
+	ana := &analytics{
+		Name:             config.Name,
+		Password:         config.Password,
+		groupBy:          config.GroupByURLSegment,
+		entriesBy:        config.EntriesByURLSegment,
+		HashIPSecret:     config.HashIPSecret,
+		flushInterval:    flushInterval,
+		Directory:        config.Directory,
+		topURLs:          config.TopURLs,
+		rangeCache:       newLRU(rangeCacheSize),
+		dayCache:         newLRU(dayCacheSize),
+		dataCache:        dataCache,
+		todayCache:       time.Duration(config.TodayCacheSeconds) * time.Second,
+		live:             &liveWindow{},
+		trackBots:        config.TrackBots,
+		maxBotActions:    config.MaxBotActionsPerDay,
+		BotEntries:       map[string]map[string][]Action{},
+		botActions:       map[string]int{},
+		Mux:              &sync.RWMutex{},
+		writeMux:         &sync.Mutex{},
+		log:              log,
+		defaultSite:      config.Name,
+		siteResolver:     config.SiteResolver,
+		sites:            map[string]*analytics{},
+		location:         location,
+		queryReports:     config.QueryReports,
+		goals:            goals,
+		funnel:           config.Funnel,
+		widgetToken:      config.WidgetToken,
+		clock:            s.clock,
+		tuning:           tuning,
+		verifyPassword:   config.PasswordVerifier,
+		passwordHash:     config.PasswordHash,
+		disableQueryKey:  config.DisableQueryKey,
+		queryKeyWarned:   new(int32),
+		sessionKey:       sessionKey,
+		sessionLifetime:  time.Duration(config.SessionSeconds) * time.Second,
+		logins:           newLoginLimiter(),
+		maxDayBytes:      config.MaxDayFileBytes,
+		health:           &persistHealth{started: s.clock.Now()},
+		metrics:          &siteMetrics{},
+		onPersistFailure: config.OnPersistFailure,
+		failureThreshold: config.PersistFailureThreshold,
+		report:           report,
+		memoryOnly:       config.DisablePersistence,
+		manualFlush:      config.ManualFlush,
+		peers:            peers(config),
+		normalize:        config.URLNormalization,
+		pathTemplates:    pathTemplates,
+		keepRawPaths:     config.KeepRawPaths,
+		groupRules:       groupRules,
+		rateLimit:        newRateLimiter(config.MaxActionsPerVisitorPerMinute),
+		disableDashboard: config.DisableDashboard,
+		hash:             hash,
+		hashScheme:       hashScheme,
+		truncateIPs:      config.IPAnonymization == AnonymizeTruncate,
+		consent:          config.ConsentFunc,
+		schemes:          map[string]string{},
+		sketchPrecision:  config.SketchPrecision,
+		traceID:          config.TraceID,
+		observer:         config.Observer,
+		closed:           new(int32),
+		quit:             make(chan struct{}),
+		aggregations:     newAggregationSlots(config.MaxConcurrentAggregations),
+		duplicateWindow:  duplicateWindow(config.DuplicateWindowSeconds),
+		recordPrefetch:   config.RecordPrefetch,
+		anonymizeAfter:   config.AnonymizeAfterDays,
+		groupLabels:      copyLabels(config.GroupLabels),
+		returningDays:    config.ReturningLookbackDays,
+		siteSearch:       searchPaths(config.SiteSearchPaths),
+		variant:          config.VariantFunc,
+		clickPages:       config.ClickMapPages,
+		maxClicks:        config.MaxClicksPerPagePerDay,
+		clickMaps:        map[string]map[string][]clickPoint{},
+		tlsHeader:        config.TLSVersionHeader,
+		staticExts:       staticExts,
+		enricher:         enricher,
+	}
+	if len(alerts) > 0 {
+		ana.alerts = &alerter{webhook: config.AlertWebhook, rules: alerts, started: ana.now(), client: &http.Client{Timeout: webhookTimeout}, fired: map[string]time.Time{}}
+		ana.traffic = &trafficWindow{}
+	}
+	if ana.failureThreshold <= 0 {
+		ana.failureThreshold = defaultPersistFailureThreshold
+	}
+	if ana.maxDayBytes <= 0 {
+		ana.maxDayBytes = defaultMaxDayBytes
+	}
+	if ana.topURLs <= 0 {
+		ana.topURLs = defaultTopURLs
+	}
+	if ana.maxClicks == 0 {
+		ana.maxClicks = defaultMaxClicksPerPage
+	}
+	builtin, funcs, err := assets{dir: config.TemplateDir}.parse()
+	if err != nil {
+		return nil, fmt.Errorf("parsing built-in dashboard templates: %w", err)
+	}
+	ana.builtin = builtin
+	if len(config.TemplatePath) > 0 {
+		t, err := template.New(filepath.Base(config.TemplatePath)).Funcs(funcs).ParseFiles(config.TemplatePath)
+		if err != nil {
+			return nil, fmt.Errorf("parsing dashboard template: %w", err)
+		}
+		ana.template = t
+	}
+	if config.RotatingSecret {
+		ana.salts = newDaySalts(config.Directory, config.DisablePersistence, config.RetentionDays)
+		ana.pruneSalts()
+	}
+	ana.shards = newShards(config.Shards)
+	ana.openDays = map[string]bool{}
+	ana.sites[ana.Name] = ana
+	for _, name := range config.Sites {
+		ana.addSite(name)
+	}
+	for _, s := range ana.sites {
+		s.openDay(s.today(), s.now())
+		if s.trackBots {
+			s.loadBots(s.now())
+		}
+	}
+	if !config.ManualFlush && !ana.memoryOnly {
+		ana.scheduleWrite()
+	}
+	if ana.alerts != nil {
+		ana.scheduleAlerts()
+	}
+	if ana.enricher != nil {
+		go ana.enricher.run(ana.log, ana.quit)
+	}
+	if ana.report != nil {
+		ana.scheduleReports()
+	}
+	if ana.anonymizeAfter > 0 && !config.ManualFlush && !ana.memoryOnly {
+		ana.scheduleAnonymization()
+	}
+	return ana, nil
+}
+
+// scheduleWrite flushes every flushInterval. A failed flush is retried
+// sooner, backing off exponentially, as the data is only safe once it's on
+// disk.
 func (a analytics) scheduleWrite() {
-	ticker := time.NewTicker(time.Duration(a.WriteScheduleSeconds) * time.Second)
-	quit := make(chan struct{})
+	interval := a.flushInterval
+	timer := time.NewTimer(interval)
 	go func() {
+		retry := minFlushRetry
 		for {
 			select {
-			case <-ticker.C:
-				err := a.writeFile()
-				if err != nil {
-					a.logger(err)
+			case <-timer.C:
+				if err := a.Flush(); err != nil {
+					a.log.Error("flushing: %v", err)
+					timer.Reset(retry)
+					retry = nextRetry(retry, interval)
+					continue
 				}
-			case <-quit:
-				ticker.Stop()
+				retry = minFlushRetry
+				timer.Reset(interval)
+			case <-a.quit:
+				timer.Stop()
 				return
 			}
 		}
 	}()
 }
 
+// Flush writes the data of every site to disk now. It returns the first
+// error, logging those of the sites written after it. With ManualFlush it's
+// the only way data is written. Once PersistFailureThreshold flushes in a row
+// failed OnPersistFailure is called. With DisablePersistence it does nothing.
+func (a analytics) Flush() error {
+	a.pruneSalts()
+	if a.memoryOnly {
+		return nil
+	}
+	start := time.Now()
+	var first error
+	for _, s := range a.sites {
+		err := s.writeFile()
+		if err != nil && first == nil {
+			first = err
+		} else if err != nil {
+			a.log.Error("flushing: %v", err)
+			a.health.recordError(err, a.now())
+		}
+	}
+	failures := a.health.record(first, a.now(), time.Since(start))
+	if failures == a.failureThreshold && a.onPersistFailure != nil {
+		a.onPersistFailure(first, failures)
+	}
+	return first
+}
+
+// Close stops recording requests of every site and the scheduled writes,
+// alerts, reports and lookups, then writes what's buffered like Flush once
+// the ASN lookup running, if any, is stored. With DisablePersistence it only
+// stops recording. Closing again does nothing.
+func (a analytics) Close() error {
+	if !atomic.CompareAndSwapInt32(a.closed, 0, 1) {
+		return nil
+	}
+	if a.enricher != nil {
+		a.enricher.close()
+	}
+	close(a.quit)
+	if a.enricher != nil {
+		// A lookup running now is stored before the last flush.
+		a.enricher.pending.Wait()
+	}
+	return a.Flush()
+}
+
 var DefaultUserAgentBlacklist = []string{
 	"wget", "python", "perl", "msnbot", "netresearch", "bot",
 	"archive", "crawl", "googlebot", "msn", "archive", "php",
 	"panscient", "berry", "yandex", "bing", "fluffy",
 }
 
+// maxPathLength caps the stored path and query of a request so a single
+// request can't bloat the day file.
+const maxPathLength = 2048
+
+// unknownAddr is who requests without a RemoteAddr are counted as.
+const unknownAddr = "unknown"
+
+// InsertRequest is InsertRequestContext without a context.
 func (a analytics) InsertRequest(r *http.Request) {
-	ua := strings.ToLower(r.UserAgent())
-	bots := a.UserAgentBlackList
-	for _, b := range bots {
-		if strings.Contains(strings.ToLower(ua), b) {
-			return
+	a.InsertRequestContext(context.Background(), r)
+}
+
+// InsertRequestContext records a request unless ctx is already done, storing
+// the TraceID of ctx with it.
+func (a analytics) InsertRequestContext(ctx context.Context, r *http.Request) {
+	if r == nil || r.URL == nil {
+		a.log.Warn("can't record a request without a URL")
+		return
+	}
+	a.record(ctx, r, Action{Page: r.URL.Path, Query: r.URL.RawQuery})
+}
+
+// record stores an action of r for the site r belongs to, returning the name
+// of that site and the key the visitor was stored under, or "" if the action
+// wasn't recorded as a visitor's.
+func (a analytics) record(ctx context.Context, r *http.Request, act Action) (site, visitor string) {
+	if atomic.LoadInt32(a.closed) == 1 || ctx.Err() != nil || !a.consented(r) {
+		return a.Name, ""
+	}
+	if a.siteResolver != nil {
+		a = a.site(a.siteResolver(r))
+	}
+	if len(act.Event) == 0 && a.staticAsset(act.Page) {
+		return a.Name, ""
+	}
+	rc := a.tuning.load()
+	if len(act.Event) == 0 && rc.ignored(r) {
+		return a.Name, ""
+	}
+	if len(act.Event) == 0 && isPrefetch(r) {
+		if !a.recordPrefetch {
+			atomic.AddInt64(&a.metrics.prefetches, 1)
+			a.log.Debug("skipping prefetch of %s", act.Page)
+			return a.Name, ""
 		}
+		act.Prefetch = true
 	}
-	act := action{Page: r.URL.Path, Query: r.URL.RawQuery}
-	a.Mux.Lock()
-	defer a.Mux.Unlock()
-	a.insert(r.RemoteAddr, act)
+	now := a.now()
+	act.Timestamp = now.UnixMilli()
+	if len(act.Page) == 0 {
+		// CONNECT and other requests in authority form have no path.
+		act.Page = "/"
+	}
+	if a.normalize.enabled() {
+		act.Page, act.Query = a.normalize.normalize(act.Page, act.Query)
+	}
+	if t := matchTemplate(a.pathTemplates, act.Page); len(t) > 0 {
+		if a.keepRawPaths {
+			act.RawPage = act.Page
+		}
+		act.Page = t
+	}
+	act.Query = rc.scrubQuery(act.Query)
+	if len(act.Page) > maxPathLength {
+		act.Page = act.Page[:maxPathLength]
+	}
+	if len(act.Query) > maxPathLength {
+		act.Query = act.Query[:maxPathLength]
+	}
+	if a.traceID != nil {
+		act.TraceID = a.traceID(ctx)
+		if len(act.TraceID) > maxTargetLength {
+			act.TraceID = act.TraceID[:maxTargetLength]
+		}
+	}
+	addr := r.RemoteAddr
+	if len(addr) == 0 {
+		addr = unknownAddr
+	}
+	if a.truncateIPs {
+		addr = truncateIP(addr)
+	}
+	if rc.blacklisted(r.UserAgent()) {
+		atomic.AddInt64(&a.metrics.blacklisted, 1)
+		a.log.Debug("skipping blacklisted user agent %q", r.UserAgent())
+		if a.trackBots {
+			act.UserAgent = r.UserAgent()
+			if len(act.UserAgent) > maxTargetLength {
+				act.UserAgent = act.UserAgent[:maxTargetLength]
+			}
+			a.Mux.Lock()
+			a.insertBot(addr, act)
+			a.Mux.Unlock()
+		}
+		return a.Name, ""
+	}
+	if len(act.Event) == 0 {
+		act.Referrer = r.Referer()
+		if rc.spam(act.Referrer) {
+			atomic.AddInt64(&a.metrics.referrerSpam, 1)
+			if rc.dropSpam {
+				a.log.Debug("skipping a visit referred by spam %q", act.Referrer)
+				return a.Name, ""
+			}
+			act.Referrer = spamReferrer
+		}
+		if len(act.Referrer) > maxTargetLength {
+			act.Referrer = act.Referrer[:maxTargetLength]
+		}
+		a.live.add(act.Page, now)
+	}
+	a.assignVariant(r, &act)
+	act.TLS, act.Proto = a.protocols(r)
+	enriched := true
+	if a.enricher != nil {
+		ignored, known := a.enrichAction(clientIP(r), &act)
+		if ignored {
+			atomic.AddInt64(&a.metrics.ignoredASN, 1)
+			a.log.Debug("skipping a visit from ignored AS%d", act.ASN)
+			return a.Name, ""
+		}
+		enriched = known
+	}
+	// The limit is per IP, a client opening new connections gets a new port
+	// each time.
+	if ip := clientIP(r); a.rateLimit != nil && !a.rateLimit.allow(ip, now) {
+		atomic.AddInt64(&a.metrics.rateLimited, 1)
+		a.log.Debug("rate limiting %s", ip)
+		return a.Name, ""
+	}
+	visitor, duplicate, capped := a.insert(addr, act, rc.maxActions)
+	if duplicate {
+		atomic.AddInt64(&a.metrics.duplicates, 1)
+		a.log.Debug("skipping a repeated view of %s", act.Page)
+		return a.Name, visitor
+	}
+	if capped {
+		atomic.AddInt64(&a.metrics.dropped, 1)
+		return a.Name, visitor
+	}
+	atomic.AddInt64(&a.metrics.recorded, 1)
+	atomic.AddInt64(&a.metrics.buffered, 1)
+	if !enriched {
+		a.enricher.enqueue(enrichJob{site: a, ts: a.dayKey(now), key: visitor, ip: clientIP(r), queued: now})
+	}
+	return a.Name, visitor
 }
 
-func (a analytics) Dashboard(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-	if len(a.Password) > 0 && (len(q["k"]) == 0 || len(q["k"][0]) == 0 || q["k"][0] != a.Password) {
-		a.logger(fmt.Errorf("Unauthorized"))
-		w.WriteHeader(http.StatusUnauthorized)
-		w.Write(nil)
-		return
+// blacklist is the configured UserAgentBlackList, DefaultUserAgentBlacklist
+// if there is none, or nothing at all with DisableBotFiltering.
+func blacklist(config AnalyticsConfiguration) []string {
+	if config.DisableBotFiltering {
+		return nil
+	}
+	if len(config.UserAgentBlackList) == 0 {
+		return DefaultUserAgentBlacklist
+	}
+	return config.UserAgentBlackList
+}
+
+// normalizeBlacklist lowercases the blacklist once so entries like
+// "Googlebot" match, dropping empty and duplicate entries.
+func normalizeBlacklist(list []string) []string {
+	seen := make(map[string]bool, len(list))
+	normalized := make([]string, 0, len(list))
+	for _, b := range list {
+		b = strings.ToLower(strings.TrimSpace(b))
+		if len(b) == 0 || seen[b] {
+			continue
+		}
+		seen[b] = true
+		normalized = append(normalized, b)
 	}
+	return normalized
+}
 
-	date := time.Now()
+// blacklisted tells whether ua contains any blacklist entry, ignoring case.
+func (rc runtimeConfig) blacklisted(ua string) bool {
+	return rc.matcher.matches(ua)
+}
+
+// requestRange reads the day (?date=), period (?period=week|month around the
+// day) or range (?from=&to=) a request asks for, defaulting to today, writing
+// a 400 if it's malformed.
+func (a analytics) requestRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	q := r.URL.Query()
+	from := a.now()
+	to := from
 	var err error
-	if len(q["date"]) > 0 {
-		date, err = time.Parse("2006-01-02", q["date"][0])
+	if len(q["from"]) > 0 || len(q["to"]) > 0 {
+		from, to, err = parseRange(q.Get("from"), q.Get("to"), a.location)
 		if err != nil {
-			a.logger(err)
+			a.log.Info("bad range: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return from, to, false
+		}
+	} else if len(q["date"]) > 0 {
+		from, err = a.parseDay(q["date"][0])
+		if err != nil {
+			a.log.Info("bad date: %v", err)
 			w.WriteHeader(http.StatusBadRequest)
 			w.Write(nil)
-			return
+			return from, to, false
+		}
+		to = from
+	}
+	if period := q.Get("period"); len(period) > 0 {
+		from, to, err = periodRange(period, from)
+		if err != nil {
+			a.log.Info("bad period: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return from, to, false
 		}
 	}
-	var data map[string][]action
-	if date.Format("2006-01-02") == time.Now().Format("2006-01-02") {
-		data = a.IPEntries[date.Format("2006-01-02")]
+	return from, to, true
+}
+
+// dashboardData authorizes the request and aggregates the day or range it
+// asks for. On failure the error response has already been written.
+func (a analytics) dashboardData(w http.ResponseWriter, r *http.Request) (DashboardData, bool) {
+	if !a.authorized(w, r) {
+		return DashboardData{}, false
+	}
+	from, to, ok := a.requestRange(w, r)
+	if !ok {
+		return DashboardData{}, false
+	}
+	release, ok := a.admitView(w, r, from, to)
+	if !ok {
+		return DashboardData{}, false
+	}
+	defer release()
+	return a.viewData(r, from, to), true
+}
+
+// viewData is the DashboardData of the days from through to, shown the way
+// r's query asks for.
+func (a analytics) viewData(r *http.Request, from, to time.Time) DashboardData {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	perPage := a.topURLs
+	if n, err := strconv.Atoi(q.Get("per_page")); err == nil && n > 0 {
+		perPage = n
+	}
+	if q.Get("all") == "1" {
+		perPage = 0
+	}
+	view := newURLView(q.Get("q"), page, perPage, q.Get("sort"), q.Get("order"))
+	dd := a.stats(from, to, view, q.Get("compare"))
+	dd.Period = q.Get("period")
+	dd.LoggedIn = a.validSession(r)
+	return dd
+}
+
+// Stats returns the numbers the dashboard shows for the day date falls on,
+// listing every URL. Today's come from memory. Days without data have zero
+// values rather than an error.
+func (a analytics) Stats(date time.Time) (DashboardData, error) {
+	return a.StatsRange(date, date)
+}
+
+// StatsRange is Stats for the days from through to, at most 92 of them like
+// the dashboard's ?from=&to=.
+func (a analytics) StatsRange(from, to time.Time) (DashboardData, error) {
+	from, err := a.parseDay(a.dayKey(from))
+	if err != nil {
+		return DashboardData{}, err
+	}
+	to, err = a.parseDay(a.dayKey(to))
+	if err != nil {
+		return DashboardData{}, err
+	}
+	if err := checkRange(from, to); err != nil {
+		return DashboardData{}, err
+	}
+	return a.stats(from, to, newURLView("", 0, 0, "", ""), CompareDay), nil
+}
+
+// stats aggregates the days from through to for the dashboard, the JSON API
+// and Stats, comparing them with the days before on basis.
+func (a analytics) stats(from, to time.Time, view urlView, basis string) DashboardData {
+	ag := a.aggregateRange(from, to)
+	dd := ag.report(view)
+	a.labelGroups(dd.URLHits)
+	dd.Visitors = a.visitorIDs(dd.Visitors)
+	dd.Goals = a.goalStats(ag.goals, ag.sessions)
+	dd.Funnel = funnelSteps(a.funnel, ag.funnel)
+	dd.Experiments = a.experimentStats(ag.variants)
+	dd.Protocols = ag.protocols.report()
+	dd.ASNs = ag.asns.report(topASNs)
+	dd.Date = a.dayKey(from)
+	if !to.Equal(from) {
+		dd.To = a.dayKey(to)
+	}
+	dd.Site = a.Name
+	dd.Sites = a.siteNames()
+	dd.NoHistory = a.memoryOnly && !a.isToday(from)
+	dd.MixedHashDays = a.mixedDays(from, to)
+	dd.Nodes = a.nodeStats(from, to)
+	dd.Trend = a.trend(to)
+	if to.Equal(from) {
+		dd.Returning = a.returning(from)
 	} else {
-		data = a.readSavedData(date)
-	}
-
-	entries := len(data)
-	urlHits := map[string]map[string]int{}
-	for _, actions := range data {
-		for _, act := range actions {
-			pParts := strings.Split(act.Page, "/")
-			groupBy := pParts[a.groupBy]
-			dataEntry := strings.Join(pParts[a.entriesBy:], "/")
-			_, ok := urlHits[groupBy]
-			if !ok {
-				urlHits[groupBy] = map[string]int{}
-			}
+		dd.Frequency = a.frequency(from, to)
+	}
+
+	if basis != CompareWeek {
+		basis = CompareDay
+	}
+	cFrom, cTo := compareRange(from, to, basis)
+	prev := a.aggregateRange(cFrom, cTo)
+	dd.Comparison = Comparison{
+		Basis:     basis,
+		Date:      a.dayKey(cFrom),
+		Sessions:  newDelta(dd.SessionCount, prev.sessions),
+		PageViews: newDelta(dd.PageViews, prev.views),
+	}
+	if dd.To != "" {
+		dd.Comparison.To = a.dayKey(cTo)
+	}
+	return dd
+}
+
+// compareRange is the range the days from through to are compared with, the
+// same days a week before with CompareWeek or the days right before.
+func compareRange(from, to time.Time, basis string) (time.Time, time.Time) {
+	if basis == CompareWeek {
+		return from.AddDate(0, 0, -7), to.AddDate(0, 0, -7)
+	}
+	days := int(to.Sub(from).Hours()/24+0.5) + 1
+	return from.AddDate(0, 0, -days), to.AddDate(0, 0, -days)
+}
 
-			urlHits[groupBy][dataEntry] = urlHits[groupBy][dataEntry] + 1
+func (a analytics) Dashboard(w http.ResponseWriter, r *http.Request) {
+	if a.disableDashboard {
+		http.NotFound(w, r)
+		return
+	}
+	a = a.site(r.URL.Query().Get("site"))
+	if r.Method == http.MethodPost {
+		a.login(w, r)
+		return
+	}
+	if r.URL.Query().Get("logout") == "1" {
+		a.logout(w, r)
+		return
+	}
+	if partial := r.URL.Query().Get("partial"); len(partial) > 0 {
+		a.partial(w, r, partial)
+		return
+	}
+	if r.URL.Query().Get("live") == "1" {
+		a.Live(w, r)
+		return
+	}
+	if len(r.URL.Query().Get("visitor")) > 0 {
+		a.visitorDetail(w, r)
+		return
+	}
+	if r.URL.Query().Get("bots") == "1" {
+		a.botDashboard(w, r)
+		return
+	}
+	if r.URL.Query().Get("heatmap") == "1" {
+		a.heatmap(w, r)
+		return
+	}
+	if len(r.URL.Query().Get("clickmap")) > 0 {
+		a.clickMap(w, r)
+		return
+	}
+	if !a.authorized(w, r) {
+		return
+	}
+	from, to, ok := a.requestRange(w, r)
+	if !ok || a.notModified(w, r, to) {
+		return
+	}
+	release, ok := a.admitView(w, r, from, to)
+	if !ok {
+		return
+	}
+	defer release()
+	dd := a.viewData(r, from, to)
+	var buf bytes.Buffer
+	if a.template != nil {
+		securityHeaders(w)
+		err := a.executeCustom(&buf, dd)
+		if err == nil {
+			a.writeCompressed(w, r, "text/html; charset=utf-8", buf.Bytes())
+			return
 		}
+		a.log.Warn("custom dashboard template failed, using the built-in one: %v", err)
+		buf.Reset()
+	}
+	dd.Nonce = a.withCSP(w)
+	if err := a.builtin.ExecuteTemplate(&buf, "layout", dd); err != nil {
+		a.log.Error("rendering layout: %v", err)
+		w.Header().Del("ETag")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(nil)
+		return
 	}
+	a.writeCompressed(w, r, "text/html; charset=utf-8", buf.Bytes())
+}
 
-	dd := dashData{SessionCount: entries, URLHits: urlHits, Date: date.Format("2006-01-02")}
-	t, err := template.New("").Parse(HTML)
+// render executes one of the built-in templates. It renders into a buffer so
+// a failing template results in a 500 rather than half a page.
+func (a analytics) render(w http.ResponseWriter, name string, data interface{}) {
+	var buf bytes.Buffer
+	err := a.builtin.ExecuteTemplate(&buf, name, data)
 	if err != nil {
-		a.logger(err)
+		a.log.Error("rendering %s: %v", name, err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write(nil)
 		return
 	}
-	err = t.ExecuteTemplate(w, "layout", dd)
-	if err != nil {
-		a.logger(err)
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// executeCustom runs the TemplatePath template, starting at its "layout"
+// template if it defines one like the built-in template does.
+func (a analytics) executeCustom(w io.Writer, dd DashboardData) error {
+	if a.template.Lookup("layout") != nil {
+		return a.template.ExecuteTemplate(w, "layout", dd)
 	}
+	return a.template.Execute(w, dd)
+}
+
+// Action is a page view or beacon event of a visitor, as stored in the day
+// files.
+type Action struct {
+	Page   string
+	Query  string
+	Event  string `json:",omitempty"`
+	Target string `json:",omitempty"`
+	Bytes  int64  `json:",omitempty"`
+	// Prefetch marks a page view the browser prefetched, kept with
+	// RecordPrefetch.
+	Prefetch bool `json:",omitempty"`
+
+	Duration time.Duration `json:",omitempty"`
+	Referrer string        `json:",omitempty"`
+	// RawPage is the path Page was rewritten from by a PathTemplates entry,
+	// kept with KeepRawPaths.
+	RawPage string `json:",omitempty"`
+	// TraceID is what the TraceID func returned for the request's context.
+	TraceID string `json:",omitempty"`
+	// Experiment and Variant are what VariantFunc assigned the request to.
+	Experiment string `json:",omitempty"`
+	Variant    string `json:",omitempty"`
+	// Scroll and Seconds are the deepest scroll, in quartiles of the page,
+	// and the longest time on page the beacon's pings reported for a page
+	// view.
+	Scroll  int `json:",omitempty"`
+	Seconds int `json:",omitempty"`
+	// TLS and Proto are the TLS and HTTP versions of the visitor's first
+	// request of the day, see TLSVersionHeader.
+	TLS   string `json:",omitempty"`
+	Proto string `json:",omitempty"`
+	// ASN and ASNOrg are the autonomous system of the visitor's IP and
+	// Hostname its reverse DNS name, looked up after their first request of
+	// the day was recorded, see ASNLookup.
+	ASN      uint32 `json:",omitempty"`
+	ASNOrg   string `json:",omitempty"`
+	Hostname string `json:",omitempty"`
+	// UserAgent is only kept for bot actions, see TrackBots.
+	UserAgent string `json:",omitempty"`
+	// Timestamp is when the action was recorded, in Unix milliseconds.
+	Timestamp int64 `json:",omitempty"`
 }
 
-type dashData struct {
-	SessionCount int
-	Date         string
-	URLHits      map[string]map[string]int
+var templateFuncs = template.FuncMap{
+	"bytes":    humanBytes,
+	"duration": humanDuration,
+	"inc":      func(i int) int { return i + 1 },
+	"dec":      func(i int) int { return i - 1 },
+	"visitor":  visitorLabel,
 }
 
-type action struct {
-	Page  string
-	Query string
+// humanDuration formats a response time, rendering a missing (zero) value as
+// a dash rather than claiming an instant response.
+func humanDuration(d time.Duration) string {
+	if d <= 0 {
+		return "—"
+	}
+	if d < time.Millisecond {
+		return fmt.Sprintf("%d µs", d.Microseconds())
+	}
+	return fmt.Sprintf("%.1f ms", float64(d)/float64(time.Millisecond))
 }
 
-func (a analytics) readSavedData(td time.Time) map[string][]action {
-	fileName := a.Directory + td.Format("/2006/01/02/") + a.Name + td.Format("2006-01-02")
+// humanBytes formats a byte count using binary units, e.g. 1.5 MiB.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
 
-	entries := map[string][]action{}
-	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+func (a analytics) readSavedData(td time.Time) map[string][]Action {
+	return a.readDayFile(a.dayFileName(td))
+}
+
+func (a analytics) readDayFile(fileName string) map[string][]Action {
+	entries := map[string][]Action{}
+	if a.memoryOnly {
+		return entries
+	}
+	_, err := os.Stat(fileName)
+	if os.IsNotExist(err) && !dayFileExists(fileName) {
 
 	} else {
-		bs, err := ioutil.ReadFile(fileName)
+		entries, err = a.decodeDayFile(fileName)
 		if err != nil {
-			a.logger(err)
+			a.log.Error("%v", err)
 			return entries
 		}
-		r, err := zlib.NewReader(bytes.NewReader(bs))
-		if err != nil {
-			a.logger(err)
-			return entries
+		// Reading never writes, the dashboard and analyticscli may read
+		// concurrently or from a copy. Days in memory are written with hex
+		// keys the next time they're written, the others are re-keyed
+		// whenever they're read.
+		migrateKeys(entries)
+	}
+	return entries
+}
+
+// decodeDayFile streams a day file through zlib into the JSON decoder, so
+// the file is never held in memory compressed or as JSON. It stops with an
+// error once more than MaxDayFileBytes were decompressed.
+func (a analytics) decodeDayFile(fileName string) (map[string][]Action, error) {
+	entries := map[string][]Action{}
+	err := a.streamDayFile(fileName, func(visitor string, actions []Action) {
+		entries[visitor] = actions
+	})
+	if err != nil && errors.Is(err, errDayTooBig) {
+		return map[string][]Action{}, err
+	}
+	return entries, err
+}
+
+var errDayTooBig = errors.New("decompresses to more than MaxDayFileBytes")
+
+// streamDayFile decodes a day file one visitor at a time, calling visit with
+// each visitor's actions, so the whole day never has to be in memory. Like
+// json.Decode into a map, it stops at the first malformed value.
+func (a analytics) streamDayFile(fileName string, visit func(visitor string, actions []Action)) error {
+	f, err := openDayFile(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := zlib.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", fileName, err)
+	}
+	defer r.Close()
+	capped := &cappedReader{r: r, left: a.maxDayBytes}
+	if err := decodeDay(json.NewDecoder(capped), visit); err != nil {
+		if capped.left <= 0 {
+			return fmt.Errorf("reading %s: %w (%d bytes)", fileName, errDayTooBig, a.maxDayBytes)
 		}
-		jsonBytes := bytes.NewBuffer([]byte{})
-		_, err = io.Copy(jsonBytes, r)
+		return fmt.Errorf("reading %s: %w", fileName, err)
+	}
+	return nil
+}
+
+// decodeDay reads the object of visitors and their actions encodeDay writes.
+func decodeDay(dec *json.Decoder, visit func(visitor string, actions []Action)) error {
+	if t, err := dec.Token(); err != nil {
+		return err
+	} else if t == nil {
+		// null decoded into a map leaves it empty.
+		return nil
+	} else if d, ok := t.(json.Delim); !ok || d != '{' {
+		return fmt.Errorf("expected an object of visitors, got %v", t)
+	}
+	for dec.More() {
+		t, err := dec.Token()
 		if err != nil {
-			a.logger(err)
-			return entries
+			return err
 		}
-		r.Close()
-		err = json.Unmarshal(jsonBytes.Bytes(), &entries)
-		if err != nil {
-			a.logger(err)
+		visitor, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("expected a visitor, got %v", t)
 		}
+		var actions []Action
+		if err := dec.Decode(&actions); err != nil {
+			return err
+		}
+		visit(visitor, actions)
 	}
-	return entries
+	_, err := dec.Token()
+	return err
 }
 
-func (a analytics) insert(ip string, act action) {
-	ts := time.Now().Format("2006-01-02")
-	stamps := a.IPEntries[ts]
-	if stamps == nil {
-		a.IPEntries[ts] = map[string][]action{}
+// cappedReader reads at most left bytes, then fails instead of returning
+// io.EOF so a truncated read isn't mistaken for the end of the data.
+type cappedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		return 0, errors.New("size limit reached")
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
 	}
-	if len(a.HashIPSecret) > 0 {
-		hash := sha256.New()
-		ip = ts + ip + a.HashIPSecret
-		inpIP := strings.NewReader(ip)
-		if _, err := io.Copy(hash, inpIP); err != nil {
-			a.logger(err)
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+// binaryKey reports whether a visitor key is a raw sha256 digest, as written
+// before hashed keys were hex encoded. Decoding those from JSON turns invalid
+// UTF-8 into replacement characters, so they're recognised by not being
+// printable.
+func binaryKey(key string) bool {
+	return !utf8.ValidString(key) || strings.IndexFunc(key, func(r rune) bool { return !unicode.IsPrint(r) || r == utf8.RuneError }) >= 0
+}
+
+// migrateKeys re-keys the entries of binary visitor keys by their hex
+// encoding, reporting whether any needed it. A binary key mangled into the
+// same string as another one stays merged with it.
+func migrateKeys(entries map[string][]Action) bool {
+	migrated := false
+	for key, actions := range entries {
+		if !binaryKey(key) {
+			continue
 		}
-		sum := hash.Sum(nil)
-		ip = string(sum)
+		hexKey := hex.EncodeToString([]byte(key))
+		entries[hexKey] = append(entries[hexKey], actions...)
+		delete(entries, key)
+		migrated = true
+	}
+	return migrated
+}
+
+// insert adds an action of the visitor at ip, returning their key, whether
+// it was dropped as a duplicate of their last page view and whether it was
+// only counted as dropped by the day's cap on their actions. It only
+// holds Mux for reading and the lock of the visitor's shard, unless the day
+// has to be opened first.
+func (a analytics) insert(ip string, act Action, maxActions int) (key string, duplicate, capped bool) {
+	day := time.UnixMilli(act.Timestamp)
+	ts := a.dayKey(day)
+	key = a.visitorKey(ts, ip)
+	a.Mux.RLock()
+	for !a.openDays[ts] {
+		a.Mux.RUnlock()
+		a.Mux.Lock()
+		if !a.openDays[ts] {
+			if a.memoryOnly {
+				// Nothing is written, so only the current day is kept.
+				for k := range a.openDays {
+					a.closeDay(k)
+				}
+			}
+			a.openDay(ts, day)
+		}
+		a.Mux.Unlock()
+		a.Mux.RLock()
+	}
+	defer a.Mux.RUnlock()
+	if a.traffic != nil {
+		a.traffic.add(key, day)
+	}
+	sh := a.shards.of(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if a.duplicate(sh, ts, key, act) {
+		return key, true, false
+	}
+	entries := sh.entries[ts][key]
+	if entries == nil {
+		a.sketchOf(sh, ts).add(keyHash(key))
+	} else {
+		// The protocols and lookups are kept once per visitor and day.
+		act.TLS, act.Proto = "", ""
+		act.ASN, act.ASNOrg, act.Hostname = 0, "", ""
+	}
+	if maxActions > 0 && len(entries) >= maxActions {
+		sh.dropped[ts][key]++
+		return key, false, true
 	}
-	entries := stamps[ip]
 	if entries == nil {
-		entries = []action{}
+		entries = []Action{}
 	}
 	entries = append(entries, act)
 
-	a.IPEntries[ts][ip] = entries
+	sh.entries[ts][key] = entries
+	a.countAction(sh, ts, key, entries)
+	return key, false, false
+}
+
+// snapshot copies a day of entries under the read lock so it can be aggregated
+// and rendered without holding the lock. The action slices are shared, but
+// inserts only ever append past their end, so the snapshot doesn't change.
+func (a analytics) snapshot(entries map[string]map[string][]Action, day string) map[string][]Action {
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	data := make(map[string][]Action, len(entries[day]))
+	for k, actions := range entries[day] {
+		data[k] = actions
+	}
+	return data
+}
+
+// snapshotDays copies the days of entries like snapshot does. The caller has
+// to hold the lock.
+func snapshotDays(entries map[string]map[string][]Action) map[string]map[string][]Action {
+	days := make(map[string]map[string][]Action, len(entries))
+	for day, visitors := range entries {
+		data := make(map[string][]Action, len(visitors))
+		for k, actions := range visitors {
+			data[k] = actions
+		}
+		days[day] = data
+	}
+	return days
+}
+
+// visitorKey is the key a visitor's actions are stored under on day ts, the
+// IP hashed by HashFunc, or SHA256Hash when HashIPSecret is set, with the
+// day's secret under RotatingSecret.
+func (a analytics) visitorKey(ts, ip string) string {
+	if a.hash == nil {
+		return ip
+	}
+	secret, _ := a.daySecret(ts, true)
+	return a.hash(ts, ip, secret)
 }
 
+// writeFile writes every day in memory to disk. It only holds the lock to
+// snapshot the days, so inserts aren't blocked while they are encoded and
+// written. The days stay in memory, so anything a failed write didn't save
+// is written again on the next one.
 func (a analytics) writeFile() error {
-	ts := time.Now().Format("/2006/01/02")
-	err := os.MkdirAll(a.Directory+ts, os.ModePerm)
-	if err != nil {
-		return err
+	if a.memoryOnly {
+		return nil
+	}
+	a.writeMux.Lock()
+	defer a.writeMux.Unlock()
+	return a.writeDays("")
+}
+
+// writeDays writes the days in memory whose key starts with prefix, every
+// day for "". The caller holds writeMux.
+func (a analytics) writeDays(prefix string) (err error) {
+	a.Mux.RLock()
+	botEntries := snapshotDays(a.BotEntries)
+	for k := range botEntries {
+		if !strings.HasPrefix(k, prefix) {
+			delete(botEntries, k)
+		}
 	}
-	a.Mux.Lock()
-	defer a.Mux.Unlock()
-	for k, e := range a.IPEntries {
-		data, err := json.Marshal(e)
+	clickMaps := snapshotClicks(a.clickMaps, prefix)
+	ipEntries := make(map[string]map[string][]Action, len(a.openDays))
+	schemes := make(map[string]string, len(a.openDays))
+	for k := range a.openDays {
+		if strings.HasPrefix(k, prefix) {
+			ipEntries[k] = map[string][]Action{}
+			schemes[k] = a.dayScheme(k)
+		}
+	}
+	// These are the actions buffered since the last write, they're counted
+	// again if this write fails. Inserts happening while the shards are
+	// copied may be written now and still be counted for the next write.
+	var buffered int64
+	if len(prefix) == 0 {
+		buffered = atomic.SwapInt64(&a.metrics.buffered, 0)
+	}
+	dropped := make(map[string]map[string]int, len(ipEntries))
+	daySketches := make(map[string]*sketch, len(ipEntries))
+	for _, sh := range a.shards {
+		sh.mu.Lock()
+		for k, data := range ipEntries {
+			for visitor, actions := range sh.entries[k] {
+				data[visitor] = actions
+			}
+			for visitor, n := range sh.dropped[k] {
+				if dropped[k] == nil {
+					dropped[k] = map[string]int{}
+				}
+				dropped[k][visitor] = n
+			}
+			if s := sh.sketches[k]; s != nil && daySketches[k] == nil {
+				daySketches[k] = s.clone()
+			} else if s != nil {
+				daySketches[k] = daySketches[k].union(s)
+			}
+		}
+		sh.mu.Unlock()
+	}
+	a.Mux.RUnlock()
+	sketches := make(map[string][]byte, len(daySketches))
+	for k, s := range daySketches {
+		sketches[k] = s.marshal()
+	}
+	defer func() {
+		if err != nil {
+			atomic.AddInt64(&a.metrics.buffered, buffered)
+		}
+	}()
+	indexed := make(map[string]indexDay, len(ipEntries))
+	for k, e := range ipEntries {
+		day, err := a.parseDay(k)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(a.dayDir(day), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		n, err := writeDayFile(a.dayFileName(day), e)
+		atomic.AddInt64(&a.health.bytesWritten, int64(n))
+		if err != nil {
+			return err
+		}
+		summary := summarize(e, a.location)
+		summary.Scheme = schemes[k]
+		summary.Sketch, summary.KeySketch = sketches[k], true
+		if summary.Sketch == nil {
+			summary.Sketch = keysSketch(e, a.sketchPrecision).marshal()
+		}
+		err = a.writeSummary(day, summary)
+		if err != nil {
+			return err
+		}
+		indexed[k] = indexDay{Sessions: summary.Sessions, PageViews: summary.PageViews}
+		if d, ok := dropped[k]; ok {
+			err = a.writeDropped(day, d)
+			if err != nil {
+				return err
+			}
+		}
+		if k != a.today() {
+			a.invalidateDay(day)
+		}
+	}
+	// The indexes only spare readers the summaries, which are written.
+	if err := a.updateIndex(indexed, true); err != nil {
+		a.log.Error("writing month index: %v", err)
+	}
+	for k, e := range botEntries {
+		day, err := a.parseDay(k)
 		if err != nil {
 			return err
 		}
-		f, err := os.Create(a.Directory + ts + "/" + a.Name + k)
+		err = os.MkdirAll(a.dayDir(day), os.ModePerm)
 		if err != nil {
 			return err
 		}
-		var b bytes.Buffer
-		w := zlib.NewWriter(&b)
-		w.Write(data)
-		w.Close()
-		defer f.Close()
-		_, err = f.Write(b.Bytes())
+		n, err := writeDayFile(a.botFileName(day), e)
+		atomic.AddInt64(&a.health.bytesWritten, int64(n))
+		if err != nil {
+			return err
+		}
+	}
+	for k, clicks := range clickMaps {
+		day, err := a.parseDay(k)
+		if err != nil {
+			return err
+		}
+		err = os.MkdirAll(a.dayDir(day), os.ModePerm)
+		if err != nil {
+			return err
+		}
+		err = a.writeClicks(day, clicks)
 		if err != nil {
 			return err
 		}
@@ -262,93 +1453,156 @@ func (a analytics) writeFile() error {
 	return nil
 }
 
-const HTML = `
-{{ define "layout" }}
-<!DOCTYPE html>
-<html lang="en">
-    <head></head>
-    <body>
-        <style type="text/css">
-            .tg  {border-collapse:collapse;border-spacing:0;}
-            .tg td{border-color:black;border-style:solid;border-width:1px;font-family:Arial, sans-serif;font-size:14px; overflow:hidden;padding:10px 5px;word-break:normal;}
-            .tg th{border-color:black;border-style:solid;border-width:1px;font-family:Arial, sans-serif;font-size:14px; font-weight:normal;overflow:hidden;padding:10px 5px;word-break:normal;}
-            .tg .tg-0lax{text-align:left;vertical-align:top}
-        </style>
-        <script>
-           function UpdateQueryString(key, value, url) {
-                if (!url) url = window.location.href;
-                var re = new RegExp("([?&])" + key + "=.*?(&|#|$)(.*)", "gi"),
-                    hash;
-
-                if (re.test(url)) {
-                    if (typeof value !== 'undefined' && value !== null) {
-                        return url.replace(re, '$1' + key + "=" + value + '$2$3');
-                    } 
-                    else {
-                        hash = url.split('#');
-                        url = hash[0].replace(re, '$1$3').replace(/(&|\?)$/, '');
-                        if (typeof hash[1] !== 'undefined' && hash[1] !== null) {
-                            url += '#' + hash[1];
-                        }
-                        return url;
-                    }
-                }
-                else {
-                    if (typeof value !== 'undefined' && value !== null) {
-                        var separator = url.indexOf('?') !== -1 ? '&' : '?';
-                        hash = url.split('#');
-                        url = hash[0] + separator + key + '=' + value;
-                        if (typeof hash[1] !== 'undefined' && hash[1] !== null) {
-                            url += '#' + hash[1];
-                        }
-                        return url;
-                    }
-                    else {
-                        return url;
-                    }
-                }
-            }
-
-            function chooseDate(object) {
-               window.location.href = UpdateQueryString("date", object.value, window.location.href)
-            }
-        </script>
-        <section id="about">
-            <div class="container-fluid align-self-center">
-                <div class="row d-flex justify-content-center">
-                    <div class="col-12 text-center align-self-center">
-                        <h1>{{.Date}}</h1>
-                        <input type="date" id="date" value="{{.Date}}" onchange="chooseDate(this)">
-                        <h2>Unique Sessions Today: {{.SessionCount}}</h2>
-                        <h3>Page Views</h3>
-                        {{range $Category, $URLS := .URLHits}}
-                            <h5> /{{$Category}}</h5>
-                            <table class="tg" style="undefined;table-layout: fixed; width: 320px">
-                                <colgroup>
-                                    <col style="width: 70px">
-                                    <col style="width: 250px">
-                                </colgroup>
-                                <thead>
-                                    <tr>
-                                        <th class="tg-0lax">Page Views</th>
-                                        <th class="tg-0lax">URL</th>
-                                    </tr>
-                                </thead>
-                                <tbody>
-                                {{range $URL, $count := $URLS}}
-                                    <tr>
-                                            <td class="tg-0lax">{{$count}} </td>
-                                            <td class="tg-0lax">{{$URL}}</td>
-                                    </tr>
-                                {{end}}
-                                </tbody>
-                            </table>
-                        {{ end }}
-                    </div>
-                </div>
-            </div>
-        </section>
-    </body>
-</html>
-{{ end }}
-`
+// Writers and buffers reused by every write, Get hands each concurrent
+// write its own. maxPooledBuffer keeps the buffer of an unusually active
+// visitor from being held forever.
+var (
+	zlibWriters   = sync.Pool{New: func() interface{} { return zlib.NewWriter(nil) }}
+	fileWriters   = sync.Pool{New: func() interface{} { return bufio.NewWriterSize(nil, 64<<10) }}
+	encodeBuffers = sync.Pool{New: func() interface{} { return new(bytes.Buffer) }}
+)
+
+const maxPooledBuffer = 1 << 20
+
+// writeDayFile writes a day file with writeFile, returning how many bytes
+// were written. The JSON is compressed and written as it's encoded.
+func writeDayFile(fileName string, e map[string][]Action) (int, error) {
+	var n int
+	err := writeFileWith(fileName, func(w io.Writer) error {
+		cw := &countingWriter{w: w}
+		zw := zlibWriters.Get().(*zlib.Writer)
+		defer zlibWriters.Put(zw)
+		zw.Reset(cw)
+		if err := encodeDay(zw, e); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		n = cw.n
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// encodeDay writes the JSON json.Marshal makes of e one visitor at a time,
+// so the JSON of the whole day is never held in memory.
+func encodeDay(w io.Writer, e map[string][]Action) error {
+	keys := make([]string, 0, len(e))
+	for k := range e {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf := encodeBuffers.Get().(*bytes.Buffer)
+	defer func() {
+		if buf.Cap() <= maxPooledBuffer {
+			encodeBuffers.Put(buf)
+		}
+	}()
+	buf.Reset()
+	enc := json.NewEncoder(buf)
+	// encode appends the JSON of v to buf, without the newline the Encoder
+	// ends it with.
+	encode := func(v interface{}) error {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		buf.Truncate(buf.Len() - 1)
+		return nil
+	}
+	buf.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := encode(k); err != nil {
+			return err
+		}
+		buf.WriteByte(':')
+		if err := encode(e[k]); err != nil {
+			return err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		buf.Reset()
+	}
+	buf.WriteByte('}')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// writeFile replaces fileName with data, see writeFileWith.
+func writeFile(fileName string, data []byte) error {
+	return writeFileWith(fileName, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileWith replaces fileName with what write writes through a temporary
+// file in the same directory, synced before it's renamed over fileName, so
+// neither readers, a failed write nor a crash ever leave the file half
+// written. Temporary files end in .tmp and are never mistaken for data files.
+func writeFileWith(fileName string, write func(w io.Writer) error) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(fileName), filepath.Base(fileName)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	bw := fileWriters.Get().(*bufio.Writer)
+	defer func() {
+		bw.Reset(nil)
+		fileWriters.Put(bw)
+	}()
+	bw.Reset(f)
+	if err := write(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, fileName); err != nil {
+		return err
+	}
+	// The rename is only durable once the directory is synced. Not every OS
+	// can sync a directory, the file itself is complete either way.
+	if dir, err := os.Open(filepath.Dir(fileName)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
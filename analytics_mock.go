@@ -5,8 +5,11 @@
 package analytics
 
 import (
+	context "context"
+	io "io"
 	http "net/http"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -34,6 +37,167 @@ func (m *MockAnalyzer) EXPECT() *MockAnalyzerMockRecorder {
 	return m.recorder
 }
 
+// AnonymizeNow mocks base method.
+func (m *MockAnalyzer) AnonymizeNow() (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AnonymizeNow")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AnonymizeNow indicates an expected call of AnonymizeNow.
+func (mr *MockAnalyzerMockRecorder) AnonymizeNow() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AnonymizeNow", reflect.TypeOf((*MockAnalyzer)(nil).AnonymizeNow))
+}
+
+// Archive mocks base method.
+func (m *MockAnalyzer) Archive(month time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Archive", month)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Archive indicates an expected call of Archive.
+func (mr *MockAnalyzerMockRecorder) Archive(month interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Archive", reflect.TypeOf((*MockAnalyzer)(nil).Archive), month)
+}
+
+// Beacon mocks base method.
+func (m *MockAnalyzer) Beacon(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Beacon", w, r)
+}
+
+// Beacon indicates an expected call of Beacon.
+func (mr *MockAnalyzerMockRecorder) Beacon(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Beacon", reflect.TypeOf((*MockAnalyzer)(nil).Beacon), w, r)
+}
+
+// Close mocks base method.
+func (m *MockAnalyzer) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockAnalyzerMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockAnalyzer)(nil).Close))
+}
+
+// Dashboard mocks base method.
+func (m *MockAnalyzer) Dashboard(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Dashboard", w, r)
+}
+
+// Dashboard indicates an expected call of Dashboard.
+func (mr *MockAnalyzerMockRecorder) Dashboard(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dashboard", reflect.TypeOf((*MockAnalyzer)(nil).Dashboard), w, r)
+}
+
+// DeleteVisitor mocks base method.
+func (m *MockAnalyzer) DeleteVisitor(ipOrHash string, from time.Time, to time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteVisitor", ipOrHash, from, to)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteVisitor indicates an expected call of DeleteVisitor.
+func (mr *MockAnalyzerMockRecorder) DeleteVisitor(ipOrHash, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteVisitor", reflect.TypeOf((*MockAnalyzer)(nil).DeleteVisitor), ipOrHash, from, to)
+}
+
+// Erase mocks base method.
+func (m *MockAnalyzer) Erase(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Erase", w, r)
+}
+
+// Erase indicates an expected call of Erase.
+func (mr *MockAnalyzerMockRecorder) Erase(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Erase", reflect.TypeOf((*MockAnalyzer)(nil).Erase), w, r)
+}
+
+// Export mocks base method.
+func (m *MockAnalyzer) Export(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Export", w, r)
+}
+
+// Export indicates an expected call of Export.
+func (mr *MockAnalyzerMockRecorder) Export(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Export", reflect.TypeOf((*MockAnalyzer)(nil).Export), w, r)
+}
+
+// ExportRange mocks base method.
+func (m *MockAnalyzer) ExportRange(w io.Writer, from time.Time, to time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportRange", w, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportRange indicates an expected call of ExportRange.
+func (mr *MockAnalyzerMockRecorder) ExportRange(w, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportRange", reflect.TypeOf((*MockAnalyzer)(nil).ExportRange), w, from, to)
+}
+
+// ExportVisitor mocks base method.
+func (m *MockAnalyzer) ExportVisitor(ipOrHash string, from time.Time, to time.Time) (VisitorExport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportVisitor", ipOrHash, from, to)
+	ret0, _ := ret[0].(VisitorExport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportVisitor indicates an expected call of ExportVisitor.
+func (mr *MockAnalyzerMockRecorder) ExportVisitor(ipOrHash, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportVisitor", reflect.TypeOf((*MockAnalyzer)(nil).ExportVisitor), ipOrHash, from, to)
+}
+
+// Flush mocks base method.
+func (m *MockAnalyzer) Flush() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Flush")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Flush indicates an expected call of Flush.
+func (mr *MockAnalyzerMockRecorder) Flush() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Flush", reflect.TypeOf((*MockAnalyzer)(nil).Flush))
+}
+
+// Health mocks base method.
+func (m *MockAnalyzer) Health(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Health", w, r)
+}
+
+// Health indicates an expected call of Health.
+func (mr *MockAnalyzerMockRecorder) Health(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Health", reflect.TypeOf((*MockAnalyzer)(nil).Health), w, r)
+}
+
 // InsertRequest mocks base method.
 func (m *MockAnalyzer) InsertRequest(r *http.Request) {
 	m.ctrl.T.Helper()
@@ -45,3 +209,589 @@ func (mr *MockAnalyzerMockRecorder) InsertRequest(r interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertRequest", reflect.TypeOf((*MockAnalyzer)(nil).InsertRequest), r)
 }
+
+// InsertRequestContext mocks base method.
+func (m *MockAnalyzer) InsertRequestContext(ctx context.Context, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "InsertRequestContext", ctx, r)
+}
+
+// InsertRequestContext indicates an expected call of InsertRequestContext.
+func (mr *MockAnalyzerMockRecorder) InsertRequestContext(ctx, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertRequestContext", reflect.TypeOf((*MockAnalyzer)(nil).InsertRequestContext), ctx, r)
+}
+
+// Live mocks base method.
+func (m *MockAnalyzer) Live(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Live", w, r)
+}
+
+// Live indicates an expected call of Live.
+func (mr *MockAnalyzerMockRecorder) Live(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Live", reflect.TypeOf((*MockAnalyzer)(nil).Live), w, r)
+}
+
+// Metrics mocks base method.
+func (m *MockAnalyzer) Metrics(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Metrics", w, r)
+}
+
+// Metrics indicates an expected call of Metrics.
+func (mr *MockAnalyzerMockRecorder) Metrics(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Metrics", reflect.TypeOf((*MockAnalyzer)(nil).Metrics), w, r)
+}
+
+// Middleware mocks base method.
+func (m *MockAnalyzer) Middleware(next http.Handler) http.Handler {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Middleware", next)
+	ret0, _ := ret[0].(http.Handler)
+	return ret0
+}
+
+// Middleware indicates an expected call of Middleware.
+func (mr *MockAnalyzerMockRecorder) Middleware(next interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Middleware", reflect.TypeOf((*MockAnalyzer)(nil).Middleware), next)
+}
+
+// RecordRoute mocks base method.
+func (m *MockAnalyzer) RecordRoute(r *http.Request, route string, bytes int64, duration time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordRoute", r, route, bytes, duration)
+}
+
+// RecordRoute indicates an expected call of RecordRoute.
+func (mr *MockAnalyzerMockRecorder) RecordRoute(r, route, bytes, duration interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordRoute", reflect.TypeOf((*MockAnalyzer)(nil).RecordRoute), r, route, bytes, duration)
+}
+
+// ReportNow mocks base method.
+func (m *MockAnalyzer) ReportNow() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReportNow")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReportNow indicates an expected call of ReportNow.
+func (mr *MockAnalyzerMockRecorder) ReportNow() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportNow", reflect.TypeOf((*MockAnalyzer)(nil).ReportNow))
+}
+
+// RouteMiddleware mocks base method.
+func (m *MockAnalyzer) RouteMiddleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RouteMiddleware", route)
+	ret0, _ := ret[0].(func(http.Handler) http.Handler)
+	return ret0
+}
+
+// RouteMiddleware indicates an expected call of RouteMiddleware.
+func (mr *MockAnalyzerMockRecorder) RouteMiddleware(route interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RouteMiddleware", reflect.TypeOf((*MockAnalyzer)(nil).RouteMiddleware), route)
+}
+
+// ShouldTrack mocks base method.
+func (m *MockAnalyzer) ShouldTrack(r *http.Request) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ShouldTrack", r)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// ShouldTrack indicates an expected call of ShouldTrack.
+func (mr *MockAnalyzerMockRecorder) ShouldTrack(r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ShouldTrack", reflect.TypeOf((*MockAnalyzer)(nil).ShouldTrack), r)
+}
+
+// Stats mocks base method.
+func (m *MockAnalyzer) Stats(date time.Time) (DashboardData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats", date)
+	ret0, _ := ret[0].(DashboardData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockAnalyzerMockRecorder) Stats(date interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockAnalyzer)(nil).Stats), date)
+}
+
+// StatsJSON mocks base method.
+func (m *MockAnalyzer) StatsJSON(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "StatsJSON", w, r)
+}
+
+// StatsJSON indicates an expected call of StatsJSON.
+func (mr *MockAnalyzerMockRecorder) StatsJSON(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatsJSON", reflect.TypeOf((*MockAnalyzer)(nil).StatsJSON), w, r)
+}
+
+// StatsRange mocks base method.
+func (m *MockAnalyzer) StatsRange(from time.Time, to time.Time) (DashboardData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StatsRange", from, to)
+	ret0, _ := ret[0].(DashboardData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatsRange indicates an expected call of StatsRange.
+func (mr *MockAnalyzerMockRecorder) StatsRange(from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatsRange", reflect.TypeOf((*MockAnalyzer)(nil).StatsRange), from, to)
+}
+
+// SubjectAccess mocks base method.
+func (m *MockAnalyzer) SubjectAccess(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SubjectAccess", w, r)
+}
+
+// SubjectAccess indicates an expected call of SubjectAccess.
+func (mr *MockAnalyzerMockRecorder) SubjectAccess(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubjectAccess", reflect.TypeOf((*MockAnalyzer)(nil).SubjectAccess), w, r)
+}
+
+// UpdateConfig mocks base method.
+func (m *MockAnalyzer) UpdateConfig(u ConfigUpdate) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateConfig", u)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateConfig indicates an expected call of UpdateConfig.
+func (mr *MockAnalyzerMockRecorder) UpdateConfig(u interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateConfig", reflect.TypeOf((*MockAnalyzer)(nil).UpdateConfig), u)
+}
+
+// MockRecorder is a mock of Recorder interface.
+type MockRecorder struct {
+	ctrl     *gomock.Controller
+	recorder *MockRecorderMockRecorder
+}
+
+// MockRecorderMockRecorder is the mock recorder for MockRecorder.
+type MockRecorderMockRecorder struct {
+	mock *MockRecorder
+}
+
+// NewMockRecorder creates a new mock instance.
+func NewMockRecorder(ctrl *gomock.Controller) *MockRecorder {
+	mock := &MockRecorder{ctrl: ctrl}
+	mock.recorder = &MockRecorderMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockRecorder) EXPECT() *MockRecorderMockRecorder {
+	return m.recorder
+}
+
+// Beacon mocks base method.
+func (m *MockRecorder) Beacon(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Beacon", w, r)
+}
+
+// Beacon indicates an expected call of Beacon.
+func (mr *MockRecorderMockRecorder) Beacon(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Beacon", reflect.TypeOf((*MockRecorder)(nil).Beacon), w, r)
+}
+
+// InsertRequest mocks base method.
+func (m *MockRecorder) InsertRequest(r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "InsertRequest", r)
+}
+
+// InsertRequest indicates an expected call of InsertRequest.
+func (mr *MockRecorderMockRecorder) InsertRequest(r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertRequest", reflect.TypeOf((*MockRecorder)(nil).InsertRequest), r)
+}
+
+// InsertRequestContext mocks base method.
+func (m *MockRecorder) InsertRequestContext(ctx context.Context, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "InsertRequestContext", ctx, r)
+}
+
+// InsertRequestContext indicates an expected call of InsertRequestContext.
+func (mr *MockRecorderMockRecorder) InsertRequestContext(ctx, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "InsertRequestContext", reflect.TypeOf((*MockRecorder)(nil).InsertRequestContext), ctx, r)
+}
+
+// Middleware mocks base method.
+func (m *MockRecorder) Middleware(next http.Handler) http.Handler {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Middleware", next)
+	ret0, _ := ret[0].(http.Handler)
+	return ret0
+}
+
+// Middleware indicates an expected call of Middleware.
+func (mr *MockRecorderMockRecorder) Middleware(next interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Middleware", reflect.TypeOf((*MockRecorder)(nil).Middleware), next)
+}
+
+// RecordRoute mocks base method.
+func (m *MockRecorder) RecordRoute(r *http.Request, route string, bytes int64, duration time.Duration) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "RecordRoute", r, route, bytes, duration)
+}
+
+// RecordRoute indicates an expected call of RecordRoute.
+func (mr *MockRecorderMockRecorder) RecordRoute(r, route, bytes, duration interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RecordRoute", reflect.TypeOf((*MockRecorder)(nil).RecordRoute), r, route, bytes, duration)
+}
+
+// RouteMiddleware mocks base method.
+func (m *MockRecorder) RouteMiddleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RouteMiddleware", route)
+	ret0, _ := ret[0].(func(http.Handler) http.Handler)
+	return ret0
+}
+
+// RouteMiddleware indicates an expected call of RouteMiddleware.
+func (mr *MockRecorderMockRecorder) RouteMiddleware(route interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RouteMiddleware", reflect.TypeOf((*MockRecorder)(nil).RouteMiddleware), route)
+}
+
+// ShouldTrack mocks base method.
+func (m *MockRecorder) ShouldTrack(r *http.Request) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ShouldTrack", r)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// ShouldTrack indicates an expected call of ShouldTrack.
+func (mr *MockRecorderMockRecorder) ShouldTrack(r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ShouldTrack", reflect.TypeOf((*MockRecorder)(nil).ShouldTrack), r)
+}
+
+// MockAdmin is a mock of Admin interface.
+type MockAdmin struct {
+	ctrl     *gomock.Controller
+	recorder *MockAdminMockRecorder
+}
+
+// MockAdminMockRecorder is the mock recorder for MockAdmin.
+type MockAdminMockRecorder struct {
+	mock *MockAdmin
+}
+
+// NewMockAdmin creates a new mock instance.
+func NewMockAdmin(ctrl *gomock.Controller) *MockAdmin {
+	mock := &MockAdmin{ctrl: ctrl}
+	mock.recorder = &MockAdminMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockAdmin) EXPECT() *MockAdminMockRecorder {
+	return m.recorder
+}
+
+// AnonymizeNow mocks base method.
+func (m *MockAdmin) AnonymizeNow() (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AnonymizeNow")
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AnonymizeNow indicates an expected call of AnonymizeNow.
+func (mr *MockAdminMockRecorder) AnonymizeNow() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AnonymizeNow", reflect.TypeOf((*MockAdmin)(nil).AnonymizeNow))
+}
+
+// Archive mocks base method.
+func (m *MockAdmin) Archive(month time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Archive", month)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Archive indicates an expected call of Archive.
+func (mr *MockAdminMockRecorder) Archive(month interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Archive", reflect.TypeOf((*MockAdmin)(nil).Archive), month)
+}
+
+// Close mocks base method.
+func (m *MockAdmin) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockAdminMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockAdmin)(nil).Close))
+}
+
+// DeleteVisitor mocks base method.
+func (m *MockAdmin) DeleteVisitor(ipOrHash string, from time.Time, to time.Time) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteVisitor", ipOrHash, from, to)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DeleteVisitor indicates an expected call of DeleteVisitor.
+func (mr *MockAdminMockRecorder) DeleteVisitor(ipOrHash, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteVisitor", reflect.TypeOf((*MockAdmin)(nil).DeleteVisitor), ipOrHash, from, to)
+}
+
+// Erase mocks base method.
+func (m *MockAdmin) Erase(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Erase", w, r)
+}
+
+// Erase indicates an expected call of Erase.
+func (mr *MockAdminMockRecorder) Erase(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Erase", reflect.TypeOf((*MockAdmin)(nil).Erase), w, r)
+}
+
+// Flush mocks base method.
+func (m *MockAdmin) Flush() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Flush")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Flush indicates an expected call of Flush.
+func (mr *MockAdminMockRecorder) Flush() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Flush", reflect.TypeOf((*MockAdmin)(nil).Flush))
+}
+
+// UpdateConfig mocks base method.
+func (m *MockAdmin) UpdateConfig(u ConfigUpdate) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateConfig", u)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateConfig indicates an expected call of UpdateConfig.
+func (mr *MockAdminMockRecorder) UpdateConfig(u interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateConfig", reflect.TypeOf((*MockAdmin)(nil).UpdateConfig), u)
+}
+
+// MockPresenter is a mock of Presenter interface.
+type MockPresenter struct {
+	ctrl     *gomock.Controller
+	recorder *MockPresenterMockRecorder
+}
+
+// MockPresenterMockRecorder is the mock recorder for MockPresenter.
+type MockPresenterMockRecorder struct {
+	mock *MockPresenter
+}
+
+// NewMockPresenter creates a new mock instance.
+func NewMockPresenter(ctrl *gomock.Controller) *MockPresenter {
+	mock := &MockPresenter{ctrl: ctrl}
+	mock.recorder = &MockPresenterMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockPresenter) EXPECT() *MockPresenterMockRecorder {
+	return m.recorder
+}
+
+// Dashboard mocks base method.
+func (m *MockPresenter) Dashboard(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Dashboard", w, r)
+}
+
+// Dashboard indicates an expected call of Dashboard.
+func (mr *MockPresenterMockRecorder) Dashboard(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Dashboard", reflect.TypeOf((*MockPresenter)(nil).Dashboard), w, r)
+}
+
+// Export mocks base method.
+func (m *MockPresenter) Export(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Export", w, r)
+}
+
+// Export indicates an expected call of Export.
+func (mr *MockPresenterMockRecorder) Export(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Export", reflect.TypeOf((*MockPresenter)(nil).Export), w, r)
+}
+
+// ExportRange mocks base method.
+func (m *MockPresenter) ExportRange(w io.Writer, from time.Time, to time.Time) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportRange", w, from, to)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ExportRange indicates an expected call of ExportRange.
+func (mr *MockPresenterMockRecorder) ExportRange(w, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportRange", reflect.TypeOf((*MockPresenter)(nil).ExportRange), w, from, to)
+}
+
+// ExportVisitor mocks base method.
+func (m *MockPresenter) ExportVisitor(ipOrHash string, from time.Time, to time.Time) (VisitorExport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ExportVisitor", ipOrHash, from, to)
+	ret0, _ := ret[0].(VisitorExport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ExportVisitor indicates an expected call of ExportVisitor.
+func (mr *MockPresenterMockRecorder) ExportVisitor(ipOrHash, from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ExportVisitor", reflect.TypeOf((*MockPresenter)(nil).ExportVisitor), ipOrHash, from, to)
+}
+
+// Health mocks base method.
+func (m *MockPresenter) Health(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Health", w, r)
+}
+
+// Health indicates an expected call of Health.
+func (mr *MockPresenterMockRecorder) Health(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Health", reflect.TypeOf((*MockPresenter)(nil).Health), w, r)
+}
+
+// Live mocks base method.
+func (m *MockPresenter) Live(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Live", w, r)
+}
+
+// Live indicates an expected call of Live.
+func (mr *MockPresenterMockRecorder) Live(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Live", reflect.TypeOf((*MockPresenter)(nil).Live), w, r)
+}
+
+// Metrics mocks base method.
+func (m *MockPresenter) Metrics(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "Metrics", w, r)
+}
+
+// Metrics indicates an expected call of Metrics.
+func (mr *MockPresenterMockRecorder) Metrics(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Metrics", reflect.TypeOf((*MockPresenter)(nil).Metrics), w, r)
+}
+
+// ReportNow mocks base method.
+func (m *MockPresenter) ReportNow() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReportNow")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ReportNow indicates an expected call of ReportNow.
+func (mr *MockPresenterMockRecorder) ReportNow() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReportNow", reflect.TypeOf((*MockPresenter)(nil).ReportNow))
+}
+
+// Stats mocks base method.
+func (m *MockPresenter) Stats(date time.Time) (DashboardData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Stats", date)
+	ret0, _ := ret[0].(DashboardData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Stats indicates an expected call of Stats.
+func (mr *MockPresenterMockRecorder) Stats(date interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Stats", reflect.TypeOf((*MockPresenter)(nil).Stats), date)
+}
+
+// StatsJSON mocks base method.
+func (m *MockPresenter) StatsJSON(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "StatsJSON", w, r)
+}
+
+// StatsJSON indicates an expected call of StatsJSON.
+func (mr *MockPresenterMockRecorder) StatsJSON(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatsJSON", reflect.TypeOf((*MockPresenter)(nil).StatsJSON), w, r)
+}
+
+// StatsRange mocks base method.
+func (m *MockPresenter) StatsRange(from time.Time, to time.Time) (DashboardData, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StatsRange", from, to)
+	ret0, _ := ret[0].(DashboardData)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// StatsRange indicates an expected call of StatsRange.
+func (mr *MockPresenterMockRecorder) StatsRange(from, to interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StatsRange", reflect.TypeOf((*MockPresenter)(nil).StatsRange), from, to)
+}
+
+// SubjectAccess mocks base method.
+func (m *MockPresenter) SubjectAccess(w http.ResponseWriter, r *http.Request) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "SubjectAccess", w, r)
+}
+
+// SubjectAccess indicates an expected call of SubjectAccess.
+func (mr *MockPresenterMockRecorder) SubjectAccess(w, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SubjectAccess", reflect.TypeOf((*MockPresenter)(nil).SubjectAccess), w, r)
+}
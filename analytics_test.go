@@ -0,0 +1,289 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestDashboardDuringInserts requests the dashboard while requests are
+// recorded, for go test -race to check today's data is only read under the
+// lock.
+func TestDashboardDuringInserts(t *testing.T) {
+	// Visitors view the same pages over and over, none dropped as repeats.
+	a := newTestAnalytics(t, AnalyticsConfiguration{GroupByURLSegment: 1, EntriesByURLSegment: 1, DuplicateWindowSeconds: -1})
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				a.InsertRequest(visit(fmt.Sprintf("192.0.2.%d:4000", i%50), fmt.Sprintf("/g%d/%d", g, i%10)))
+			}
+		}(g)
+	}
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				rec := httptest.NewRecorder()
+				a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+				if rec.Code != http.StatusOK {
+					t.Errorf("dashboard answered %d", rec.Code)
+					return
+				}
+				a.StatsJSON(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+		}()
+	}
+	wg.Wait()
+	dd, err := a.Stats(a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd.SessionCount != 50 || dd.PageViews != 8*200 {
+		t.Errorf("got %d sessions and %d views, want 50 and %d", dd.SessionCount, dd.PageViews, 8*200)
+	}
+}
+
+// TestBinaryKeysMigratedInMemory reads a day file with raw hash keys, which
+// must be re-keyed without touching the file, and writes it again once the
+// day is in memory.
+func TestBinaryKeysMigratedInMemory(t *testing.T) {
+	dir := t.TempDir()
+	if err := copyTree(filepath.Join("testdata", "format", "v0", "data"), dir); err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2026, time.October, 14, 12, 0, 0, 0, time.UTC)
+	config := corpusConfig
+	config.Directory = dir
+	clock := fixedClock(day.AddDate(0, 0, 1))
+	a := newTestAnalytics(t, config, WithClock(clock))
+	fileName := a.dayFileName(day)
+	before, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := a.readSavedData(day)
+	if len(entries) == 0 {
+		t.Fatal("read no visitors")
+	}
+	for key := range entries {
+		if binaryKey(key) {
+			t.Errorf("key %q wasn't migrated", key)
+		}
+	}
+	after, err := os.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("reading rewrote the day file")
+	}
+
+	// The same day as today is held in memory and written on the next flush.
+	b := newTestAnalytics(t, config, WithClock(fixedClock(day)))
+	if err := b.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	written, err := b.decodeDayFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != len(entries) {
+		t.Errorf("wrote %d visitors, want %d", len(written), len(entries))
+	}
+	for key := range written {
+		if binaryKey(key) {
+			t.Errorf("flush wrote binary key %q", key)
+		}
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file")
+	for _, data := range []string{"first", "second"} {
+		if err := writeFile(name, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		if bs, err := os.ReadFile(name); err != nil || string(bs) != data {
+			t.Fatalf("read %q, %v, want %q", bs, err, data)
+		}
+	}
+	// Renaming over a directory fails, the temporary file mustn't be left.
+	taken := filepath.Join(dir, "taken")
+	if err := os.MkdirAll(filepath.Join(taken, "child"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFile(taken, []byte("data")); err == nil {
+		t.Error("writing over a directory succeeded")
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("%d files, want file and taken only", len(files))
+	}
+}
+
+func TestFlushLeavesNoTemporaryFiles(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{TrackBots: true})
+	a.InsertRequest(visit("192.0.2.1:1234", "/"))
+	bot := visit("192.0.2.2:1234", "/")
+	bot.Header.Set("User-Agent", "Googlebot")
+	a.InsertRequest(bot)
+	for i := 0; i < 2; i++ {
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var names []string
+	filepath.Walk(a.Directory, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			names = append(names, info.Name())
+		}
+		return err
+	})
+	day := a.today()
+	want := map[string]bool{
+		"test" + day:                true,
+		"test" + day + ".summary":   true,
+		"test" + day + ".bots":      true,
+		"test" + day[:7] + ".index": true,
+	}
+	for _, name := range names {
+		if !want[name] {
+			t.Errorf("unexpected file %s", name)
+		}
+		delete(want, name)
+	}
+	for name := range want {
+		t.Errorf("%s wasn't written", name)
+	}
+}
+
+func benchmarkDay(visitors int) map[string][]Action {
+	e := make(map[string][]Action, visitors)
+	for i := 0; i < visitors; i++ {
+		actions := make([]Action, 10)
+		for j := range actions {
+			actions[j] = Action{Page: fmt.Sprintf("/page/%d", j), Query: "q=go", Timestamp: int64(1e12 + j), Bytes: 1024}
+		}
+		e[fmt.Sprintf("%032x", i)] = actions
+	}
+	return e
+}
+
+func TestEncodeDayMatchesMarshal(t *testing.T) {
+	e := benchmarkDay(50)
+	e["\x00\xffbinary\"key<>"] = []Action{{Page: "/<script>", Referrer: "https://example.com/?a=1&b=2"}}
+	e["nil"] = nil
+	for _, day := range []map[string][]Action{{}, e} {
+		want, err := json.Marshal(day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got bytes.Buffer
+		if err := encodeDay(&got, day); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("encodeDay wrote\n%s\nwant\n%s", got.Bytes(), want)
+		}
+	}
+}
+
+// TestFailedWriteKeepsFile checks a write failing midway leaves the old file
+// and no temporary one.
+func TestFailedWriteKeepsFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "day")
+	if _, err := writeDayFile(name, benchmarkDay(5)); err != nil {
+		t.Fatal(err)
+	}
+	old, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := errors.New("disk full")
+	err = writeFileWith(name, func(w io.Writer) error {
+		w.Write(bytes.Repeat([]byte("x"), 10000))
+		return failed
+	})
+	if err != failed {
+		t.Errorf("got %v, want the write's error", err)
+	}
+	if now, err := os.ReadFile(name); err != nil || !bytes.Equal(now, old) {
+		t.Errorf("the file changed: %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 1 {
+		t.Errorf("%d files left, want the day file only", len(files))
+	}
+}
+
+func BenchmarkWriteDayFile(b *testing.B) {
+	e := benchmarkDay(2000)
+	name := filepath.Join(b.TempDir(), "day")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := writeDayFile(name, e); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFlush writes a day of 50000 actions.
+func BenchmarkFlush(b *testing.B) {
+	a := newTestAnalytics(b, AnalyticsConfiguration{MaxActionsPerVisitorPerDay: -1})
+	for i := 0; i < 50000; i++ {
+		a.InsertRequest(visit(fmt.Sprintf("192.0.2.%d:%d", i%250, 1000+i%20), fmt.Sprintf("/page/%d", i%100)))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := a.Flush(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestConcurrentWrites checks writes at the same time, like those of two
+// sites flushing together, never share pooled writers or buffers.
+func TestConcurrentWrites(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	dir := t.TempDir()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			e := benchmarkDay(20 + i)
+			name := filepath.Join(dir, fmt.Sprint(i))
+			for j := 0; j < 5; j++ {
+				if _, err := writeDayFile(name, e); err != nil {
+					t.Error(err)
+					return
+				}
+				got, err := a.decodeDayFile(name)
+				if err != nil || len(got) != len(e) {
+					t.Errorf("read back %d visitors, %v, want %d", len(got), err, len(e))
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,173 @@
+// Package analyticstest provides Analyzers for the tests of code using
+// go-web-analytics, recording into memory without files or timers.
+package analyticstest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	analytics "github.com/JakeKalstad/go-web-analytics"
+)
+
+var (
+	_ analytics.Analyzer = NopAnalyzer{}
+	_ analytics.Analyzer = (*RecordingAnalyzer)(nil)
+)
+
+// NopAnalyzer records nothing. Its handlers answer 204 No Content and its
+// numbers are all zero.
+type NopAnalyzer struct{}
+
+func noContent(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (NopAnalyzer) InsertRequest(r *http.Request) {}
+
+func (NopAnalyzer) InsertRequestContext(ctx context.Context, r *http.Request) {}
+
+func (NopAnalyzer) Middleware(next http.Handler) http.Handler { return next }
+
+func (NopAnalyzer) RouteMiddleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler { return next }
+}
+
+func (NopAnalyzer) RecordRoute(r *http.Request, route string, bytes int64, duration time.Duration) {}
+
+func (NopAnalyzer) Beacon(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) Flush() error { return nil }
+
+func (NopAnalyzer) Close() error { return nil }
+
+func (NopAnalyzer) Archive(month time.Time) error { return nil }
+
+func (NopAnalyzer) AnonymizeNow() (int, error) { return 0, nil }
+
+func (NopAnalyzer) DeleteVisitor(ipOrHash string, from, to time.Time) (int, error) { return 0, nil }
+
+func (NopAnalyzer) Erase(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) UpdateConfig(u analytics.ConfigUpdate) error { return nil }
+
+func (NopAnalyzer) ShouldTrack(r *http.Request) bool { return true }
+
+func (NopAnalyzer) Dashboard(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) StatsJSON(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) Export(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) ExportRange(w io.Writer, from, to time.Time) error { return nil }
+
+func (NopAnalyzer) ExportVisitor(ipOrHash string, from, to time.Time) (analytics.VisitorExport, error) {
+	return analytics.VisitorExport{}, nil
+}
+
+func (NopAnalyzer) SubjectAccess(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) Live(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) Metrics(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) Health(w http.ResponseWriter, r *http.Request) { noContent(w, r) }
+
+func (NopAnalyzer) ReportNow() error { return nil }
+
+func (NopAnalyzer) Stats(date time.Time) (analytics.DashboardData, error) {
+	return analytics.DashboardData{}, nil
+}
+
+func (NopAnalyzer) StatsRange(from, to time.Time) (analytics.DashboardData, error) {
+	return analytics.DashboardData{}, nil
+}
+
+// Request is a request a RecordingAnalyzer recorded. Path is the request's
+// path, Route the route pattern it was recorded under with RouteMiddleware
+// or RecordRoute. Beacon is set for requests to the beacon handler.
+type Request struct {
+	Request  *http.Request
+	Path     string
+	Route    string
+	Bytes    int64
+	Duration time.Duration
+	Beacon   bool
+}
+
+// RecordingAnalyzer is a NopAnalyzer keeping every request it's given to
+// record, e.g. to check a handler's middleware is in place. It's safe for
+// concurrent use, its zero value is ready to use.
+type RecordingAnalyzer struct {
+	NopAnalyzer
+
+	mu       sync.Mutex
+	requests []Request
+}
+
+func (ra *RecordingAnalyzer) record(req Request) {
+	if req.Request == nil || req.Request.URL == nil {
+		return
+	}
+	req.Path = req.Request.URL.Path
+	ra.mu.Lock()
+	ra.requests = append(ra.requests, req)
+	ra.mu.Unlock()
+}
+
+// Requests returns the requests recorded since it was created or Reset, in
+// the order they were recorded.
+func (ra *RecordingAnalyzer) Requests() []Request {
+	ra.mu.Lock()
+	defer ra.mu.Unlock()
+	return append([]Request(nil), ra.requests...)
+}
+
+// Reset forgets the recorded requests.
+func (ra *RecordingAnalyzer) Reset() {
+	ra.mu.Lock()
+	ra.requests = nil
+	ra.mu.Unlock()
+}
+
+func (ra *RecordingAnalyzer) InsertRequest(r *http.Request) {
+	ra.record(Request{Request: r})
+}
+
+// InsertRequestContext records r unless ctx is already done.
+func (ra *RecordingAnalyzer) InsertRequestContext(ctx context.Context, r *http.Request) {
+	if ctx.Err() != nil {
+		return
+	}
+	ra.record(Request{Request: r})
+}
+
+// Middleware records requests once next has served them, like the Analyzer's.
+func (ra *RecordingAnalyzer) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		ra.record(Request{Request: r, Duration: time.Since(start)})
+	})
+}
+
+func (ra *RecordingAnalyzer) RouteMiddleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			ra.record(Request{Request: r, Route: route(r), Duration: time.Since(start)})
+		})
+	}
+}
+
+func (ra *RecordingAnalyzer) RecordRoute(r *http.Request, route string, bytes int64, duration time.Duration) {
+	ra.record(Request{Request: r, Route: route, Bytes: bytes, Duration: duration})
+}
+
+func (ra *RecordingAnalyzer) Beacon(w http.ResponseWriter, r *http.Request) {
+	ra.record(Request{Request: r, Beacon: true})
+	noContent(w, r)
+}
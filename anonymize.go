@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	"net"
+	"strings"
+)
+
+// Modes of IPAnonymization. AnonymizeHash stores visitors under keys hashed
+// with HashIPSecret or HashFunc, which one of them is required for.
+// AnonymizeTruncate zeroes the last octet of IPv4 addresses and the last 80
+// bits of IPv6 ones and drops the port before keying, hashing the truncated
+// IP if there's a HashIPSecret or HashFunc too, so the IP can't be recovered
+// even with the secret. Everyone on the same /24, or /48 for IPv6, is then
+// one visitor: sessions, unique visitors and per-visitor limits count
+// networks rather than people. AnonymizeNone stores the IP and port as they
+// are and can't be combined with hashing. Without a mode keys are hashed if
+// there's a HashIPSecret or HashFunc and the IP is stored otherwise.
+const (
+	AnonymizeHash     = "hash"
+	AnonymizeTruncate = "truncate"
+	AnonymizeNone     = "none"
+)
+
+// truncatedScheme is appended to the scheme of keys of truncated IPs.
+const truncatedScheme = "/truncated"
+
+// IPv4 addresses keep their first 24 bits, IPv6 ones their first 48.
+var (
+	truncatedIPv4 = net.CIDRMask(24, 8*net.IPv4len)
+	truncatedIPv6 = net.CIDRMask(48, 8*net.IPv6len)
+)
+
+// truncateIP returns the truncated IP of addr, an IP with or without a port,
+// dropping the port and any IPv6 zone. Anything that isn't an IP is kept as
+// it is.
+func truncateIP(addr string) string {
+	host := strings.TrimSuffix(strings.TrimPrefix(addrHost(addr), "["), "]")
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return addr
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.Mask(truncatedIPv4).String()
+	}
+	return ip.Mask(truncatedIPv6).String()
+}
@@ -0,0 +1,101 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTruncateIP(t *testing.T) {
+	for addr, want := range map[string]string{
+		"192.0.2.123:4000":                 "192.0.2.0",
+		"192.0.2.123":                      "192.0.2.0",
+		"::ffff:192.0.2.9":                 "192.0.2.0",
+		"[2001:db8:1234:5678::1]:443":      "2001:db8:1234::",
+		"2001:db8:1234:5678:9abc:def0:1:2": "2001:db8:1234::",
+		"[2001:db8:ffff:ffff::1]":          "2001:db8:ffff::",
+		"[fe80::1%eth0]:80":                "fe80::",
+		"::1":                              "::",
+		unknownAddr:                        unknownAddr,
+	} {
+		if got := truncateIP(addr); got != want {
+			t.Errorf("truncated %s to %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestTruncatedVisitors(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{IPAnonymization: AnonymizeTruncate})
+	// Pages differ, repeated views of one network are one visitor's repeats.
+	for _, addr := range []string{"192.0.2.1:4000", "192.0.2.200:5000", "[2001:db8:1::1]:4000", "[2001:db8:1:ffff::2]:5000", "[2001:db8:2::1]:4000"} {
+		a.InsertRequest(visit(addr, "/"+addr))
+	}
+	entries := views(a)
+	for key, want := range map[string]int{"192.0.2.0": 2, "2001:db8:1::": 2, "2001:db8:2::": 1} {
+		if got := len(entries[key]); got != want {
+			t.Errorf("%s has %d actions, want %d", key, got, want)
+		}
+	}
+	if len(entries) != 3 {
+		t.Errorf("got visitors %v, want 3", entries)
+	}
+	for key := range entries {
+		if strings.Contains(key, "4000") || strings.Contains(key, "5000") {
+			t.Errorf("%s kept the port", key)
+		}
+	}
+	if a.hashScheme != SchemeNone+truncatedScheme {
+		t.Errorf("scheme is %q, want %q", a.hashScheme, SchemeNone+truncatedScheme)
+	}
+}
+
+func TestTruncatedHashedVisitors(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{IPAnonymization: AnonymizeTruncate, HashIPSecret: "secret"})
+	a.InsertRequest(visit("[2001:db8:1:2:3:4:5:6]:4000", "/a"))
+	a.InsertRequest(visit("192.0.2.7:4000", "/a"))
+	today := a.today()
+	for _, ip := range []string{"2001:db8:1::", "192.0.2.0"} {
+		if views(a)[SHA256Hash(today, ip, "secret")] == nil {
+			t.Errorf("nothing was stored under the hash of %s", ip)
+		}
+	}
+	if n, err := a.DeleteVisitor("2001:db8:1:9::9", a.now(), a.now()); err != nil || n != 1 {
+		t.Fatalf("deleted from %d days (%v), want 1", n, err)
+	}
+	if len(views(a)) != 1 {
+		t.Errorf("left %d visitors, want 1", len(views(a)))
+	}
+}
+
+// TestTruncatedBots checks bot traffic is keyed by the truncated IP too.
+func TestTruncatedBots(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{IPAnonymization: AnonymizeTruncate, TrackBots: true})
+	r := httptest.NewRequest(http.MethodGet, "/a", nil)
+	r.RemoteAddr = "192.0.2.5:4000"
+	r.Header.Set("User-Agent", "Googlebot")
+	a.InsertRequest(r)
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	if a.BotEntries[a.today()]["192.0.2.0"] == nil {
+		t.Errorf("bots are keyed %v", a.BotEntries[a.today()])
+	}
+}
+
+func TestIPAnonymizationConfig(t *testing.T) {
+	for _, c := range []struct {
+		config AnalyticsConfiguration
+		err    string
+	}{
+		{AnalyticsConfiguration{IPAnonymization: "mask"}, "IPAnonymization must be"},
+		{AnalyticsConfiguration{IPAnonymization: AnonymizeHash}, "needs a HashIPSecret"},
+		{AnalyticsConfiguration{IPAnonymization: AnonymizeNone, HashIPSecret: "secret"}, "can't be combined"},
+		{AnalyticsConfiguration{IPAnonymization: AnonymizeHash, HashIPSecret: "secret"}, ""},
+		{AnalyticsConfiguration{IPAnonymization: AnonymizeNone}, ""},
+	} {
+		_, _, err := visitorHash(c.config)
+		if len(c.err) == 0 && err != nil || len(c.err) > 0 && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%q: got %v, want %q", c.config.IPAnonymization, err, c.err)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package analytics
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Archive bundles the files of every day of month, of every site, into one
+// zip file per site, Directory/YYYY/MM/<Name>YYYY-MM.zip, and deletes them.
+// Days of archived months are read from the bundle as long as there's no
+// loose file for them. The month's days still in memory are written first and
+// then dropped from memory. The current month can't be archived; archiving a
+// month again adds the files written since to its bundle.
+func (a analytics) Archive(month time.Time) error {
+	if a.memoryOnly {
+		return errors.New("nothing to archive with DisablePersistence")
+	}
+	month = month.In(a.location)
+	month = time.Date(month.Year(), month.Month(), 1, 0, 0, 0, 0, a.location)
+	now := a.now()
+	if !month.Before(time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, a.location)) {
+		return fmt.Errorf("can't archive %s, it isn't over yet", month.Format("2006-01"))
+	}
+	for _, s := range a.sites {
+		if err := s.archive(month, nil); err != nil {
+			return fmt.Errorf("archiving %s of %s: %w", month.Format("2006-01"), s.Name, err)
+		}
+	}
+	return nil
+}
+
+// bundleFileName is the bundle the days of month are archived in.
+func (a analytics) bundleFileName(month time.Time) string {
+	month = month.In(a.location)
+	return filepath.Join(a.Directory, month.Format("2006"), month.Format("01"), a.Name+month.Format("2006-01")+".zip")
+}
+
+// evictDays drops the days whose key starts with prefix from memory, with
+// their bots, click maps and schemes, once they're written. The caller holds
+// Mux for writing.
+func (a analytics) evictDays(prefix string) {
+	for k := range a.openDays {
+		if strings.HasPrefix(k, prefix) {
+			a.closeDay(k)
+		}
+	}
+	for k := range a.BotEntries {
+		if strings.HasPrefix(k, prefix) {
+			delete(a.BotEntries, k)
+		}
+	}
+	for k := range a.clickMaps {
+		if strings.HasPrefix(k, prefix) {
+			delete(a.clickMaps, k)
+		}
+	}
+	for k := range a.schemes {
+		if strings.HasPrefix(k, prefix) {
+			delete(a.schemes, k)
+		}
+	}
+}
+
+// archivedFile is a loose file to be bundled: name is its path inside the
+// bundle, DD/<file name>, and path where it's read from.
+type archivedFile struct {
+	name string
+	path string
+}
+
+// archive bundles the loose files of month, leaving the files named in
+// removed out of the bundle.
+func (a analytics) archive(month time.Time, removed map[string]bool) error {
+	a.writeMux.Lock()
+	defer a.writeMux.Unlock()
+	prefix := month.Format("2006-01")
+	if err := a.writeDays(prefix); err != nil {
+		return err
+	}
+	// The month is over, so nothing is recorded for its days anymore.
+	a.Mux.Lock()
+	a.evictDays(prefix)
+	a.Mux.Unlock()
+
+	var loose []archivedFile
+	for d := month; d.Month() == month.Month(); d = d.AddDate(0, 0, 1) {
+		infos, err := ioutil.ReadDir(a.dayDir(d))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		base := a.Name + a.dayKey(d)
+		for _, info := range infos {
+			ext := strings.TrimPrefix(info.Name(), base)
+			if !info.Mode().IsRegular() || len(ext) == len(info.Name()) || len(ext) > 0 && ext[0] != '.' || ext == ".tmp" {
+				continue
+			}
+			loose = append(loose, archivedFile{
+				name: d.Format("02") + "/" + info.Name(),
+				path: filepath.Join(a.dayDir(d), info.Name()),
+			})
+		}
+	}
+	if len(loose) == 0 && len(removed) == 0 {
+		return nil
+	}
+	bundle := a.bundleFileName(month)
+	if err := writeBundle(bundle, loose, removed); err != nil {
+		return err
+	}
+	for _, f := range loose {
+		if err := os.Remove(f.path); err != nil {
+			return err
+		}
+		// Only empty directories are removed, other sites' files stay.
+		os.Remove(filepath.Dir(f.path))
+	}
+	for d := month; d.Month() == month.Month(); d = d.AddDate(0, 0, 1) {
+		a.invalidateDay(d)
+	}
+	return nil
+}
+
+// writeBundle writes the loose files and those of an existing bundle they
+// don't replace, except the removed ones, to a temporary file, checks every
+// file can be read back from it and only then replaces the bundle. On errors the temporary file is
+// removed and nothing else is touched.
+func writeBundle(bundle string, loose []archivedFile, removed map[string]bool) (err error) {
+	tmp := bundle + ".tmp"
+	defer func() {
+		if err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	zw := zip.NewWriter(f)
+	names := map[string]bool{}
+	sizes := map[string]int64{}
+	for _, lf := range loose {
+		names[lf.name] = true
+		n, err := addToBundle(zw, lf)
+		if err != nil {
+			return err
+		}
+		sizes[lf.name] = n
+	}
+	if old, err := zip.OpenReader(bundle); err == nil {
+		defer old.Close()
+		for _, zf := range old.File {
+			if names[zf.Name] || removed[zf.Name] {
+				continue
+			}
+			if err := zw.Copy(zf); err != nil {
+				return err
+			}
+			sizes[zf.Name] = int64(zf.UncompressedSize64)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("reading %s: %w", bundle, err)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	err = f.Close()
+	f = nil
+	if err != nil {
+		return err
+	}
+	if err := verifyBundle(tmp, sizes); err != nil {
+		return err
+	}
+	return os.Rename(tmp, bundle)
+}
+
+// addToBundle copies a loose file into the bundle. Day files are stored as
+// they are since they're compressed already, the small JSON files deflated.
+func addToBundle(zw *zip.Writer, lf archivedFile) (int64, error) {
+	src, err := os.Open(lf.path)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	header := &zip.FileHeader{Name: lf.name, Method: zip.Deflate, Modified: info.ModTime()}
+	if ext := filepath.Ext(lf.name); ext != ".summary" && ext != ".dropped" && ext != ".aggregate" && ext != ".clicks" {
+		header.Method = zip.Store
+	}
+	w, err := zw.CreateHeader(header)
+	if err != nil {
+		return 0, err
+	}
+	return io.Copy(w, src)
+}
+
+// verifyBundle reads every file of a bundle, which checks their checksums,
+// and compares their sizes to the files put in.
+func verifyBundle(bundle string, sizes map[string]int64) error {
+	zr, err := zip.OpenReader(bundle)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	if len(zr.File) != len(sizes) {
+		return fmt.Errorf("verifying %s: %d files instead of %d", bundle, len(zr.File), len(sizes))
+	}
+	for _, zf := range zr.File {
+		r, err := zf.Open()
+		if err != nil {
+			return err
+		}
+		n, err := io.Copy(ioutil.Discard, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("verifying %s: %w", bundle, err)
+		}
+		if want, ok := sizes[zf.Name]; !ok || n != want {
+			return fmt.Errorf("verifying %s: %s has %d bytes instead of %d", bundle, zf.Name, n, want)
+		}
+	}
+	return nil
+}
+
+// bundleEntry returns the bundle a loose file of a day is archived in and
+// its name inside, working for the files of AggregateNames instances too:
+// Directory/YYYY/MM/DD/<name>YYYY-MM-DD.ext is archived in
+// Directory/YYYY/MM/<name>YYYY-MM.zip as DD/<name>YYYY-MM-DD.ext.
+func bundleEntry(fileName string) (string, string, bool) {
+	dayDir := filepath.Dir(fileName)
+	monthDir := filepath.Dir(dayDir)
+	day, month, year := filepath.Base(dayDir), filepath.Base(monthDir), filepath.Base(filepath.Dir(monthDir))
+	base := filepath.Base(fileName)
+	i := strings.Index(base, year+"-"+month+"-"+day)
+	if i < 0 {
+		return "", "", false
+	}
+	bundle := filepath.Join(monthDir, base[:i]+year+"-"+month+".zip")
+	return bundle, day + "/" + base, true
+}
+
+// bundledFile is a file read from a bundle, closing the bundle with it.
+type bundledFile struct {
+	io.ReadCloser
+	zr *zip.ReadCloser
+}
+
+func (f bundledFile) Close() error {
+	err := f.ReadCloser.Close()
+	if zerr := f.zr.Close(); err == nil {
+		err = zerr
+	}
+	return err
+}
+
+// openDayFile opens a file of a day, the loose one if there is one and else
+// the one in the month's bundle. If neither exists the error is the one of
+// the loose file, so os.IsNotExist holds.
+func openDayFile(fileName string) (io.ReadCloser, error) {
+	f, err := os.Open(fileName)
+	if err == nil || !os.IsNotExist(err) {
+		return f, err
+	}
+	bundle, name, ok := bundleEntry(fileName)
+	if !ok {
+		return nil, err
+	}
+	zr, zerr := zip.OpenReader(bundle)
+	if zerr != nil {
+		return nil, err
+	}
+	for _, zf := range zr.File {
+		if zf.Name != name {
+			continue
+		}
+		r, zerr := zf.Open()
+		if zerr != nil {
+			zr.Close()
+			return nil, zerr
+		}
+		return bundledFile{ReadCloser: r, zr: zr}, nil
+	}
+	zr.Close()
+	return nil, err
+}
+
+// readDayFileBytes reads a file of a day like openDayFile opens it.
+func readDayFileBytes(fileName string) ([]byte, error) {
+	f, err := openDayFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// dayFileExists tells whether a file of a day exists, loose or archived.
+func dayFileExists(fileName string) bool {
+	f, err := openDayFile(fileName)
+	if err != nil {
+		return false
+	}
+	f.Close()
+	return true
+}
@@ -0,0 +1,143 @@
+package analytics
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// content holds the built-in dashboard: the templates in content/templates,
+// each defining one or more named templates, and the CSS and JavaScript in
+// content/static they inline with the css and js functions.
+//
+//go:embed content/templates/*.html content/static/*
+var content embed.FS
+
+// HTML is the built-in templates, every file of content/templates joined.
+//
+// Deprecated: it can't be parsed without the package's template functions.
+// Set TemplateDir to change parts of the dashboard instead.
+var HTML = builtinHTML()
+
+func builtinHTML() string {
+	var b strings.Builder
+	names, _ := fs.Glob(content, "content/templates/*.html")
+	for _, name := range names {
+		bs, _ := content.ReadFile(name)
+		b.Write(bs)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// assets reads the built-in files of content, each shadowed by the file with
+// the same path below dir, e.g. templates/summary.html or
+// static/dashboard.css, if there is one.
+type assets struct {
+	dir string
+}
+
+// read returns the file name, a slash separated path below content.
+func (as assets) read(name string) ([]byte, error) {
+	if len(as.dir) > 0 {
+		bs, err := os.ReadFile(filepath.Join(as.dir, filepath.FromSlash(name)))
+		if err == nil || !errors.Is(err, fs.ErrNotExist) {
+			return bs, err
+		}
+	}
+	return content.ReadFile(path.Join("content", name))
+}
+
+// list returns the names of the files in the directory sub of content and
+// below dir, sorted.
+func (as assets) list(sub, pattern string) ([]string, error) {
+	seen := map[string]bool{}
+	builtin, err := fs.Glob(content, path.Join("content", sub, pattern))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range builtin {
+		seen[path.Base(name)] = true
+	}
+	if len(as.dir) > 0 {
+		own, err := filepath.Glob(filepath.Join(as.dir, sub, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range own {
+			seen[filepath.Base(name)] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, path.Join(sub, name))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// parse parses every template and reads every static file, so a broken or
+// missing file is reported when the Analyzer is created. The static files
+// are inlined with {{css "name"}} and {{js "name"}}.
+func (as assets) parse() (*template.Template, template.FuncMap, error) {
+	statics, err := as.list("static", "*")
+	if err != nil {
+		return nil, nil, err
+	}
+	files := map[string]string{}
+	for _, name := range statics {
+		bs, err := as.read(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		files[path.Base(name)] = string(bs)
+	}
+	static := func(name string) (string, error) {
+		s, ok := files[name]
+		if !ok {
+			return "", fmt.Errorf("no static file %q", name)
+		}
+		return s, nil
+	}
+	funcs := template.FuncMap{
+		"css": func(name string) (template.CSS, error) {
+			s, err := static(name)
+			return template.CSS(s), err
+		},
+		"js": func(name string) (template.JS, error) {
+			s, err := static(name)
+			return template.JS(s), err
+		},
+	}
+	for k, f := range templateFuncs {
+		funcs[k] = f
+	}
+
+	names, err := as.list("templates", "*.html")
+	if err != nil {
+		return nil, nil, err
+	}
+	t := template.New("").Funcs(funcs)
+	for _, name := range names {
+		bs, err := as.read(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+		if _, err := t.New(name).Parse(string(bs)); err != nil {
+			return nil, nil, err
+		}
+	}
+	for _, required := range []string{"layout", "login", "visitor", "bots", "heatmap", "report", "partial-summary", "partial-toppages"} {
+		if t.Lookup(required) == nil {
+			return nil, nil, fmt.Errorf("no %q template", required)
+		}
+	}
+	return t, funcs, nil
+}
@@ -0,0 +1,102 @@
+package analytics
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// credentials returns the password a request carries as a bearer token, as
+// the password of HTTP Basic auth or, if allowQuery is set, as ?k=.
+func credentials(r *http.Request, allowQuery bool) (string, bool) {
+	password, _, ok := credentialsFrom(r, allowQuery)
+	return password, ok
+}
+
+// credentialsFrom is credentials also telling whether the password came
+// from ?k=.
+func credentialsFrom(r *http.Request, allowQuery bool) (password string, query, ok bool) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), false, true
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password, false, true
+	}
+	if k := r.URL.Query().Get("k"); allowQuery && len(k) > 0 {
+		return k, true, true
+	}
+	return "", false, false
+}
+
+// warnQueryKey logs, once per instance, that the dashboard was opened with
+// the deprecated ?k=.
+func (a analytics) warnQueryKey() {
+	if atomic.CompareAndSwapInt32(a.queryKeyWarned, 0, 1) {
+		a.log.Warn("the dashboard password was sent as ?k=, which is deprecated and ends up in browser history and access logs; use HTTP Basic auth or a Bearer token instead, or set DisableQueryKey")
+	}
+}
+
+// checkPassword compares in constant time, or asks PasswordVerifier or
+// checks PasswordHash if set.
+func (a analytics) checkPassword(password string) bool {
+	if a.verifyPassword != nil {
+		return a.verifyPassword(password)
+	}
+	if len(a.passwordHash) > 0 {
+		return bcrypt.CompareHashAndPassword([]byte(a.passwordHash), []byte(password)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(password), []byte(a.Password)) == 1
+}
+
+// tooManyLogins answers a request from an IP loginLimiter blocks.
+func (a analytics) tooManyLogins(w http.ResponseWriter, ip string) {
+	a.log.Warn("too many failed logins from %s", ip)
+	w.Header().Set("Retry-After", strconv.Itoa(int(loginWindow/time.Second)))
+	w.WriteHeader(http.StatusTooManyRequests)
+	w.Write(nil)
+}
+
+// authorized checks the session cookie or dashboard password. If neither is
+// valid browsers are shown the login form, unless DisableDashboard is set,
+// other clients get a 401 asking for Basic auth. Without a Password,
+// PasswordHash or PasswordVerifier every request is authorized.
+func (a analytics) authorized(w http.ResponseWriter, r *http.Request) bool {
+	if len(a.Password) == 0 && len(a.passwordHash) == 0 && a.verifyPassword == nil {
+		return true
+	}
+	if a.validSession(r) {
+		return true
+	}
+	password, query, ok := credentialsFrom(r, !a.disableQueryKey)
+	if ok {
+		// Passwords sent with every request are limited like the login form,
+		// or they would be the way around its limit.
+		ip, now := clientIP(r), a.now()
+		if !a.logins.allowed(ip, now) {
+			a.tooManyLogins(w, ip)
+			return false
+		}
+		if a.checkPassword(password) {
+			a.logins.reset(ip)
+			if query {
+				a.warnQueryKey()
+			}
+			return true
+		}
+		a.logins.fail(ip, now)
+	}
+	a.log.Info("unauthorized dashboard request from %s", clientIP(r))
+	if !ok && wantsHTML(r) && !a.disableDashboard {
+		a.loginForm(w, http.StatusUnauthorized, "")
+		return false
+	}
+	w.Header().Set("WWW-Authenticate", `Basic realm="analytics", charset="UTF-8"`)
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write(nil)
+	return false
+}
@@ -0,0 +1,144 @@
+package analytics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// testHash is the bcrypt hash of "hunter2" at the lowest cost.
+const testHash = "$2b$04$abcdefghijklmnopqrstuuV3duMsC0HpUex6N9qapiuOHHWkwRXVm"
+
+func dashboardAs(a *analytics, set func(r *http.Request)) int {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	set(r)
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, r)
+	return rec.Code
+}
+
+func TestPasswordHash(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{PasswordHash: testHash})
+	for _, tc := range []struct {
+		name string
+		set  func(r *http.Request)
+		want int
+	}{
+		{"basic", func(r *http.Request) { r.SetBasicAuth("", "hunter2") }, http.StatusOK},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer hunter2") }, http.StatusOK},
+		{"wrong", func(r *http.Request) { r.SetBasicAuth("", "hunter3") }, http.StatusUnauthorized},
+		{"hash", func(r *http.Request) { r.SetBasicAuth("", testHash) }, http.StatusUnauthorized},
+		{"none", func(r *http.Request) {}, http.StatusUnauthorized},
+	} {
+		if got := dashboardAs(a, tc.set); got != tc.want {
+			t.Errorf("%s: got %d, want %d", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestQueryKeyDeprecated(t *testing.T) {
+	var warnings []string
+	logger := func(args ...interface{}) (int, error) {
+		if line := fmt.Sprint(args...); strings.HasPrefix(line, "WARN") {
+			warnings = append(warnings, line)
+		}
+		return 0, nil
+	}
+	a := newTestAnalytics(t, AnalyticsConfiguration{Password: "hunter2"}, WithLogger(logger))
+	for i := 0; i < 2; i++ {
+		if got := dashboardAs(a, func(r *http.Request) { r.URL.RawQuery = "k=hunter2" }); got != http.StatusOK {
+			t.Fatalf("?k= got %d, want 200", got)
+		}
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "deprecated") {
+		t.Errorf("logged %q, want one deprecation warning", warnings)
+	}
+	a = newTestAnalytics(t, AnalyticsConfiguration{Password: "hunter2", DisableQueryKey: true})
+	if got := dashboardAs(a, func(r *http.Request) { r.URL.RawQuery = "k=hunter2" }); got != http.StatusUnauthorized {
+		t.Errorf("?k= with DisableQueryKey got %d, want 401", got)
+	}
+}
+
+func TestPasswordHashValidated(t *testing.T) {
+	for _, config := range []AnalyticsConfiguration{
+		{PasswordHash: "hunter2"},
+		{PasswordHash: testHash, Password: "hunter2"},
+		{PasswordHash: testHash, PasswordVerifier: func(string) bool { return true }},
+	} {
+		config.ManualFlush = true
+		if _, err := New("test", WithConfiguration(config)); err == nil {
+			t.Errorf("%+v was accepted", config)
+		}
+	}
+}
+
+func TestHeaderCredentialsLimited(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		wrong func(r *http.Request)
+		right func(r *http.Request)
+	}{
+		{"basic",
+			func(r *http.Request) { r.SetBasicAuth("", "hunter3") },
+			func(r *http.Request) { r.SetBasicAuth("", "hunter2") }},
+		{"bearer",
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer hunter3") },
+			func(r *http.Request) { r.Header.Set("Authorization", "Bearer hunter2") }},
+		{"query",
+			func(r *http.Request) { r.URL.RawQuery = "k=hunter3" },
+			func(r *http.Request) { r.URL.RawQuery = "k=hunter2" }},
+	} {
+		a := newTestAnalytics(t, AnalyticsConfiguration{Password: "hunter2"})
+		for i := 0; i < maxLoginFailures; i++ {
+			if got := dashboardAs(a, tc.wrong); got != http.StatusUnauthorized {
+				t.Fatalf("%s: attempt %d got %d, want 401", tc.name, i, got)
+			}
+		}
+		if got := dashboardAs(a, tc.wrong); got != http.StatusTooManyRequests {
+			t.Errorf("%s: got %d after %d failures, want 429", tc.name, got, maxLoginFailures)
+		}
+		if got := dashboardAs(a, tc.right); got != http.StatusTooManyRequests {
+			t.Errorf("%s: the right password got %d while blocked, want 429", tc.name, got)
+		}
+		other := func(r *http.Request) { tc.right(r); r.RemoteAddr = "198.51.100.7:1234" }
+		if got := dashboardAs(a, other); got != http.StatusOK {
+			t.Errorf("%s: another IP got %d, want 200", tc.name, got)
+		}
+	}
+}
+
+func TestWidgetTokenLimited(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		a := newTestAnalytics(t, AnalyticsConfiguration{Password: "hunter2", WidgetToken: "widget", DisableQueryKey: disable})
+		token := func(k string) func(r *http.Request) {
+			return func(r *http.Request) { r.URL.RawQuery = "partial=summary&k=" + k }
+		}
+		for i := 0; i < maxLoginFailures; i++ {
+			if got := dashboardAs(a, token("guess")); got != http.StatusUnauthorized {
+				t.Fatalf("DisableQueryKey %v: attempt %d got %d, want 401", disable, i, got)
+			}
+		}
+		if got := dashboardAs(a, token("widget")); got != http.StatusTooManyRequests {
+			t.Errorf("DisableQueryKey %v: the right token got %d after %d failures, want 429", disable, got, maxLoginFailures)
+		}
+		other := func(r *http.Request) { token("widget")(r); r.RemoteAddr = "198.51.100.7:1234" }
+		if got := dashboardAs(a, other); got != http.StatusOK {
+			t.Errorf("DisableQueryKey %v: another IP got %d, want 200", disable, got)
+		}
+	}
+}
+
+func TestHeaderCredentialsReset(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{Password: "hunter2"})
+	for i := 0; i < 2*maxLoginFailures; i++ {
+		dashboardAs(a, func(r *http.Request) { r.SetBasicAuth("", "hunter3") })
+		if i%2 == 1 {
+			dashboardAs(a, func(r *http.Request) { r.SetBasicAuth("", "hunter2") })
+		}
+	}
+	if got := dashboardAs(a, func(r *http.Request) { r.SetBasicAuth("", "hunter2") }); got != http.StatusOK {
+		t.Errorf("got %d, a success should reset the failures", got)
+	}
+}
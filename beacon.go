@@ -0,0 +1,197 @@
+package analytics
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// Beacon event types. Actions carrying an event are clicks reported by the
+// browser rather than page views and are kept out of the page view counts.
+const (
+	EventOutbound = "outbound"
+	EventDownload = "download"
+)
+
+// maxTargetLength caps the length of a beacon's target URL and page so the
+// endpoint can't be used to stuff arbitrary data into the day files.
+const maxTargetLength = 512
+
+// Beacon records a click event sent by the script returned from BeaconScript.
+// It expects the form values "type" (outbound or download), "url" (the link
+// target) and optionally "page" (the page the click happened on, defaulting
+// to the referer's path). Pings, of type ping, carry "scroll" (the deepest
+// scroll in percent) and "seconds" (the time since the page loaded) instead
+// of "url". Clicks, of type click, carry "x" and "y" (where the click landed
+// as fractions of the page's width and height) and "width" (the viewport's).
+func (a analytics) Beacon(w http.ResponseWriter, r *http.Request) {
+	if !a.consented(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.log.Info("bad beacon: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(nil)
+		return
+	}
+	event := r.Form.Get("type")
+	if event == EventPing {
+		a.beaconPing(w, r)
+		return
+	}
+	if event == EventClick {
+		a.beaconClick(w, r)
+		return
+	}
+	if event != EventOutbound && event != EventDownload {
+		a.log.Info("unknown beacon event %q", event)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(nil)
+		return
+	}
+	target, err := validTarget(r.Form.Get("url"))
+	if err != nil {
+		a.log.Info("bad beacon: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(nil)
+		return
+	}
+
+	a.record(r.Context(), r, Action{Page: beaconPage(r), Event: event, Target: target})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// beaconPing updates the page view a ping is for, see EventPing.
+func (a analytics) beaconPing(w http.ResponseWriter, r *http.Request) {
+	scroll, seconds, err := parsePing(r)
+	if err != nil {
+		a.log.Info("bad beacon: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(nil)
+		return
+	}
+	if !a.ping(r, beaconPage(r), scroll, seconds) {
+		a.log.Debug("no page view to ping")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// beaconClick adds a click to its page's click map, see EventClick.
+func (a analytics) beaconClick(w http.ResponseWriter, r *http.Request) {
+	p, err := parseClick(r)
+	if err != nil {
+		a.log.Info("bad beacon: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(nil)
+		return
+	}
+	if !a.recordClick(r, beaconPage(r), p) {
+		a.log.Debug("click not kept")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// beaconPage is the page a beacon was sent from, the form value "page" or
+// the referer's path.
+func beaconPage(r *http.Request) string {
+	page := r.Form.Get("page")
+	if len(page) == 0 {
+		if ref, err := url.Parse(r.Referer()); err == nil {
+			page = ref.Path
+		}
+	}
+	if len(page) > maxTargetLength {
+		page = page[:maxTargetLength]
+	}
+	return page
+}
+
+// beaconPath is a beacon's page as page views of it are recorded: normalized,
+// templated and capped.
+func (a analytics) beaconPath(page string) string {
+	if a.normalize.enabled() {
+		page, _ = a.normalize.normalize(page, "")
+	}
+	if t := matchTemplate(a.pathTemplates, page); len(t) > 0 {
+		page = t
+	}
+	if len(page) > maxPathLength {
+		page = page[:maxPathLength]
+	}
+	return page
+}
+
+// validTarget only lets absolute http(s) URLs of a reasonable length through.
+func validTarget(raw string) (string, error) {
+	if len(raw) == 0 || len(raw) > maxTargetLength {
+		return "", fmt.Errorf("beacon target must be between 1 and %d characters", maxTargetLength)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", fmt.Errorf("beacon target %q is not an http(s) URL", raw)
+	}
+	return u.String(), nil
+}
+
+// BeaconScript returns a script tag to include in your pages that reports
+// outbound link and download clicks to the Beacon handler mounted at endpoint,
+// and pings it every pingInterval while the page is visible. Every click is
+// sent for the click maps too, those on pages that aren't ClickMapPages are
+// dropped by the handler.
+func BeaconScript(endpoint string) template.HTML {
+	return ConsentBeaconScript(endpoint, "")
+}
+
+// ConsentBeaconScript is BeaconScript only sending clicks once the cookie
+// named consentCookie is set, checked on every click so consent given after
+// the page loaded counts. It reports every click if consentCookie is "".
+func ConsentBeaconScript(endpoint, consentCookie string) template.HTML {
+	return template.HTML(fmt.Sprintf(beaconJS, template.JSEscapeString(endpoint), template.JSEscapeString(consentCookie), pingInterval))
+}
+
+// pingInterval is how often, in milliseconds, the beacon script pings.
+const pingInterval = 15000
+
+const beaconJS = `<script>
+(function () {
+    var endpoint = "%s";
+    var consent = "%s";
+    var downloads = /\.(zip|tar|gz|tgz|rar|7z|dmg|exe|msi|pkg|deb|rpm|pdf|docx?|xlsx?|pptx?|csv|mp3|mp4|iso)$/i;
+    var loaded = Date.now(), depth = 0;
+    function consented() {
+        return !consent || document.cookie.split(/;\s*/).some(function (c) { return c.indexOf(consent + "=") === 0; });
+    }
+    function scrolled() {
+        var height = document.documentElement.scrollHeight - window.innerHeight;
+        depth = Math.max(depth, height > 0 ? Math.min(100, Math.round(window.scrollY * 100 / height)) : 100);
+    }
+    scrolled();
+    window.addEventListener("scroll", scrolled, {passive: true});
+    setInterval(function () {
+        if (!navigator.sendBeacon || document.visibilityState !== "visible" || !consented()) return;
+        var seconds = Math.round((Date.now() - loaded) / 1000);
+        navigator.sendBeacon(endpoint, new URLSearchParams({type: "ping", page: window.location.pathname, scroll: depth, seconds: seconds}));
+    }, %d);
+    document.addEventListener("click", function (e) {
+        if (!navigator.sendBeacon || !consented()) return;
+        var doc = document.documentElement;
+        if (e.detail > 0 && doc.scrollWidth > 0 && doc.scrollHeight > 0) {
+            navigator.sendBeacon(endpoint, new URLSearchParams({type: "click", page: window.location.pathname,
+                x: Math.min(1, e.pageX / doc.scrollWidth).toFixed(3), y: Math.min(1, e.pageY / doc.scrollHeight).toFixed(3), width: window.innerWidth}));
+        }
+        if (!e.target.closest) return;
+        var a = e.target.closest("a[href]");
+        if (!a || (a.protocol !== "http:" && a.protocol !== "https:")) return;
+        var type;
+        if (a.hasAttribute("download") || downloads.test(a.pathname)) type = "download";
+        else if (a.host !== window.location.host) type = "outbound";
+        else return;
+        navigator.sendBeacon(endpoint, new URLSearchParams({type: type, url: a.href, page: window.location.pathname}));
+    }, true);
+})();
+</script>`
@@ -0,0 +1,72 @@
+package analytics
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func withAgent(r *http.Request, ua string) *http.Request {
+	r.Header.Set("User-Agent", ua)
+	return r
+}
+
+func TestMixedCaseBlacklist(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{UserAgentBlackList: []string{"Googlebot", " CURL ", "googlebot"}})
+	if got, want := a.tuning.load().blacklist, []string{"googlebot", "curl"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("blacklist is %q, want %q", got, want)
+	}
+	for _, ua := range []string{
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+		"GOOGLEBOT",
+		"curl/8.4.0",
+	} {
+		a.InsertRequest(withAgent(visit("192.0.2.1:1234", "/"), ua))
+	}
+	a.InsertRequest(visit("192.0.2.2:1234", "/"))
+	if got := a.metrics.blacklisted; got != 3 {
+		t.Errorf("%d requests blacklisted, want 3", got)
+	}
+	if got := a.metrics.recorded; got != 1 {
+		t.Errorf("%d requests recorded, want 1", got)
+	}
+}
+
+func BenchmarkInsertRequestBlacklist(b *testing.B) {
+	list := make([]string, 50)
+	for i := range list {
+		list[i] = fmt.Sprintf("Crawler%d", i)
+	}
+	a := newTestAnalytics(b, AnalyticsConfiguration{UserAgentBlackList: list, DisablePersistence: true})
+	r := withAgent(visit("192.0.2.1:1234", "/"), "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0")
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		a.InsertRequest(r)
+	}
+}
+
+func TestDefaultBlacklist(t *testing.T) {
+	const googlebot = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	for _, tc := range []struct {
+		name     string
+		config   AnalyticsConfiguration
+		want     []string
+		recorded int64
+	}{
+		{"nil", AnalyticsConfiguration{}, DefaultUserAgentBlacklist, 0},
+		{"empty", AnalyticsConfiguration{UserAgentBlackList: []string{}}, DefaultUserAgentBlacklist, 0},
+		{"own", AnalyticsConfiguration{UserAgentBlackList: []string{"curl"}}, []string{"curl"}, 1},
+		{"disabled", AnalyticsConfiguration{DisableBotFiltering: true}, nil, 1},
+		{"disabled with list", AnalyticsConfiguration{UserAgentBlackList: []string{"googlebot"}, DisableBotFiltering: true}, nil, 1},
+	} {
+		if got := blacklist(tc.config); fmt.Sprint(got) != fmt.Sprint(tc.want) {
+			t.Errorf("%s: blacklist is %q, want %q", tc.name, got, tc.want)
+		}
+		a := newTestAnalytics(t, tc.config)
+		a.InsertRequest(withAgent(visit("192.0.2.1:1234", "/"), googlebot))
+		if got := a.metrics.recorded; got != tc.recorded {
+			t.Errorf("%s: %d Googlebot requests recorded, want %d", tc.name, got, tc.recorded)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package analytics
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// botListLimit caps the user agent and path tables of the bot view.
+const botListLimit = 50
+
+// BotData is what the bot traffic view (?bots=1) renders. Tracked is false
+// when TrackBots is off and nothing is being recorded.
+type BotData struct {
+	Date       string       `json:"date"`
+	Tracked    bool         `json:"tracked"`
+	Sessions   int          `json:"sessions"`
+	Requests   int          `json:"requests"`
+	UserAgents []NamedCount `json:"user_agents"`
+	Paths      []NamedCount `json:"paths"`
+	Nonce      string       `json:"-"`
+}
+
+// NamedCount is one row of the bot view's user agent or path table.
+type NamedCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func (a analytics) botFileName(td time.Time) string {
+	return a.dayFileName(td) + ".bots"
+}
+
+// loadBots reads a day's saved bot actions back into memory.
+func (a analytics) loadBots(td time.Time) {
+	ts := a.dayKey(td)
+	a.BotEntries[ts] = a.readDayFile(a.botFileName(td))
+	for _, actions := range a.BotEntries[ts] {
+		a.botActions[ts] += len(actions)
+	}
+}
+
+// insertBot records a blacklisted request, kept apart from the visitor data
+// so it never shows up in the regular numbers. Once MaxBotActionsPerDay is
+// reached the rest of the day's bot traffic is dropped.
+func (a analytics) insertBot(ip string, act Action) {
+	ts := a.dayKey(time.UnixMilli(act.Timestamp))
+	if a.maxBotActions > 0 && a.botActions[ts] >= a.maxBotActions {
+		return
+	}
+	if a.BotEntries[ts] == nil {
+		if a.memoryOnly {
+			for k := range a.BotEntries {
+				delete(a.BotEntries, k)
+				delete(a.botActions, k)
+			}
+		}
+		a.loadBots(time.UnixMilli(act.Timestamp))
+	}
+	key := a.visitorKey(ts, ip)
+	a.BotEntries[ts][key] = append(a.BotEntries[ts][key], act)
+	a.botActions[ts]++
+}
+
+func (a analytics) botDashboard(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	date, _, ok := a.requestRange(w, r)
+	if !ok {
+		return
+	}
+	var data map[string][]Action
+	a.Mux.RLock()
+	_, inMemory := a.BotEntries[a.dayKey(date)]
+	a.Mux.RUnlock()
+	if inMemory {
+		data = a.snapshot(a.BotEntries, a.dayKey(date))
+	} else {
+		data = a.readDayFile(a.botFileName(date))
+	}
+
+	bd := BotData{Date: a.dayKey(date), Tracked: a.trackBots, Sessions: len(data)}
+	agents, paths := map[string]int{}, map[string]int{}
+	for _, actions := range data {
+		for _, act := range actions {
+			bd.Requests++
+			agents[act.UserAgent]++
+			paths[act.Page]++
+		}
+	}
+	bd.UserAgents = rankCounts(agents, botListLimit)
+	bd.Paths = rankCounts(paths, botListLimit)
+	bd.Nonce = a.withCSP(w)
+	a.render(w, "bots", bd)
+}
+
+// rankCounts orders counts from most to least, keeping at most limit.
+func rankCounts(counts map[string]int, limit int) []NamedCount {
+	ranked := make([]NamedCount, 0, len(counts))
+	for name, n := range counts {
+		ranked = append(ranked, NamedCount{Name: name, Count: n})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Name < ranked[j].Name
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
@@ -0,0 +1,96 @@
+package analytics
+
+import (
+	"container/list"
+	"sync"
+)
+
+// lru is a small, concurrency safe, least recently used cache. Besides max
+// entries it can hold at most maxBytes of values added with a size.
+type lru struct {
+	mu       sync.Mutex
+	max      int
+	maxBytes int64
+	bytes    int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type lruEntry struct {
+	key   string
+	value interface{}
+	size  int64
+}
+
+func newLRU(max int) *lru {
+	return &lru{max: max, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (c *lru) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*lruEntry).value, true
+	}
+	return nil, false
+}
+
+func (c *lru) add(key string, value interface{}) {
+	c.addSized(key, value, 0)
+}
+
+// addSized adds a value taking up size bytes, evicting the least recently
+// used entries until the cache fits maxBytes again. A value bigger than
+// maxBytes on its own isn't kept.
+func (c *lru) addSized(key string, value interface{}, size int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.ll.MoveToFront(e)
+		entry := e.Value.(*lruEntry)
+		c.bytes += size - entry.size
+		entry.value, entry.size = value, size
+	} else {
+		c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, size: size})
+		c.bytes += size
+	}
+	for c.ll.Len() > 0 && (c.max > 0 && c.ll.Len() > c.max || c.maxBytes > 0 && c.bytes > c.maxBytes) {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+func (c *lru) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *lru) removeElement(e *list.Element) {
+	entry := e.Value.(*lruEntry)
+	c.ll.Remove(e)
+	delete(c.items, entry.key)
+	c.bytes -= entry.size
+}
+
+// purge drops every entry.
+func (c *lru) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = map[string]*list.Element{}
+	c.bytes = 0
+}
+
+// removeIf drops every entry whose key matches.
+func (c *lru) removeIf(match func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.items {
+		if match(key) {
+			c.removeElement(e)
+		}
+	}
+}
@@ -0,0 +1,215 @@
+package analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// EventClick is the beacon type of the clicks on ClickMapPages, recorded in
+// the page's click map rather than as actions of the visitor.
+const EventClick = "click"
+
+// defaultMaxClicksPerPage is how many clicks a page keeps a day unless
+// MaxClicksPerPagePerDay says otherwise.
+const defaultMaxClicksPerPage = 5000
+
+// viewportWidths are the lower bounds of the viewport width buckets clicks
+// are recorded in, in CSS pixels.
+var viewportWidths = []int{0, 576, 768, 992, 1200, 1400}
+
+// maxViewportWidth is the widest viewport a click is accepted from.
+const maxViewportWidth = 100000
+
+// ClickPoint is where a click landed, X and Y as fractions of the page's
+// width and height, and the lower bound of the viewport width bucket it was
+// made in.
+type ClickPoint struct {
+	X        float64 `json:"x"`
+	Y        float64 `json:"y"`
+	Viewport int     `json:"viewport"`
+}
+
+// MarshalJSON keeps click maps compact on disk, as [x, y, viewport].
+func (p clickPoint) MarshalJSON() ([]byte, error) {
+	return json.Marshal([3]float64{p.X, p.Y, float64(p.Viewport)})
+}
+
+func (p *clickPoint) UnmarshalJSON(bs []byte) error {
+	var v [3]float64
+	if err := json.Unmarshal(bs, &v); err != nil {
+		return err
+	}
+	*p = clickPoint{X: v[0], Y: v[1], Viewport: int(v[2])}
+	return nil
+}
+
+// clickPoint is a ClickPoint as it's stored.
+type clickPoint ClickPoint
+
+// ClickMap is what the ?clickmap= endpoint returns: the clicks recorded on a
+// page on a day. Full is set when the day's cap was reached and later
+// clicks weren't kept.
+type ClickMap struct {
+	Page   string       `json:"page"`
+	Date   string       `json:"date"`
+	Clicks []ClickPoint `json:"clicks"`
+	Full   bool         `json:"full,omitempty"`
+}
+
+// clickFileName is the file holding a day's click maps, the clicks of each
+// page.
+func (a analytics) clickFileName(td time.Time) string {
+	return a.dayFileName(td) + ".clicks"
+}
+
+// parseClick reads the position and viewport width of a click, rounding the
+// position to a thousandth and the width down to its bucket.
+func parseClick(r *http.Request) (ClickPoint, error) {
+	x, errX := strconv.ParseFloat(r.Form.Get("x"), 64)
+	y, errY := strconv.ParseFloat(r.Form.Get("y"), 64)
+	if errX != nil || errY != nil || !(x >= 0 && x <= 1 && y >= 0 && y <= 1) {
+		return ClickPoint{}, fmt.Errorf("click x and y must be between 0 and 1, got %q and %q", r.Form.Get("x"), r.Form.Get("y"))
+	}
+	width, err := strconv.Atoi(r.Form.Get("width"))
+	if err != nil || width < 1 || width > maxViewportWidth {
+		return ClickPoint{}, fmt.Errorf("click width must be between 1 and %d, got %q", maxViewportWidth, r.Form.Get("width"))
+	}
+	p := ClickPoint{X: math.Round(x*1000) / 1000, Y: math.Round(y*1000) / 1000}
+	for _, w := range viewportWidths {
+		if width >= w {
+			p.Viewport = w
+		}
+	}
+	return p, nil
+}
+
+// recordClick adds a click to page's click map of today, unless page isn't
+// one of ClickMapPages or its map is full, reporting whether it was kept.
+func (a analytics) recordClick(r *http.Request, page string, p ClickPoint) bool {
+	if atomic.LoadInt32(a.closed) == 1 {
+		return false
+	}
+	if a.siteResolver != nil {
+		a = a.site(a.siteResolver(r))
+	}
+	page = a.beaconPath(page)
+	if !a.clickMapped(page) {
+		return false
+	}
+	ts := a.today()
+	a.Mux.Lock()
+	defer a.Mux.Unlock()
+	if a.clickMaps[ts] == nil {
+		if a.memoryOnly {
+			for k := range a.clickMaps {
+				delete(a.clickMaps, k)
+			}
+		}
+		a.clickMaps[ts] = a.readClicks(a.now())
+	}
+	clicks := a.clickMaps[ts][page]
+	if len(clicks) >= a.maxClicks {
+		return false
+	}
+	a.clickMaps[ts][page] = append(clicks, clickPoint(p))
+	return true
+}
+
+// clickMapped tells whether clicks on page are recorded.
+func (a analytics) clickMapped(page string) bool {
+	for _, p := range a.clickPages {
+		if matchPattern(p, page) {
+			return true
+		}
+	}
+	return false
+}
+
+// readClicks reads the click maps of a day from disk.
+func (a analytics) readClicks(td time.Time) map[string][]clickPoint {
+	clicks := map[string][]clickPoint{}
+	if a.memoryOnly {
+		return clicks
+	}
+	bs, err := readDayFileBytes(a.clickFileName(td))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.log.Error("reading click maps: %v", err)
+		}
+		return clicks
+	}
+	if err := json.Unmarshal(bs, &clicks); err != nil {
+		a.log.Error("reading click maps: %v", err)
+	}
+	return clicks
+}
+
+// snapshotClicks copies the click maps of the days starting with prefix so
+// they can be written without holding Mux. Clicks are only ever appended, so
+// the copies can share them.
+func snapshotClicks(days map[string]map[string][]clickPoint, prefix string) map[string]map[string][]clickPoint {
+	snap := map[string]map[string][]clickPoint{}
+	for day, pages := range days {
+		if !strings.HasPrefix(day, prefix) {
+			continue
+		}
+		snap[day] = make(map[string][]clickPoint, len(pages))
+		for page, clicks := range pages {
+			snap[day][page] = clicks
+		}
+	}
+	return snap
+}
+
+func (a analytics) writeClicks(td time.Time, clicks map[string][]clickPoint) error {
+	bs, err := json.Marshal(clicks)
+	if err != nil {
+		return err
+	}
+	return writeFile(a.clickFileName(td), bs)
+}
+
+// dayClicks returns the clicks of page on a day, from memory if the day's
+// click maps are there.
+func (a analytics) dayClicks(date time.Time, page string) []ClickPoint {
+	a.Mux.RLock()
+	stored, inMemory := a.clickMaps[a.dayKey(date)]
+	var clicks []clickPoint
+	if inMemory {
+		clicks = stored[page]
+	}
+	a.Mux.RUnlock()
+	if !inMemory {
+		clicks = a.readClicks(date)[page]
+	}
+	points := make([]ClickPoint, len(clicks))
+	for i, p := range clicks {
+		points[i] = ClickPoint(p)
+	}
+	return points
+}
+
+// clickMap serves the clicks of ?clickmap= on ?date= as JSON.
+func (a analytics) clickMap(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	date, _, ok := a.requestRange(w, r)
+	if !ok {
+		return
+	}
+	page := r.URL.Query().Get("clickmap")
+	cm := ClickMap{Page: page, Date: a.dayKey(date), Clicks: a.dayClicks(date, page)}
+	cm.Full = len(cm.Clicks) >= a.maxClicks
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(cm); err != nil {
+		a.log.Info("writing click map: %v", err)
+	}
+}
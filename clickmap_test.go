@@ -0,0 +1,106 @@
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// clicked sends a click on page from addr and returns the status it got.
+func clicked(a *analytics, addr, page, x, y, width string) int {
+	form := url.Values{"type": {EventClick}, "page": {page}, "x": {x}, "y": {y}, "width": {width}}
+	r := httptest.NewRequest(http.MethodPost, "/beacon", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = addr
+	rec := httptest.NewRecorder()
+	a.Beacon(rec, r)
+	return rec.Code
+}
+
+// clickMapOf fetches the click map of page on day with the password.
+func clickMapOf(t *testing.T, a *analytics, page string, day time.Time) ClickMap {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/?clickmap="+url.QueryEscape(page)+"&date="+a.dayKey(day), nil)
+	r.SetBasicAuth("", "pw")
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("the click map answered %d", rec.Code)
+	}
+	var cm ClickMap
+	if err := json.Unmarshal(rec.Body.Bytes(), &cm); err != nil {
+		t.Fatal(err)
+	}
+	return cm
+}
+
+func TestClickMap(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{
+		Directory:              t.TempDir(),
+		Password:               "pw",
+		ClickMapPages:          []string{"/pricing", "/docs/*"},
+		MaxClicksPerPagePerDay: 3,
+	}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	for _, c := range []struct{ x, y, width string }{{"0.12345", "0.5", "1280"}, {"1", "0", "320"}, {"0", "1", "800"}, {"0.5", "0.5", "1024"}} {
+		if code := clicked(a, "192.0.2.1:4000", "/pricing", c.x, c.y, c.width); code != http.StatusNoContent {
+			t.Fatalf("a click answered %d", code)
+		}
+	}
+	clicked(a, "192.0.2.1:4000", "/docs/start", "0.2", "0.3", "1500")
+	clicked(a, "192.0.2.1:4000", "/", "0.2", "0.3", "1500")
+	for _, c := range []struct{ x, y, width string }{{"1.5", "0.5", "1280"}, {"0.5", "-0.1", "1280"}, {"0.5", "0.5", "0"}, {"NaN", "0.5", "1280"}} {
+		if code := clicked(a, "192.0.2.1:4000", "/pricing", c.x, c.y, c.width); code != http.StatusBadRequest {
+			t.Errorf("click %+v answered %d", c, code)
+		}
+	}
+	if actions := views(a)["192.0.2.1:4000"]; len(actions) != 0 {
+		t.Errorf("clicks were recorded as actions %+v", actions)
+	}
+
+	want := ClickMap{Page: "/pricing", Date: a.dayKey(day), Full: true, Clicks: []ClickPoint{
+		{X: 0.123, Y: 0.5, Viewport: 1200},
+		{X: 1, Y: 0, Viewport: 0},
+		{X: 0, Y: 1, Viewport: 768},
+	}}
+	if got := clickMapOf(t, a, "/pricing", day); !reflect.DeepEqual(got, want) {
+		t.Errorf("today got %+v, want %+v", got, want)
+	}
+	if got := clickMapOf(t, a, "/", day); len(got.Clicks) != 0 {
+		t.Errorf("a page without a click map got %+v", got.Clicks)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a = newTestAnalytics(t, config, WithClock(clock))
+	if got := clickMapOf(t, a, "/pricing", day); !reflect.DeepEqual(got, want) {
+		t.Errorf("the saved day got %+v, want %+v", got, want)
+	}
+	if got := clickMapOf(t, a, "/docs/start", day).Clicks; !reflect.DeepEqual(got, []ClickPoint{{X: 0.2, Y: 0.3, Viewport: 1400}}) {
+		t.Errorf("the saved docs page got %+v", got)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?clickmap=/pricing&date="+a.dayKey(day), nil))
+	if rec.Code == http.StatusOK {
+		t.Error("the click map was served without the password")
+	}
+}
+
+func TestBeaconScriptClicks(t *testing.T) {
+	script := string(BeaconScript("/beacon"))
+	for _, s := range []string{`type: "click"`, "e.pageX / doc.scrollWidth", "width: window.innerWidth"} {
+		if !strings.Contains(script, s) {
+			t.Errorf("the script has no %s", s)
+		}
+	}
+}
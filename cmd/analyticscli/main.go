@@ -0,0 +1,175 @@
+// Command analyticscli prints the numbers of day files written by
+// go-web-analytics without running the server, e.g. from a synced copy of
+// the Directory.
+//
+//	analyticscli -dir logs -name example.com -date 2021-03-04
+//	analyticscli -dir logs -name example.com -from 2021-03-01 -to 2021-03-07 -format json
+//	analyticscli -dir logs -name example.com -date 2021-03-04 -csv > 2021-03-04.csv
+//	analyticscli -dir logs -name example.com -archive 2021-02
+//
+// Days without a file count as days without visitors. -archive bundles the
+// files of a past month into one, see Analyzer.Archive, instead of printing.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	analytics "github.com/JakeKalstad/go-web-analytics"
+)
+
+const dayLayout = "2006-01-02"
+
+// page is a URL of the top pages table with the group it belongs to.
+type page struct {
+	group string
+	analytics.URLHit
+}
+
+func main() {
+	dir := flag.String("dir", "", "directory the day files are in, the Directory of the server")
+	name := flag.String("name", "", "site name the day files are named after")
+	date := flag.String("date", "", "day to report on, YYYY-MM-DD, today by default")
+	from := flag.String("from", "", "first day of a range, YYYY-MM-DD")
+	to := flag.String("to", "", "last day of a range, YYYY-MM-DD")
+	timezone := flag.String("timezone", "", "IANA timezone the days are in, the Timezone of the server")
+	format := flag.String("format", "table", "output format: table, json or csv")
+	csvOut := flag.Bool("csv", false, "print every action as CSV like the web export, same as -format csv")
+	top := flag.Int("top", 10, "how many pages the table lists")
+	archive := flag.String("archive", "", "month to bundle the files of, YYYY-MM, instead of printing")
+	flag.Parse()
+
+	if *csvOut {
+		*format = "csv"
+	}
+	if err := run(*dir, *name, *date, *from, *to, *timezone, *format, *archive, *top); err != nil {
+		fmt.Fprintln(os.Stderr, "analyticscli:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, name, date, from, to, timezone, format, archive string, top int) error {
+	if len(dir) == 0 || len(name) == 0 {
+		return fmt.Errorf("-dir and -name are required")
+	}
+	if info, err := os.Stat(dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	if format != "table" && format != "json" && format != "csv" {
+		return fmt.Errorf("unknown -format %q, expected table, json or csv", format)
+	}
+	loc := time.Local
+	if len(timezone) > 0 {
+		var err error
+		if loc, err = time.LoadLocation(timezone); err != nil {
+			return err
+		}
+	}
+	var month time.Time
+	if len(archive) > 0 {
+		var err error
+		if month, err = time.ParseInLocation("2006-01", archive, loc); err != nil {
+			return fmt.Errorf("-archive: %w", err)
+		}
+	}
+	start, end, err := days(date, from, to, loc)
+	if err != nil {
+		return err
+	}
+
+	an, err := analytics.New(name,
+		analytics.WithConfiguration(analytics.AnalyticsConfiguration{Timezone: timezone, ManualFlush: true}),
+		analytics.WithDirectory(dir),
+		analytics.WithLogger(func(a ...interface{}) (int, error) { return fmt.Fprintln(os.Stderr, a...) }),
+	)
+	if err != nil {
+		return err
+	}
+	if len(archive) > 0 {
+		return an.Archive(month)
+	}
+	if format == "csv" {
+		return an.ExportRange(os.Stdout, start, end)
+	}
+	stats, err := an.StatsRange(start, end)
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(stats)
+	}
+	return printTable(stats, top)
+}
+
+// days returns the range picked with -from and -to, or the single -date.
+func days(date, from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	if len(from) > 0 || len(to) > 0 {
+		if len(from) == 0 || len(to) == 0 {
+			return time.Time{}, time.Time{}, fmt.Errorf("-from and -to go together")
+		}
+		f, err := time.ParseInLocation(dayLayout, from, loc)
+		if err != nil {
+			return f, f, fmt.Errorf("invalid -from %q, expected YYYY-MM-DD", from)
+		}
+		t, err := time.ParseInLocation(dayLayout, to, loc)
+		if err != nil {
+			return f, t, fmt.Errorf("invalid -to %q, expected YYYY-MM-DD", to)
+		}
+		return f, t, nil
+	}
+	if len(date) == 0 {
+		now := time.Now().In(loc)
+		return now, now, nil
+	}
+	d, err := time.ParseInLocation(dayLayout, date, loc)
+	if err != nil {
+		return d, d, fmt.Errorf("invalid -date %q, expected YYYY-MM-DD", date)
+	}
+	return d, d, nil
+}
+
+func printTable(stats analytics.DashboardData, top int) error {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	period := stats.Date
+	if len(stats.To) > 0 {
+		period += " – " + stats.To
+	}
+	fmt.Fprintf(tw, "Site\t%s\n", stats.Site)
+	fmt.Fprintf(tw, "Date\t%s\n", period)
+	fmt.Fprintf(tw, "Visitors\t%d\n", stats.SessionCount)
+	if stats.UniqueVisitors > 0 {
+		fmt.Fprintf(tw, "Unique visitors\t~%d (±%.1f%%)\n", stats.UniqueVisitors, stats.UniqueVisitorsError)
+	}
+	fmt.Fprintf(tw, "Page views\t%d\n", stats.PageViews)
+	fmt.Fprintf(tw, "Bytes\t%d\n\n", stats.Bytes)
+
+	var pages []page
+	for _, g := range stats.URLHits {
+		for _, u := range g.URLs {
+			pages = append(pages, page{group: g.Group, URLHit: u})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Views != pages[j].Views {
+			return pages[i].Views > pages[j].Views
+		}
+		return pages[i].URL < pages[j].URL
+	})
+	if len(pages) > top {
+		pages = pages[:top]
+	}
+	fmt.Fprintln(tw, "Views\tVisitors\tURL")
+	for _, p := range pages {
+		fmt.Fprintf(tw, "%d\t%d\t%s\n", p.Views, p.Visitors, p.URL)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,86 @@
+package analytics
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// todayMaxAge is how long browsers may reuse a dashboard including today,
+// long enough for an auto-refreshing tab without hiding new visits for long.
+const todayMaxAge = 10 * time.Second
+
+// acceptsGzip tells whether the client accepts gzip encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding := strings.TrimSpace(part)
+		params := ""
+		if i := strings.Index(coding, ";"); i >= 0 {
+			coding, params = strings.TrimSpace(coding[:i]), coding[i+1:]
+		}
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if q := strings.TrimSpace(params); strings.HasPrefix(q, "q=") {
+			if v, err := strconv.ParseFloat(q[2:], 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// writeCompressed writes body, gzip encoded if the client accepts it.
+func (a analytics) writeCompressed(w http.ResponseWriter, r *http.Request, contentType string, body []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		w.Write(body)
+		return
+	}
+	w.Header().Set("Content-Encoding", "gzip")
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(body); err != nil {
+		a.log.Info("writing dashboard: %v", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		a.log.Info("writing dashboard: %v", err)
+	}
+}
+
+// notModified sets the caching headers of a dashboard ending with to,
+// reporting whether it answered 304 Not Modified. Days before today don't
+// change, so their dashboards get an ETag of the site and query, and of when
+// the Analyzer started and of UpdateConfig calls since in case its
+// configuration changed. Those including
+// today may only be reused for todayMaxAge.
+func (a analytics) notModified(w http.ResponseWriter, r *http.Request, to time.Time) bool {
+	if a.dayKey(to) >= a.today() {
+		w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(todayMaxAge/time.Second)))
+		return false
+	}
+	loggedIn := "0"
+	if a.validSession(r) {
+		loggedIn = "1"
+	}
+	started := strconv.FormatInt(a.health.started.UnixNano(), 36)
+	generation := strconv.FormatUint(a.tuning.load().generation, 36)
+	sum := sha256.Sum256([]byte(a.Name + "\x00" + r.URL.RawQuery + "\x00" + loggedIn + "\x00" + started + "\x00" + generation))
+	etag := `W/"` + hex.EncodeToString(sum[:12]) + `"`
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", etag)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == strings.TrimPrefix(etag, "W/") {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,124 @@
+package analytics
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// defaultWriteScheduleSeconds is how often data is written to disk when
+// WriteScheduleSeconds isn't set.
+const defaultWriteScheduleSeconds = 60
+
+// validate checks a configuration before anything is created from it and
+// fills in the defaults of unset values. Directory is created if it doesn't
+// exist yet, unless DisablePersistence is set and it isn't used at all.
+func validate(config AnalyticsConfiguration) (AnalyticsConfiguration, error) {
+	if len(config.PasswordHash) > 0 {
+		if len(config.Password) > 0 || config.PasswordVerifier != nil {
+			return config, fmt.Errorf("PasswordHash can't be combined with Password or PasswordVerifier")
+		}
+		if _, err := bcrypt.Cost([]byte(config.PasswordHash)); err != nil {
+			return config, fmt.Errorf("PasswordHash must be a bcrypt hash, e.g. from htpasswd -nB")
+		}
+	}
+	if err := validSiteName("Name", config.Name); err != nil {
+		return config, err
+	}
+	for _, name := range config.Sites {
+		if err := validSiteName("Sites", name); err != nil {
+			return config, err
+		}
+	}
+	for _, name := range config.AggregateNames {
+		if err := validSiteName("AggregateNames", name); err != nil {
+			return config, err
+		}
+		for _, site := range config.Sites {
+			if name == site {
+				return config, fmt.Errorf("AggregateNames %q is one of Sites, their files would be merged", name)
+			}
+		}
+	}
+	if len(config.AggregateNames) > 0 && config.DisablePersistence {
+		return config, fmt.Errorf("AggregateNames reads the files of other instances, it can't be used with DisablePersistence")
+	}
+	if config.AnonymizeAfterDays < 0 {
+		return config, fmt.Errorf("AnonymizeAfterDays can't be negative, got %d", config.AnonymizeAfterDays)
+	}
+	if config.AnonymizeAfterDays > 0 && len(config.AggregateNames) > 0 {
+		return config, fmt.Errorf("AnonymizeAfterDays can't be combined with AggregateNames, merged days are read from the instances' day files")
+	}
+	if config.ReturningLookbackDays < 0 || config.ReturningLookbackDays > maxReturningLookback {
+		return config, fmt.Errorf("ReturningLookbackDays must be between 0 and %d, got %d", maxReturningLookback, config.ReturningLookbackDays)
+	}
+	if config.ReverseDNSPerSecond < 0 {
+		return config, fmt.Errorf("ReverseDNSPerSecond can't be negative, got %d", config.ReverseDNSPerSecond)
+	}
+	if len(config.ASNDatabase) > 0 && config.ASNLookup != nil {
+		return config, fmt.Errorf("ASNDatabase and ASNLookup can't both be set")
+	}
+	if len(config.IgnoreASNs) > 0 && len(config.ASNDatabase) == 0 && config.ASNLookup == nil {
+		return config, fmt.Errorf("IgnoreASNs needs ASNDatabase or ASNLookup")
+	}
+	if config.MaxClicksPerPagePerDay < 0 {
+		return config, fmt.Errorf("MaxClicksPerPagePerDay can't be negative, got %d", config.MaxClicksPerPagePerDay)
+	}
+	if config.WriteScheduleSeconds < 0 {
+		return config, fmt.Errorf("WriteScheduleSeconds must be positive, got %d", config.WriteScheduleSeconds)
+	}
+	if config.WriteScheduleSeconds == 0 {
+		config.WriteScheduleSeconds = defaultWriteScheduleSeconds
+	}
+	if config.GroupByURLSegment < 0 {
+		return config, fmt.Errorf("GroupByURLSegment can't be negative, got %d", config.GroupByURLSegment)
+	}
+	if config.EntriesByURLSegment < 0 {
+		return config, fmt.Errorf("EntriesByURLSegment can't be negative, got %d", config.EntriesByURLSegment)
+	}
+	if config.MaxActionsPerVisitorPerMinute < 0 {
+		return config, fmt.Errorf("MaxActionsPerVisitorPerMinute can't be negative, got %d", config.MaxActionsPerVisitorPerMinute)
+	}
+	if config.SketchPrecision == 0 {
+		config.SketchPrecision = defaultSketchPrecision
+	}
+	if config.SketchPrecision < minSketchPrecision || config.SketchPrecision > maxSketchPrecision {
+		return config, fmt.Errorf("SketchPrecision must be between %d and %d, got %d", minSketchPrecision, maxSketchPrecision, config.SketchPrecision)
+	}
+	if config.Shards < 0 {
+		return config, fmt.Errorf("Shards can't be negative, got %d", config.Shards)
+	}
+	if config.SessionSeconds < 0 {
+		return config, fmt.Errorf("SessionSeconds must be positive, got %d", config.SessionSeconds)
+	}
+	if config.SessionSeconds == 0 {
+		config.SessionSeconds = defaultSessionSeconds
+	}
+	if config.DisablePersistence {
+		return config, nil
+	}
+	if len(config.Directory) == 0 {
+		return config, fmt.Errorf("Directory is required, use \".\" for the working directory")
+	}
+	if err := os.MkdirAll(config.Directory, os.ModePerm); err != nil {
+		return config, fmt.Errorf("creating Directory: %w", err)
+	}
+	return config, nil
+}
+
+// validSiteName rejects names that can't be used as a file name prefix.
+func validSiteName(field, name string) error {
+	if len(name) == 0 {
+		return fmt.Errorf("%s can't be empty, it names the data files", field)
+	}
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." {
+		return fmt.Errorf("%s %q can't contain path separators", field, name)
+	}
+	if strings.ContainsRune(name, 0) || len(filepath.VolumeName(name)) > 0 {
+		return fmt.Errorf("%s %q isn't a valid file name", field, name)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidSiteName(t *testing.T) {
+	for _, name := range []string{"site", "example.com", "my-site_2", "..site"} {
+		if err := validSiteName("Name", name); err != nil {
+			t.Errorf("%q was rejected: %v", name, err)
+		}
+	}
+	for _, name := range []string{
+		"", ".", "..",
+		"../site", "a/b", "/site", "site/",
+		`..\site`, `a\b`, `\site`, `site\`,
+		"a\x00b",
+	} {
+		if err := validSiteName("Name", name); err == nil {
+			t.Errorf("%q was accepted", name)
+		}
+	}
+}
+
+func TestNewRejectsPathNames(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"../escape", `..\escape`, "a/b", `a\b`} {
+		if _, err := New(name, WithConfiguration(AnalyticsConfiguration{Directory: dir, ManualFlush: true})); err == nil {
+			t.Errorf("New(%q) was accepted", name)
+		}
+	}
+}
+
+// TestDayPaths checks day paths are built with the OS separator whichever
+// separator Directory was written with.
+func TestDayPaths(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	for _, dir := range []string{"data/site", "data/site/", `data\site`, `data\site\`} {
+		a := analytics{Name: "site", Directory: dir, location: time.UTC}
+		want := filepath.Join(dir, "2027", "01", "15")
+		if got := a.dayDir(day); got != want {
+			t.Errorf("dayDir(%q) = %q, want %q", dir, got, want)
+		}
+		if got, want := a.dayFileName(day), filepath.Join(want, "site2027-01-15"); got != want {
+			t.Errorf("dayFileName(%q) = %q, want %q", dir, got, want)
+		}
+		if filepath.Separator != '/' && strings.Contains(strings.TrimPrefix(a.dayDir(day), dir), "/") {
+			t.Errorf("dayDir(%q) = %q mixes in forward slashes", dir, a.dayDir(day))
+		}
+	}
+}
+
+func TestBadDateRejected(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	for _, date := range []string{"../../etc", `..\..\etc`, "2027-01-15/../..", "2027-13-01", "today"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.URL.RawQuery = "date=" + date
+		rec := httptest.NewRecorder()
+		a.Dashboard(rec, r)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("?date=%s got %d, want 400", date, rec.Code)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// consentCookie is set by a site's banner once the visitor agreed.
+func consentCookie(r *http.Request) bool {
+	_, err := r.Cookie("consent")
+	return err == nil
+}
+
+func consenting(r *http.Request) *http.Request {
+	r.AddCookie(&http.Cookie{Name: "consent", Value: "yes"})
+	return r
+}
+
+func TestConsent(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{ConsentFunc: consentCookie})
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	if a.ShouldTrack(visit("192.0.2.1:4000", "/a")) {
+		t.Error("ShouldTrack is true without consent")
+	}
+
+	served := false
+	handler := a.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+		w.Write([]byte("hello"))
+	}))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, visit("192.0.2.2:4000", "/b"))
+	if !served || rec.Body.String() != "hello" {
+		t.Error("the request wasn't served")
+	}
+
+	form := url.Values{"type": {EventOutbound}, "url": {"https://example.com/"}, "page": {"/a"}}
+	r := httptest.NewRequest(http.MethodPost, "/beacon", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = "192.0.2.3:4000"
+	rec = httptest.NewRecorder()
+	a.Beacon(rec, r)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("beacon answered %d, want 204", rec.Code)
+	}
+
+	if got := countActions(views(a)); got != 0 {
+		t.Errorf("recorded %d actions without consent", got)
+	}
+	if a.metrics.noConsent != 3 || a.metrics.recorded != 0 {
+		t.Errorf("counted %d without consent and %d recorded, want 3 and 0", a.metrics.noConsent, a.metrics.recorded)
+	}
+
+	a.InsertRequest(consenting(visit("192.0.2.1:4000", "/a")))
+	handler.ServeHTTP(httptest.NewRecorder(), consenting(visit("192.0.2.2:4000", "/b")))
+	if got := countActions(views(a)); got != 2 {
+		t.Errorf("recorded %d actions with consent, want 2", got)
+	}
+	if !a.ShouldTrack(consenting(visit("192.0.2.1:4000", "/a"))) {
+		t.Error("ShouldTrack is false with consent")
+	}
+}
+
+func TestConsentCountedBySite(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{
+		ConsentFunc: consentCookie,
+		Sites:       []string{"blog"},
+		SiteResolver: func(r *http.Request) string {
+			return strings.TrimPrefix(r.Host, "www.")
+		},
+	})
+	r := visit("192.0.2.1:4000", "/a")
+	r.Host = "blog"
+	a.InsertRequest(r)
+	if got := a.sites["blog"].metrics.noConsent; got != 1 {
+		t.Errorf("blog counted %d without consent, want 1", got)
+	}
+	if got := a.metrics.noConsent; got != 0 {
+		t.Errorf("the default site counted %d, want 0", got)
+	}
+}
+
+func TestConsentBeaconScript(t *testing.T) {
+	if s := string(BeaconScript("/beacon")); !strings.Contains(s, `var consent = "";`) {
+		t.Errorf("BeaconScript waits for consent:\n%s", s)
+	}
+	s := string(ConsentBeaconScript("/beacon", `agreed"`))
+	if !strings.Contains(s, `var consent = "agreed\"";`) || !strings.Contains(s, `var endpoint = "/beacon";`) {
+		t.Errorf("ConsentBeaconScript doesn't wait for the cookie:\n%s", s)
+	}
+}
@@ -0,0 +1,6 @@
+document.addEventListener("click", function (e) {
+    if (e.target.closest("a[data-back]")) {
+        e.preventDefault();
+        history.back();
+    }
+});
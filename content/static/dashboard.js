@@ -0,0 +1,126 @@
+function UpdateQueryString(key, value, url) {
+     if (!url) url = window.location.href;
+     var re = new RegExp("([?&])" + key + "=.*?(&|#|$)(.*)", "gi"),
+         hash;
+
+     if (re.test(url)) {
+         if (typeof value !== 'undefined' && value !== null) {
+             return url.replace(re, '$1' + key + "=" + value + '$2$3');
+         } 
+         else {
+             hash = url.split('#');
+             url = hash[0].replace(re, '$1$3').replace(/(&|\?)$/, '');
+             if (typeof hash[1] !== 'undefined' && hash[1] !== null) {
+                 url += '#' + hash[1];
+             }
+             return url;
+         }
+     }
+     else {
+         if (typeof value !== 'undefined' && value !== null) {
+             var separator = url.indexOf('?') !== -1 ? '&' : '?';
+             hash = url.split('#');
+             url = hash[0] + separator + key + '=' + value;
+             if (typeof hash[1] !== 'undefined' && hash[1] !== null) {
+                 url += '#' + hash[1];
+             }
+             return url;
+         }
+         else {
+             return url;
+         }
+     }
+ }
+
+ function sortBy(key, current, order) {
+    var next = key == "url" ? "asc" : "desc"
+    if (key == current) {
+        next = order == "asc" ? "desc" : "asc"
+    }
+    var url = UpdateQueryString("page", null, window.location.href)
+    url = UpdateQueryString("sort", key, url)
+    window.location.href = UpdateQueryString("order", next, url)
+ }
+ function chooseDate(object) {
+    var url = UpdateQueryString("period", null, window.location.href)
+    url = UpdateQueryString("from", null, url)
+    url = UpdateQueryString("to", null, url)
+    window.location.href = UpdateQueryString("date", object.value, url)
+ }
+
+ function choosePeriod(period) {
+    var url = UpdateQueryString("from", null, window.location.href)
+    url = UpdateQueryString("to", null, url)
+    window.location.href = UpdateQueryString("period", period, url)
+ }
+
+ function startLive() {
+     if (!window.EventSource) return;
+     var source = new EventSource(UpdateQueryString("live", "1", window.location.href));
+     source.onmessage = function (e) {
+         var update = JSON.parse(e.data);
+         document.getElementById("live-sessions").textContent = update.sessions;
+         document.getElementById("live-views").textContent = update.views_last_minute;
+         var list = document.getElementById("live-recent");
+         list.innerHTML = "";
+         (update.recent || []).forEach(function (page) {
+             var item = document.createElement("li");
+             item.textContent = page;
+             list.appendChild(item);
+         });
+     };
+ }
+ document.addEventListener("DOMContentLoaded", startLive);
+
+ function showVisitor(visitor, date) {
+    var url = UpdateQueryString("period", null, window.location.href)
+    url = UpdateQueryString("from", null, url)
+    url = UpdateQueryString("to", null, url)
+    url = UpdateQueryString("date", date, url)
+    window.location.href = UpdateQueryString("visitor", encodeURIComponent(visitor), url)
+ }
+
+ function chooseRange(object) {
+    var url = UpdateQueryString("date", null, window.location.href)
+    url = UpdateQueryString("period", null, url)
+    window.location.href = UpdateQueryString(object.id, object.value, url)
+ }
+
+// Links and inputs carry what they change in data- attributes rather than
+// inline handlers, which the Content-Security-Policy doesn't allow.
+document.addEventListener("click", function (e) {
+    var link = e.target.closest("a[data-set], a[data-sort], a[data-period], a[data-visitor]");
+    if (!link) return;
+    e.preventDefault();
+    var d = link.dataset;
+    if ("sort" in d) {
+        sortBy(d.sort, d.current, d.order);
+    } else if ("period" in d) {
+        choosePeriod(d.period || null);
+    } else if ("visitor" in d) {
+        showVisitor(d.visitor, d.date);
+    } else {
+        var url = window.location.href;
+        if (d.clear) url = UpdateQueryString(d.clear, null, url);
+        window.location.href = UpdateQueryString(d.set, d.value, url);
+    }
+});
+
+document.addEventListener("change", function (e) {
+    var input = e.target;
+    switch (input.id) {
+    case "site":
+        window.location.href = UpdateQueryString("page", null, UpdateQueryString("site", input.value));
+        break;
+    case "date":
+        chooseDate(input);
+        break;
+    case "from":
+    case "to":
+        chooseRange(input);
+        break;
+    case "q":
+        window.location.href = UpdateQueryString("page", null, UpdateQueryString("q", input.value || null));
+        break;
+    }
+});
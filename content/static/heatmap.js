@@ -0,0 +1,6 @@
+document.addEventListener("change", function (e) {
+    if (e.target.id !== "weeks") return;
+    var params = new URLSearchParams(window.location.search)
+    params.set("weeks", e.target.value)
+    window.location.search = params.toString()
+});
@@ -0,0 +1,223 @@
+package analytics
+
+import (
+	"time"
+)
+
+// todayCounters is the aggregate of today, kept up to date by insert so the
+// dashboard, which may be refreshed constantly, doesn't walk every action of
+// the day on every request. The actions in memory stay what's written to disk;
+// the counters are rebuilt from them whenever they aren't for today, e.g.
+// after a restart or at midnight. Every shard counts its own visitors; the
+// counters are guarded like the rest of the shard.
+type todayCounters struct {
+	day string
+	ag  *aggregate
+	// seen holds the visitor and URL pairs counted in a URL's Visitors.
+	seen     map[string]bool
+	visitors map[string]*countedVisitor
+}
+
+// countedVisitor is what the counters keep of a visitor: when they were last
+// seen, for the recent visitors, and the goals completed and funnel steps
+// reached, to correct the counts when they change.
+type countedVisitor struct {
+	lastSeen int64
+	// session is set once the visitor viewed a page and was counted as a
+	// session.
+	session bool
+	goals   []bool
+	funnel  int
+	// variants and variantGoals are what the visitor was counted as in
+	// the experiments.
+	variants     map[string]string
+	variantGoals []bool
+}
+
+// resetCounters makes the counters be rebuilt when they're next used, after
+// settings affecting what they count changed. The caller holds Mux.
+func (a analytics) resetCounters() {
+	for _, sh := range a.shards {
+		sh.mu.Lock()
+		sh.counters.day = ""
+		sh.mu.Unlock()
+	}
+}
+
+// countAction counts an action of visitor, the last of actions, in the
+// counters of today of its shard. Actions of other days aren't counted. The
+// caller holds the shard's lock.
+func (a analytics) countAction(sh *shard, ts, visitor string, actions []Action) {
+	if ts != a.today() {
+		return
+	}
+	if sh.counters.day != ts {
+		// Rebuilding counts the new action too, it's in memory already.
+		a.rebuildCounters(sh, ts)
+		return
+	}
+	a.count(&sh.counters, visitor, actions, actions[len(actions)-1])
+	a.countGoals(&sh.counters, visitor, actions)
+	a.countExperiments(&sh.counters, visitor, actions)
+}
+
+// rebuildCounters counts every action of day ts in a shard from scratch.
+// The caller holds the shard's lock.
+func (a analytics) rebuildCounters(sh *shard, ts string) {
+	c := &sh.counters
+	c.day = ts
+	c.ag = newAggregate()
+	c.ag.goals = make([]int, len(a.goals))
+	c.ag.funnel = make([]int, len(a.funnel))
+	c.seen = map[string]bool{}
+	c.visitors = map[string]*countedVisitor{}
+	for visitor, actions := range sh.entries[ts] {
+		for i := range actions {
+			a.count(c, visitor, actions[:i+1], actions[i])
+		}
+		a.countGoals(c, visitor, actions)
+		a.countExperiments(c, visitor, actions)
+	}
+}
+
+// count adds act, the last of actions of visitor so far, to the counters,
+// like aggregateDay counts it.
+func (a analytics) count(c *todayCounters, visitor string, actions []Action, act Action) {
+	ag := c.ag
+	v := c.visitors[visitor]
+	if v == nil {
+		v = &countedVisitor{goals: make([]bool, len(a.goals))}
+		c.visitors[visitor] = v
+		ag.protocols.add(act)
+		ag.asns.add(act, 1)
+	}
+	if act.Timestamp > v.lastSeen {
+		v.lastSeen = act.Timestamp
+	}
+	if len(act.Event) > 0 {
+		ag.clicks[Link{Event: act.Event, URL: act.Target}]++
+		return
+	}
+	if !v.session {
+		v.session = true
+		ag.sessions++
+	}
+	groupBy, dataEntry := a.urlKey(act.Page)
+	if ag.urlHits[groupBy] == nil {
+		ag.urlHits[groupBy] = map[string]*urlCounter{}
+	}
+	stats := ag.urlHits[groupBy][dataEntry]
+	if stats == nil {
+		stats = &urlCounter{}
+		ag.urlHits[groupBy][dataEntry] = stats
+	}
+	stats.Views++
+	stats.Bytes += act.Bytes
+	stats.engaged.add(act, 1)
+	if len(act.RawPage) > 0 {
+		if stats.raw == nil {
+			stats.raw = map[string]int{}
+		}
+		stats.raw[act.RawPage]++
+	}
+	if pair := visitor + "\x00" + groupBy + "\x00" + dataEntry; !c.seen[pair] {
+		c.seen[pair] = true
+		stats.Visitors++
+	}
+	ag.views++
+	ag.bytes += act.Bytes
+	if len(a.queryReports) > 0 {
+		if ag.queries[groupBy] == nil {
+			ag.queries[groupBy] = map[queryKey]int{}
+		}
+		a.countQueries(ag.queries[groupBy], act.Page, act.Query)
+	}
+	a.countSearches(ag.searches, act)
+	if act.Duration > 0 {
+		ag.durations[groupBy] = append(ag.durations[groupBy], act.Duration)
+	}
+	if act.Timestamp > 0 {
+		ag.hours[time.UnixMilli(act.Timestamp).In(a.location).Hour()]++
+	}
+}
+
+// countGoals updates the goals and funnel counts with what visitor reached
+// with actions. It goes through all of the visitor's actions as they may
+// not have been recorded in order.
+func (a analytics) countGoals(c *todayCounters, visitor string, actions []Action) {
+	if len(a.goals) == 0 && len(a.funnel) == 0 {
+		return
+	}
+	v := c.visitors[visitor]
+	ordered := inOrder(actions)
+	for i, g := range a.goals {
+		done := stepsReached(g.Steps, ordered) == len(g.Steps)
+		if done && !v.goals[i] {
+			c.ag.goals[i]++
+		} else if !done && v.goals[i] {
+			c.ag.goals[i]--
+		}
+		v.goals[i] = done
+	}
+	if len(a.funnel) > 0 {
+		reached := stepsReached(a.funnel, ordered)
+		for i := v.funnel; i < reached; i++ {
+			c.ag.funnel[i]++
+		}
+		for i := reached; i < v.funnel; i++ {
+			c.ag.funnel[i]--
+		}
+		v.funnel = reached
+	}
+}
+
+// todayAggregate returns the counters of today of every shard added up as
+// the aggregate of date, rebuilding those that are for another day.
+func (a analytics) todayAggregate(date time.Time) *aggregate {
+	ts := a.dayKey(date)
+	ag := newAggregate()
+	var s *sketch
+	var recent []VisitorSummary
+	dropped := map[string]int{}
+	entries := map[string][]Action{}
+	a.Mux.RLock()
+	for _, sh := range a.shards {
+		sh.mu.Lock()
+		if sh.counters.day != ts {
+			a.rebuildCounters(sh, ts)
+		}
+		c := &sh.counters
+		ag.merge(c.ag)
+		if ds := sh.sketches[ts]; ds != nil && s == nil {
+			s = ds.clone()
+		} else if ds != nil {
+			s = s.union(ds)
+		}
+		for visitor, n := range sh.dropped[ts] {
+			dropped[visitor] = n
+		}
+		for visitor, v := range c.visitors {
+			recent = append(recent, VisitorSummary{Visitor: visitor, lastSeen: v.lastSeen})
+		}
+		recent = latestVisitors(recent)
+		for _, vs := range recent {
+			if actions, ok := sh.entries[ts][vs.Visitor]; ok {
+				entries[vs.Visitor] = actions
+			}
+		}
+		sh.mu.Unlock()
+	}
+	a.Mux.RUnlock()
+	ag.days = []DaySessions{{Date: ts, Sessions: ag.sessions}}
+	ag.sketch = s
+	for _, n := range dropped {
+		ag.truncated++
+		ag.dropped += n
+	}
+	for i, vs := range recent {
+		recent[i] = summarizeVisitor(vs.Visitor, date, entries[vs.Visitor])
+		recent[i].Dropped = dropped[vs.Visitor]
+	}
+	ag.visitors = recent
+	return ag
+}
@@ -0,0 +1,149 @@
+package analytics
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counterConfig exercises everything the counters count: groups and
+// entries, query reports, goals, the funnel and dropped actions.
+var counterConfig = AnalyticsConfiguration{
+	GroupByURLSegment:          1,
+	EntriesByURLSegment:        2,
+	MaxActionsPerVisitorPerDay: 20,
+	QueryReports:               []QueryReport{{Param: "q"}},
+	Goals:                      []GoalConfig{{Name: "signup", Steps: []string{"/pricing", "/signup"}}},
+	Funnel:                     []string{"/", "/pricing", "/signup"},
+}
+
+var counterPaths = []string{
+	"/", "/pricing", "/signup", "/blog", "/blog/go", "/blog/go/maps",
+	"/docs/a/b/c/d", "/search?q=go", "/search?q=maps", "/about?ref=x",
+}
+
+// randomWorkload records n random page views and clicks from a few
+// goroutines at once.
+func randomWorkload(a *analytics, seed int64, n int) {
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(rnd *rand.Rand) {
+			defer wg.Done()
+			for i := 0; i < n/4; i++ {
+				addr := fmt.Sprintf("192.0.2.%d:%d", rnd.Intn(40), 1000+rnd.Intn(3))
+				if rnd.Intn(10) == 0 {
+					form := url.Values{"type": {EventOutbound}, "url": {"https://example.com/"}, "page": {"/blog"}}
+					r := httptest.NewRequest(http.MethodPost, "/beacon?"+form.Encode(), nil)
+					r.RemoteAddr = addr
+					a.Beacon(httptest.NewRecorder(), r)
+					continue
+				}
+				a.InsertRequest(visit(addr, counterPaths[rnd.Intn(len(counterPaths))]))
+			}
+		}(rand.New(rand.NewSource(seed + int64(g))))
+	}
+	wg.Wait()
+}
+
+// scanReport is what the dashboard showed for today before the counters,
+// the aggregate of a scan over all of today's actions.
+func scanReport(a *analytics) (DashboardData, *aggregate) {
+	now := a.now()
+	ag := a.aggregateDay(now, a.dayEntries(a.today()), a.loadDropped(now))
+	return ag.report(urlView{}), ag
+}
+
+func checkCounters(t *testing.T, a *analytics) {
+	t.Helper()
+	counted := a.todayAggregate(a.now())
+	scanned, ag := scanReport(a)
+	if got := counted.report(urlView{}); !reflect.DeepEqual(got, scanned) {
+		t.Errorf("counters report\n%+v\nthe scan\n%+v", got, scanned)
+	}
+	if !reflect.DeepEqual(counted.goals, ag.goals) || !reflect.DeepEqual(counted.funnel, ag.funnel) {
+		t.Errorf("counters count goals %v and funnel %v, the scan %v and %v", counted.goals, counted.funnel, ag.goals, ag.funnel)
+	}
+	if scanned.PageViews == 0 || scanned.Dropped == 0 {
+		t.Errorf("the workload recorded %d page views and dropped %d actions, want both", scanned.PageViews, scanned.Dropped)
+	}
+}
+
+func TestCountersMatchScan(t *testing.T) {
+	for _, seed := range []int64{1, 2, 3} {
+		clock := &movingClock{t: time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)}
+		a := newTestAnalytics(t, counterConfig, WithClock(clock))
+		randomWorkload(a, seed, 2000)
+		checkCounters(t, a)
+	}
+}
+
+// TestCountersRebuilt checks counters rebuilt from today's file after a
+// restart match the scan too.
+func TestCountersRebuilt(t *testing.T) {
+	clock := &movingClock{t: time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)}
+	config := counterConfig
+	config.Directory = t.TempDir()
+	a := newTestAnalytics(t, config, WithClock(clock))
+	randomWorkload(a, 4, 3000)
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := newTestAnalytics(t, config, WithClock(clock))
+	checkCounters(t, b)
+	randomWorkload(b, 5, 1000)
+	checkCounters(t, b)
+}
+
+// TestBeaconsOnlyNoSession checks a visitor who only sent beacons isn't a
+// session, today, once the day is saved, or after the page view that makes
+// them one.
+func TestBeaconsOnlyNoSession(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{Directory: t.TempDir()}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	outbound := func(addr string) {
+		form := url.Values{"type": {EventOutbound}, "url": {"https://example.com/"}, "page": {"/a"}}
+		r := httptest.NewRequest(http.MethodPost, "/beacon", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.RemoteAddr = addr
+		a.Beacon(httptest.NewRecorder(), r)
+	}
+	sessions := func(want int) {
+		t.Helper()
+		dd, err := a.Stats(day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dd.SessionCount != want {
+			t.Errorf("got %d sessions, want %d", dd.SessionCount, want)
+		}
+	}
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	outbound("192.0.2.1:4000")
+	outbound("192.0.2.2:4000")
+	outbound("192.0.2.3:4000")
+	sessions(1)
+	if n := a.sessionsToday(); n != 1 {
+		t.Errorf("live counts %d sessions, want 1", n)
+	}
+	a.InsertRequest(visit("192.0.2.3:4000", "/a"))
+	sessions(2)
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a = newTestAnalytics(t, config, WithClock(clock))
+	sessions(2)
+	if s := a.ownSummary(day); s.Sessions != 2 {
+		t.Errorf("the summary has %d sessions, want 2", s.Sessions)
+	}
+}
@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// dayLayout is how days are written in keys, file names and query values.
+const dayLayout = "2006-01-02"
+
+// Clock tells the time. WithClock replaces the real clock with one tests can
+// move, e.g. across midnight.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// now is the current time in the configured Timezone, which decides what day
+// actions are recorded under and what day the dashboard calls today.
+func (a analytics) now() time.Time {
+	return a.clock.Now().In(a.location)
+}
+
+// dayKey is the day t falls on in the configured Timezone. Everything that
+// decides which day something belongs to goes through it.
+func (a analytics) dayKey(t time.Time) string {
+	return t.In(a.location).Format(dayLayout)
+}
+
+func (a analytics) today() string {
+	return a.dayKey(a.now())
+}
+
+func (a analytics) isToday(t time.Time) bool {
+	return a.dayKey(t) == a.today()
+}
+
+// parseDay parses a day key as the start of that day in the configured
+// Timezone.
+func (a analytics) parseDay(key string) (time.Time, error) {
+	return time.ParseInLocation(dayLayout, key, a.location)
+}
+
+// dayDir is the directory a day's files are written to, Directory/YYYY/MM/DD
+// with the separators of the OS.
+func (a analytics) dayDir(t time.Time) string {
+	t = t.In(a.location)
+	return filepath.Join(a.Directory, t.Format("2006"), t.Format("01"), t.Format("02"))
+}
+
+// dayFileName is the day file of the site, the other files of the day are
+// named after it.
+func (a analytics) dayFileName(t time.Time) string {
+	return filepath.Join(a.dayDir(t), a.Name+a.dayKey(t))
+}
@@ -0,0 +1,118 @@
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// movingClock is a Clock tests set the time of.
+type movingClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *movingClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *movingClock) set(t time.Time) {
+	c.mu.Lock()
+	c.t = t
+	c.mu.Unlock()
+}
+
+func newYork(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	return loc
+}
+
+func TestDayKeyAcrossDST(t *testing.T) {
+	a := analytics{location: newYork(t)}
+	for _, tc := range []struct {
+		utc  string
+		want string
+	}{
+		// Spring forward on 2026-03-08, midnight is still 05:00 UTC.
+		{"2026-03-08T04:59:59Z", "2026-03-07"},
+		{"2026-03-08T05:00:00Z", "2026-03-08"},
+		{"2026-03-09T03:59:59Z", "2026-03-08"},
+		{"2026-03-09T04:00:00Z", "2026-03-09"},
+		// Fall back on 2026-11-01, which is 25 hours long.
+		{"2026-11-01T03:59:59Z", "2026-10-31"},
+		{"2026-11-01T04:00:00Z", "2026-11-01"},
+		{"2026-11-02T04:59:59Z", "2026-11-01"},
+		{"2026-11-02T05:00:00Z", "2026-11-02"},
+	} {
+		ts, err := time.Parse(time.RFC3339, tc.utc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := a.dayKey(ts); got != tc.want {
+			t.Errorf("dayKey(%s) = %s, want %s", tc.utc, got, tc.want)
+		}
+	}
+	day, err := a.parseDay("2026-11-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := a.dayKey(day.AddDate(0, 0, 1)); got != "2026-11-02" {
+		t.Errorf("the day after 2026-11-01 is %s", got)
+	}
+	if got := a.dayKey(day.Add(24 * time.Hour)); got != "2026-11-01" {
+		t.Errorf("24 hours into 2026-11-01 is %s, the day has 25", got)
+	}
+}
+
+// TestMidnight records on both sides of midnight in the configured Timezone,
+// which isn't the one of the machine, and checks every action lands on its
+// day, on the dashboard and on disk.
+func TestMidnight(t *testing.T) {
+	loc := newYork(t)
+	clock := &movingClock{t: time.Date(2026, time.March, 7, 23, 59, 30, 0, loc)}
+	a := newTestAnalytics(t, AnalyticsConfiguration{Timezone: "America/New_York"}, WithClock(clock))
+	a.InsertRequest(visit("192.0.2.1:4000", "/before"))
+	clock.set(time.Date(2026, time.March, 8, 0, 0, 30, 0, loc))
+	a.InsertRequest(visit("192.0.2.2:4000", "/after"))
+	a.InsertRequest(visit("192.0.2.3:4000", "/after"))
+
+	views := func(query string) (string, int) {
+		rec := httptest.NewRecorder()
+		a.StatsJSON(rec, httptest.NewRequest(http.MethodGet, "/"+query, nil))
+		var dd DashboardData
+		if err := json.Unmarshal(rec.Body.Bytes(), &dd); err != nil {
+			t.Fatalf("decoding %s: %v", query, err)
+		}
+		return dd.Date, dd.PageViews
+	}
+	if date, n := views(""); date != "2026-03-08" || n != 2 {
+		t.Errorf("today is %s with %d views, want 2026-03-08 with 2", date, n)
+	}
+	if date, n := views("?date=2026-03-07"); date != "2026-03-07" || n != 1 {
+		t.Errorf("?date=2026-03-07 is %s with %d views, want 1", date, n)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	for day, want := range map[time.Time]int{
+		time.Date(2026, time.March, 7, 12, 0, 0, 0, loc): 1,
+		time.Date(2026, time.March, 8, 12, 0, 0, 0, loc): 2,
+	} {
+		if _, err := os.Stat(a.dayFileName(day)); err != nil {
+			t.Errorf("%s wasn't written: %v", a.dayKey(day), err)
+		}
+		if n := len(a.readSavedData(day)); n != want {
+			t.Errorf("%s has %d visitors on disk, want %d", a.dayKey(day), n, want)
+		}
+	}
+}
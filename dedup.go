@@ -0,0 +1,62 @@
+package analytics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultDuplicateWindow is how close a repeated page view has to follow
+// the visitor's last one to be dropped unless DuplicateWindowSeconds says
+// otherwise.
+const defaultDuplicateWindow = 2 * time.Second
+
+// prefetchHeaders are the headers browsers mark prefetches with: Sec-Purpose
+// in Chrome, which also uses Purpose, and X-Moz in Firefox.
+var prefetchHeaders = []string{"Sec-Purpose", "Purpose", "X-Purpose", "X-Moz"}
+
+// lastView is a visitor's last page view.
+type lastView struct {
+	page  string
+	query string
+	at    int64
+}
+
+// duplicateWindow applies the default to DuplicateWindowSeconds, 0 meaning
+// nothing is dropped.
+func duplicateWindow(seconds int) time.Duration {
+	if seconds < 0 {
+		return 0
+	}
+	if seconds == 0 {
+		return defaultDuplicateWindow
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// isPrefetch tells whether the browser prefetched or prerendered r rather
+// than the visitor opening it.
+func isPrefetch(r *http.Request) bool {
+	for _, h := range prefetchHeaders {
+		if strings.Contains(strings.ToLower(r.Header.Get(h)), "prefetch") {
+			return true
+		}
+	}
+	return false
+}
+
+// duplicate tells whether act repeats the page view of the visitor with key
+// within the duplicate window, remembering it as their last one if not. The
+// caller holds the lock of sh.
+func (a analytics) duplicate(sh *shard, ts, key string, act Action) bool {
+	if a.duplicateWindow <= 0 || len(act.Event) > 0 {
+		return false
+	}
+	views := sh.views[ts]
+	last, ok := views[key]
+	if ok && last.page == act.Page && last.query == act.Query && act.Timestamp-last.at < a.duplicateWindow.Milliseconds() {
+		return true
+	}
+	views[key] = lastView{page: act.Page, query: act.Query, at: act.Timestamp}
+	return false
+}
@@ -0,0 +1,110 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// views returns the actions buffered today, by visitor.
+func views(a *analytics) map[string][]Action {
+	return a.dayEntries(a.today())
+}
+
+func countActions(entries map[string][]Action) int {
+	n := 0
+	for _, actions := range entries {
+		n += len(actions)
+	}
+	return n
+}
+
+func TestDuplicateViews(t *testing.T) {
+	clock := &movingClock{t: time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)}
+	a := newTestAnalytics(t, AnalyticsConfiguration{}, WithClock(clock))
+	start := clock.Now()
+	at := func(d time.Duration, addr, target string) {
+		clock.set(start.Add(d))
+		a.InsertRequest(visit(addr, target))
+	}
+	at(0, "192.0.2.1:4000", "/a?x=1")
+	at(500*time.Millisecond, "192.0.2.1:4000", "/a?x=1")  // double submit
+	at(time.Second, "192.0.2.1:4000", "/a?x=2")           // another query
+	at(1500*time.Millisecond, "192.0.2.1:4000", "/a?x=1") // back to the first
+	at(1600*time.Millisecond, "192.0.2.2:4000", "/a?x=1") // another visitor
+	at(3600*time.Millisecond, "192.0.2.1:4000", "/a?x=1") // after the window
+	if got := countActions(views(a)); got != 5 {
+		t.Errorf("kept %d views, want 5", got)
+	}
+	if got := a.metrics.duplicates; got != 1 {
+		t.Errorf("counted %d duplicates, want 1", got)
+	}
+	if got := a.metrics.recorded; got != 5 {
+		t.Errorf("counted %d recorded, want 5", got)
+	}
+}
+
+func TestDuplicateWindowDisabled(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{DuplicateWindowSeconds: -1})
+	for i := 0; i < 3; i++ {
+		a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	}
+	if got := countActions(views(a)); got != 3 {
+		t.Errorf("kept %d views, want 3", got)
+	}
+}
+
+func TestDuplicateEventsKept(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	form := url.Values{"type": {EventOutbound}, "url": {"https://example.com/"}, "page": {"/a"}}
+	for i := 0; i < 2; i++ {
+		r := httptest.NewRequest(http.MethodPost, "/beacon", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("User-Agent", "Mozilla/5.0")
+		r.RemoteAddr = "192.0.2.1:4000"
+		a.Beacon(httptest.NewRecorder(), r)
+	}
+	if got := countActions(views(a)); got != 2 {
+		t.Errorf("kept %d clicks, want 2", got)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	headers := map[string]string{
+		"Sec-Purpose": "prefetch;prerender",
+		"Purpose":     "Prefetch",
+		"X-Moz":       "prefetch",
+	}
+	for header, value := range headers {
+		a := newTestAnalytics(t, AnalyticsConfiguration{})
+		r := visit("192.0.2.1:4000", "/a")
+		r.Header.Set(header, value)
+		a.InsertRequest(r)
+		if got := countActions(views(a)); got != 0 {
+			t.Errorf("%s: %s kept %d views, want none", header, value, got)
+		}
+		if got := a.metrics.prefetches; got != 1 {
+			t.Errorf("%s: %s counted %d prefetches, want 1", header, value, got)
+		}
+	}
+
+	a := newTestAnalytics(t, AnalyticsConfiguration{RecordPrefetch: true})
+	r := visit("192.0.2.1:4000", "/a")
+	r.Header.Set("Sec-Purpose", "prefetch")
+	a.InsertRequest(r)
+	a.InsertRequest(visit("192.0.2.2:4000", "/a"))
+	prefetched := 0
+	for _, actions := range views(a) {
+		for _, act := range actions {
+			if act.Prefetch {
+				prefetched++
+			}
+		}
+	}
+	if got := countActions(views(a)); got != 2 || prefetched != 1 {
+		t.Errorf("kept %d views with %d prefetched, want 2 with 1", got, prefetched)
+	}
+}
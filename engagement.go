@@ -0,0 +1,174 @@
+package analytics
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// EventPing is the beacon type of the pings the script sends while a page
+// is visible, reporting how far it was scrolled and for how long it's been
+// open. Pings update the page view they are for rather than being recorded.
+const EventPing = "ping"
+
+// maxPingSeconds caps the time on page a ping can report.
+const maxPingSeconds = 3600
+
+// minEngagementViews is how many pinged views a URL needs before its
+// engagement is reported.
+const minEngagementViews = 5
+
+// scrollDepths are the quartiles scroll depth is reported in, as percent of
+// the page.
+var scrollDepths = [...]int{0, 25, 50, 75, 100}
+
+// Engagement is how long the pinged views of a URL lasted on average and how
+// far down they were scrolled.
+type Engagement struct {
+	Views          int           `json:"views"`
+	AverageSeconds float64       `json:"average_seconds"`
+	Scroll         []ScrollDepth `json:"scroll"`
+}
+
+// ScrollDepth is how many pinged views were scrolled down to Depth percent of
+// the page at most, and what share of them that is.
+type ScrollDepth struct {
+	Depth   int     `json:"depth"`
+	Views   int     `json:"views"`
+	Percent float64 `json:"percent"`
+}
+
+// engagement adds up the pings of a URL's views.
+type engagement struct {
+	views   int
+	seconds int64
+	scroll  [len(scrollDepths)]int
+}
+
+// add counts a page view if it was pinged, or takes it away again with sign
+// -1. Views without a ping have no Seconds.
+func (e *engagement) add(act Action, sign int) {
+	if act.Seconds <= 0 {
+		return
+	}
+	e.views += sign
+	e.seconds += int64(sign * act.Seconds)
+	e.scroll[scrollBucket(act.Scroll)] += sign
+}
+
+func (e *engagement) merge(o engagement) {
+	e.views += o.views
+	e.seconds += o.seconds
+	for i, n := range o.scroll {
+		e.scroll[i] += n
+	}
+}
+
+// report is the Engagement of the views, nil if there are too few of them.
+func (e engagement) report() *Engagement {
+	if e.views < minEngagementViews {
+		return nil
+	}
+	r := &Engagement{Views: e.views, AverageSeconds: float64(e.seconds) / float64(e.views), Scroll: make([]ScrollDepth, len(scrollDepths))}
+	for i, n := range e.scroll {
+		r.Scroll[i] = ScrollDepth{Depth: scrollDepths[i], Views: n, Percent: float64(n) * 100 / float64(e.views)}
+	}
+	return r
+}
+
+// scrollBucket is the index of the quartile a scroll depth in percent falls
+// in.
+func scrollBucket(depth int) int {
+	i := depth / 25
+	if i < 0 {
+		return 0
+	}
+	if i >= len(scrollDepths) {
+		return len(scrollDepths) - 1
+	}
+	return i
+}
+
+// parsePing reads the scroll depth and seconds on page of a ping, rounding
+// the depth down to its quartile.
+func parsePing(r *http.Request) (int, int, error) {
+	scroll, err := strconv.Atoi(r.Form.Get("scroll"))
+	if err != nil || scroll < 0 || scroll > 100 {
+		return 0, 0, fmt.Errorf("ping scroll must be a percentage, got %q", r.Form.Get("scroll"))
+	}
+	seconds, err := strconv.Atoi(r.Form.Get("seconds"))
+	if err != nil || seconds < 1 {
+		return 0, 0, fmt.Errorf("ping seconds must be a positive number, got %q", r.Form.Get("seconds"))
+	}
+	if seconds > maxPingSeconds {
+		seconds = maxPingSeconds
+	}
+	return scrollDepths[scrollBucket(scroll)], seconds, nil
+}
+
+// ping updates the latest view of page by the visitor of r today with the
+// deepest scroll and longest time on page seen so far, reporting whether
+// there was such a view. The visitor's actions are copied rather than
+// changed in place, as snapshots share them.
+func (a analytics) ping(r *http.Request, page string, scroll, seconds int) bool {
+	if atomic.LoadInt32(a.closed) == 1 {
+		return false
+	}
+	if a.siteResolver != nil {
+		a = a.site(a.siteResolver(r))
+	}
+	page = a.beaconPath(page)
+	addr := r.RemoteAddr
+	if len(addr) == 0 {
+		addr = unknownAddr
+	}
+	if a.truncateIPs {
+		addr = truncateIP(addr)
+	}
+	ts := a.today()
+	key := a.visitorKey(ts, addr)
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	if !a.openDays[ts] {
+		return false
+	}
+	sh := a.shards.of(key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	actions := sh.entries[ts][key]
+	for i := len(actions) - 1; i >= 0; i-- {
+		act := actions[i]
+		if len(act.Event) > 0 || act.Page != page {
+			continue
+		}
+		pinged := act
+		if scroll > pinged.Scroll {
+			pinged.Scroll = scroll
+		}
+		if seconds > pinged.Seconds {
+			pinged.Seconds = seconds
+		}
+		if pinged == act {
+			return true
+		}
+		updated := append([]Action(nil), actions...)
+		updated[i] = pinged
+		sh.entries[ts][key] = updated
+		if sh.counters.day == ts {
+			a.countPing(&sh.counters, act, pinged)
+		}
+		return true
+	}
+	return false
+}
+
+// countPing replaces a page view counted as was with the pinged one in the
+// counters of today.
+func (a analytics) countPing(c *todayCounters, was, pinged Action) {
+	groupBy, dataEntry := a.urlKey(pinged.Page)
+	if stats := c.ag.urlHits[groupBy][dataEntry]; stats != nil {
+		stats.engaged.add(was, -1)
+		stats.engaged.add(pinged, 1)
+	}
+}
@@ -0,0 +1,109 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// pinged sends a ping for page from addr and returns the status it got.
+func pinged(a *analytics, addr, page string, scroll, seconds int) int {
+	form := url.Values{"type": {EventPing}, "page": {page}, "scroll": {strconv.Itoa(scroll)}, "seconds": {strconv.Itoa(seconds)}}
+	r := httptest.NewRequest(http.MethodPost, "/beacon", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = addr
+	rec := httptest.NewRecorder()
+	a.Beacon(rec, r)
+	return rec.Code
+}
+
+func urlHit(dd DashboardData, u string) URLHit {
+	for _, g := range dd.URLHits {
+		for _, h := range g.URLs {
+			if h.URL == u {
+				return h
+			}
+		}
+	}
+	return URLHit{}
+}
+
+func TestEngagement(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{Directory: t.TempDir()}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	for i, p := range []struct{ scroll, seconds int }{{10, 20}, {30, 40}, {60, 60}, {99, 80}, {100, 100}} {
+		addr := "192.0.2." + strconv.Itoa(i+1) + ":4000"
+		a.InsertRequest(visit(addr, "/post"))
+		if code := pinged(a, addr, "/post", p.scroll, p.seconds/2); code != http.StatusNoContent {
+			t.Fatalf("ping answered %d", code)
+		}
+		pinged(a, addr, "/post", p.scroll, p.seconds)
+		pinged(a, addr, "/post", 0, 1)
+	}
+	a.InsertRequest(visit("192.0.2.1:4000", "/short"))
+	pinged(a, "192.0.2.1:4000", "/short", 100, 5)
+	if code := pinged(a, "192.0.2.9:4000", "/post", 50, 5); code != http.StatusNoContent {
+		t.Errorf("a ping without a page view answered %d", code)
+	}
+	if code := pinged(a, "192.0.2.1:4000", "/post", 150, 5); code != http.StatusBadRequest {
+		t.Errorf("a bad ping answered %d", code)
+	}
+	if actions := views(a)["192.0.2.1:4000"]; len(actions) != 2 || actions[0].Scroll != 0 || actions[0].Seconds != 20 {
+		t.Errorf("stored %+v, want the view updated with the longest ping", actions)
+	}
+
+	want := &Engagement{Views: 5, AverageSeconds: 60, Scroll: []ScrollDepth{
+		{Depth: 0, Views: 1, Percent: 20},
+		{Depth: 25, Views: 1, Percent: 20},
+		{Depth: 50, Views: 1, Percent: 20},
+		{Depth: 75, Views: 1, Percent: 20},
+		{Depth: 100, Views: 1, Percent: 20},
+	}}
+	today, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if today.PageViews != 6 {
+		t.Errorf("got %d page views, want 6", today.PageViews)
+	}
+	if got := urlHit(today, "/post").Engagement; !reflect.DeepEqual(got, want) {
+		t.Errorf("today got %+v, want %+v", got, want)
+	}
+	if got := urlHit(today, "/short").Engagement; got != nil {
+		t.Errorf("a single pinged view got %+v", got)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a = newTestAnalytics(t, config, WithClock(clock))
+	saved, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := urlHit(saved, "/post").Engagement; !reflect.DeepEqual(got, want) {
+		t.Errorf("the saved day got %+v, want %+v", got, want)
+	}
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?date="+a.dayKey(day), nil))
+	if !strings.Contains(rec.Body.String(), "60s on page on average") {
+		t.Error("the dashboard doesn't show the time on page")
+	}
+}
+
+func TestBeaconScriptPings(t *testing.T) {
+	script := string(BeaconScript("/beacon"))
+	for _, s := range []string{`type: "ping"`, `document.visibilityState !== "visible"`, "}, 15000);"} {
+		if !strings.Contains(script, s) {
+			t.Errorf("the script has no %s", s)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package analytics
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ASN is the autonomous system an IP belongs to, like AS16509 Amazon.com.
+// A zero Number means it isn't known.
+type ASN struct {
+	Number uint32 `json:"number"`
+	Org    string `json:"org"`
+}
+
+// ASNLookup returns the autonomous system of ip, the zero ASN if it's in
+// none. OpenASNDatabase returns one reading a MaxMind DB.
+type ASNLookup func(ip net.IP) (ASN, error)
+
+// unknownASN is what visitors whose lookup failed or found nothing are
+// counted as.
+const unknownASN = "unknown"
+
+// enrichQueue is how many lookups can wait for the enricher. Visitors
+// arriving while it's full aren't looked up.
+const enrichQueue = 1024
+
+// enrichCacheSize is how many IPs the enricher remembers. It starts over
+// once that many were looked up.
+const enrichCacheSize = 10000
+
+// reverseDNSTimeout bounds a reverse DNS lookup.
+const reverseDNSTimeout = 2 * time.Second
+
+// topASNs is how many autonomous systems the dashboard lists.
+const topASNs = 50
+
+// ASNCount is how many sessions came from an autonomous system.
+type ASNCount struct {
+	ASN
+	Sessions int `json:"sessions"`
+}
+
+// enrichment is what the enricher found about an IP.
+type enrichment struct {
+	asn      ASN
+	hostname string
+}
+
+// enrichJob is a visitor of a site to look up, by the IP their key was
+// derived from.
+type enrichJob struct {
+	site   analytics
+	ts     string
+	key    string
+	ip     string
+	queued time.Time
+}
+
+// enricher looks up the autonomous system, and with ReverseDNSPerSecond the
+// hostname, of new visitors off the request path, shared by every site.
+type enricher struct {
+	lookup  ASNLookup
+	ignore  map[uint32]bool
+	rdns    int
+	resolve func(ctx context.Context, addr string) ([]string, error)
+	jobs    chan enrichJob
+	// pending counts the queued and running lookups, which Close waits for.
+	pending sync.WaitGroup
+
+	mu sync.Mutex
+	// closed is set once Close started, nothing is queued from then on.
+	closed bool
+	cache  map[string]enrichment
+	// resolved counts the reverse lookups of the current second.
+	resolved int
+	second   int64
+}
+
+func newEnricher(config AnalyticsConfiguration) (*enricher, error) {
+	lookup := config.ASNLookup
+	if len(config.ASNDatabase) > 0 {
+		db, err := OpenASNDatabase(config.ASNDatabase)
+		if err != nil {
+			return nil, err
+		}
+		lookup = db
+	}
+	if lookup == nil && config.ReverseDNSPerSecond == 0 {
+		return nil, nil
+	}
+	e := &enricher{
+		lookup:  lookup,
+		ignore:  map[uint32]bool{},
+		rdns:    config.ReverseDNSPerSecond,
+		resolve: net.DefaultResolver.LookupAddr,
+		jobs:    make(chan enrichJob, enrichQueue),
+		cache:   map[string]enrichment{},
+	}
+	for _, n := range config.IgnoreASNs {
+		e.ignore[n] = true
+	}
+	return e, nil
+}
+
+// cached returns what's known about ip already.
+func (e *enricher) cached(ip string) (enrichment, bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	en, ok := e.cache[ip]
+	return en, ok
+}
+
+// ignored tells whether IgnoreASNs drops the visitors of en.
+func (e *enricher) ignored(en enrichment) bool {
+	return e.ignore[en.asn.Number]
+}
+
+// enqueue has a visitor looked up, unless too many already wait or it's
+// closed.
+func (e *enricher) enqueue(job enrichJob) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return
+	}
+	e.pending.Add(1)
+	select {
+	case e.jobs <- job:
+	default:
+		e.pending.Done()
+	}
+}
+
+// run looks up the queued visitors until quit is closed. The visitors still
+// queued then aren't looked up.
+func (e *enricher) run(log Logger, quit chan struct{}) {
+	for {
+		select {
+		case job := <-e.jobs:
+			job.site.enrich(job, e.find(log, job.ip, job.queued))
+			e.pending.Done()
+		case <-quit:
+			for {
+				select {
+				case <-e.jobs:
+					e.pending.Done()
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// close stops queueing lookups. Once quit is closed too, pending.Wait returns
+// after the lookup running then was stored.
+func (e *enricher) close() {
+	e.mu.Lock()
+	e.closed = true
+	e.mu.Unlock()
+}
+
+// find looks ip up unless it's cached. Failures are logged and leave the
+// ASN unknown.
+func (e *enricher) find(log Logger, ip string, now time.Time) enrichment {
+	if en, ok := e.cached(ip); ok {
+		return en
+	}
+	var en enrichment
+	parsed := net.ParseIP(ip)
+	if e.lookup != nil && parsed != nil {
+		asn, err := e.lookup(parsed)
+		if err != nil {
+			log.Warn("looking up the ASN of %s: %v", ip, err)
+		}
+		en.asn = asn
+	}
+	if len(en.asn.Org) == 0 {
+		en.asn = ASN{Number: en.asn.Number, Org: unknownASN}
+	}
+	if len(en.asn.Org) > maxTargetLength {
+		en.asn.Org = en.asn.Org[:maxTargetLength]
+	}
+	if parsed != nil && e.allowResolve(now) {
+		ctx, cancel := context.WithTimeout(context.Background(), reverseDNSTimeout)
+		names, err := e.resolve(ctx, ip)
+		cancel()
+		if err == nil && len(names) > 0 {
+			en.hostname = strings.TrimSuffix(names[0], ".")
+			if len(en.hostname) > maxTargetLength {
+				en.hostname = en.hostname[:maxTargetLength]
+			}
+		}
+	}
+	e.mu.Lock()
+	if len(e.cache) >= enrichCacheSize {
+		e.cache = map[string]enrichment{}
+	}
+	e.cache[ip] = en
+	e.mu.Unlock()
+	return en
+}
+
+// allowResolve tells whether another reverse lookup fits in
+// ReverseDNSPerSecond. Visitors over the rate get no hostname.
+func (e *enricher) allowResolve(now time.Time) bool {
+	if e.rdns <= 0 {
+		return false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if s := now.Unix(); s != e.second {
+		e.second, e.resolved = s, 0
+	}
+	if e.resolved >= e.rdns {
+		return false
+	}
+	e.resolved++
+	return true
+}
+
+// enrichAction stores a cached lookup on act, reporting whether IgnoreASNs
+// drops it. Without one the visitor is queued once they're recorded.
+func (a analytics) enrichAction(ip string, act *Action) (ignored, known bool) {
+	en, ok := a.enricher.cached(ip)
+	if !ok {
+		return false, false
+	}
+	act.ASN, act.ASNOrg, act.Hostname = en.asn.Number, en.asn.Org, en.hostname
+	return a.enricher.ignored(en), true
+}
+
+// enrich stores the lookup of a visitor on their first action of the day,
+// or removes them from it if IgnoreASNs drops their autonomous system. The
+// visitor's actions are copied rather than changed in place, as snapshots
+// share them.
+func (a analytics) enrich(job enrichJob, en enrichment) {
+	if a.enricher.ignored(en) {
+		a.dropVisitor(job.ts, job.key)
+		return
+	}
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	if !a.openDays[job.ts] {
+		return
+	}
+	sh := a.shards.of(job.key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	actions := sh.entries[job.ts][job.key]
+	if len(actions) == 0 || len(actions[0].ASNOrg) > 0 {
+		return
+	}
+	was := actions[0]
+	updated := append([]Action(nil), actions...)
+	updated[0].ASN, updated[0].ASNOrg, updated[0].Hostname = en.asn.Number, en.asn.Org, en.hostname
+	sh.entries[job.ts][job.key] = updated
+	if sh.counters.day == job.ts {
+		sh.counters.ag.asns.add(was, -1)
+		sh.counters.ag.asns.add(updated[0], 1)
+	}
+}
+
+// dropVisitor removes a visitor IgnoreASNs drops from a day in memory,
+// recorded before their lookup was done. Like DeleteVisitor it makes the
+// day's sketch again from the keys left, which is what insert feeds it.
+func (a analytics) dropVisitor(ts, key string) {
+	a.Mux.Lock()
+	defer a.Mux.Unlock()
+	sh := a.shards.of(key)
+	if _, ok := sh.entries[ts][key]; !ok {
+		return
+	}
+	delete(sh.entries[ts], key)
+	delete(sh.views[ts], key)
+	delete(sh.dropped[ts], key)
+	data := map[string][]Action{}
+	for _, sh := range a.shards {
+		delete(sh.sketches, ts)
+		for k, actions := range sh.entries[ts] {
+			data[k] = actions
+		}
+	}
+	a.shards[0].sketches[ts] = keysSketch(data, a.sketchPrecision)
+	if ts == a.today() {
+		a.resetCounters()
+	}
+	a.log.Debug("dropped a visitor of an ignored ASN")
+}
+
+// asnCounts counts the sessions of each autonomous system.
+type asnCounts map[ASN]int
+
+// add counts a session whose first action is act, or takes it away again
+// with sign -1. Sessions that weren't looked up aren't counted.
+func (c asnCounts) add(act Action, sign int) {
+	if len(act.ASNOrg) == 0 {
+		return
+	}
+	k := ASN{Number: act.ASN, Org: act.ASNOrg}
+	if c[k] += sign; c[k] == 0 {
+		delete(c, k)
+	}
+}
+
+// report lists the autonomous systems with the most sessions first.
+func (c asnCounts) report(n int) []ASNCount {
+	if len(c) == 0 {
+		return nil
+	}
+	ranked := make([]ASNCount, 0, len(c))
+	for asn, sessions := range c {
+		ranked = append(ranked, ASNCount{ASN: asn, Sessions: sessions})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Sessions != ranked[j].Sessions {
+			return ranked[i].Sessions > ranked[j].Sessions
+		}
+		return ranked[i].Number < ranked[j].Number
+	})
+	if len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
@@ -0,0 +1,152 @@
+package analytics
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testASNs looks up the documentation networks, failing for 203.0.113.0/24.
+func testASNs(ip net.IP) (ASN, error) {
+	switch {
+	case ip.Equal(net.ParseIP("192.0.2.1")), ip.Equal(net.ParseIP("192.0.2.2")):
+		return ASN{Number: 64500, Org: "Example Hosting"}, nil
+	case ip.Equal(net.ParseIP("198.51.100.1")):
+		return ASN{Number: 64501, Org: "Example Cloud"}, nil
+	}
+	return ASN{}, errors.New("lookup failed")
+}
+
+func TestASNEnrichment(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{Directory: t.TempDir(), ASNLookup: testASNs, IgnoreASNs: []uint32{64501}}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	insert := func(addr, page string) {
+		a.InsertRequest(visit(addr, page))
+		a.enricher.pending.Wait()
+	}
+	insert("192.0.2.1:4000", "/")
+	insert("192.0.2.1:4000", "/about")
+	insert("192.0.2.2:4000", "/")
+	insert("198.51.100.1:4000", "/")
+	insert("198.51.100.1:4001", "/")
+	insert("203.0.113.1:4000", "/")
+
+	byKey := views(a)
+	if actions := byKey["192.0.2.1:4000"]; len(actions) != 2 || actions[0].ASN != 64500 || actions[0].ASNOrg != "Example Hosting" || actions[1].ASNOrg != "" {
+		t.Errorf("stored %+v, want the ASN on the first action only", actions)
+	}
+	if _, ok := byKey["198.51.100.1:4000"]; ok {
+		t.Error("a visitor of an ignored ASN was kept after the lookup")
+	}
+	if _, ok := byKey["198.51.100.1:4001"]; ok {
+		t.Error("a visitor of an ignored ASN was recorded")
+	}
+	if n := atomic.LoadInt64(&a.metrics.ignoredASN); n != 1 {
+		t.Errorf("counted %d ignored requests, want the one of a known IP", n)
+	}
+
+	want := []ASNCount{
+		{ASN: ASN{Number: 64500, Org: "Example Hosting"}, Sessions: 2},
+		{ASN: ASN{Org: unknownASN}, Sessions: 1},
+	}
+	today, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if today.SessionCount != 3 || !reflect.DeepEqual(today.ASNs, want) {
+		t.Errorf("today got %d sessions and %+v, want 3 and %+v", today.SessionCount, today.ASNs, want)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a = newTestAnalytics(t, config, WithClock(clock))
+	saved, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.ASNs, want) {
+		t.Errorf("the saved day got %+v, want %+v", saved.ASNs, want)
+	}
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?date="+a.dayKey(day), nil))
+	for _, s := range []string{"<h3>Networks</h3>", "AS64500", "Example Hosting"} {
+		if !strings.Contains(rec.Body.String(), s) {
+			t.Errorf("the dashboard doesn't show %q", s)
+		}
+	}
+}
+
+// TestCloseWaitsForLookup checks a lookup running while the Analyzer is
+// closed is stored before the last flush.
+func TestCloseWaitsForLookup(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	started, release := make(chan struct{}), make(chan struct{})
+	lookup := func(ip net.IP) (ASN, error) {
+		close(started)
+		<-release
+		return ASN{Number: 64500, Org: "Example Hosting"}, nil
+	}
+	config := AnalyticsConfiguration{Directory: t.TempDir(), ASNLookup: lookup}
+	a := newTestAnalytics(t, config, WithClock(fixedClock(day)))
+	a.InsertRequest(visit("192.0.2.1:4000", "/"))
+	<-started
+	closed := make(chan error, 1)
+	go func() { closed <- a.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("Close returned during the lookup")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	saved := a.readDayFile(a.dayFileName(day))
+	if actions := saved["192.0.2.1:4000"]; len(actions) != 1 || actions[0].ASNOrg != "Example Hosting" {
+		t.Errorf("saved %+v, want the lookup stored", actions)
+	}
+}
+
+func TestReverseDNSRate(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{Directory: t.TempDir(), ReverseDNSPerSecond: 1})
+	a.enricher.resolve = func(ctx context.Context, addr string) ([]string, error) {
+		return []string{"crawler-" + strings.ReplaceAll(addr, ".", "-") + ".example.net."}, nil
+	}
+	now := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	if en := a.enricher.find(a.log, "192.0.2.1", now); en.hostname != "crawler-192-0-2-1.example.net" || en.asn.Org != unknownASN {
+		t.Errorf("got %+v", en)
+	}
+	if en := a.enricher.find(a.log, "192.0.2.2", now); en.hostname != "" {
+		t.Errorf("a lookup over the rate got %+v", en)
+	}
+	if en := a.enricher.find(a.log, "192.0.2.3", now.Add(time.Second)); en.hostname != "crawler-192-0-2-3.example.net" {
+		t.Errorf("a lookup in the next second got %+v", en)
+	}
+	if en := a.enricher.find(a.log, "192.0.2.1", now.Add(time.Second)); en.hostname != "crawler-192-0-2-1.example.net" {
+		t.Errorf("a cached lookup got %+v", en)
+	}
+}
+
+func TestASNConfig(t *testing.T) {
+	for _, config := range []AnalyticsConfiguration{
+		{IgnoreASNs: []uint32{64500}},
+		{ASNDatabase: "asn.mmdb", ASNLookup: testASNs},
+		{ReverseDNSPerSecond: -1},
+	} {
+		config.Directory = t.TempDir()
+		if _, err := NewAnalyticsWithError(config, discard); err == nil {
+			t.Errorf("%+v was accepted", config)
+		}
+	}
+}
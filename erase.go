@@ -0,0 +1,288 @@
+package analytics
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// DeleteVisitor removes every action of a visitor recorded from through to,
+// for erasure requests, from memory and from the day files of every site,
+// returning how many days were rewritten. The visitor is given as a key, as
+// the dashboard and Export show them, or as an IP, with or without a port.
+// With HashIPSecret or a HashFunc the keys are hashed again for every day,
+// and since keys are derived from the address with its port, an IP alone is
+// hashed with every port, unless IPAnonymization truncates them. Days of
+// archived months are rewritten and bundled again. Days on AggregateNames
+// peers aren't touched, it must be called on every instance.
+func (a analytics) DeleteVisitor(ipOrHash string, from, to time.Time) (int, error) {
+	from, to, err := a.visitorRange(ipOrHash, from, to)
+	if err != nil {
+		return 0, err
+	}
+	rewritten := 0
+	for _, s := range a.sites {
+		n, err := s.deleteVisitor(ipOrHash, from, to)
+		rewritten += n
+		if err != nil {
+			return rewritten, fmt.Errorf("deleting a visitor of %s: %w", s.Name, err)
+		}
+	}
+	return rewritten, nil
+}
+
+func (a analytics) deleteVisitor(who string, from, to time.Time) (int, error) {
+	// Hashing an IP with every port takes a while, so the keys are derived
+	// before writes are held up.
+	matchers := map[string]func(key string) bool{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if ts := a.dayKey(d); a.hasVisitorDay(ts, d) {
+			matchers[ts] = a.visitorMatcher(ts, who)
+		}
+	}
+	a.writeMux.Lock()
+	rewritten := 0
+	var bundled []time.Time
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		deleted, err := a.deleteVisitorDay(who, d, matchers[a.dayKey(d)])
+		if err != nil {
+			a.writeMux.Unlock()
+			return rewritten, err
+		}
+		if !deleted {
+			continue
+		}
+		rewritten++
+		month := time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, a.location)
+		if _, err := os.Stat(a.bundleFileName(month)); err == nil && (len(bundled) == 0 || !bundled[len(bundled)-1].Equal(month)) {
+			bundled = append(bundled, month)
+		}
+	}
+	a.writeMux.Unlock()
+	// The rewritten days of archived months are loose files now, read
+	// instead of the bundle but still leaving the visitor in it.
+	for _, month := range bundled {
+		if err := a.archive(month, nil); err != nil {
+			return rewritten, err
+		}
+	}
+	return rewritten, nil
+}
+
+// hasVisitorDay tells whether date is in memory or has visitors or bots
+// saved.
+func (a analytics) hasVisitorDay(ts string, date time.Time) bool {
+	a.Mux.RLock()
+	open := a.openDays[ts]
+	a.Mux.RUnlock()
+	return open || !a.memoryOnly && (dayFileExists(a.dayFileName(date)) || dayFileExists(a.botFileName(date)))
+}
+
+// deleteVisitorDay removes the visitor from date with match, their
+// visitorMatcher for the day, loading the day into memory to rewrite it if it
+// isn't already. The caller holds writeMux.
+func (a analytics) deleteVisitorDay(who string, date time.Time, match func(key string) bool) (bool, error) {
+	ts := a.dayKey(date)
+	a.Mux.Lock()
+	open := a.openDays[ts]
+	a.Mux.Unlock()
+	saved := !a.memoryOnly && dayFileExists(a.dayFileName(date))
+	bots := !a.memoryOnly && dayFileExists(a.botFileName(date))
+	if !open && !saved && !bots {
+		return false, nil
+	}
+	if match == nil {
+		// The day was recorded since the matchers were made.
+		match = a.visitorMatcher(ts, who)
+	}
+
+	a.Mux.Lock()
+	if !open {
+		a.openDay(ts, date)
+	}
+	loadedBots := a.BotEntries[ts] == nil && bots
+	if loadedBots {
+		a.loadBots(date)
+	}
+	deleted, dropped := false, false
+	data := map[string][]Action{}
+	for _, sh := range a.shards {
+		for k := range sh.entries[ts] {
+			if match(k) {
+				delete(sh.entries[ts], k)
+				delete(sh.views[ts], k)
+				deleted = true
+			}
+		}
+		for k := range sh.dropped[ts] {
+			if match(k) {
+				delete(sh.dropped[ts], k)
+				deleted, dropped = true, true
+			}
+		}
+		for k, actions := range sh.entries[ts] {
+			data[k] = actions
+		}
+	}
+	for k, actions := range a.BotEntries[ts] {
+		if match(k) {
+			a.botActions[ts] -= len(actions)
+			delete(a.BotEntries[ts], k)
+			deleted = true
+		}
+	}
+	if deleted {
+		// A sketch can't forget a visitor, so it's made again from the
+		// visitors left.
+		for _, sh := range a.shards {
+			delete(sh.sketches, ts)
+		}
+		a.shards[0].sketches[ts] = keysSketch(data, a.sketchPrecision)
+		if ts == a.today() {
+			a.resetCounters()
+		}
+	}
+	a.Mux.Unlock()
+
+	var err error
+	if deleted && !a.memoryOnly {
+		err = a.writeDays(ts)
+		// writeDays only writes the dropped counts of days that have some.
+		if err == nil && dropped {
+			err = a.writeDropped(date, a.dayDropped(ts))
+		}
+	}
+	a.Mux.Lock()
+	if !open {
+		a.closeDay(ts)
+	}
+	if loadedBots && !open {
+		delete(a.BotEntries, ts)
+		delete(a.botActions, ts)
+	}
+	a.Mux.Unlock()
+	a.invalidateDay(date)
+	return deleted && err == nil, err
+}
+
+// visitorMatcher returns whether a key of day ts belongs to who, a key or an
+// IP with or without a port.
+func (a analytics) visitorMatcher(ts, who string) func(key string) bool {
+	if a.hash == nil && a.truncateIPs {
+		truncated := truncateIP(who)
+		return func(key string) bool {
+			return key == who || key == truncated
+		}
+	}
+	if a.hash == nil {
+		return func(key string) bool {
+			return key == who || addrHost(key) == who
+		}
+	}
+	keys := map[string]bool{who: true}
+	secret, ok := a.daySecret(ts, false)
+	if !ok {
+		// The day's salt was deleted, its keys can't be derived anymore.
+		return func(key string) bool {
+			return key == who
+		}
+	}
+	add := func(addr string) {
+		key := a.hash(ts, addr, secret)
+		keys[key] = true
+		// Binary keys are hex encoded when they're read, see migrateKeys.
+		if binaryKey(key) {
+			keys[hex.EncodeToString([]byte(key))] = true
+		}
+	}
+	switch {
+	case a.truncateIPs:
+		add(truncateIP(who))
+	case addrHost(who) == who:
+		add(who)
+		for port := 0; port <= 65535; port++ {
+			add(net.JoinHostPort(who, strconv.Itoa(port)))
+		}
+	default:
+		add(who)
+	}
+	return func(key string) bool {
+		return keys[key]
+	}
+}
+
+// Erase deletes a visitor's actions with DeleteVisitor. It expects a POST
+// with the form values "visitor" (their IP or key) and optionally "from"
+// and "to" (YYYY-MM-DD, today by default) and answers with how many days
+// were rewritten, {"days": n}. It's protected by the dashboard password.
+func (a analytics) Erase(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "erasing takes a POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.log.Info("bad erasure: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visitor := r.Form.Get("visitor")
+	if len(visitor) == 0 {
+		http.Error(w, "visitor is required", http.StatusBadRequest)
+		return
+	}
+	from, to, ok := a.formRange(w, r)
+	if !ok {
+		return
+	}
+	n, err := a.DeleteVisitor(visitor, from, to)
+	if err != nil {
+		a.log.Error("erasing a visitor: %v", err)
+		http.Error(w, "erasing failed, "+strconv.Itoa(n)+" days were rewritten", http.StatusInternalServerError)
+		return
+	}
+	a.log.Info("erased a visitor from %d days of %s to %s", n, a.dayKey(from), a.dayKey(to))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Days int `json:"days"`
+	}{n})
+}
+
+// formRange reads the days "from" through "to" of a parsed form, today for
+// either if unset. On failure the error response has already been written.
+func (a analytics) formRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	today, err := a.parseDay(a.today())
+	if err != nil {
+		a.log.Error("reading a range: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(nil)
+		return today, today, false
+	}
+	from, to := today, today
+	for _, bound := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		if v := r.Form.Get(bound.name); len(v) > 0 {
+			t, err := a.parseDay(v)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid %s date %q, expected YYYY-MM-DD", bound.name, v), http.StatusBadRequest)
+				return from, to, false
+			}
+			*bound.t = t
+		}
+	}
+	if from.After(to) {
+		http.Error(w, "from is after to", http.StatusBadRequest)
+		return from, to, false
+	}
+	return from, to, true
+}
@@ -0,0 +1,172 @@
+package analytics
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordedDay records two visits of 192.0.2.1 and one of 192.0.2.2 on
+// day, flushed to a fresh directory, and returns that directory.
+func recordedDay(t *testing.T, config AnalyticsConfiguration, day time.Time) string {
+	t.Helper()
+	config.Directory = t.TempDir()
+	a := newTestAnalytics(t, config, WithClock(&movingClock{t: day}))
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	a.InsertRequest(visit("192.0.2.1:5000", "/b"))
+	a.InsertRequest(visit("192.0.2.2:4000", "/a"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return config.Directory
+}
+
+func TestDeleteVisitorToday(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	a.InsertRequest(visit("192.0.2.1:5000", "/b"))
+	a.InsertRequest(visit("192.0.2.2:4000", "/a"))
+	if dd, _ := a.Stats(a.now()); dd.SessionCount != 3 {
+		t.Fatalf("got %d sessions before deleting, want 3", dd.SessionCount)
+	}
+	n, err := a.DeleteVisitor("192.0.2.1", a.now(), a.now())
+	if err != nil || n != 1 {
+		t.Fatalf("deleted from %d days (%v), want 1", n, err)
+	}
+	if entries := views(a); len(entries) != 1 || entries["192.0.2.2:4000"] == nil {
+		t.Errorf("left %v, want 192.0.2.2:4000 only", entries)
+	}
+	if dd, _ := a.Stats(a.now()); dd.SessionCount != 1 || dd.PageViews != 1 {
+		t.Errorf("got %d sessions and %d views, want 1 and 1", dd.SessionCount, dd.PageViews)
+	}
+	if n, err := a.DeleteVisitor("192.0.2.1", a.now(), a.now()); err != nil || n != 0 {
+		t.Errorf("deleting again rewrote %d days (%v), want none", n, err)
+	}
+}
+
+// TestDeleteHashedVisitor deletes a visitor by IP from a day on disk whose
+// keys were hashed with the port.
+func TestDeleteHashedVisitor(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	config := AnalyticsConfiguration{HashIPSecret: "secret"}
+	dir := recordedDay(t, config, day)
+	config.Directory = dir
+	a := newTestAnalytics(t, config, WithClock(&movingClock{t: day.AddDate(0, 0, 3)}))
+	other := a.visitorKey(a.dayKey(day), "192.0.2.2:4000")
+
+	n, err := a.DeleteVisitor("192.0.2.1", day.AddDate(0, 0, -1), day.AddDate(0, 0, 1))
+	if err != nil || n != 1 {
+		t.Fatalf("deleted from %d days (%v), want 1", n, err)
+	}
+	if entries := a.readSavedData(day); len(entries) != 1 || entries[other] == nil {
+		t.Errorf("left %d visitors in the day file, want 192.0.2.2 only", len(entries))
+	}
+	if s := a.ownSummary(day); s.Sessions != 1 || s.PageViews != 1 {
+		t.Errorf("summary has %d sessions and %d views, want 1 and 1", s.Sessions, s.PageViews)
+	}
+	if a.isOpen(a.dayKey(day)) {
+		t.Error("the day stayed in memory")
+	}
+
+	n, err = a.DeleteVisitor(other, day, day)
+	if err != nil || n != 1 {
+		t.Fatalf("deleting by key rewrote %d days (%v), want 1", n, err)
+	}
+	if entries := a.readSavedData(day); len(entries) != 0 {
+		t.Errorf("left %d visitors, want none", len(entries))
+	}
+}
+
+func TestDeleteDroppedVisitor(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	a := newTestAnalytics(t, AnalyticsConfiguration{MaxActionsPerVisitorPerDay: 1}, WithClock(&movingClock{t: day}))
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	a.InsertRequest(visit("192.0.2.1:4000", "/b"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if dropped := a.readDropped(day); dropped["192.0.2.1:4000"] != 1 {
+		t.Fatalf("dropped %v before deleting, want one action", dropped)
+	}
+	if n, err := a.DeleteVisitor("192.0.2.1:4000", day, day); err != nil || n != 1 {
+		t.Fatalf("deleted from %d days (%v), want 1", n, err)
+	}
+	if dropped := a.readDropped(day); len(dropped) != 0 {
+		t.Errorf("dropped counts %v left", dropped)
+	}
+}
+
+func TestDeleteArchivedVisitor(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	dir := recordedDay(t, AnalyticsConfiguration{}, day)
+	a := newTestAnalytics(t, AnalyticsConfiguration{Directory: dir}, WithClock(&movingClock{t: day.AddDate(0, 2, 0)}))
+	if err := a.Archive(day); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := a.DeleteVisitor("192.0.2.1", day, day); err != nil || n != 1 {
+		t.Fatalf("deleted from %d days (%v), want 1", n, err)
+	}
+	if _, err := os.Stat(a.dayFileName(day)); !os.IsNotExist(err) {
+		t.Errorf("the day was left out of the bundle: %v", err)
+	}
+	if entries := a.readSavedData(day); len(entries) != 1 || entries["192.0.2.2:4000"] == nil {
+		t.Errorf("bundle has %d visitors, want 192.0.2.2:4000 only", len(entries))
+	}
+	zr, err := zip.OpenReader(a.bundleFileName(day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if strings.HasSuffix(f.Name, ".tmp") {
+			t.Errorf("bundle has %s", f.Name)
+		}
+	}
+}
+
+func TestErase(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{Password: "pw"})
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	erase := func(method string, form url.Values, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/erase", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(password) > 0 {
+			r.SetBasicAuth("", password)
+		}
+		rec := httptest.NewRecorder()
+		a.Erase(rec, r)
+		return rec
+	}
+	today := a.today()
+	for _, c := range []struct {
+		method   string
+		form     url.Values
+		password string
+		code     int
+	}{
+		{http.MethodPost, url.Values{"visitor": {"192.0.2.1"}}, "", http.StatusUnauthorized},
+		{http.MethodGet, url.Values{"visitor": {"192.0.2.1"}}, "pw", http.StatusMethodNotAllowed},
+		{http.MethodPost, url.Values{}, "pw", http.StatusBadRequest},
+		{http.MethodPost, url.Values{"visitor": {"192.0.2.1"}, "from": {"yesterday"}}, "pw", http.StatusBadRequest},
+		{http.MethodPost, url.Values{"visitor": {"192.0.2.1"}, "from": {"2999-01-01"}}, "pw", http.StatusBadRequest},
+	} {
+		if rec := erase(c.method, c.form, c.password); rec.Code != c.code {
+			t.Errorf("%s %v answered %d, want %d", c.method, c.form, rec.Code, c.code)
+		}
+	}
+	if len(views(a)) != 1 {
+		t.Fatal("a refused erasure deleted the visitor")
+	}
+	rec := erase(http.MethodPost, url.Values{"visitor": {"192.0.2.1"}, "from": {today}, "to": {today}}, "pw")
+	if rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"days":1}` {
+		t.Errorf("answered %d %q, want 200 {\"days\":1}", rec.Code, rec.Body.String())
+	}
+	if len(views(a)) != 0 {
+		t.Error("the visitor wasn't deleted")
+	}
+}
@@ -0,0 +1,162 @@
+package analytics
+
+import (
+	"net/http"
+	"sort"
+)
+
+// VariantFunc returns the experiment a request takes part in and the variant
+// of it the visitor was assigned, e.g. from a cookie set by the handler. An
+// empty experiment records no assignment.
+type VariantFunc func(r *http.Request) (experiment, variant string)
+
+// contaminated is what the variant of an experiment is counted as for a
+// visitor seen with several of its variants on the same day, who is left out
+// of the comparison.
+const contaminated = "\x00contaminated"
+
+type experimentKey struct {
+	experiment, variant string
+}
+
+// variantCount is how many visitors of a variant there were and how many of
+// them completed each goal.
+type variantCount struct {
+	sessions int
+	goals    []int
+}
+
+// ExperimentStats compares the variants of an experiment, ordered by name.
+// Contaminated is how many visitors saw more than one variant on the same
+// day, who aren't counted in any.
+type ExperimentStats struct {
+	Name         string         `json:"name"`
+	Variants     []VariantStats `json:"variants"`
+	Contaminated int            `json:"contaminated,omitempty"`
+}
+
+// VariantStats is how many visitors were assigned a variant and how many of
+// them completed each goal, in the order they are configured.
+type VariantStats struct {
+	Variant  string      `json:"variant"`
+	Sessions int         `json:"sessions"`
+	Goals    []GoalStats `json:"goals,omitempty"`
+}
+
+// assignVariant records the experiment and variant VariantFunc assigns the
+// request to on act.
+func (a analytics) assignVariant(r *http.Request, act *Action) {
+	if a.variant == nil {
+		return
+	}
+	experiment, variant := a.variant(r)
+	if len(experiment) == 0 {
+		return
+	}
+	if len(experiment) > maxTargetLength {
+		experiment = experiment[:maxTargetLength]
+	}
+	if len(variant) > maxTargetLength {
+		variant = variant[:maxTargetLength]
+	}
+	act.Experiment, act.Variant = experiment, variant
+}
+
+// visitorVariants returns the variant of each experiment a visitor's actions
+// of a day were assigned, contaminated for those with several.
+func visitorVariants(actions []Action) map[string]string {
+	var variants map[string]string
+	for _, act := range actions {
+		if len(act.Experiment) == 0 {
+			continue
+		}
+		if variants == nil {
+			variants = map[string]string{}
+		}
+		if v, ok := variants[act.Experiment]; !ok {
+			variants[act.Experiment] = act.Variant
+		} else if v != act.Variant {
+			variants[act.Experiment] = contaminated
+		}
+	}
+	return variants
+}
+
+// goalsDone tells which goals a visitor's actions of a day complete.
+func (a analytics) goalsDone(actions []Action) []bool {
+	if len(a.goals) == 0 {
+		return nil
+	}
+	ordered := inOrder(actions)
+	done := make([]bool, len(a.goals))
+	for i, g := range a.goals {
+		done[i] = stepsReached(g.Steps, ordered) == len(g.Steps)
+	}
+	return done
+}
+
+// tallyVariants adds a visitor assigned variants who completed the done
+// goals to counts, or takes them away again with sign -1.
+func tallyVariants(counts map[experimentKey]variantCount, variants map[string]string, done []bool, sign int) {
+	for experiment, variant := range variants {
+		k := experimentKey{experiment: experiment, variant: variant}
+		c := counts[k]
+		c.sessions += sign
+		if variant != contaminated {
+			if len(c.goals) < len(done) {
+				c.goals = append(c.goals, make([]int, len(done)-len(c.goals))...)
+			}
+			for i, d := range done {
+				if d {
+					c.goals[i] += sign
+				}
+			}
+		}
+		if c.sessions == 0 {
+			delete(counts, k)
+		} else {
+			counts[k] = c
+		}
+	}
+}
+
+// countExperiments updates the variant counts of today with the actions of
+// visitor so far, taking away what their earlier actions were counted as.
+func (a analytics) countExperiments(c *todayCounters, visitor string, actions []Action) {
+	v := c.visitors[visitor]
+	variants := visitorVariants(actions)
+	if len(variants) == 0 && len(v.variants) == 0 {
+		return
+	}
+	tallyVariants(c.ag.variants, v.variants, v.variantGoals, -1)
+	v.variants, v.variantGoals = variants, a.goalsDone(actions)
+	tallyVariants(c.ag.variants, v.variants, v.variantGoals, 1)
+}
+
+// experimentStats reports the counted experiments with the conversion rate
+// of each goal per variant.
+func (a analytics) experimentStats(counts map[experimentKey]variantCount) []ExperimentStats {
+	if len(counts) == 0 {
+		return nil
+	}
+	byName := map[string]*ExperimentStats{}
+	for k, c := range counts {
+		e := byName[k.experiment]
+		if e == nil {
+			e = &ExperimentStats{Name: k.experiment}
+			byName[k.experiment] = e
+		}
+		if k.variant == contaminated {
+			e.Contaminated += c.sessions
+			continue
+		}
+		e.Variants = append(e.Variants, VariantStats{Variant: k.variant, Sessions: c.sessions, Goals: a.goalStats(c.goals, c.sessions)})
+	}
+	stats := make([]ExperimentStats, 0, len(byName))
+	for _, e := range byName {
+		sort.Slice(e.Variants, func(i, j int) bool { return e.Variants[i].Variant < e.Variants[j].Variant })
+		stats = append(stats, *e)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
@@ -0,0 +1,109 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// abVariant assigns the variant of the "ab" cookie of the checkout
+// experiment.
+func abVariant(r *http.Request) (string, string) {
+	c, err := r.Cookie("ab")
+	if err != nil {
+		return "", ""
+	}
+	return "checkout", c.Value
+}
+
+func TestExperiments(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{
+		Directory:   t.TempDir(),
+		Goals:       []GoalConfig{{Name: "signup", Steps: []string{"/signup/done"}}},
+		VariantFunc: abVariant,
+	}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	for _, v := range []struct{ addr, variant, page string }{
+		{"192.0.2.1:4000", "a", "/"},
+		{"192.0.2.1:4000", "a", "/signup/done"},
+		{"192.0.2.2:4000", "a", "/"},
+		{"192.0.2.3:4000", "b", "/signup/done"},
+		{"192.0.2.4:4000", "a", "/"},
+		{"192.0.2.4:4000", "b", "/signup/done"},
+		{"192.0.2.5:4000", "", "/signup/done"},
+	} {
+		r := visit(v.addr, v.page)
+		if len(v.variant) > 0 {
+			r.AddCookie(&http.Cookie{Name: "ab", Value: v.variant})
+		}
+		a.InsertRequest(r)
+	}
+	want := []ExperimentStats{{
+		Name: "checkout",
+		Variants: []VariantStats{
+			{Variant: "a", Sessions: 2, Goals: []GoalStats{{Name: "signup", Completions: 1, Rate: 50}}},
+			{Variant: "b", Sessions: 1, Goals: []GoalStats{{Name: "signup", Completions: 1, Rate: 100}}},
+		},
+		Contaminated: 1,
+	}}
+	today, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(today.Experiments, want) {
+		t.Errorf("today got %+v, want %+v", today.Experiments, want)
+	}
+	if actions := views(a)["192.0.2.1:4000"]; len(actions) == 0 || actions[0].Experiment != "checkout" || actions[0].Variant != "a" {
+		t.Errorf("stored %+v, want the assignment", actions)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a = newTestAnalytics(t, config, WithClock(clock))
+	saved, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Experiments, want) {
+		t.Errorf("the saved day got %+v, want %+v", saved.Experiments, want)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?date="+a.dayKey(day), nil))
+	for _, s := range []string{"<h4>checkout</h4>", "1 (50.0%)", "1 visitors saw more than one variant"} {
+		if !strings.Contains(rec.Body.String(), s) {
+			t.Errorf("the dashboard doesn't show %q", s)
+		}
+	}
+}
+
+func TestExperimentsRange(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	a := newTestAnalytics(t, AnalyticsConfiguration{Directory: t.TempDir(), VariantFunc: abVariant}, WithClock(clock))
+	// Seeing another variant on the next day doesn't contaminate a visitor.
+	for i, variant := range []string{"a", "b"} {
+		clock.set(day.AddDate(0, 0, i))
+		r := visit("192.0.2.1:4000", "/")
+		r.AddCookie(&http.Cookie{Name: "ab", Value: variant})
+		a.InsertRequest(r)
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dd, err := a.StatsRange(day, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ExperimentStats{{Name: "checkout", Variants: []VariantStats{{Variant: "a", Sessions: 1}, {Variant: "b", Sessions: 1}}}}
+	if !reflect.DeepEqual(dd.Experiments, want) {
+		t.Errorf("got %+v, want %+v", dd.Experiments, want)
+	}
+}
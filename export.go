@@ -0,0 +1,134 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var exportHeader = []string{"date", "visitor_hash", "page", "query", "timestamp", "event", "target", "bytes", "duration_ms", "referrer", "trace_id", "raw_page", "group"}
+
+// Export streams the recorded actions of a day (?date=) or range (?from=&to=)
+// as CSV, one row per action, or with ?searches=1 every search term of
+// them. It's protected by the dashboard password.
+func (a analytics) Export(w http.ResponseWriter, r *http.Request) {
+	a = a.site(r.URL.Query().Get("site"))
+	if !a.authorized(w, r) {
+		return
+	}
+	from, to, ok := a.requestRange(w, r)
+	if !ok {
+		return
+	}
+
+	fileName := a.Name + "-" + a.dayKey(from)
+	if !to.Equal(from) {
+		fileName += "_" + a.dayKey(to)
+	}
+	searches := r.URL.Query().Get("searches") == "1"
+	if searches {
+		fileName += "-searches"
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName + ".csv"}))
+	if searches {
+		if err := a.exportSearches(w, from, to); err != nil {
+			a.log.Info("writing search terms: %v", err)
+		}
+		return
+	}
+
+	flusher, _ := w.(http.Flusher)
+	if err := a.exportCSV(w, from, to, flusher); err != nil {
+		a.log.Info("writing export: %v", err)
+	}
+}
+
+// ExportRange writes the actions of the days from through to as CSV, the
+// same rows Export serves. Like StatsRange the range is at most 92 days.
+func (a analytics) ExportRange(w io.Writer, from, to time.Time) error {
+	from, err := a.parseDay(a.dayKey(from))
+	if err != nil {
+		return err
+	}
+	to, err = a.parseDay(a.dayKey(to))
+	if err != nil {
+		return err
+	}
+	if err := checkRange(from, to); err != nil {
+		return err
+	}
+	return a.exportCSV(w, from, to, nil)
+}
+
+// exportCSV writes the header and then a day at a time, flushing after each
+// day if flusher isn't nil so large exports start arriving early.
+func (a analytics) exportCSV(w io.Writer, from, to time.Time, flusher http.Flusher) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(exportHeader); err != nil {
+		return err
+	}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if err := a.exportDay(cw, d); err != nil {
+			return err
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	return nil
+}
+
+func (a analytics) exportDay(cw *csv.Writer, date time.Time) error {
+	data := a.loadDay(date)
+	visitors := make([]string, 0, len(data))
+	for v := range data {
+		visitors = append(visitors, v)
+	}
+	sort.Strings(visitors)
+
+	day := a.dayKey(date)
+	row := make([]string, len(exportHeader))
+	for _, v := range visitors {
+		for _, act := range data[v] {
+			row[0] = day
+			row[1] = v
+			row[2] = act.Page
+			row[3] = act.Query
+			row[4] = ""
+			if act.Timestamp > 0 {
+				row[4] = time.UnixMilli(act.Timestamp).In(date.Location()).Format(time.RFC3339)
+			}
+			row[5] = act.Event
+			row[6] = act.Target
+			row[7] = strconv.FormatInt(act.Bytes, 10)
+			row[8] = ""
+			if act.Duration > 0 {
+				row[8] = strconv.FormatFloat(float64(act.Duration)/float64(time.Millisecond), 'f', 3, 64)
+			}
+			row[9] = act.Referrer
+			row[10] = act.TraceID
+			row[11] = act.RawPage
+			row[12] = ""
+			if len(act.Event) == 0 {
+				group, _ := a.urlKey(act.Page)
+				row[12] = group
+				if label := a.groupLabels[group]; len(label) > 0 {
+					row[12] = label
+				}
+			}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package analytics
+
+// On-disk layout versions. Every version is still read by the current code;
+// each has a sample data directory in testdata/format/v<version> written by
+// the release that introduced it, and the numbers the current code reads
+// from it in testdata/format/v<version>/golden.json.
+//
+// Version 0 is a day file at <Directory>/YYYY/MM/DD/<Name>YYYY-MM-DD holding
+// the zlib compressed JSON encoding of that day's visitor -> actions map,
+// with HashIPSecret keys being the raw bytes of their hash.
+//
+// Version 1 adds <Name>YYYY-MM-DD.summary next to each day file, the JSON
+// encoding of its daySummary, then only its Sessions and PageViews.
+//
+// Version 2 adds <Name>YYYY-MM-DD.bots, the day's blacklisted requests kept
+// with TrackBots, encoded like the day file.
+//
+// Version 3 adds the page views by hour of the day, Hours, to summaries.
+//
+// Version 4 stores HashIPSecret keys hex encoded. Older day files are
+// re-keyed by the hex encoding of their keys when they're read.
+//
+// Version 5 adds <Name>YYYY-MM-DD.dropped, the JSON encoding of how many
+// actions of each visitor MaxActionsPerVisitorPerDay dropped.
+//
+// Version 6 adds the HashScheme the day's visitors were keyed with, Scheme,
+// to summaries.
+//
+// Version 7 adds <Directory>/YYYY/MM/<Name>YYYY-MM.index, the JSON encoding
+// of the monthIndex of the month's days, and the day's most viewed pages,
+// Pages, to summaries.
+//
+// Version 8 adds <Directory>/YYYY/MM/<Name>YYYY-MM.zip, the bundle Archive
+// moves the files of a month's days into as DD/<file name>.
+//
+// Version 9 adds the HyperLogLog sketch of the day's visitors, Sketch, to
+// summaries.
+//
+// Version 10 adds <Name>YYYY-MM-DD.aggregate, the JSON encoding of the
+// anonymizedDay AnonymizeAfterDays reduces a day to, deleting its day file,
+// bots and dropped counts.
+//
+// Version 11 adds <Name>YYYY-MM-DD.clicks, the JSON encoding of the click map
+// of each ClickMapPages page, its clicks as [x, y, viewport] triples.
+//
+// Version 12 feeds the sketches of summaries the keyHash of the visitor keys
+// rather than the IPs, and marks them with KeySketch. Older sketches are used
+// as they are for days that are only read, and made again from the keys when
+// a day is loaded to record into.
+//
+// Version 13 adds <Directory>/salts/YYYY-MM-DD.salt, the 32 random bytes of the
+// salt RotatingSecret derives the secret of a day's visitor keys from, shared by
+// every site of the directory.
+//
+// Fields added to Action don't change the version, as older readers ignore
+// them and newer ones read their zero values from older files.
+//
+// FormatVersion is the layout writeFile produces. MinFormatVersion is the
+// oldest layout readSavedData is guaranteed to load. Raising FormatVersion
+// requires adding the corpus of the new version, TestFormatCorpus fails
+// without it. Raising MinFormatVersion drops support for data people
+// already have on disk, so it must only happen together with a migration
+// that rewrites the older layouts, and the change has to be called out in
+// the readme.
+const (
+	FormatVersion    = 13
+	MinFormatVersion = 0
+)
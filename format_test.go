@@ -0,0 +1,258 @@
+package analytics
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var updateCorpus = flag.Bool("update", false, "rewrite the golden files of the format corpus")
+
+// corpusConfig is what the corpus was recorded with.
+var corpusConfig = AnalyticsConfiguration{
+	Name:                "corpus",
+	HashIPSecret:        "corpus-secret",
+	GroupByURLSegment:   1,
+	EntriesByURLSegment: 1,
+	TrackBots:           true,
+	Timezone:            "UTC",
+}
+
+// corpusNow is after every day of the corpus, so none is today.
+var corpusNow = time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// corpusVisitor is the address whose key on each day with a RotatingSecret
+// salt the golden files hold.
+const corpusVisitor = "192.0.2.1:4000"
+
+// corpusGolden is what the current code reads from a corpus version.
+type corpusGolden struct {
+	Days  map[string]corpusDay `json:"days"`
+	Range *corpusDay           `json:"range,omitempty"`
+	Salts map[string]string    `json:"salted_keys,omitempty"`
+}
+
+type corpusDay struct {
+	SessionCount   int              `json:"session_count"`
+	PageViews      int              `json:"page_views"`
+	Bytes          int64            `json:"bytes"`
+	UniqueVisitors int              `json:"unique_visitors,omitempty"`
+	Truncated      int              `json:"truncated_visitors,omitempty"`
+	Dropped        int              `json:"dropped_actions,omitempty"`
+	URLHits        []URLGroup       `json:"url_hits"`
+	Outbound       []LinkClicks     `json:"outbound"`
+	Hours          []HourViews      `json:"hours"`
+	Visitors       []VisitorSummary `json:"visitors"`
+	Trend          []TrendDay       `json:"trend"`
+	Bots           int              `json:"bots"`
+	BotRequests    int              `json:"bot_requests"`
+	Clicks         map[string]int   `json:"clicks,omitempty"`
+}
+
+func newCorpusDay(dd DashboardData) corpusDay {
+	return corpusDay{
+		SessionCount:   dd.SessionCount,
+		PageViews:      dd.PageViews,
+		Bytes:          dd.Bytes,
+		UniqueVisitors: dd.UniqueVisitors,
+		Truncated:      dd.Truncated,
+		Dropped:        dd.Dropped,
+		URLHits:        dd.URLHits,
+		Outbound:       dd.Outbound,
+		Hours:          dd.Hours,
+		Visitors:       dd.Visitors,
+		Trend:          dd.Trend,
+	}
+}
+
+// TestFormatCorpus reads the corpus of every format version with the
+// current code and compares the numbers with the golden files. Run it with
+// -update to rewrite them after checking the differences are intended.
+func TestFormatCorpus(t *testing.T) {
+	if MinFormatVersion > FormatVersion {
+		t.Fatalf("MinFormatVersion %d is above FormatVersion %d", MinFormatVersion, FormatVersion)
+	}
+	for v := MinFormatVersion; v <= FormatVersion; v++ {
+		t.Run(fmt.Sprintf("v%d", v), func(t *testing.T) {
+			dir := filepath.Join("testdata", "format", fmt.Sprintf("v%d", v))
+			if _, err := os.Stat(filepath.Join(dir, "data")); err != nil {
+				t.Fatalf("format version %d has no corpus in %s: %v", v, dir, err)
+			}
+			got := readCorpus(t, dir)
+			goldenFile := filepath.Join(dir, "golden.json")
+			if *updateCorpus {
+				if err := os.WriteFile(goldenFile, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("format version %d has no golden file: %v", v, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("reading %s differs from %s, run go test -run TestFormatCorpus -update and review the difference", dir, goldenFile)
+			}
+		})
+	}
+}
+
+// corpusDayFile matches the files and bundled files of the corpus's days.
+var corpusDayFile = regexp.MustCompile(`corpus(\d{4}-\d{2}-\d{2})(\.|$)`)
+
+// readCorpus reads every day of the corpus in dir from a copy, since
+// reading may migrate files and save summaries, and returns its golden file.
+func readCorpus(t *testing.T, dir string) []byte {
+	data := t.TempDir()
+	if err := copyTree(filepath.Join(dir, "data"), data); err != nil {
+		t.Fatal(err)
+	}
+	var dates []string
+	seen := map[string]bool{}
+	err := filepath.Walk(data, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		names := []string{info.Name()}
+		if filepath.Ext(path) == ".zip" {
+			names = bundleNames(t, path)
+		}
+		for _, name := range names {
+			if m := corpusDayFile.FindStringSubmatch(name); m != nil && !seen[m[1]] {
+				seen[m[1]] = true
+				dates = append(dates, m[1])
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dates) == 0 {
+		t.Fatalf("%s holds no days", dir)
+	}
+	sort.Strings(dates)
+
+	config := corpusConfig
+	config.Directory = data
+	a := newTestAnalytics(t, config, WithClock(fixedClock(corpusNow)))
+	golden := corpusGolden{Days: map[string]corpusDay{}}
+	for _, date := range dates {
+		day, _ := time.ParseInLocation(dayLayout, date, time.UTC)
+		dd, err := a.Stats(day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cd := newCorpusDay(dd)
+		for _, actions := range a.readDayFile(a.botFileName(day)) {
+			cd.Bots++
+			cd.BotRequests += len(actions)
+		}
+		for page, clicks := range a.readClicks(day) {
+			if cd.Clicks == nil {
+				cd.Clicks = map[string]int{}
+			}
+			cd.Clicks[page] = len(clicks)
+		}
+		golden.Days[date] = cd
+	}
+	if len(dates) > 1 {
+		from, _ := time.ParseInLocation(dayLayout, dates[0], time.UTC)
+		to, _ := time.ParseInLocation(dayLayout, dates[len(dates)-1], time.UTC)
+		dd, err := a.StatsRange(from, to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cd := newCorpusDay(dd)
+		golden.Range = &cd
+	}
+	golden.Salts = readCorpusSalts(t, data)
+	bs, err := json.MarshalIndent(golden, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(bs, '\n')
+}
+
+// readCorpusSalts returns the key corpusVisitor gets on each day with a
+// salt in the corpus copied to dir, nil if there are none.
+func readCorpusSalts(t *testing.T, dir string) map[string]string {
+	infos, err := os.ReadDir(filepath.Join(dir, "salts"))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	salts := newDaySalts(dir, false, 0)
+	keys := map[string]string{}
+	for _, info := range infos {
+		ts := strings.TrimSuffix(info.Name(), ".salt")
+		secret, ok, err := salts.secret(ts, corpusConfig.HashIPSecret, false)
+		if err != nil || !ok {
+			t.Fatalf("reading the salt of %s: %v", ts, err)
+		}
+		keys[ts] = SHA256Hash(ts, corpusVisitor, secret)
+	}
+	return keys
+}
+
+// copyTree copies the files of src into dst.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// bundleNames lists the files of a month's bundle.
+func bundleNames(t *testing.T, bundle string) []string {
+	zr, err := zip.OpenReader(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var names []string
+	for _, zf := range zr.File {
+		names = append(names, filepath.Base(zf.Name))
+	}
+	return names
+}
@@ -0,0 +1,80 @@
+package analytics
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"time"
+)
+
+// frequencyBuckets are the ranges of days visited Frequency counts visitors
+// in, the last one open ended.
+var frequencyBuckets = [][2]int{{1, 1}, {2, 3}, {4, 7}, {8, 0}}
+
+// Frequency is how many visitors of a range came on how many of its days.
+// Like Returning it needs visitors keyed the same every day, Unavailable says
+// why they aren't and Buckets are empty then.
+type Frequency struct {
+	Buckets     []FrequencyBucket `json:"buckets,omitempty"`
+	Unavailable string            `json:"unavailable,omitempty"`
+}
+
+// FrequencyBucket counts the visitors seen on Min through Max days, or Min
+// days or more when Max is 0.
+type FrequencyBucket struct {
+	Min      int `json:"min"`
+	Max      int `json:"max,omitempty"`
+	Visitors int `json:"visitors"`
+}
+
+// frequency counts the days each visitor of from through to was seen on.
+// Saved days are streamed from their files one at a time, so only the count
+// of each visitor is held. Anonymized days have no visitors left and aren't
+// counted.
+func (a analytics) frequency(from, to time.Time) *Frequency {
+	if reason := a.unstableKeys(); len(reason) > 0 {
+		return &Frequency{Unavailable: reason}
+	}
+	days := map[string]int{}
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if scheme := a.readSummary(d).Scheme; !a.sharesScheme(scheme) {
+			return &Frequency{Unavailable: fmt.Sprintf("visitors of %s were keyed with %s, not %s", a.dayKey(d), scheme, a.hashScheme)}
+		}
+		a.dayKeys(d, func(key string) { days[key]++ })
+	}
+	f := &Frequency{Buckets: make([]FrequencyBucket, len(frequencyBuckets))}
+	for i, b := range frequencyBuckets {
+		f.Buckets[i] = FrequencyBucket{Min: b[0], Max: b[1]}
+	}
+	for _, n := range days {
+		for i := len(frequencyBuckets) - 1; i >= 0; i-- {
+			if n >= frequencyBuckets[i][0] {
+				f.Buckets[i].Visitors++
+				break
+			}
+		}
+	}
+	return f
+}
+
+// dayKeys calls visit with the key of every visitor of date. Days in memory,
+// cached or merged with other instances' are read through loadDay, others
+// are streamed from their files.
+func (a analytics) dayKeys(date time.Time, visit func(key string)) {
+	key := a.dayKey(date)
+	if _, cached := a.dataCache.get(key); cached || a.memoryOnly || len(a.peers) > 0 || a.isToday(date) || a.isOpen(key) {
+		for k := range a.loadDay(date) {
+			visit(k)
+		}
+		return
+	}
+	err := a.streamDayFile(a.dayFileName(date), func(k string, _ []Action) {
+		if binaryKey(k) {
+			k = hex.EncodeToString([]byte(k))
+		}
+		visit(k)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		a.log.Error("%v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVisitFrequency(t *testing.T) {
+	day := time.Date(2027, time.January, 1, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{Directory: t.TempDir()}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	// Visitor i comes on the first days[i] days.
+	days := map[string]int{"192.0.2.1:4000": 1, "192.0.2.2:4000": 2, "192.0.2.3:4000": 3, "192.0.2.4:4000": 5, "192.0.2.5:4000": 9}
+	for i := 0; i < 9; i++ {
+		clock.set(day.AddDate(0, 0, i))
+		for addr, n := range days {
+			if i < n {
+				a.InsertRequest(visit(addr, "/"))
+			}
+		}
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []FrequencyBucket{{Min: 1, Max: 1, Visitors: 1}, {Min: 2, Max: 3, Visitors: 2}, {Min: 4, Max: 7, Visitors: 1}, {Min: 8, Visitors: 1}}
+
+	// Today is read from memory, the days before from their files.
+	a = newTestAnalytics(t, config, WithClock(clock))
+	a.InsertRequest(visit("192.0.2.5:4000", "/"))
+	dd, err := a.StatsRange(day, clock.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd.Frequency == nil || !reflect.DeepEqual(dd.Frequency.Buckets, want) {
+		t.Errorf("got %+v, want buckets %+v", dd.Frequency, want)
+	}
+	if dd, _ := a.Stats(day); dd.Frequency != nil {
+		t.Errorf("a single day got %+v", dd.Frequency)
+	}
+
+	if dd, _ := a.StatsRange(day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)); dd.Frequency == nil || dd.Frequency.Buckets[0].Visitors != 1 || dd.Frequency.Buckets[1].Visitors != 3 {
+		t.Errorf("the second and third day got %+v, want 1 visitor on 1 day and 3 on 2", dd.Frequency)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?from="+a.dayKey(day)+"&to="+a.today(), nil))
+	for _, row := range []string{">2&ndash;3</td>", ">8+</td>"} {
+		if !strings.Contains(rec.Body.String(), row) {
+			t.Errorf("the range view has no %s row", row)
+		}
+	}
+}
+
+func TestVisitFrequencyNeedsStableKeys(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{HashIPSecret: "secret"})
+	a.InsertRequest(visit("192.0.2.1:4000", "/"))
+	dd, err := a.StatsRange(a.now().AddDate(0, 0, -1), a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd.Frequency == nil || dd.Frequency.Unavailable == "" || len(dd.Frequency.Buckets) != 0 {
+		t.Fatalf("got %+v, want it unavailable", dd.Frequency)
+	}
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?from="+a.dayKey(a.now().AddDate(0, 0, -1))+"&to="+a.today(), nil))
+	if !strings.Contains(rec.Body.String(), "n/a, "+dd.Frequency.Unavailable) {
+		t.Error("the range view doesn't explain why the frequency isn't shown")
+	}
+}
@@ -0,0 +1,61 @@
+package analytics
+
+// FunnelStep is how many visitors reached a step of a funnel after reaching
+// every step before it, in order.
+type FunnelStep struct {
+	Step     string `json:"step"`
+	Visitors int    `json:"visitors"`
+	// Percent is Visitors as a share of the previous step's, 100 for the
+	// first step.
+	Percent float64 `json:"percent_of_previous"`
+}
+
+// Funnel counts how far through steps each visitor of a day's data got.
+// Steps match page paths like GoalConfig steps do, and a visitor reaches a
+// step at most once however often they revisit it, in whatever order.
+func Funnel(steps []string, data map[string][]Action) []FunnelStep {
+	return funnelSteps(steps, funnelCounts(steps, data))
+}
+
+// funnelCounts is how many visitors reached each step. Counts of several days
+// add up to those of the range.
+func funnelCounts(steps []string, data map[string][]Action) []int {
+	counts := make([]int, len(steps))
+	if len(steps) == 0 {
+		return counts
+	}
+	for _, actions := range data {
+		countFunnel(counts, steps, actions)
+	}
+	return counts
+}
+
+// countFunnel adds a visitor's actions to the counts of the steps reached.
+func countFunnel(counts []int, steps []string, actions []Action) {
+	if len(steps) == 0 {
+		return
+	}
+	for i := stepsReached(steps, inOrder(actions)) - 1; i >= 0; i-- {
+		counts[i]++
+	}
+}
+
+func funnelSteps(steps []string, counts []int) []FunnelStep {
+	if len(steps) == 0 {
+		return nil
+	}
+	funnel := make([]FunnelStep, len(steps))
+	for i, step := range steps {
+		funnel[i] = FunnelStep{Step: step, Percent: 100}
+		if i < len(counts) {
+			funnel[i].Visitors = counts[i]
+		}
+		if i > 0 {
+			funnel[i].Percent = 0
+			if prev := funnel[i-1].Visitors; prev > 0 {
+				funnel[i].Percent = float64(funnel[i].Visitors) * 100 / float64(prev)
+			}
+		}
+	}
+	return funnel
+}
@@ -2,4 +2,7 @@ module github.com/JakeKalstad/go-web-analytics
 
 go 1.17
 
-require github.com/golang/mock v1.6.0
+require (
+	github.com/golang/mock v1.6.0
+	golang.org/x/crypto v0.1.0
+)
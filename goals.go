@@ -0,0 +1,113 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// GoalConfig is a goal a visitor completes by viewing pages matching each of
+// Steps in order on the same day. A single step is a plain page goal. A "*"
+// in a step matches any run of characters, so "/product/*" matches every
+// product page.
+type GoalConfig struct {
+	Name  string
+	Steps []string
+}
+
+// GoalStats is how many visitors completed a goal and what share of all
+// visitors that is.
+type GoalStats struct {
+	Name        string  `json:"name"`
+	Completions int     `json:"completions"`
+	Rate        float64 `json:"rate_percent"`
+}
+
+func validGoals(goals []GoalConfig) ([]GoalConfig, error) {
+	valid := make([]GoalConfig, len(goals))
+	for i, g := range goals {
+		if len(g.Steps) == 0 {
+			return nil, fmt.Errorf("goal %q has no steps", g.Name)
+		}
+		if len(g.Name) == 0 {
+			g.Name = strings.Join(g.Steps, " → ")
+		}
+		valid[i] = g
+	}
+	return valid, nil
+}
+
+// completedGoals counts, for each goal, whether the visitor's actions of a
+// day complete it. Completing a goal more than once still counts once.
+func (a analytics) completedGoals(counts []int, actions []Action) {
+	if len(a.goals) == 0 {
+		return
+	}
+	ordered := inOrder(actions)
+	for i, g := range a.goals {
+		if stepsReached(g.Steps, ordered) == len(g.Steps) {
+			counts[i]++
+		}
+	}
+}
+
+// inOrder returns a copy of actions sorted by when they were recorded.
+func inOrder(actions []Action) []Action {
+	ordered := append([]Action(nil), actions...)
+	sort.SliceStable(ordered, func(i, j int) bool { return ordered[i].Timestamp < ordered[j].Timestamp })
+	return ordered
+}
+
+// stepsReached is how many of steps the ordered page views match one after
+// the other.
+func stepsReached(steps []string, ordered []Action) int {
+	step := 0
+	for _, act := range ordered {
+		if step == len(steps) {
+			break
+		}
+		if len(act.Event) == 0 && matchPattern(steps[step], act.Page) {
+			step++
+		}
+	}
+	return step
+}
+
+// matchPattern reports whether path matches pattern, where "*" matches any
+// run of characters, slashes included.
+func matchPattern(pattern, path string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == path
+	}
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	path = path[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(path, p)
+		if i < 0 {
+			return false
+		}
+		path = path[i+len(p):]
+	}
+	return strings.HasSuffix(path, last)
+}
+
+func (a analytics) goalStats(completions []int, sessions int) []GoalStats {
+	if len(a.goals) == 0 {
+		return nil
+	}
+	stats := make([]GoalStats, len(a.goals))
+	for i, g := range a.goals {
+		stats[i] = GoalStats{Name: g.Name}
+		if i < len(completions) {
+			stats[i].Completions = completions[i]
+		}
+		if sessions > 0 {
+			stats[i].Rate = float64(stats[i].Completions) * 100 / float64(sessions)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,59 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGroupLabels(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{
+		GroupByURLSegment: 1,
+		GroupLabels:       map[string]string{"p": "Products", "u": "User profiles"},
+	})
+	a.InsertRequest(visit("192.0.2.1:4000", "/p/42"))
+	a.InsertRequest(visit("192.0.2.1:4000", "/docs/install"))
+	a.RecordRoute(visit("192.0.2.2:4000", "/u/alice"), "/u/{name}", 0, 0)
+
+	dd, err := a.Stats(a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{}
+	for _, g := range dd.URLHits {
+		labels[g.Group] = g.Label
+	}
+	if labels["p"] != "Products" || labels["u"] != "User profiles" || labels["docs"] != "" {
+		t.Errorf("got labels %q, want p and u labelled and docs as it is", labels)
+	}
+	if actions := views(a)["192.0.2.1:4000"]; len(actions) == 0 || actions[0].Page != "/p/42" {
+		t.Errorf("stored %v, want the raw page", actions)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	for _, want := range []string{"Products", "User profiles", "/docs"} {
+		if !strings.Contains(rec.Body.String(), want) {
+			t.Errorf("the dashboard doesn't show %q", want)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := a.ExportRange(&buf, a.now(), a.now()); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string]string{}
+	for _, row := range rows[1:] {
+		groups[row[2]] = row[len(row)-1]
+	}
+	if groups["/p/42"] != "Products" || groups["/u/{name}"] != "User profiles" || groups["/docs/install"] != "docs" {
+		t.Errorf("exported groups %q", groups)
+	}
+}
@@ -0,0 +1,67 @@
+package analytics
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// GroupRule puts the pages whose path matches Pattern into the URL group
+// Group, which may refer to Pattern's capture groups like
+// regexp.Regexp.Expand, e.g. "$1" or "${section}".
+type GroupRule struct {
+	Pattern string
+	Group   string
+}
+
+// groupRule is a GroupRule with its Pattern compiled.
+type groupRule struct {
+	re    *regexp.Regexp
+	group string
+}
+
+// validGroupRules compiles GroupRules.
+func validGroupRules(rules []GroupRule) ([]groupRule, error) {
+	compiled := make([]groupRule, 0, len(rules))
+	for i, r := range rules {
+		if len(r.Group) == 0 {
+			return nil, fmt.Errorf("GroupRules %d needs a Group", i)
+		}
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("GroupRules %d: %w", i, err)
+		}
+		compiled = append(compiled, groupRule{re: re, group: r.Group})
+	}
+	return compiled, nil
+}
+
+// ruleGroup is the group of the first rule matching page, groupOther if none
+// does.
+func ruleGroup(rules []groupRule, page string) string {
+	for _, r := range rules {
+		if m := r.re.FindStringSubmatchIndex(page); m != nil {
+			if group := string(r.re.ExpandString(nil, r.group, page, m)); len(group) > 0 {
+				return group
+			}
+			return groupOther
+		}
+	}
+	return groupOther
+}
+
+// labelGroups sets the Label of the groups with one in GroupLabels. Only
+// reports are labelled, days are stored with the groups themselves, so
+// relabelling never rewrites them.
+func (a analytics) labelGroups(groups []URLGroup) {
+	for i := range groups {
+		groups[i].Label = a.groupLabels[groups[i].Group]
+	}
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	c := make(map[string]string, len(labels))
+	for group, label := range labels {
+		c[group] = label
+	}
+	return c
+}
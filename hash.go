@@ -0,0 +1,147 @@
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HashFunc derives the key a visitor's actions are stored under from the day
+// (YYYY-MM-DD), their IP and HashIPSecret. The same inputs must give the same
+// key, and keys should be printable, like hex.
+type HashFunc func(date, ip, secret string) string
+
+// Schemes of the built-in visitor keys, see HashScheme. Days mixing schemes
+// are recorded as the schemes joined by mixedSchemes.
+const (
+	SchemeNone   = "none"
+	SchemeSHA256 = "sha256"
+	SchemeHMAC   = "hmac-sha256"
+	mixedSchemes = "+"
+)
+
+// SHA256Hash is the default HashFunc when HashIPSecret is set, the hex encoded
+// SHA-256 of date, ip and secret concatenated.
+func SHA256Hash(date, ip, secret string) string {
+	sum := sha256.Sum256([]byte(date + ip + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// HMACHash is the hex encoded HMAC-SHA-256 of date and ip concatenated, keyed
+// with secret.
+func HMACHash(date, ip, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(date + ip))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// TruncatedHash keeps the first chars characters of the keys of hash, making
+// day files smaller at the cost of the odd visitor sharing a key with
+// another one.
+func TruncatedHash(hash HashFunc, chars int) HashFunc {
+	return func(date, ip, secret string) string {
+		key := hash(date, ip, secret)
+		if len(key) > chars {
+			key = key[:chars]
+		}
+		return key
+	}
+}
+
+// visitorHash picks the HashFunc and scheme of a configuration: HashFunc if
+// set, SHA256Hash with a HashIPSecret and the plain IP otherwise. Keys of
+// truncated IPs have schemes of their own, they can't be matched with the
+// keys of whole ones.
+func visitorHash(config AnalyticsConfiguration) (HashFunc, string, error) {
+	switch config.IPAnonymization {
+	case "", AnonymizeTruncate:
+	case AnonymizeHash:
+		if config.HashFunc == nil && len(config.HashIPSecret) == 0 {
+			return nil, "", fmt.Errorf("IPAnonymization %q needs a HashIPSecret or HashFunc", AnonymizeHash)
+		}
+	case AnonymizeNone:
+		if config.HashFunc != nil || len(config.HashIPSecret) > 0 {
+			return nil, "", fmt.Errorf("IPAnonymization %q can't be combined with HashIPSecret or HashFunc", AnonymizeNone)
+		}
+	default:
+		return nil, "", fmt.Errorf("IPAnonymization must be %q, %q or %q, got %q", AnonymizeHash, AnonymizeTruncate, AnonymizeNone, config.IPAnonymization)
+	}
+	if config.RotatingSecret && len(config.HashIPSecret) == 0 {
+		return nil, "", fmt.Errorf("RotatingSecret derives day secrets from HashIPSecret, which isn't set")
+	}
+	if config.RetentionDays < 0 {
+		return nil, "", fmt.Errorf("RetentionDays can't be negative, got %d", config.RetentionDays)
+	}
+	hash, scheme, err := configuredHash(config)
+	if config.RotatingSecret {
+		scheme += rotatingScheme
+	}
+	if config.IPAnonymization == AnonymizeTruncate {
+		scheme += truncatedScheme
+	}
+	return hash, scheme, err
+}
+
+// configuredHash picks the HashFunc and scheme of the keys before
+// IPAnonymization is taken into account.
+func configuredHash(config AnalyticsConfiguration) (HashFunc, string, error) {
+	if config.HashFunc != nil {
+		if len(config.HashScheme) == 0 {
+			return nil, "", fmt.Errorf("HashScheme is required with HashFunc, it's recorded with the data of each day")
+		}
+		if strings.Contains(config.HashScheme, mixedSchemes) {
+			return nil, "", fmt.Errorf("HashScheme %q can't contain %q", config.HashScheme, mixedSchemes)
+		}
+		return config.HashFunc, config.HashScheme, nil
+	}
+	if len(config.HashScheme) > 0 {
+		return nil, "", fmt.Errorf("HashScheme %q is set without a HashFunc", config.HashScheme)
+	}
+	if len(config.HashIPSecret) > 0 {
+		return SHA256Hash, SchemeSHA256, nil
+	}
+	return nil, SchemeNone, nil
+}
+
+// loadScheme checks the scheme a day loaded from disk was recorded with. Its
+// visitors can't be matched with ours if it differs, so the day is marked as
+// mixing both until it's written. Days written before schemes were recorded
+// are assumed to match. The caller holds the write lock.
+func (a analytics) loadScheme(ts string, date time.Time, entries map[string][]Action) {
+	stored, _ := a.storedSummary(date)
+	if len(entries) == 0 || len(stored.Scheme) == 0 || stored.Scheme == a.hashScheme {
+		return
+	}
+	for _, scheme := range strings.Split(stored.Scheme, mixedSchemes) {
+		if scheme == a.hashScheme {
+			a.schemes[ts] = stored.Scheme
+			return
+		}
+	}
+	a.log.Warn("%s was recorded with visitor keys %s, not %s, its visitors will be counted twice", ts, stored.Scheme, a.hashScheme)
+	a.schemes[ts] = stored.Scheme + mixedSchemes + a.hashScheme
+}
+
+// dayScheme is the scheme of the visitor keys of a day in memory. The caller
+// holds the lock.
+func (a analytics) dayScheme(ts string) string {
+	if scheme, ok := a.schemes[ts]; ok {
+		return scheme
+	}
+	return a.hashScheme
+}
+
+// mixedDays lists the days from through to whose visitors were keyed with
+// different schemes.
+func (a analytics) mixedDays(from, to time.Time) []string {
+	var days []string
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		if strings.Contains(a.readSummary(d).Scheme, mixedSchemes) {
+			days = append(days, a.dayKey(d))
+		}
+	}
+	return days
+}
@@ -0,0 +1,145 @@
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// HealthStatus is what Health responds with. Healthy is false once data
+// hasn't been written to disk for twice the flush interval.
+type HealthStatus struct {
+	Healthy             bool           `json:"healthy"`
+	LastFlush           *time.Time     `json:"last_flush,omitempty"`
+	FlushInterval       string         `json:"flush_interval"`
+	ConsecutiveFailures int            `json:"consecutive_failures"`
+	BufferedActions     int64          `json:"buffered_actions"`
+	RateLimited         int64          `json:"rate_limited_actions"`
+	Days                []HealthDay    `json:"days"`
+	DiskBytes           int64          `json:"disk_bytes"`
+	Errors              []PersistError `json:"errors"`
+}
+
+// HealthDay is a day of a site held in memory. Bytes is an estimate.
+type HealthDay struct {
+	Site     string `json:"site"`
+	Date     string `json:"date"`
+	Visitors int    `json:"visitors"`
+	Actions  int    `json:"actions"`
+	Bytes    int64  `json:"bytes"`
+}
+
+// Health serves the state of persistence as JSON, protected like the
+// dashboard. It answers 200 while data is written to disk on schedule and 503
+// once the last successful write is more than twice the flush interval ago.
+// With ManualFlush it's unhealthy while the last Flush failed, with
+// DisablePersistence it's always healthy.
+func (a analytics) Health(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	status := a.healthStatus()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !status.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		a.log.Info("writing health: %v", err)
+	}
+}
+
+func (a analytics) healthStatus() HealthStatus {
+	h := a.health.snapshot()
+	status := HealthStatus{
+		FlushInterval:       a.flushInterval.String(),
+		ConsecutiveFailures: h.ConsecutiveFailures,
+		Days:                []HealthDay{},
+		Errors:              h.Errors,
+	}
+	if status.Errors == nil {
+		status.Errors = []PersistError{}
+	}
+	if !h.LastSuccess.IsZero() {
+		status.LastFlush = &h.LastSuccess
+	}
+	status.Healthy = a.flushedInTime(h)
+
+	for _, s := range a.sites {
+		status.BufferedActions += atomic.LoadInt64(&s.metrics.buffered)
+		status.RateLimited += atomic.LoadInt64(&s.metrics.rateLimited)
+		status.Days = append(status.Days, s.healthDays()...)
+	}
+	sort.Slice(status.Days, func(i, j int) bool {
+		if status.Days[i].Site != status.Days[j].Site {
+			return status.Days[i].Site < status.Days[j].Site
+		}
+		return status.Days[i].Date < status.Days[j].Date
+	})
+
+	if !a.memoryOnly {
+		size, err := diskUsage(a.Directory)
+		if err != nil {
+			a.log.Warn("measuring %s: %v", a.Directory, err)
+		}
+		status.DiskBytes = size
+	}
+	return status
+}
+
+// flushedInTime tells whether data was written to disk recently enough.
+// Before the first flush the time since starting counts.
+func (a analytics) flushedInTime(h flushHealth) bool {
+	if a.memoryOnly {
+		return true
+	}
+	if a.manualFlush {
+		return h.ConsecutiveFailures == 0
+	}
+	last := h.LastSuccess
+	if last.IsZero() {
+		last = a.health.started
+	}
+	return a.now().Sub(last) <= 2*a.flushInterval
+}
+
+// healthDays lists the days of a site in memory.
+func (a analytics) healthDays() []HealthDay {
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	days := make([]HealthDay, 0, len(a.openDays))
+	for date := range a.openDays {
+		day := HealthDay{Site: a.Name, Date: date}
+		for _, sh := range a.shards {
+			sh.mu.Lock()
+			entries := sh.entries[date]
+			for _, acts := range entries {
+				day.Actions += len(acts)
+			}
+			day.Visitors += len(entries)
+			day.Bytes += dataSize(entries)
+			sh.mu.Unlock()
+		}
+		days = append(days, day)
+	}
+	return days
+}
+
+// diskUsage adds up the sizes of the files in dir.
+func diskUsage(dir string) (int64, error) {
+	size := int64(0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
@@ -0,0 +1,91 @@
+package analytics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Heatmap week counts, selected with ?weeks=.
+const (
+	defaultHeatmapWeeks = 4
+	maxHeatmapWeeks     = 52
+)
+
+// HeatmapData is what the weekday by hour view (?heatmap=1) renders: the page
+// views of each hour of each weekday over the Weeks weeks ending on Date.
+type HeatmapData struct {
+	Date     string       `json:"date"`
+	Weeks    int          `json:"weeks"`
+	MaxWeeks int          `json:"-"`
+	Rows     []HeatmapRow `json:"rows"`
+	Nonce    string       `json:"-"`
+}
+
+// HeatmapRow is one weekday, Monday first.
+type HeatmapRow struct {
+	Weekday string        `json:"weekday"`
+	Cells   []HeatmapCell `json:"cells"`
+}
+
+// HeatmapCell is an hour of a weekday. Lightness shades the cell, from 100
+// for no views down to 40 for the busiest hour of the grid.
+type HeatmapCell struct {
+	Hour      int `json:"hour"`
+	Views     int `json:"views"`
+	Lightness int `json:"-"`
+}
+
+// heatmapGrid adds up the hourly page views of the weeks*7 days ending on
+// last from the day summaries, rows starting on Monday.
+func (a analytics) heatmapGrid(last time.Time, weeks int) [7][24]int {
+	var grid [7][24]int
+	for i := 0; i < weeks*7; i++ {
+		date := last.AddDate(0, 0, -i)
+		row := (int(date.Weekday()) + 6) % 7
+		for h, n := range a.readSummary(date).Hours {
+			grid[row][h] += n
+		}
+	}
+	return grid
+}
+
+func (a analytics) heatmap(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	date, _, ok := a.requestRange(w, r)
+	if !ok {
+		return
+	}
+	weeks, err := strconv.Atoi(r.URL.Query().Get("weeks"))
+	if err != nil || weeks < 1 {
+		weeks = defaultHeatmapWeeks
+	}
+	if weeks > maxHeatmapWeeks {
+		weeks = maxHeatmapWeeks
+	}
+
+	grid := a.heatmapGrid(date, weeks)
+	busiest := 0
+	for _, hours := range grid {
+		for _, n := range hours {
+			if n > busiest {
+				busiest = n
+			}
+		}
+	}
+	hd := HeatmapData{Date: a.dayKey(date), Weeks: weeks, MaxWeeks: maxHeatmapWeeks, Rows: make([]HeatmapRow, 7)}
+	for row, hours := range grid {
+		hr := HeatmapRow{Weekday: time.Weekday((row + 1) % 7).String(), Cells: make([]HeatmapCell, 24)}
+		for h, n := range hours {
+			hr.Cells[h] = HeatmapCell{Hour: h, Views: n, Lightness: 100}
+			if busiest > 0 {
+				hr.Cells[h].Lightness = 100 - n*60/busiest
+			}
+		}
+		hd.Rows[row] = hr
+	}
+	hd.Nonce = a.withCSP(w)
+	a.render(w, "heatmap", hd)
+}
@@ -0,0 +1,39 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestAnalytics creates an Analyzer writing to a temporary directory
+// only when flushed, closed when the test ends.
+func newTestAnalytics(t testing.TB, config AnalyticsConfiguration, opts ...Option) *analytics {
+	t.Helper()
+	if len(config.Name) == 0 {
+		config.Name = "test"
+	}
+	if len(config.Directory) == 0 && !config.DisablePersistence {
+		config.Directory = t.TempDir()
+	}
+	config.ManualFlush = true
+	opts = append([]Option{WithConfiguration(config), WithLogger(discard)}, opts...)
+	an, err := New(config.Name, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { an.Close() })
+	return an.(*analytics)
+}
+
+func discard(...interface{}) (int, error) {
+	return 0, nil
+}
+
+// visit is a request of the visitor at addr, an IP and port, for target.
+func visit(addr, target string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r.RemoteAddr = addr
+	r.Header.Set("User-Agent", "Mozilla/5.0")
+	return r
+}
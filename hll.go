@@ -0,0 +1,198 @@
+package analytics
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// Sketch precisions: the registers of a sketch are 2^precision bytes and
+// its estimates are off by 1.04/sqrt(2^precision), 1.6% by default.
+const (
+	defaultSketchPrecision = 12
+	minSketchPrecision     = 4
+	maxSketchPrecision     = 18
+)
+
+// sketch is a HyperLogLog sketch of the visitors of one or more days, fed
+// the keyHash of each visitor key so it can always be made again from the
+// keys left, e.g. once a visitor was erased. Sketches of several days can be
+// united to estimate how many different visitors a range had; keys that
+// include the day, like hashed ones, count once per day.
+type sketch struct {
+	precision uint8
+	registers []uint8
+}
+
+func newSketch(precision int) *sketch {
+	return &sketch{precision: uint8(precision), registers: make([]uint8, 1<<precision)}
+}
+
+// keysSketch sketches the visitor keys of a day, as insert does while
+// recording it.
+func keysSketch(data map[string][]Action, precision int) *sketch {
+	s := newSketch(precision)
+	for key := range data {
+		s.add(keyHash(key))
+	}
+	return s
+}
+
+// keyHash is what a sketch is fed for a visitor key.
+func keyHash(key string) uint64 {
+	sum := sha256.Sum256([]byte(key))
+	return binary.BigEndian.Uint64(sum[:])
+}
+
+func (s *sketch) add(x uint64) {
+	p := s.precision
+	i := x >> (64 - p)
+	rank := uint8(bits.LeadingZeros64(x<<p|1<<(p-1))) + 1
+	if rank > s.registers[i] {
+		s.registers[i] = rank
+	}
+}
+
+func (s *sketch) clone() *sketch {
+	return &sketch{precision: s.precision, registers: append([]uint8(nil), s.registers...)}
+}
+
+// reduce returns s at a lower precision, the precision of both when
+// uniting sketches that differ.
+func (s *sketch) reduce(precision uint8) *sketch {
+	if precision >= s.precision {
+		return s
+	}
+	r := newSketch(int(precision))
+	shift := s.precision - precision
+	for i, rank := range s.registers {
+		if rank == 0 {
+			continue
+		}
+		// The index bits dropped continue the bits rank was counted on.
+		rest := uint64(i) & (1<<shift - 1)
+		if rest != 0 {
+			rank = uint8(bits.LeadingZeros64(rest<<(64-shift))) + 1
+		} else {
+			rank += shift
+		}
+		if j := i >> shift; rank > r.registers[j] {
+			r.registers[j] = rank
+		}
+	}
+	return r
+}
+
+// union adds o to s and returns the result, which is s unless s had to be
+// reduced to the precision of o. o isn't modified.
+func (s *sketch) union(o *sketch) *sketch {
+	if o.precision < s.precision {
+		s = s.reduce(o.precision)
+	} else {
+		o = o.reduce(s.precision)
+	}
+	for i, rank := range o.registers {
+		if rank > s.registers[i] {
+			s.registers[i] = rank
+		}
+	}
+	return s
+}
+
+// estimate is the number of different values fed to the sketch, counted
+// linearly while there are empty registers and few values.
+func (s *sketch) estimate() float64 {
+	m := float64(len(s.registers))
+	sum, zeros := 0.0, 0
+	for _, rank := range s.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+	e := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return e
+}
+
+// stdError is the relative standard error of the estimates.
+func (s *sketch) stdError() float64 {
+	return 1.04 / math.Sqrt(float64(len(s.registers)))
+}
+
+// marshal encodes the sketch as its precision followed by its registers.
+func (s *sketch) marshal() []byte {
+	return append([]byte{s.precision}, s.registers...)
+}
+
+// unmarshalSketch decodes a marshaled sketch, nil if it's malformed.
+func unmarshalSketch(bs []byte) *sketch {
+	if len(bs) < 1 || bs[0] < minSketchPrecision || bs[0] > maxSketchPrecision || len(bs) != 1+1<<bs[0] {
+		return nil
+	}
+	return &sketch{precision: bs[0], registers: append([]uint8(nil), bs[1:]...)}
+}
+
+// loadSketch picks up the sketch of a day loaded from disk, from its summary
+// or, if it has none or one of IPs written before format version 12, from
+// its visitor keys, so the visitors recorded next are counted alike. It's
+// kept by the first shard, the shards' sketches are united when they're
+// read. The caller holds Mux for writing.
+func (a analytics) loadSketch(ts string, date time.Time, entries map[string][]Action) {
+	stored, _ := a.storedSummary(date)
+	if s := unmarshalSketch(stored.Sketch); s != nil && stored.KeySketch {
+		a.shards[0].sketches[ts] = s
+		return
+	}
+	a.shards[0].sketches[ts] = keysSketch(entries, a.sketchPrecision)
+}
+
+// sketchOf returns the sketch of a day in memory of a shard. The caller
+// holds the shard's lock.
+func (a analytics) sketchOf(sh *shard, ts string) *sketch {
+	s := sh.sketches[ts]
+	if s == nil {
+		s = newSketch(a.sketchPrecision)
+		sh.sketches[ts] = s
+	}
+	return s
+}
+
+// daySketch returns the sketch of a day's visitors, from memory or its
+// summary. With AggregateNames, or if there's none, it's made from the
+// visitor keys of data, the day's actions.
+func (a analytics) daySketch(date time.Time, data map[string][]Action) *sketch {
+	if len(a.peers) == 0 {
+		ts := a.dayKey(date)
+		var s *sketch
+		a.Mux.RLock()
+		for _, sh := range a.shards {
+			sh.mu.Lock()
+			if ds := sh.sketches[ts]; ds != nil && s == nil {
+				s = ds.clone()
+			} else if ds != nil {
+				s = s.union(ds)
+			}
+			sh.mu.Unlock()
+		}
+		a.Mux.RUnlock()
+		if s != nil {
+			return s
+		}
+		if s := a.storedSketch(date); s != nil {
+			return s
+		}
+	}
+	return keysSketch(data, a.sketchPrecision)
+}
+
+// storedSketch is the sketch in the summary of a saved day, nil if there's
+// none.
+func (a analytics) storedSketch(date time.Time) *sketch {
+	stored, _ := a.storedSummary(date)
+	return unmarshalSketch(stored.Sketch)
+}
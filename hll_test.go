@@ -0,0 +1,85 @@
+package analytics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// TestSketchCountsKeys checks a visitor coming back with the same key on
+// another day is one unique visitor of a range, while hashed keys, which
+// include the day, count once per day.
+func TestSketchCountsKeys(t *testing.T) {
+	for _, tc := range []struct {
+		secret string
+		want   int
+	}{
+		{"", 2},
+		{"secret", 3},
+	} {
+		day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+		clock := &movingClock{t: day}
+		a := newTestAnalytics(t, AnalyticsConfiguration{HashIPSecret: tc.secret}, WithClock(clock))
+		a.InsertRequest(visit("192.0.2.1:1000", "/"))
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		clock.set(day.AddDate(0, 0, 1))
+		a.InsertRequest(visit("192.0.2.1:1000", "/"))
+		a.InsertRequest(visit("192.0.2.2:1000", "/"))
+		if err := a.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		clock.set(day.AddDate(0, 0, 2))
+		dd, err := a.StatsRange(day, day.AddDate(0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dd.UniqueVisitors != tc.want {
+			t.Errorf("secret %q: %d unique visitors, want %d", tc.secret, dd.UniqueVisitors, tc.want)
+		}
+	}
+}
+
+// TestSketchAfterErase checks the sketch made again after erasing a visitor
+// counts the visitors left like the sketches of the other days.
+func TestSketchAfterErase(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	a := newTestAnalytics(t, AnalyticsConfiguration{}, WithClock(clock))
+	a.InsertRequest(visit("192.0.2.1:1000", "/"))
+	a.InsertRequest(visit("192.0.2.9:1000", "/"))
+	if _, err := a.DeleteVisitor("192.0.2.9:1000", day, day); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a.InsertRequest(visit("192.0.2.1:1000", "/"))
+	dd, err := a.StatsRange(day, day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd.UniqueVisitors != 1 {
+		t.Errorf("%d unique visitors, want the one left on both days", dd.UniqueVisitors)
+	}
+}
+
+// TestSketchOfIPsRebuilt checks a day loaded with a sketch written before
+// format version 12 is sketched from its keys again.
+func TestSketchOfIPsRebuilt(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	config := AnalyticsConfiguration{Directory: t.TempDir()}
+	a := newTestAnalytics(t, config, WithClock(fixedClock(day)))
+	a.InsertRequest(visit("192.0.2.1:1000", "/"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := a.storedSummary(day)
+	s.Sketch, s.KeySketch = newSketch(a.sketchPrecision).marshal(), false
+	if err := a.writeSummary(day, s); err != nil {
+		t.Fatal(err)
+	}
+	a = newTestAnalytics(t, config, WithClock(fixedClock(day)))
+	if n := math.Round(a.daySketch(day, nil).estimate()); n != 1 {
+		t.Errorf("the loaded day estimates %v visitors, want 1", n)
+	}
+}
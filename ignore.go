@@ -0,0 +1,100 @@
+package analytics
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// ignoreWildcard matches any method in IgnoreRule.Method and, at the end of
+// a path pattern, the path before it and everything below it.
+const ignoreWildcard = "*"
+
+// IgnoreRule skips requests with Method whose path matches Path. Either can
+// be "*" for any; a Path ending in "/*" matches that path and everything
+// below it, e.g. "/admin/*" matches /admin and /admin/users. Other paths
+// match exactly.
+type IgnoreRule struct {
+	Method string
+	Path   string
+}
+
+// validIgnoreRules checks IgnorePaths and IgnoreRules, returning them as one
+// list of rules.
+func validIgnoreRules(paths []string, rules []IgnoreRule) ([]IgnoreRule, error) {
+	all := make([]IgnoreRule, 0, len(paths)+len(rules))
+	for _, p := range paths {
+		all = append(all, IgnoreRule{Method: ignoreWildcard, Path: p})
+	}
+	for _, r := range rules {
+		if len(r.Method) == 0 {
+			r.Method = ignoreWildcard
+		}
+		r.Method = strings.ToUpper(r.Method)
+		all = append(all, r)
+	}
+	for _, r := range all {
+		if r.Path == ignoreWildcard {
+			continue
+		}
+		if !strings.HasPrefix(r.Path, "/") {
+			return nil, fmt.Errorf("ignored path %q must start with / or be %s", r.Path, ignoreWildcard)
+		}
+		if n := strings.Count(r.Path, ignoreWildcard); n > 1 || n == 1 && !strings.HasSuffix(r.Path, "/"+ignoreWildcard) {
+			return nil, fmt.Errorf("ignored path %q can only end with /%s", r.Path, ignoreWildcard)
+		}
+	}
+	return all, nil
+}
+
+func (rule IgnoreRule) matches(method, path string) bool {
+	if rule.Method != ignoreWildcard && rule.Method != method {
+		return false
+	}
+	if rule.Path == ignoreWildcard || rule.Path == path {
+		return true
+	}
+	if prefix := strings.TrimSuffix(rule.Path, ignoreWildcard); len(prefix) < len(rule.Path) {
+		return path == strings.TrimSuffix(prefix, "/") || strings.HasPrefix(path, prefix)
+	}
+	return false
+}
+
+// ignored tells whether a rule skips r.
+func (rc runtimeConfig) ignored(r *http.Request) bool {
+	for _, rule := range rc.ignore {
+		if rule.matches(r.Method, r.URL.Path) {
+			return true
+		}
+	}
+	return false
+}
+
+// ShouldTrack tells whether InsertRequest and the middleware would count r
+// as a visitor's request: ConsentFunc, if set, consents to it, it isn't
+// skipped by IgnorePaths, IgnoreRules or IgnoreStaticAssets and its user
+// agent isn't blacklisted.
+func (a analytics) ShouldTrack(r *http.Request) bool {
+	if r == nil || r.URL == nil {
+		return false
+	}
+	if a.consent != nil && !a.consent(r) {
+		return false
+	}
+	rc := a.tuning.load()
+	return !a.staticAsset(r.URL.Path) && !rc.ignored(r) && !rc.blacklisted(r.UserAgent())
+}
+
+// consented asks ConsentFunc whether r may be recorded at all, counting it
+// for the site it's for if not.
+func (a analytics) consented(r *http.Request) bool {
+	if a.consent == nil || a.consent(r) {
+		return true
+	}
+	if a.siteResolver != nil {
+		a = a.site(a.siteResolver(r))
+	}
+	atomic.AddInt64(&a.metrics.noConsent, 1)
+	return false
+}
@@ -0,0 +1,114 @@
+package analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// liveWindowSize bounds how many recent actions are kept for Live.
+	liveWindowSize = 500
+	// liveInterval is how often Live pushes an update.
+	liveInterval = 5 * time.Second
+	liveRecent   = 10
+)
+
+// liveWindow is a ring buffer of the most recent page views.
+type liveWindow struct {
+	mu      sync.Mutex
+	actions [liveWindowSize]liveAction
+	next    int
+	full    bool
+}
+
+type liveAction struct {
+	Page string
+	Time time.Time
+}
+
+func (lw *liveWindow) add(page string, t time.Time) {
+	lw.mu.Lock()
+	lw.actions[lw.next] = liveAction{Page: page, Time: t}
+	lw.next = (lw.next + 1) % liveWindowSize
+	if lw.next == 0 {
+		lw.full = true
+	}
+	lw.mu.Unlock()
+}
+
+// recent returns the buffered actions, newest first.
+func (lw *liveWindow) recent() []liveAction {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	n := lw.next
+	if lw.full {
+		n = liveWindowSize
+	}
+	out := make([]liveAction, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, lw.actions[(lw.next-i+liveWindowSize)%liveWindowSize])
+	}
+	return out
+}
+
+// LiveUpdate is the payload Live pushes to the dashboard.
+type LiveUpdate struct {
+	Sessions        int      `json:"sessions"`
+	ViewsLastMinute int      `json:"views_last_minute"`
+	Recent          []string `json:"recent"`
+}
+
+func (a analytics) liveUpdate() LiveUpdate {
+	u := LiveUpdate{Sessions: a.sessionsToday()}
+
+	since := a.now().Add(-time.Minute)
+	for _, act := range a.live.recent() {
+		if act.Time.After(since) {
+			u.ViewsLastMinute++
+		}
+		if len(u.Recent) < liveRecent {
+			u.Recent = append(u.Recent, act.Page)
+		}
+	}
+	return u
+}
+
+// Live streams a LiveUpdate every few seconds as server-sent events until
+// the client disconnects. The dashboard's live section reads it through
+// Dashboard with ?live=1, but it can be mounted on its own as well.
+func (a analytics) Live(w http.ResponseWriter, r *http.Request) {
+	a = a.site(r.URL.Query().Get("site"))
+	if !a.authorized(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(liveInterval)
+	defer ticker.Stop()
+	for {
+		bs, err := json.Marshal(a.liveUpdate())
+		if err != nil {
+			a.log.Error("encoding live update: %v", err)
+			return
+		}
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", bs); err != nil {
+			return
+		}
+		flusher.Flush()
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
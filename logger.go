@@ -0,0 +1,41 @@
+package analytics
+
+import "fmt"
+
+// Logger receives what the package logs by severity, with Printf style
+// arguments: Debug for requests skipped on purpose, like blacklisted user
+// agents; Info for bad or unauthorized requests; Warn for problems the
+// package works around; Error for failures losing data or breaking pages,
+// like failed writes to disk.
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// FuncLogger adapts a fmt.Println shaped function, what NewAnalytics takes,
+// to Logger. Messages are prefixed with their level; Debug messages are
+// dropped so the function sees what it did before levels existed.
+func FuncLogger(log func(...interface{}) (int, error)) Logger {
+	if log == nil {
+		log = fmt.Println
+	}
+	return funcLogger(log)
+}
+
+type funcLogger func(...interface{}) (int, error)
+
+func (l funcLogger) Debug(format string, args ...interface{}) {}
+
+func (l funcLogger) Info(format string, args ...interface{}) {
+	l("INFO", fmt.Sprintf(format, args...))
+}
+
+func (l funcLogger) Warn(format string, args ...interface{}) {
+	l("WARN", fmt.Sprintf(format, args...))
+}
+
+func (l funcLogger) Error(format string, args ...interface{}) {
+	l("ERROR", fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,116 @@
+package analytics
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// uaMatcher tells whether a user agent contains any of a set of lowercase
+// patterns, ignoring case, in one pass: an Aho-Corasick automaton compiled to
+// a state table. Bytes that appear in no pattern share class 0 so the table
+// only has a column per distinct pattern byte.
+type uaMatcher struct {
+	class   [256]uint16
+	classes int
+	next    []int32
+	match   []bool
+}
+
+// newUAMatcher compiles patterns, which normalizeBlacklist has lowercased.
+// It's nil without patterns, which matches nothing.
+func newUAMatcher(patterns []string) *uaMatcher {
+	if len(patterns) == 0 {
+		return nil
+	}
+	m := &uaMatcher{classes: 1}
+	for _, p := range patterns {
+		for i := 0; i < len(p); i++ {
+			if m.class[p[i]] == 0 {
+				m.class[p[i]] = uint16(m.classes)
+				m.classes++
+			}
+		}
+	}
+	// The trie of the patterns, state 0 being the root. Missing edges are
+	// -1 until they're filled in below.
+	m.next = m.newState()
+	m.match = []bool{false}
+	for _, p := range patterns {
+		s := int32(0)
+		for i := 0; i < len(p); i++ {
+			c := int32(m.class[p[i]])
+			if m.next[s*int32(m.classes)+c] < 0 {
+				m.next[s*int32(m.classes)+c] = int32(len(m.match))
+				m.next = append(m.next, m.newState()...)
+				m.match = append(m.match, false)
+			}
+			s = m.next[s*int32(m.classes)+c]
+		}
+		m.match[s] = true
+	}
+	// Breadth first, each missing edge leads where the edge of the state's
+	// longest proper suffix in the trie does, and a state matches when that
+	// suffix does.
+	fail := make([]int32, len(m.match))
+	queue := []int32{}
+	for c := 0; c < m.classes; c++ {
+		if t := m.next[c]; t < 0 {
+			m.next[c] = 0
+		} else {
+			queue = append(queue, t)
+		}
+	}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		m.match[s] = m.match[s] || m.match[fail[s]]
+		for c := int32(0); c < int32(m.classes); c++ {
+			i := s*int32(m.classes) + c
+			if t := m.next[i]; t < 0 {
+				m.next[i] = m.next[fail[s]*int32(m.classes)+c]
+			} else {
+				fail[t] = m.next[fail[s]*int32(m.classes)+c]
+				queue = append(queue, t)
+			}
+		}
+	}
+	return m
+}
+
+func (m *uaMatcher) newState() []int32 {
+	s := make([]int32, m.classes)
+	for i := range s {
+		s[i] = -1
+	}
+	return s
+}
+
+// matches tells whether ua contains a pattern once lowercased like
+// strings.ToLower would. ASCII is lowercased as it's scanned, anything else
+// goes through strings.ToLower first.
+func (m *uaMatcher) matches(ua string) bool {
+	if m == nil {
+		return false
+	}
+	for i := 0; i < len(ua); i++ {
+		if ua[i] >= utf8.RuneSelf {
+			return m.scan(strings.ToLower(ua))
+		}
+	}
+	return m.scan(ua)
+}
+
+func (m *uaMatcher) scan(s string) bool {
+	state := int32(0)
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if 'A' <= b && b <= 'Z' {
+			b += 'a' - 'A'
+		}
+		state = m.next[state*int32(m.classes)+int32(m.class[b])]
+		if m.match[state] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package analytics
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// realAgents are user agents seen in access logs, browsers and bots.
+var realAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+	"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+	"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.6099.43 Mobile Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36 Edg/120.0.2210.61",
+	"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+	"Mozilla/5.0 (Linux; Android 6.0.1; Nexus 5X Build/MMB29P) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.6045.199 Mobile Safari/537.36 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)",
+	"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)",
+	"Mozilla/5.0 (compatible; YandexBot/3.0; +http://yandex.com/bots)",
+	"Mozilla/5.0 (compatible; AhrefsBot/7.0; +http://ahrefs.com/robot/)",
+	"Mozilla/5.0 (compatible; SemrushBot/7~bl; +http://www.semrush.com/bot.html)",
+	"Mozilla/5.0 (compatible; DuckDuckBot-Https/1.1; https://duckduckgo.com/duckduckbot)",
+	"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)",
+	"Twitterbot/1.0",
+	"Mozilla/5.0 (compatible; archive.org_bot +http://archive.org/details/archive.org_bot)",
+	"ia_archiver (+http://www.alexa.com/site/help/webmasters; crawler@alexa.com)",
+	"msnbot/2.0b (+http://search.msn.com/msnbot.htm)",
+	"Wget/1.21.4",
+	"curl/8.4.0",
+	"python-requests/2.31.0",
+	"Python-urllib/3.11",
+	"libwww-perl/6.72",
+	"PHP/8.2.12",
+	"Go-http-client/1.1",
+	"Apache-HttpClient/4.5.14 (Java/17.0.9)",
+	"BlackBerry9700/5.0.0.862 Profile/MIDP-2.1 Configuration/CLDC-1.1 VendorID/120",
+	"Mozilla/5.0 (compatible; Panscient web crawler; +http://www.panscient.com/)",
+	"Fluffy the spider; (+http://www.searchhippo.com/)",
+	"NetResearchServer/2.5(loopfuse.com)",
+	"Mozilla/5.0 (compatible; MJ12bot/v1.4.8; http://mj12bot.com/)",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0.6099.71 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; WOW64; Trident/7.0; rv:11.0) like Gecko",
+	"Opera/9.80 (Windows NT 6.1; U; ru) Presto/2.10.289 Version/12.02",
+	"Mozilla/5.0 (Linux; U; Android 4.0.3; ko-kr; LG-L160L Build/IML74K) AppleWebkit/534.30 (KHTML, like Gecko) Version/4.0 Mobile Safari/534.30",
+	"ÜBERBOT/1.0",
+	"Mozilla/5.0 (Ｃompatible; ＢＯＴ)",
+	"\xff\xfeBOT",
+	"",
+}
+
+// loopBlacklisted is how the blacklist was matched before uaMatcher.
+func loopBlacklisted(blacklist []string, ua string) bool {
+	ua = strings.ToLower(ua)
+	for _, b := range blacklist {
+		if strings.Contains(ua, b) {
+			return true
+		}
+	}
+	return false
+}
+
+var matcherLists = [][]string{
+	DefaultUserAgentBlacklist,
+	{"Googlebot", "HeadlessChrome", "bot"},
+	{"a", "ab", "abc", "bc", "c"},
+	{"überbot", "ｂｏｔ"},
+	{"crawler", "spider", "scraper", "curl", "go-http-client", "java/", "libwww"},
+}
+
+func TestMatcherMatchesLoop(t *testing.T) {
+	for _, list := range matcherLists {
+		list = normalizeBlacklist(list)
+		m := newUAMatcher(list)
+		for _, ua := range realAgents {
+			if got, want := m.matches(ua), loopBlacklisted(list, ua); got != want {
+				t.Errorf("%q with %q: matcher says %v, the loop %v", ua, list, got, want)
+			}
+		}
+	}
+}
+
+// TestMatcherRandom compares the matcher with the loop on random strings
+// over a small alphabet, so patterns overlap and suffixes share prefixes.
+func TestMatcherRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	const alphabet = "abcABC/ü"
+	random := func(n int) string {
+		var b strings.Builder
+		for i := 0; i < n; i++ {
+			b.WriteByte(alphabet[rnd.Intn(len(alphabet))])
+		}
+		return b.String()
+	}
+	for i := 0; i < 200; i++ {
+		list := make([]string, 1+rnd.Intn(6))
+		for j := range list {
+			list[j] = random(1 + rnd.Intn(4))
+		}
+		list = normalizeBlacklist(list)
+		m := newUAMatcher(list)
+		for j := 0; j < 50; j++ {
+			ua := random(rnd.Intn(30))
+			if got, want := m.matches(ua), loopBlacklisted(list, ua); got != want {
+				t.Fatalf("%q with %q: matcher says %v, the loop %v", ua, list, got, want)
+			}
+		}
+	}
+}
+
+func TestMatcherEmpty(t *testing.T) {
+	if newUAMatcher(nil).matches("Googlebot") {
+		t.Error("no patterns matched")
+	}
+}
+
+func BenchmarkBlacklist(b *testing.B) {
+	list := normalizeBlacklist(DefaultUserAgentBlacklist)
+	b.Run("loop", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			loopBlacklisted(list, realAgents[i%len(realAgents)])
+		}
+	})
+	b.Run("matcher", func(b *testing.B) {
+		m := newUAMatcher(list)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			m.matches(realAgents[i%len(realAgents)])
+		}
+	})
+}
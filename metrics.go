@@ -0,0 +1,144 @@
+package analytics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// metricsPrefix namespaces every metric Metrics exposes.
+const metricsPrefix = "go_web_analytics_"
+
+// flushBuckets are the upper bounds, in seconds, of the flush duration
+// histogram.
+var flushBuckets = [...]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// siteMetrics counts what a site recorded. It's updated atomically.
+type siteMetrics struct {
+	recorded    int64
+	blacklisted int64
+	// rateLimited counts the actions MaxActionsPerVisitorPerMinute dropped.
+	rateLimited int64
+	// prefetches counts the prefetches skipped without RecordPrefetch and
+	// duplicates the page views DuplicateWindowSeconds dropped.
+	prefetches int64
+	duplicates int64
+	// noConsent counts the requests ConsentFunc refused.
+	noConsent int64
+	// referrerSpam counts the page views referred by a spam domain.
+	referrerSpam int64
+	// ignoredASN counts the requests IgnoreASNs dropped.
+	ignoredASN int64
+	// dropped counts the actions past MaxActionsPerVisitorPerDay, which are
+	// only counted for the day.
+	dropped int64
+	// busy counts the dashboard requests MaxConcurrentAggregations turned
+	// away.
+	busy int64
+	// buffered is how many actions were recorded since the site was last
+	// written to disk.
+	buffered int64
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Metrics serves ingestion and flush metrics in the Prometheus text format,
+// labelled by site. It's protected like the dashboard; Prometheus can send the
+// password as a bearer token. Each Analyzer serves only its own metrics, so
+// several of them can be scraped side by side.
+func (a analytics) Metrics(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	var b strings.Builder
+	names := a.siteNames()
+	if len(names) == 0 {
+		names = []string{a.defaultSite}
+	}
+	perSite := func(name, kind, help string, value func(s *analytics) int64) {
+		writeMetricHeader(&b, name, kind, help)
+		for _, site := range names {
+			fmt.Fprintf(&b, "%s%s{site=\"%s\"} %d\n", metricsPrefix, name, labelEscaper.Replace(site), value(a.sites[site]))
+		}
+	}
+	perSite("requests_recorded_total", "counter", "Requests recorded.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.recorded)
+	})
+	perSite("requests_blacklisted_total", "counter", "Requests skipped for a blacklisted user agent.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.blacklisted)
+	})
+	perSite("requests_rate_limited_total", "counter", "Requests dropped by MaxActionsPerVisitorPerMinute.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.rateLimited)
+	})
+	perSite("requests_prefetch_total", "counter", "Prefetches skipped.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.prefetches)
+	})
+	perSite("requests_duplicate_total", "counter", "Page views dropped as repeats within DuplicateWindowSeconds.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.duplicates)
+	})
+	perSite("requests_no_consent_total", "counter", "Requests not recorded for lack of consent.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.noConsent)
+	})
+	perSite("requests_referrer_spam_total", "counter", "Page views referred by a spam domain, stored as (spam) or dropped with DropReferrerSpam.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.referrerSpam)
+	})
+	perSite("requests_ignored_asn_total", "counter", "Requests dropped by IgnoreASNs.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.ignoredASN)
+	})
+	perSite("requests_dropped_total", "counter", "Actions past MaxActionsPerVisitorPerDay, counted but not stored.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.dropped)
+	})
+	perSite("dashboard_busy_total", "counter", "Dashboard requests turned away by MaxConcurrentAggregations.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.busy)
+	})
+	perSite("buffered_actions", "gauge", "Actions recorded since the last write to disk.", func(s *analytics) int64 {
+		return atomic.LoadInt64(&s.metrics.buffered)
+	})
+	perSite("visitors", "gauge", "Visitors of today held in memory.", func(s *analytics) int64 {
+		return int64(s.sessionsToday())
+	})
+
+	h := a.health.snapshot()
+	writeMetricHeader(&b, "flushes_total", "counter", "Writes of every site to disk.")
+	fmt.Fprintf(&b, "%sflushes_total %d\n", metricsPrefix, h.Successes+h.Failures)
+	writeMetricHeader(&b, "flush_failures_total", "counter", "Writes to disk that failed.")
+	fmt.Fprintf(&b, "%sflush_failures_total %d\n", metricsPrefix, h.Failures)
+	writeMetricHeader(&b, "bytes_written_total", "counter", "Bytes of day files written to disk.")
+	fmt.Fprintf(&b, "%sbytes_written_total %d\n", metricsPrefix, h.BytesWritten)
+	writeMetricHeader(&b, "last_flush_success_timestamp_seconds", "gauge", "When data was last written to disk, 0 if never.")
+	last := int64(0)
+	if !h.LastSuccess.IsZero() {
+		last = h.LastSuccess.Unix()
+	}
+	fmt.Fprintf(&b, "%slast_flush_success_timestamp_seconds %d\n", metricsPrefix, last)
+	writeMetricHeader(&b, "flush_duration_seconds", "histogram", "How long writes of every site to disk took.")
+	cumulative := uint64(0)
+	for i, le := range flushBuckets {
+		cumulative += h.Buckets[i]
+		fmt.Fprintf(&b, "%sflush_duration_seconds_bucket{le=\"%g\"} %d\n", metricsPrefix, le, cumulative)
+	}
+	fmt.Fprintf(&b, "%sflush_duration_seconds_bucket{le=\"+Inf\"} %d\n", metricsPrefix, h.Successes+h.Failures)
+	fmt.Fprintf(&b, "%sflush_duration_seconds_sum %g\n", metricsPrefix, h.DurationSum.Seconds())
+	fmt.Fprintf(&b, "%sflush_duration_seconds_count %d\n", metricsPrefix, h.Successes+h.Failures)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+func writeMetricHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s%s %s\n# TYPE %s%s %s\n", metricsPrefix, name, help, metricsPrefix, name, kind)
+}
+
+// flushBucket is the index of the histogram bucket d falls into, or
+// len(flushBuckets) if it's slower than all of them.
+func flushBucket(d time.Duration) int {
+	for i, le := range flushBuckets {
+		if d.Seconds() <= le {
+			return i
+		}
+	}
+	return len(flushBuckets)
+}
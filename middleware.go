@@ -0,0 +1,123 @@
+package analytics
+
+import (
+	"net/http"
+	"time"
+)
+
+// Middleware records every request that passes through it, together with the
+// size of the response it produced and how long it took to serve. Like
+// InsertRequestContext it uses the request's context, so requests whose
+// client went away before they were served aren't recorded. Requests
+// without consent, see ConsentFunc, are passed through untouched.
+func (a analytics) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.consented(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if r.URL == nil {
+			a.log.Warn("can't record a request without a URL")
+			return
+		}
+		duration := time.Since(start)
+		site, visitor := a.record(r.Context(), r, Action{Page: r.URL.Path, Query: r.URL.RawQuery, Bytes: rec.bytes, Duration: duration})
+		a.observe(r, site, r.URL.Path, visitor, rec, duration)
+	})
+}
+
+// RouteMiddleware is Middleware recording the route pattern route returns,
+// like "/users/{id}", instead of the path so parameterized routes are counted
+// together. route is called after the request was served, when routers like
+// chi know which route matched; if it returns "" the path is recorded.
+//
+//	router.Use(analytics.RouteMiddleware(func(r *http.Request) string {
+//		return chi.RouteContext(r.Context()).RoutePattern()
+//	}))
+func (a analytics) RouteMiddleware(route func(r *http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !a.consented(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			start := time.Now()
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			duration := time.Since(start)
+			pattern := route(r)
+			site, visitor := a.recordRoute(r, pattern, rec.bytes, duration)
+			if r.URL != nil {
+				if len(pattern) == 0 {
+					pattern = r.URL.Path
+				}
+				a.observe(r, site, pattern, visitor, rec, duration)
+			}
+		})
+	}
+}
+
+// RecordRoute records a served request under its route pattern, or its path
+// if route is "", with the size of the response and how long it took. It's
+// what middleware for frameworks with their own handler types calls, see the
+// readme for gin and echo.
+func (a analytics) RecordRoute(r *http.Request, route string, bytes int64, duration time.Duration) {
+	a.recordRoute(r, route, bytes, duration)
+}
+
+func (a analytics) recordRoute(r *http.Request, route string, bytes int64, duration time.Duration) (site, visitor string) {
+	if r == nil || r.URL == nil {
+		a.log.Warn("can't record a request without a URL")
+		return a.Name, ""
+	}
+	if len(route) == 0 {
+		route = r.URL.Path
+	}
+	return a.record(r.Context(), r, Action{Page: route, Query: r.URL.RawQuery, Bytes: bytes, Duration: duration})
+}
+
+// responseRecorder counts the bytes written through it. Every Write is
+// counted so streamed responses without a Content-Length are measured too.
+// code is the status written, if any.
+type responseRecorder struct {
+	http.ResponseWriter
+	bytes int64
+	code  int
+}
+
+func (rec *responseRecorder) WriteHeader(code int) {
+	// Informational responses like 103 Early Hints come before the real one.
+	if rec.code == 0 && code >= 200 {
+		rec.code = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// status is the status the response was sent with, 200 if the handler didn't
+// set one.
+func (rec *responseRecorder) status() int {
+	if rec.code == 0 {
+		return http.StatusOK
+	}
+	return rec.code
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+func (rec *responseRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
@@ -0,0 +1,298 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+)
+
+// mmdbMetadataMarker precedes the metadata at the end of a MaxMind DB file.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbDataSeparator is the size of the zeroes between the search tree and
+// the data section.
+const mmdbDataSeparator = 16
+
+// mmdb is a MaxMind DB file read into memory, like GeoLite2-ASN. Only what
+// looking up an IP's record needs is implemented.
+type mmdb struct {
+	tree       []byte
+	data       []byte
+	nodeCount  uint
+	recordSize uint
+	ipVersion  uint
+	ipv4Start  uint
+}
+
+// OpenASNDatabase reads a MaxMind DB with autonomous system numbers and
+// organizations, like GeoLite2-ASN, into memory and returns its lookup for
+// ASNLookup.
+func OpenASNDatabase(path string) (ASNLookup, error) {
+	bs, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	db, err := parseMMDB(bs)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return db.asn, nil
+}
+
+func parseMMDB(bs []byte) (*mmdb, error) {
+	at := bytes.LastIndex(bs, mmdbMetadataMarker)
+	if at < 0 {
+		return nil, errors.New("no MaxMind DB metadata")
+	}
+	meta, _, err := mmdbDecoder{data: bs[at+len(mmdbMetadataMarker):]}.decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("decoding metadata: %w", err)
+	}
+	fields, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("metadata isn't a map")
+	}
+	db := &mmdb{}
+	for name, v := range map[string]*uint{"node_count": &db.nodeCount, "record_size": &db.recordSize, "ip_version": &db.ipVersion} {
+		n, ok := fields[name].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("metadata has no %s", name)
+		}
+		*v = uint(n)
+	}
+	if db.recordSize != 24 && db.recordSize != 28 && db.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d", db.recordSize)
+	}
+	if db.ipVersion != 4 && db.ipVersion != 6 {
+		return nil, fmt.Errorf("unsupported IP version %d", db.ipVersion)
+	}
+	treeSize := db.nodeCount * db.recordSize / 4
+	if treeSize+mmdbDataSeparator > uint(at) {
+		return nil, errors.New("search tree is larger than the file")
+	}
+	db.tree = bs[:treeSize]
+	db.data = bs[treeSize+mmdbDataSeparator : at]
+	// IPv4 addresses live under 96 zero bits in an IPv6 tree.
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record is the left (bit 0) or right (bit 1) record of a node.
+func (db *mmdb) record(node, bit uint) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+bit*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.tree[node*8+bit*4:]))
+	}
+}
+
+// lookup decodes the data of the network ip is in, nil if it's in none.
+func (db *mmdb) lookup(ip net.IP) (interface{}, error) {
+	bits, node := ip.To16(), uint(0)
+	if v4 := ip.To4(); v4 != nil {
+		bits, node = v4, db.ipv4Start
+	} else if db.ipVersion == 4 {
+		return nil, nil
+	}
+	if bits == nil {
+		return nil, fmt.Errorf("%v isn't an IP", ip)
+	}
+	for i := 0; i < len(bits)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, uint(bits[i/8]>>(7-i%8)&1))
+	}
+	if node == db.nodeCount {
+		return nil, nil
+	}
+	if node < db.nodeCount {
+		return nil, errors.New("search tree is deeper than the address")
+	}
+	offset := node - db.nodeCount - mmdbDataSeparator
+	v, _, err := mmdbDecoder{data: db.data}.decode(offset)
+	return v, err
+}
+
+// asn is the ASNLookup of a GeoLite2-ASN like database.
+func (db *mmdb) asn(ip net.IP) (ASN, error) {
+	v, err := db.lookup(ip)
+	if err != nil || v == nil {
+		return ASN{}, err
+	}
+	fields, ok := v.(map[string]interface{})
+	if !ok {
+		return ASN{}, errors.New("record isn't a map")
+	}
+	number, _ := fields["autonomous_system_number"].(uint64)
+	org, _ := fields["autonomous_system_organization"].(string)
+	return ASN{Number: uint32(number), Org: org}, nil
+}
+
+// mmdbDecoder decodes the values of a data section: maps, arrays, strings,
+// numbers and booleans, following pointers.
+type mmdbDecoder struct {
+	data []byte
+}
+
+// MaxMind DB data types.
+const (
+	mmdbExtended = iota
+	mmdbPointer
+	mmdbString
+	mmdbDouble
+	mmdbBytes
+	mmdbUint16
+	mmdbUint32
+	mmdbMap
+	mmdbInt32
+	mmdbUint64
+	mmdbUint128
+	mmdbArray
+	mmdbContainer
+	mmdbEnd
+	mmdbBool
+	mmdbFloat
+)
+
+// decode returns the value at offset and the offset after it.
+func (d mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	typ, size, offset, err := d.control(offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	if typ == mmdbPointer {
+		target, next, err := d.pointer(size, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		v, _, err := d.decode(target)
+		return v, next, err
+	}
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, 0, errors.New("map key isn't a string")
+			}
+			if m[key], offset, err = d.decode(next); err != nil {
+				return nil, 0, err
+			}
+		}
+		return m, offset, nil
+	case mmdbArray:
+		a := make([]interface{}, size)
+		for i := range a {
+			if a[i], offset, err = d.decode(offset); err != nil {
+				return nil, 0, err
+			}
+		}
+		return a, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	}
+	if offset+size > uint(len(d.data)) {
+		return nil, 0, errors.New("value runs past the data section")
+	}
+	b := d.data[offset : offset+size]
+	switch typ {
+	case mmdbString:
+		return string(b), offset + size, nil
+	case mmdbBytes:
+		return append([]byte(nil), b...), offset + size, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("double isn't 8 bytes")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset + size, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("float isn't 4 bytes")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset + size, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64, mmdbInt32:
+		if size > 8 {
+			return nil, 0, errors.New("integer is too large")
+		}
+		var n uint64
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		if typ == mmdbInt32 {
+			return int64(int32(n)), offset + size, nil
+		}
+		return n, offset + size, nil
+	case mmdbUint128:
+		return append([]byte(nil), b...), offset + size, nil
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// control reads the control byte at offset, returning the type and size of
+// the value and the offset of its payload.
+func (d mmdbDecoder) control(offset uint) (typ int, size uint, next uint, err error) {
+	if offset >= uint(len(d.data)) {
+		return 0, 0, 0, errors.New("offset past the data section")
+	}
+	c := d.data[offset]
+	offset++
+	typ = int(c >> 5)
+	if typ == mmdbExtended {
+		if offset >= uint(len(d.data)) {
+			return 0, 0, 0, errors.New("extended type past the data section")
+		}
+		typ = 7 + int(d.data[offset])
+		offset++
+	}
+	size = uint(c & 0x1f)
+	if typ == mmdbPointer || size < 29 {
+		return typ, size, offset, nil
+	}
+	n := size - 28
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, 0, errors.New("size past the data section")
+	}
+	var extra uint
+	for _, b := range d.data[offset : offset+n] {
+		extra = extra<<8 | uint(b)
+	}
+	size = [...]uint{29, 285, 65821}[n-1] + extra
+	return typ, size, offset + n, nil
+}
+
+// pointer returns the data section offset a pointer with the size bits of
+// its control byte points to, and the offset after it.
+func (d mmdbDecoder) pointer(size, offset uint) (uint, uint, error) {
+	n := (size>>3)&3 + 1
+	if offset+n > uint(len(d.data)) {
+		return 0, 0, errors.New("pointer past the data section")
+	}
+	var p uint
+	if n < 4 {
+		p = size & 7
+	}
+	for _, b := range d.data[offset : offset+n] {
+		p = p<<8 | uint(b)
+	}
+	p += [...]uint{0, 2048, 526336, 0}[n-1]
+	return p, offset + n, nil
+}
@@ -0,0 +1,146 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// mmdbNode is a node of the search tree writeMMDB builds.
+type mmdbNode struct {
+	child [2]*mmdbNode
+	id    uint
+	leaf  bool
+	data  uint
+}
+
+// encodeMMDBString, encodeMMDBUint and encodeMMDBMap encode values of a
+// data section. Strings from 29 bytes on take a byte more for their size.
+func encodeMMDBString(s string) []byte {
+	if len(s) >= 29 {
+		return append([]byte{mmdbString<<5 | 29, byte(len(s) - 29)}, s...)
+	}
+	return append([]byte{mmdbString<<5 | byte(len(s))}, s...)
+}
+
+func encodeMMDBUint(typ byte, n uint32) []byte {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	v := bytes.TrimLeft(b[:], "\x00")
+	return append([]byte{typ<<5 | byte(len(v))}, v...)
+}
+
+func encodeMMDBMap(pairs int) []byte {
+	return []byte{mmdbMap<<5 | byte(pairs)}
+}
+
+// writeMMDB writes an IPv4 database with 24 bit records mapping each network
+// to the data at its offset.
+func writeMMDB(t *testing.T, networks map[string]uint, data []byte) string {
+	t.Helper()
+	root := &mmdbNode{}
+	for cidr, offset := range networks {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := n.Mask.Size()
+		node := root
+		for i := 0; i < ones; i++ {
+			bit := n.IP.To4()[i/8] >> (7 - i%8) & 1
+			if node.child[bit] == nil {
+				node.child[bit] = &mmdbNode{}
+			}
+			node = node.child[bit]
+		}
+		node.leaf, node.data = true, offset
+	}
+	var nodes []*mmdbNode
+	var number func(n *mmdbNode)
+	number = func(n *mmdbNode) {
+		if n == nil || n.leaf {
+			return
+		}
+		n.id = uint(len(nodes))
+		nodes = append(nodes, n)
+		number(n.child[0])
+		number(n.child[1])
+	}
+	number(root)
+	count := uint(len(nodes))
+	var file bytes.Buffer
+	for _, n := range nodes {
+		for _, c := range n.child {
+			r := count
+			if c != nil && c.leaf {
+				r = count + mmdbDataSeparator + c.data
+			} else if c != nil {
+				r = c.id
+			}
+			file.Write([]byte{byte(r >> 16), byte(r >> 8), byte(r)})
+		}
+	}
+	file.Write(make([]byte, mmdbDataSeparator))
+	file.Write(data)
+	file.Write(mmdbMetadataMarker)
+	file.Write(encodeMMDBMap(4))
+	file.Write(encodeMMDBString("node_count"))
+	file.Write(encodeMMDBUint(mmdbUint32, uint32(count)))
+	file.Write(encodeMMDBString("record_size"))
+	file.Write(encodeMMDBUint(mmdbUint16, 24))
+	file.Write(encodeMMDBString("ip_version"))
+	file.Write(encodeMMDBUint(mmdbUint16, 4))
+	file.Write(encodeMMDBString("database_type"))
+	file.Write(encodeMMDBString("GeoLite2-ASN"))
+	path := filepath.Join(t.TempDir(), "asn.mmdb")
+	if err := os.WriteFile(path, file.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenASNDatabase(t *testing.T) {
+	var data bytes.Buffer
+	data.Write(encodeMMDBMap(2))
+	data.Write(encodeMMDBString("autonomous_system_number"))
+	data.Write(encodeMMDBUint(mmdbUint32, 64500))
+	data.Write(encodeMMDBString("autonomous_system_organization"))
+	org := uint(data.Len())
+	data.Write(encodeMMDBString("Example Hosting"))
+	second := uint(data.Len())
+	data.Write(encodeMMDBMap(2))
+	data.Write(encodeMMDBString("autonomous_system_number"))
+	data.Write(encodeMMDBUint(mmdbUint32, 64501))
+	data.Write(encodeMMDBString("autonomous_system_organization"))
+	// A pointer to the first network's organization.
+	data.Write([]byte{mmdbPointer << 5, byte(org)})
+	lookup, err := OpenASNDatabase(writeMMDB(t, map[string]uint{"192.0.2.0/24": 0, "198.51.100.0/25": second}, data.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ip, want := range map[string]ASN{
+		"192.0.2.77":     {Number: 64500, Org: "Example Hosting"},
+		"198.51.100.1":   {Number: 64501, Org: "Example Hosting"},
+		"198.51.100.200": {},
+		"203.0.113.1":    {},
+		"2001:db8::1":    {},
+	} {
+		got, err := lookup(net.ParseIP(ip))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s got %+v, want %+v", ip, got, want)
+		}
+	}
+	if _, err := OpenASNDatabase(filepath.Join(t.TempDir(), "missing.mmdb")); err == nil {
+		t.Error("a missing database was opened")
+	}
+	if _, err := parseMMDB([]byte("not a database")); err == nil {
+		t.Error("a file without metadata was parsed")
+	}
+}
@@ -0,0 +1,115 @@
+package analytics
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// NodeStats is what one of the instances merged with AggregateNames recorded
+// over the days shown. Sessions adds up each day's visitors, so a visitor
+// seen by several instances counts for each of them.
+type NodeStats struct {
+	Name      string `json:"name"`
+	Sessions  int    `json:"sessions"`
+	PageViews int    `json:"page_views"`
+}
+
+// peers are the AggregateNames other than Name, which may be listed too so
+// every instance can share the same list.
+func peers(config AnalyticsConfiguration) []string {
+	var names []string
+	seen := map[string]bool{config.Name: true}
+	for _, name := range config.AggregateNames {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// peerFileName is the day file another instance called name writes to the
+// shared Directory.
+func (a analytics) peerFileName(name string, t time.Time) string {
+	return filepath.Join(a.dayDir(t), name+a.dayKey(t))
+}
+
+// peerDay reads the day file of another instance. Unlike readDayFile it
+// never rewrites the file, it belongs to the other instance.
+func (a analytics) peerDay(name string, date time.Time) map[string][]Action {
+	fileName := a.peerFileName(name, date)
+	if !dayFileExists(fileName) {
+		return map[string][]Action{}
+	}
+	entries, err := a.decodeDayFile(fileName)
+	if err != nil {
+		a.log.Error("%v", err)
+		return entries
+	}
+	migrateKeys(entries)
+	return entries
+}
+
+// withPeers merges the day of every AggregateNames instance into data, the
+// day of this one. Visitors keyed alike by several instances become one, with
+// their actions in time order. data isn't modified.
+func (a analytics) withPeers(data map[string][]Action, date time.Time) map[string][]Action {
+	if len(a.peers) == 0 {
+		return data
+	}
+	merged := make(map[string][]Action, len(data))
+	for k, actions := range data {
+		merged[k] = actions
+	}
+	for _, name := range a.peers {
+		for k, actions := range a.peerDay(name, date) {
+			own, ok := merged[k]
+			if !ok {
+				merged[k] = actions
+				continue
+			}
+			both := make([]Action, 0, len(own)+len(actions))
+			both = append(append(both, own...), actions...)
+			sort.SliceStable(both, func(i, j int) bool { return both[i].Timestamp < both[j].Timestamp })
+			merged[k] = both
+		}
+	}
+	return merged
+}
+
+// peerSummary is the summary of another instance's day, read from its
+// summary file or, if that's missing or lacks hours, from its day file.
+func (a analytics) peerSummary(name string, date time.Time) daySummary {
+	var s daySummary
+	bs, err := readDayFileBytes(a.peerFileName(name, date) + ".summary")
+	if err == nil && json.Unmarshal(bs, &s) == nil && len(s.Hours) == 24 {
+		return s
+	}
+	return summarize(a.peerDay(name, date), a.location)
+}
+
+// nodeStats breaks the days from through to down by instance, this one
+// first, when there are AggregateNames.
+func (a analytics) nodeStats(from, to time.Time) []NodeStats {
+	if len(a.peers) == 0 {
+		return nil
+	}
+	nodes := make([]NodeStats, 0, len(a.peers)+1)
+	for _, name := range append([]string{a.Name}, a.peers...) {
+		n := NodeStats{Name: name}
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			var s daySummary
+			if name == a.Name {
+				s = a.ownSummary(d)
+			} else {
+				s = a.peerSummary(name, d)
+			}
+			n.Sessions += s.Sessions
+			n.PageViews += s.PageViews
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes
+}
@@ -0,0 +1,173 @@
+package analytics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// NormalizeFunc rewrites the path and raw query of a request before it's
+// stored, after the rules of URLNormalization were applied.
+type NormalizeFunc func(path, query string) (string, string)
+
+// URLNormalization makes URLs that lead to the same page count as one, e.g.
+// /Pricing, /pricing and /pricing/. Pages are normalized when they're
+// recorded, so the stored data is consistent; Renormalize applies new rules
+// to data on disk.
+type URLNormalization struct {
+	// Lowercase lowercases paths.
+	Lowercase bool
+	// TrimTrailingSlash removes trailing slashes, except from "/".
+	TrimTrailingSlash bool
+	// CollapseSlashes replaces runs of slashes with one.
+	CollapseSlashes bool
+	// StripTrackingParams removes the utm_ parameters and those in
+	// TrackingParams from query strings.
+	StripTrackingParams bool
+	// Func is applied last, if set.
+	Func NormalizeFunc
+}
+
+// TrackingParams are the query parameters StripTrackingParams removes
+// besides those starting with utm_.
+var TrackingParams = []string{"gclid", "dclid", "fbclid", "msclkid", "mc_cid", "mc_eid", "_ga", "yclid"}
+
+func (n URLNormalization) enabled() bool {
+	return n.Lowercase || n.TrimTrailingSlash || n.CollapseSlashes || n.StripTrackingParams || n.Func != nil
+}
+
+// normalize applies the rules to a path and raw query.
+func (n URLNormalization) normalize(path, query string) (string, string) {
+	if n.CollapseSlashes {
+		for strings.Contains(path, "//") {
+			path = strings.Replace(path, "//", "/", -1)
+		}
+	}
+	if n.TrimTrailingSlash {
+		path = strings.TrimRight(path, "/")
+		if len(path) == 0 {
+			path = "/"
+		}
+	}
+	if n.Lowercase {
+		path = strings.ToLower(path)
+	}
+	if n.StripTrackingParams {
+		query = stripTracking(query)
+	}
+	if n.Func != nil {
+		path, query = n.Func(path, query)
+	}
+	return path, query
+}
+
+// stripTracking removes the tracking parameters from a raw query, leaving it
+// as it is if there are none.
+func stripTracking(raw string) string {
+	if len(raw) == 0 {
+		return raw
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	stripped := false
+	for p := range values {
+		if strings.HasPrefix(p, "utm_") || trackingParam(p) {
+			delete(values, p)
+			stripped = true
+		}
+	}
+	if !stripped {
+		return raw
+	}
+	return values.Encode()
+}
+
+func trackingParam(p string) bool {
+	for _, t := range TrackingParams {
+		if t == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Renormalize rewrites the day files in directory with the rules of n, for
+// data recorded before they were configured. It must only run while no
+// Analyzer writes to directory. Day files of every site are rewritten; the
+// number rewritten is returned along with the first error, files after it
+// aren't touched.
+func Renormalize(directory string, n URLNormalization) (int, error) {
+	rewritten := 0
+	reader := analytics{maxDayBytes: defaultMaxDayBytes}
+	err := filepath.Walk(directory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || !isDayFile(directory, path) {
+			return nil
+		}
+		entries, err := reader.decodeDayFile(path)
+		if err != nil {
+			return err
+		}
+		migrateKeys(entries)
+		changed := false
+		for _, actions := range entries {
+			for i, act := range actions {
+				page, query := n.normalize(act.Page, act.Query)
+				if page != act.Page || query != act.Query {
+					actions[i].Page, actions[i].Query = page, query
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			return nil
+		}
+		if _, err := writeDayFile(path, entries); err != nil {
+			return err
+		}
+		if err := renormalizeSummary(path+".summary", entries); err != nil {
+			return err
+		}
+		rewritten++
+		return nil
+	})
+	return rewritten, err
+}
+
+// renormalizeSummary ranks the pages of a day's summary again. A missing or
+// unreadable summary is left alone, it's rebuilt when it's read.
+func renormalizeSummary(fileName string, entries map[string][]Action) error {
+	var s daySummary
+	bs, err := ioutil.ReadFile(fileName)
+	if err != nil || json.Unmarshal(bs, &s) != nil {
+		return nil
+	}
+	s.Pages = topDayPages(entries)
+	bs, err = json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFile(fileName, bs)
+}
+
+// isDayFile tells day files from the other files of a day: they're named
+// after the day of the directory they're in, the others add an extension.
+func isDayFile(directory, path string) bool {
+	rel, err := filepath.Rel(directory, path)
+	if err != nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 4 {
+		return false
+	}
+	day := parts[0] + "-" + parts[1] + "-" + parts[2]
+	return len(parts[3]) > len(day) && strings.HasSuffix(parts[3], day)
+}
@@ -0,0 +1,46 @@
+package analytics
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// RequestObserver is told about every request Middleware and RouteMiddleware
+// served, e.g. to count them as OpenTelemetry metrics and to annotate the
+// request's span with the visitor so traces and analytics can be joined, see
+// the readme. It's called after the request was recorded and doesn't change
+// what's stored. Without one nothing is done.
+type RequestObserver interface {
+	ObserveRequest(ctx context.Context, req ObservedRequest)
+}
+
+// ObservedRequest is a request served through the middleware. Route is the
+// recorded page, the route pattern with RouteMiddleware. Visitor is the key
+// the visitor's actions are stored under, "" if the request wasn't recorded,
+// e.g. for bots.
+type ObservedRequest struct {
+	Site     string
+	Route    string
+	Method   string
+	Status   int
+	Bytes    int64
+	Duration time.Duration
+	Visitor  string
+}
+
+// observe passes a served request to the RequestObserver, if there is one.
+func (a analytics) observe(r *http.Request, site, route, visitor string, rec *responseRecorder, duration time.Duration) {
+	if a.observer == nil {
+		return
+	}
+	a.observer.ObserveRequest(r.Context(), ObservedRequest{
+		Site:     site,
+		Route:    route,
+		Method:   r.Method,
+		Status:   rec.status(),
+		Bytes:    rec.bytes,
+		Duration: duration,
+		Visitor:  visitor,
+	})
+}
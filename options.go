@@ -0,0 +1,130 @@
+package analytics
+
+import "time"
+
+// Option configures an Analyzer created with New.
+type Option func(*settings)
+
+// settings is what the options of New build up.
+type settings struct {
+	config        AnalyticsConfiguration
+	flushInterval time.Duration
+	log           Logger
+	clock         Clock
+}
+
+// New creates an Analyzer recording the site called name, validating the
+// options and applying defaults for everything they leave unset the same way
+// NewAnalyticsWithError does.
+//
+//	analytics, err := New("example.com",
+//		WithDirectory("logs"),
+//		WithPassword(os.Getenv("DASHBOARD_KEY")),
+//		WithFlushInterval(30*time.Second),
+//	)
+func New(name string, opts ...Option) (Analyzer, error) {
+	var s settings
+	for _, opt := range opts {
+		opt(&s)
+	}
+	s.config.Name = name
+	return newAnalytics(s)
+}
+
+// WithConfiguration starts from an AnalyticsConfiguration, for the settings
+// that don't have an option of their own. Options after it override it.
+func WithConfiguration(config AnalyticsConfiguration) Option {
+	return func(s *settings) {
+		s.config = config
+	}
+}
+
+// WithDirectory sets the directory the data files are written to.
+func WithDirectory(dir string) Option {
+	return func(s *settings) {
+		s.config.Directory = dir
+	}
+}
+
+// WithPassword protects the dashboard with a password.
+func WithPassword(password string) Option {
+	return func(s *settings) {
+		s.config.Password = password
+	}
+}
+
+// WithPasswordHash protects the dashboard with the password of a bcrypt
+// hash, instead of keeping the password itself in the configuration.
+func WithPasswordHash(hash string) Option {
+	return func(s *settings) {
+		s.config.PasswordHash = hash
+	}
+}
+
+// WithFlushInterval sets how often data is written to disk, taking
+// precedence over WriteScheduleSeconds.
+func WithFlushInterval(d time.Duration) Option {
+	return func(s *settings) {
+		s.flushInterval = d
+	}
+}
+
+// WithBotBlacklist sets the user agent substrings requests are ignored for.
+func WithBotBlacklist(blacklist []string) Option {
+	return func(s *settings) {
+		s.config.UserAgentBlackList = blacklist
+	}
+}
+
+// WithHashSecret hashes visitor IPs with secret before they're stored.
+func WithHashSecret(secret string) Option {
+	return func(s *settings) {
+		s.config.HashIPSecret = secret
+	}
+}
+
+// WithHashFunc keys visitors by hash, recording scheme with the data of each
+// day so days keyed differently can be told apart.
+//
+//	WithHashFunc(SchemeHMAC, HMACHash)
+//	WithHashFunc("sha256-16", TruncatedHash(SHA256Hash, 16))
+func WithHashFunc(scheme string, hash HashFunc) Option {
+	return func(s *settings) {
+		s.config.HashFunc = hash
+		s.config.HashScheme = scheme
+	}
+}
+
+// WithURLNormalization normalizes the URLs of requests before they're
+// stored, see URLNormalization.
+//
+//	WithURLNormalization(URLNormalization{Lowercase: true, TrimTrailingSlash: true})
+func WithURLNormalization(n URLNormalization) Option {
+	return func(s *settings) {
+		s.config.URLNormalization = n
+	}
+}
+
+// WithLogger logs with a fmt.Println shaped function, see FuncLogger.
+// fmt.Println is used by default.
+func WithLogger(logger func(...interface{}) (int, error)) Option {
+	return func(s *settings) {
+		s.log = FuncLogger(logger)
+	}
+}
+
+// WithLeveledLogger logs with a Logger, to tell unauthorized requests from
+// failed writes.
+func WithLeveledLogger(log Logger) Option {
+	return func(s *settings) {
+		s.log = log
+	}
+}
+
+// WithClock makes the Analyzer take the time from clock, deciding which day
+// actions are recorded under and what the dashboard calls today.
+func WithClock(clock Clock) Option {
+	return func(s *settings) {
+		s.clock = clock
+	}
+}
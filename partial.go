@@ -0,0 +1,116 @@
+package analytics
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Fragments the dashboard returns for ?partial=, to embed in other pages.
+const (
+	PartialSummary  = "summary"
+	PartialTopPages = "toppages"
+)
+
+// partialTopPages is how many URLs the toppages fragment lists.
+const partialTopPages = 10
+
+// TopPages is what the toppages fragment renders: the most viewed URLs of
+// all groups.
+type TopPages struct {
+	Date  string    `json:"date"`
+	To    string    `json:"to,omitempty"`
+	Pages []TopPage `json:"pages"`
+}
+
+// TopPage is a URL of the toppages fragment with the group it belongs to.
+type TopPage struct {
+	Group string `json:"group"`
+	Label string `json:"label,omitempty"`
+	URLHit
+}
+
+// widgetAuthorized is authorized, also accepting WidgetToken so pages
+// embedding a fragment don't give away the dashboard password. Unlike the
+// password, the token is always accepted as ?k= since an iframe can't send
+// headers. Wrong tokens count as failed logins of the same limit.
+func (a analytics) widgetAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	if token, ok := credentials(r, true); ok && len(a.widgetToken) > 0 {
+		ip, now := clientIP(r), a.now()
+		if !a.logins.allowed(ip, now) {
+			a.tooManyLogins(w, ip)
+			return false
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(a.widgetToken)) == 1 {
+			a.logins.reset(ip)
+			return true
+		}
+		// authorized counts the failure of credentials it reads itself.
+		if _, ok := credentials(r, !a.disableQueryKey); !ok {
+			a.logins.fail(ip, now)
+		}
+	}
+	return a.authorized(w, r)
+}
+
+// partial renders a bare HTML fragment, without <html> or <head>, of the
+// selected day or range.
+func (a analytics) partial(w http.ResponseWriter, r *http.Request, name string) {
+	if !a.widgetAuthorized(w, r) {
+		return
+	}
+	if name != PartialSummary && name != PartialTopPages {
+		err := fmt.Errorf("unknown partial %q, expected summary or toppages", name)
+		a.log.Info("%v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, ok := a.requestRange(w, r)
+	if !ok {
+		return
+	}
+	release, ok := a.admit(w, a.rangeCached(from, to))
+	if !ok {
+		return
+	}
+	defer release()
+	securityHeaders(w)
+	dd := a.aggregateRange(from, to).report(urlView{})
+	a.labelGroups(dd.URLHits)
+	dd.Visitors = a.visitorIDs(dd.Visitors)
+	dd.Date = a.dayKey(from)
+	if !to.Equal(from) {
+		dd.To = a.dayKey(to)
+	}
+	if name == PartialSummary {
+		a.render(w, "partial-summary", dd)
+		return
+	}
+
+	tp := TopPages{Date: dd.Date, To: dd.To, Pages: topPages(dd, partialTopPages)}
+	a.render(w, "partial-toppages", tp)
+}
+
+// topPages lists the n most viewed URLs of all groups of dd.
+func topPages(dd DashboardData, n int) []TopPage {
+	var pages []TopPage
+	for _, g := range dd.URLHits {
+		for _, u := range g.URLs {
+			pages = append(pages, TopPage{Group: g.Group, Label: g.Label, URLHit: u})
+		}
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		if pages[i].Views != pages[j].Views {
+			return pages[i].Views > pages[j].Views
+		}
+		if pages[i].Group != pages[j].Group {
+			return pages[i].Group < pages[j].Group
+		}
+		return pages[i].URL < pages[j].URL
+	})
+	if len(pages) > n {
+		pages = pages[:n]
+	}
+	return pages
+}
@@ -0,0 +1,105 @@
+package analytics
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// pathWildcard matches any one segment of a path in PathTemplates.
+const pathWildcard = "*"
+
+// topRawPaths caps how many raw paths are listed under a templated URL.
+const topRawPaths = 10
+
+// pathTemplate is a compiled entry of PathTemplates.
+type pathTemplate struct {
+	template  string
+	segments  []string
+	wildcards int
+}
+
+// PathCount is how often a raw path was viewed under a templated URL.
+type PathCount struct {
+	Path  string `json:"path"`
+	Views int    `json:"views"`
+}
+
+// validPathTemplates compiles PathTemplates, the most specific first: those
+// with fewer wildcards, then longer ones.
+func validPathTemplates(templates []string) ([]pathTemplate, error) {
+	compiled := make([]pathTemplate, 0, len(templates))
+	for _, t := range templates {
+		if !strings.HasPrefix(t, "/") {
+			return nil, fmt.Errorf("PathTemplates %q must start with /", t)
+		}
+		pt := pathTemplate{template: t, segments: strings.Split(t[1:], "/")}
+		for _, s := range pt.segments {
+			if s == pathWildcard {
+				pt.wildcards++
+			} else if strings.Contains(s, pathWildcard) {
+				return nil, fmt.Errorf("PathTemplates %q can only use %s for whole segments", t, pathWildcard)
+			}
+		}
+		compiled = append(compiled, pt)
+	}
+	sort.SliceStable(compiled, func(i, j int) bool {
+		if compiled[i].wildcards != compiled[j].wildcards {
+			return compiled[i].wildcards < compiled[j].wildcards
+		}
+		return len(compiled[i].segments) > len(compiled[j].segments)
+	})
+	return compiled, nil
+}
+
+// matchTemplate returns the most specific template matching path, or "".
+func matchTemplate(templates []pathTemplate, path string) string {
+	if len(templates) == 0 || !strings.HasPrefix(path, "/") {
+		return ""
+	}
+	segments := strings.Split(path[1:], "/")
+	for _, t := range templates {
+		if t.matches(segments) {
+			return t.template
+		}
+	}
+	return ""
+}
+
+func (t pathTemplate) matches(segments []string) bool {
+	if len(segments) != len(t.segments) {
+		return false
+	}
+	for i, s := range t.segments {
+		if s == pathWildcard {
+			if len(segments[i]) == 0 {
+				return false
+			}
+		} else if s != segments[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rankRawPaths orders the raw paths of a templated URL by views, keeping
+// topRawPaths.
+func rankRawPaths(counts map[string]int) []PathCount {
+	if len(counts) == 0 {
+		return nil
+	}
+	ranked := make([]PathCount, 0, len(counts))
+	for p, n := range counts {
+		ranked = append(ranked, PathCount{Path: p, Views: n})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Views != ranked[j].Views {
+			return ranked[i].Views > ranked[j].Views
+		}
+		return ranked[i].Path < ranked[j].Path
+	})
+	if len(ranked) > topRawPaths {
+		ranked = ranked[:topRawPaths]
+	}
+	return ranked
+}
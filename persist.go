@@ -0,0 +1,130 @@
+package analytics
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// minFlushRetry is how soon a failed scheduled flush is retried, doubling
+	// with every further failure up to the flush interval.
+	minFlushRetry = time.Second
+	// defaultPersistFailureThreshold is how many flushes in a row have to
+	// fail before OnPersistFailure is called when PersistFailureThreshold
+	// isn't set.
+	defaultPersistFailureThreshold = 3
+	// persistErrorsKept is how many of the latest flush errors Health lists.
+	persistErrorsKept = 10
+)
+
+// persistHealth counts how flushes went, for OnPersistFailure, Metrics and
+// Health.
+type persistHealth struct {
+	mux         sync.Mutex
+	started     time.Time
+	lastSuccess time.Time
+	consecutive int
+	successes   int64
+	failures    int64
+	// buckets counts the flushes of each flushBuckets duration, the last one
+	// those slower than all of them.
+	buckets     [len(flushBuckets) + 1]uint64
+	durationSum time.Duration
+	// bytesWritten is updated atomically while files are written.
+	bytesWritten int64
+	// errors is a ring of the latest persistErrorsKept errors, next is where
+	// the next one goes.
+	errors [persistErrorsKept]PersistError
+	next   int
+}
+
+// PersistError is an error writing data to disk.
+type PersistError struct {
+	Time  time.Time `json:"time"`
+	Error string    `json:"error"`
+}
+
+// flushHealth is a copy of persistHealth's counts.
+type flushHealth struct {
+	LastSuccess         time.Time
+	ConsecutiveFailures int
+	Successes           int64
+	Failures            int64
+	Buckets             [len(flushBuckets) + 1]uint64
+	DurationSum         time.Duration
+	BytesWritten        int64
+	// Errors are the latest flush errors, newest first.
+	Errors []PersistError
+}
+
+// record counts the outcome of a flush that took took and returns how many
+// flushes in a row failed, 0 after a success.
+func (h *persistHealth) record(err error, now time.Time, took time.Duration) int {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.buckets[flushBucket(took)]++
+	h.durationSum += took
+	if err == nil {
+		h.successes++
+		h.lastSuccess = now
+		h.consecutive = 0
+		return 0
+	}
+	h.failures++
+	h.consecutive++
+	h.keep(err, now)
+	return h.consecutive
+}
+
+// keep adds an error to the ring of latest errors. The caller holds the lock.
+func (h *persistHealth) keep(err error, now time.Time) {
+	h.errors[h.next] = PersistError{Time: now, Error: err.Error()}
+	h.next = (h.next + 1) % persistErrorsKept
+}
+
+// recordError keeps an error of a flush besides the one passed to record.
+func (h *persistHealth) recordError(err error, now time.Time) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.keep(err, now)
+}
+
+func (h *persistHealth) snapshot() flushHealth {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	return flushHealth{
+		LastSuccess:         h.lastSuccess,
+		ConsecutiveFailures: h.consecutive,
+		Successes:           h.successes,
+		Failures:            h.failures,
+		Buckets:             h.buckets,
+		DurationSum:         h.durationSum,
+		BytesWritten:        atomic.LoadInt64(&h.bytesWritten),
+		Errors:              h.latestErrors(),
+	}
+}
+
+// latestErrors lists the kept errors, newest first. The caller holds the
+// lock.
+func (h *persistHealth) latestErrors() []PersistError {
+	var errs []PersistError
+	for i := 1; i <= persistErrorsKept; i++ {
+		e := h.errors[(h.next-i+persistErrorsKept)%persistErrorsKept]
+		if e.Time.IsZero() {
+			break
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// nextRetry doubles the delay before the next retry, never waiting longer
+// than the regular schedule.
+func nextRetry(retry, interval time.Duration) time.Duration {
+	retry *= 2
+	if retry > interval {
+		return interval
+	}
+	return retry
+}
@@ -0,0 +1,99 @@
+package analytics
+
+import (
+	"crypto/tls"
+	"net/http"
+	"strings"
+)
+
+// noTLS is the TLS version recorded for cleartext requests.
+const noTLS = "none"
+
+// unknownProtocol is recorded for TLS and HTTP versions that aren't known.
+const unknownProtocol = "unknown"
+
+// tlsVersions names the versions r.TLS reports.
+var tlsVersions = map[uint16]string{
+	0x0300:           "SSL 3.0",
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// tlsHeaderVersions names the versions a TLSVersionHeader may carry, like
+// nginx's "TLSv1.2" or HAProxy's "TLSv1.3", once lowercased and stripped of
+// spaces, underscores and the v.
+var tlsHeaderVersions = map[string]string{
+	"ssl3":   "SSL 3.0",
+	"ssl3.0": "SSL 3.0",
+	"tls1":   "TLS 1.0",
+	"tls1.0": "TLS 1.0",
+	"tls1.1": "TLS 1.1",
+	"tls1.2": "TLS 1.2",
+	"tls1.3": "TLS 1.3",
+}
+
+var tlsHeaderReplacer = strings.NewReplacer(" ", "", "_", "", "v", "")
+
+// Protocols is how many sessions came over each TLS version, "none" for
+// cleartext HTTP, and each HTTP version, from most to least.
+type Protocols struct {
+	TLS  []NamedCount `json:"tls"`
+	HTTP []NamedCount `json:"http"`
+}
+
+// protocolCounts counts the sessions of each TLS and HTTP version.
+type protocolCounts struct {
+	tls, http map[string]int
+}
+
+func newProtocolCounts() protocolCounts {
+	return protocolCounts{tls: map[string]int{}, http: map[string]int{}}
+}
+
+// add counts a session whose first action is act. Sessions recorded before
+// protocols were have none and aren't counted.
+func (p protocolCounts) add(act Action) {
+	if len(act.TLS) == 0 {
+		return
+	}
+	p.tls[act.TLS]++
+	p.http[act.Proto]++
+}
+
+func (p protocolCounts) merge(o protocolCounts) {
+	for v, n := range o.tls {
+		p.tls[v] += n
+	}
+	for v, n := range o.http {
+		p.http[v] += n
+	}
+}
+
+// report is the Protocols of the counted sessions, nil if there are none.
+func (p protocolCounts) report() *Protocols {
+	if len(p.tls) == 0 {
+		return nil
+	}
+	return &Protocols{TLS: rankCounts(p.tls, 0), HTTP: rankCounts(p.http, 0)}
+}
+
+// protocols returns the TLS and HTTP versions of r, the TLS version from
+// TLSVersionHeader when it's set and the request has it.
+func (a analytics) protocols(r *http.Request) (string, string) {
+	version := noTLS
+	if v := r.Header.Get(a.tlsHeader); len(a.tlsHeader) > 0 && len(v) > 0 {
+		version = tlsHeaderVersions[tlsHeaderReplacer.Replace(strings.ToLower(strings.TrimSpace(v)))]
+	} else if r.TLS != nil {
+		version = tlsVersions[r.TLS.Version]
+	}
+	if len(version) == 0 {
+		version = unknownProtocol
+	}
+	proto := r.Proto
+	if !strings.HasPrefix(proto, "HTTP/") || len(proto) > len("HTTP/1.1") {
+		proto = unknownProtocol
+	}
+	return version, proto
+}
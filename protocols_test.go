@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// secureVisit is a visit over TLS version and HTTP proto.
+func secureVisit(addr, target string, version uint16, proto string) *http.Request {
+	r := visit(addr, target)
+	r.TLS = &tls.ConnectionState{Version: version}
+	r.Proto = proto
+	return r
+}
+
+func TestProtocols(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{Directory: t.TempDir()}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	a.InsertRequest(secureVisit("192.0.2.1:4000", "/", tls.VersionTLS13, "HTTP/2.0"))
+	a.InsertRequest(secureVisit("192.0.2.1:4000", "/about", tls.VersionTLS10, "HTTP/1.1"))
+	a.InsertRequest(secureVisit("192.0.2.2:4000", "/", tls.VersionTLS13, "HTTP/2.0"))
+	a.InsertRequest(secureVisit("192.0.2.3:4000", "/", tls.VersionTLS10, "HTTP/1.1"))
+	a.InsertRequest(visit("192.0.2.4:4000", "/"))
+	if actions := views(a)["192.0.2.1:4000"]; len(actions) != 2 || actions[0].TLS != "TLS 1.3" || actions[0].Proto != "HTTP/2.0" || actions[1].TLS != "" || actions[1].Proto != "" {
+		t.Errorf("stored %+v, want the protocols on the first action only", actions)
+	}
+
+	want := &Protocols{
+		TLS:  []NamedCount{{Name: "TLS 1.3", Count: 2}, {Name: "TLS 1.0", Count: 1}, {Name: noTLS, Count: 1}},
+		HTTP: []NamedCount{{Name: "HTTP/1.1", Count: 2}, {Name: "HTTP/2.0", Count: 2}},
+	}
+	today, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(today.Protocols, want) {
+		t.Errorf("today got %+v, want %+v", today.Protocols, want)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a = newTestAnalytics(t, config, WithClock(clock))
+	saved, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.Protocols, want) {
+		t.Errorf("the saved day got %+v, want %+v", saved.Protocols, want)
+	}
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?date="+a.dayKey(day), nil))
+	for _, s := range []string{"<h3>Protocols</h3>", "none (cleartext)", "HTTP/2.0"} {
+		if !strings.Contains(rec.Body.String(), s) {
+			t.Errorf("the dashboard doesn't show %q", s)
+		}
+	}
+}
+
+func TestTLSVersionHeader(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{Directory: t.TempDir(), TLSVersionHeader: "X-TLS-Version"})
+	for _, c := range []struct {
+		header string
+		tls    bool
+		want   string
+	}{
+		{"TLSv1.1", false, "TLS 1.1"},
+		{"TLS 1.3", false, "TLS 1.3"},
+		{"tlsv1", false, "TLS 1.0"},
+		{"QUIC", false, unknownProtocol},
+		{"", true, "TLS 1.2"},
+		{"", false, noTLS},
+	} {
+		r := visit("192.0.2.1:4000", "/")
+		if len(c.header) > 0 {
+			r.Header.Set("X-TLS-Version", c.header)
+		}
+		if c.tls {
+			r.TLS = &tls.ConnectionState{Version: tls.VersionTLS12}
+		}
+		if got, proto := a.protocols(r); got != c.want || proto != "HTTP/1.1" {
+			t.Errorf("%q got %q %q, want %q HTTP/1.1", c.header, got, proto, c.want)
+		}
+	}
+}
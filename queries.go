@@ -0,0 +1,122 @@
+package analytics
+
+import (
+	"net/url"
+	"sort"
+)
+
+// topQueries caps how many values each group's "Top queries" table lists.
+const topQueries = 50
+
+// redactedValue replaces the values of RedactQueryParams before a query
+// string is stored.
+const redactedValue = "redacted"
+
+// QueryReport counts the values of the query parameter Param on the pages
+// whose path is one of Paths, or on every page if Paths is empty.
+type QueryReport struct {
+	Param string
+	Paths []string
+}
+
+// QueryCount is how often a query parameter had a value within a URL group.
+type QueryCount struct {
+	Param string `json:"param"`
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type queryKey struct {
+	param, value string
+}
+
+// scrubQuery replaces the values of the RedactQueryParams in a raw query.
+func (rc runtimeConfig) scrubQuery(raw string) string {
+	if len(rc.redactParams) == 0 || len(raw) == 0 {
+		return raw
+	}
+	values, err := url.ParseQuery(raw)
+	if err != nil {
+		return raw
+	}
+	scrubbed := false
+	for _, p := range rc.redactParams {
+		if vs, ok := values[p]; ok {
+			for i := range vs {
+				vs[i] = redactedValue
+			}
+			scrubbed = true
+		}
+	}
+	if !scrubbed {
+		return raw
+	}
+	return values.Encode()
+}
+
+func (rc runtimeConfig) redacted(param string) bool {
+	for _, p := range rc.redactParams {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+// countQueries adds the reported parameter values of a page view to counts.
+// Redacted parameters are skipped, even in days stored before they were.
+func (a analytics) countQueries(counts map[queryKey]int, page, rawQuery string) {
+	if len(rawQuery) == 0 {
+		return
+	}
+	var values url.Values
+	for _, qr := range a.queryReports {
+		if a.tuning.load().redacted(qr.Param) || !reportsPath(qr, page) {
+			continue
+		}
+		if values == nil {
+			var err error
+			if values, err = url.ParseQuery(rawQuery); err != nil {
+				return
+			}
+		}
+		for _, v := range values[qr.Param] {
+			if len(v) > 0 && v != redactedValue {
+				counts[queryKey{param: qr.Param, value: v}]++
+			}
+		}
+	}
+}
+
+func reportsPath(qr QueryReport, page string) bool {
+	if len(qr.Paths) == 0 {
+		return true
+	}
+	for _, p := range qr.Paths {
+		if p == page {
+			return true
+		}
+	}
+	return false
+}
+
+// rankQueries orders a group's query values by count, keeping topQueries.
+func rankQueries(counts map[queryKey]int) []QueryCount {
+	ranked := make([]QueryCount, 0, len(counts))
+	for k, n := range counts {
+		ranked = append(ranked, QueryCount{Param: k.param, Value: k.value, Count: n})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		if ranked[i].Param != ranked[j].Param {
+			return ranked[i].Param < ranked[j].Param
+		}
+		return ranked[i].Value < ranked[j].Value
+	})
+	if len(ranked) > topQueries {
+		ranked = ranked[:topQueries]
+	}
+	return ranked
+}
@@ -0,0 +1,76 @@
+package analytics
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRateBuckets caps how many visitors are rate limited at once. Visitors
+// beyond it aren't limited until a sweep made room.
+const maxRateBuckets = 1 << 16
+
+// rateLimiter is a token bucket per IP for MaxActionsPerVisitorPerMinute.
+// Each bucket holds up to perMinute tokens and refills at that rate, so
+// bursts are smoothed without capping the day. Full buckets are forgotten
+// every minute so the map only holds the visitors active lately.
+type rateLimiter struct {
+	mux       sync.Mutex
+	perMinute float64
+	buckets   map[string]*rateBucket
+	swept     time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perMinute int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &rateLimiter{perMinute: float64(perMinute), buckets: map[string]*rateBucket{}}
+}
+
+// allow takes a token of ip's bucket, reporting whether there was one.
+func (l *rateLimiter) allow(ip string, now time.Time) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	if now.Sub(l.swept) >= time.Minute {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			return true
+		}
+		b = &rateBucket{tokens: l.perMinute, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = l.refilled(b, now)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (l *rateLimiter) refilled(b *rateBucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Minutes()*l.perMinute
+	if tokens > l.perMinute {
+		return l.perMinute
+	}
+	return tokens
+}
+
+// sweep forgets the buckets that refilled, their visitors start over with a
+// full one anyway. The caller holds the lock.
+func (l *rateLimiter) sweep(now time.Time) {
+	for ip, b := range l.buckets {
+		if l.refilled(b, now) >= l.perMinute {
+			delete(l.buckets, ip)
+		}
+	}
+	l.swept = now
+}
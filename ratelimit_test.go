@@ -0,0 +1,68 @@
+package analytics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRateLimitPerIP checks a client opening a new connection per request,
+// so a new port each time, is limited all the same.
+func TestRateLimitPerIP(t *testing.T) {
+	clock := &movingClock{t: time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)}
+	a := newTestAnalytics(t, AnalyticsConfiguration{MaxActionsPerVisitorPerMinute: 5}, WithClock(clock))
+	for port := 1000; port < 1010; port++ {
+		a.InsertRequest(visit(fmt.Sprintf("192.0.2.1:%d", port), "/"))
+	}
+	a.InsertRequest(visit("192.0.2.2:1000", "/"))
+	if got := a.metrics.recorded; got != 6 {
+		t.Errorf("%d requests recorded, want 5 of the first IP and 1 of the other", got)
+	}
+	if got := a.metrics.rateLimited; got != 5 {
+		t.Errorf("%d requests rate limited, want 5", got)
+	}
+	clock.set(clock.Now().Add(time.Minute))
+	a.InsertRequest(visit("192.0.2.1:2000", "/"))
+	if got := a.metrics.recorded; got != 7 {
+		t.Errorf("%d requests recorded, want the first IP allowed again a minute later", got)
+	}
+}
+
+// TestDayCapMetrics checks actions past MaxActionsPerVisitorPerDay are
+// counted as dropped, not recorded.
+func TestDayCapMetrics(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{MaxActionsPerVisitorPerDay: 3})
+	for i := 0; i < 5; i++ {
+		a.InsertRequest(visit("192.0.2.1:1000", fmt.Sprintf("/%d", i)))
+	}
+	if a.metrics.recorded != 3 || a.metrics.buffered != 3 || a.metrics.dropped != 2 {
+		t.Errorf("got %d recorded, %d buffered and %d dropped, want 3, 3 and 2", a.metrics.recorded, a.metrics.buffered, a.metrics.dropped)
+	}
+	rec := httptest.NewRecorder()
+	a.Metrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if want := metricsPrefix + `requests_dropped_total{site="test"} 2`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("the metrics don't have %q", want)
+	}
+}
+
+func TestRateLimiterRefills(t *testing.T) {
+	l := newRateLimiter(60)
+	now := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 60; i++ {
+		if !l.allow("192.0.2.1", now) {
+			t.Fatalf("request %d was limited", i)
+		}
+	}
+	if l.allow("192.0.2.1", now) {
+		t.Error("the 61st request within a minute was allowed")
+	}
+	if !l.allow("192.0.2.1", now.Add(time.Second)) {
+		t.Error("no token was refilled after a second")
+	}
+	if newRateLimiter(0) != nil {
+		t.Error("a limit of 0 isn't off")
+	}
+}
@@ -0,0 +1,63 @@
+package analytics
+
+import (
+	"net/url"
+	"strings"
+)
+
+// DefaultReferrerSpamList are domains known to send fake visits only to show
+// up in referrer reports. ReferrerSpamList adds to them.
+var DefaultReferrerSpamList = []string{
+	"semalt.com", "buttons-for-website.com", "buttons-for-your-website.com",
+	"darodar.com", "ilovevitaly.com", "ilovevitaly.ru", "priceg.com",
+	"blackhatworth.com", "hulfingtonpost.com", "best-seo-offer.com",
+	"best-seo-solution.com", "social-buttons.com", "simple-share-buttons.com",
+	"free-share-buttons.com", "floating-share-buttons.com", "4webmasters.org",
+	"get-free-traffic-now.com", "trafficmonetize.com", "savetubevideo.com",
+	"kambasoft.com", "econom.co", "cenoval.ru", "7makemoneyonline.com",
+	"event-tracking.com", "webmonetizer.net", "success-seo.com",
+}
+
+// spamReferrer is what the referrer of a visit from a spam domain is stored
+// as unless DropReferrerSpam is set.
+const spamReferrer = "(spam)"
+
+// spamDomains is the set of DefaultReferrerSpamList and the extra domains,
+// lowercased and without leading wildcards or trailing dots.
+func spamDomains(extra []string) map[string]bool {
+	domains := make(map[string]bool, len(DefaultReferrerSpamList)+len(extra))
+	for _, list := range [][]string{DefaultReferrerSpamList, extra} {
+		for _, d := range list {
+			d = strings.Trim(strings.TrimPrefix(strings.ToLower(strings.TrimSpace(d)), "*."), ".")
+			if len(d) > 0 {
+				domains[d] = true
+			}
+		}
+	}
+	return domains
+}
+
+// spam tells whether the host of referrer is a spam domain or a subdomain of
+// one. Hosts are matched label by label, so example-semalt-fanpage.com isn't
+// taken for semalt.com.
+func (rc runtimeConfig) spam(referrer string) bool {
+	if len(referrer) == 0 || len(rc.spamDomains) == 0 {
+		return false
+	}
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for len(host) > 0 {
+		if rc.spamDomains[host] {
+			return true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			break
+		}
+		host = host[i+1:]
+	}
+	return false
+}
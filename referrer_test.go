@@ -0,0 +1,69 @@
+package analytics
+
+import (
+	"strconv"
+	"testing"
+)
+
+// referred records a page view of a new visitor coming from referrer and
+// returns the referrer it was stored with, "" if it wasn't recorded.
+func referred(a *analytics, i int, referrer string) string {
+	addr := "192.0.2." + strconv.Itoa(i) + ":4000"
+	r := visit(addr, "/")
+	r.Header.Set("Referer", referrer)
+	a.InsertRequest(r)
+	actions := views(a)[addr]
+	if len(actions) == 0 {
+		return ""
+	}
+	return actions[0].Referrer
+}
+
+func TestReferrerSpam(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{ReferrerSpamList: []string{" *.Spam.Example. "}})
+	for i, tc := range []struct {
+		referrer string
+		spam     bool
+	}{
+		{"https://semalt.com/crawler", true},
+		{"http://www.SEMALT.com/", true},
+		{"https://blog.spam.example./post", true},
+		{"https://spam.example:8080/", true},
+		{"https://example-semalt-fanpage.com/", false},
+		{"https://notsemalt.com/", false},
+		{"https://semalt.com.example.org/", false},
+		{"https://news.example.com/story", false},
+		{"not a url", false},
+	} {
+		want := tc.referrer
+		if tc.spam {
+			want = spamReferrer
+		}
+		if got := referred(a, i+1, tc.referrer); got != want {
+			t.Errorf("%q was stored as %q, want %q", tc.referrer, got, want)
+		}
+	}
+	if n := a.metrics.referrerSpam; n != 4 {
+		t.Errorf("counted %d spam referrers, want 4", n)
+	}
+}
+
+func TestUpdateReferrerSpam(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	if got := referred(a, 1, "https://spammer.example/"); got != "https://spammer.example/" {
+		t.Fatalf("an unlisted referrer was stored as %q", got)
+	}
+	drop := true
+	if err := a.UpdateConfig(ConfigUpdate{ReferrerSpamList: &[]string{"spammer.example"}, DropReferrerSpam: &drop}); err != nil {
+		t.Fatal(err)
+	}
+	if got := referred(a, 2, "https://spammer.example/"); got != "" {
+		t.Errorf("a listed referrer was stored as %q, want it dropped", got)
+	}
+	if got := referred(a, 3, "https://buttons-for-website.com/"); got != "" {
+		t.Errorf("a default spam referrer was stored as %q, want it dropped", got)
+	}
+	if got := referred(a, 4, "https://example.org/"); got != "https://example.org/" {
+		t.Errorf("a legitimate referrer was stored as %q", got)
+	}
+}
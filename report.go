@@ -0,0 +1,244 @@
+package analytics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// reportTop is how many pages and referrers the report lists.
+	reportTop             = 10
+	defaultReportSendAt   = "00:10"
+	defaultReportSMTPPort = 587
+	reportSendAtLayout    = "15:04"
+)
+
+// ReportConfig sends yesterday's numbers of every site by email each day at
+// SendAt. Username and Password are used for PLAIN auth when Username is set,
+// which net/smtp only does over TLS or to localhost.
+type ReportConfig struct {
+	SMTPHost string
+	// SMTPPort is 587 by default.
+	SMTPPort int
+	Username string
+	Password string
+	From     string
+	To       []string
+	// SendAt is the time of day, like "07:30" in the configured Timezone,
+	// the report is sent at. It's "00:10" by default.
+	SendAt string
+}
+
+// reporter is a checked ReportConfig.
+type reporter struct {
+	config      ReportConfig
+	hour        int
+	minute      int
+	manualFlush bool
+}
+
+func validReport(rc *ReportConfig, manualFlush bool) (*reporter, error) {
+	if rc == nil {
+		return nil, nil
+	}
+	config := *rc
+	if len(config.SMTPHost) == 0 {
+		return nil, fmt.Errorf("Report needs an SMTPHost")
+	}
+	if config.SMTPPort == 0 {
+		config.SMTPPort = defaultReportSMTPPort
+	}
+	if config.SMTPPort < 0 || config.SMTPPort > 65535 {
+		return nil, fmt.Errorf("Report SMTPPort must be between 1 and 65535, got %d", config.SMTPPort)
+	}
+	if _, err := mail.ParseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("Report From %q: %w", config.From, err)
+	}
+	if len(config.To) == 0 {
+		return nil, fmt.Errorf("Report needs at least one To address")
+	}
+	for _, to := range config.To {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return nil, fmt.Errorf("Report To %q: %w", to, err)
+		}
+	}
+	if len(config.SendAt) == 0 {
+		config.SendAt = defaultReportSendAt
+	}
+	at, err := time.Parse(reportSendAtLayout, config.SendAt)
+	if err != nil {
+		return nil, fmt.Errorf("Report SendAt %q isn't a time like 07:30", config.SendAt)
+	}
+	return &reporter{config: config, hour: at.Hour(), minute: at.Minute(), manualFlush: manualFlush}, nil
+}
+
+// next is the first time the report is due after now.
+func (rp *reporter) next(now time.Time) time.Time {
+	t := time.Date(now.Year(), now.Month(), now.Day(), rp.hour, rp.minute, 0, 0, now.Location())
+	if !t.After(now) {
+		t = time.Date(now.Year(), now.Month(), now.Day()+1, rp.hour, rp.minute, 0, 0, now.Location())
+	}
+	return t
+}
+
+// Report is what an email report renders, the numbers of one site and day.
+type Report struct {
+	Site      string
+	Stats     DashboardData
+	Pages     []TopPage
+	Referrers []ReferrerCount
+}
+
+// ReferrerCount is how many page views came from links on another host.
+type ReferrerCount struct {
+	Host  string
+	Views int
+}
+
+// scheduleReports sends the report every day at its SendAt. It first writes
+// buffered data, unless ManualFlush is set, so yesterday's last actions are
+// included. It runs on its own so a slow or failing mail server doesn't hold
+// up recording or writing.
+func (a analytics) scheduleReports() {
+	go func() {
+		for {
+			now := a.now()
+			timer := time.NewTimer(a.report.next(now).Sub(now))
+			select {
+			case <-timer.C:
+			case <-a.quit:
+				timer.Stop()
+				return
+			}
+			if !a.report.manualFlush {
+				if err := a.Flush(); err != nil {
+					a.log.Error("flushing before the report: %v", err)
+				}
+			}
+			if err := a.ReportNow(); err != nil {
+				a.log.Error("sending report: %v", err)
+			}
+		}
+	}()
+}
+
+// ReportNow sends yesterday's report of every site now, e.g. to try out the
+// Report configuration. It returns the first error, logging those of the
+// sites sent after it.
+func (a analytics) ReportNow() error {
+	if a.report == nil {
+		return errors.New("no Report configured")
+	}
+	names := a.siteNames()
+	if len(names) == 0 {
+		names = []string{a.defaultSite}
+	}
+	yesterday := a.now().AddDate(0, 0, -1)
+	var first error
+	for _, name := range names {
+		err := a.sites[name].sendReport(yesterday)
+		if err != nil && first == nil {
+			first = err
+		} else if err != nil {
+			a.log.Error("sending report: %v", err)
+		}
+	}
+	return first
+}
+
+func (a analytics) sendReport(date time.Time) error {
+	dd, err := a.Stats(date)
+	if err != nil {
+		return err
+	}
+	report := Report{Site: a.Name, Stats: dd, Pages: topPages(dd, reportTop), Referrers: a.topReferrers(date, reportTop)}
+	var body bytes.Buffer
+	if err := a.builtin.ExecuteTemplate(&body, "report", report); err != nil {
+		return fmt.Errorf("rendering report of %s: %w", a.Name, err)
+	}
+	subject := fmt.Sprintf("%s: %d visitors on %s", a.Name, dd.SessionCount, dd.Date)
+	msg, err := reportMessage(a.report.config, subject, a.now(), body.Bytes())
+	if err != nil {
+		return err
+	}
+
+	rc := a.report.config
+	var auth smtp.Auth
+	if len(rc.Username) > 0 {
+		auth = smtp.PlainAuth("", rc.Username, rc.Password, rc.SMTPHost)
+	}
+	from, _ := mail.ParseAddress(rc.From)
+	to := make([]string, len(rc.To))
+	for i, t := range rc.To {
+		addr, _ := mail.ParseAddress(t)
+		to[i] = addr.Address
+	}
+	addr := net.JoinHostPort(rc.SMTPHost, strconv.Itoa(rc.SMTPPort))
+	if err := smtp.SendMail(addr, auth, from.Address, to, msg); err != nil {
+		return fmt.Errorf("mailing report of %s: %w", a.Name, err)
+	}
+	return nil
+}
+
+// reportMessage builds the email with an HTML body.
+func reportMessage(rc ReportConfig, subject string, date time.Time, html []byte) ([]byte, error) {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", rc.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(rc.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/html; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	qp := quotedprintable.NewWriter(&msg)
+	if _, err := qp.Write(html); err != nil {
+		return nil, err
+	}
+	if err := qp.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+// topReferrers counts the page views of date by the host of their referrer,
+// leaving out links within the site itself.
+func (a analytics) topReferrers(date time.Time, n int) []ReferrerCount {
+	counts := map[string]int{}
+	for _, actions := range a.loadDay(date) {
+		for _, act := range actions {
+			if len(act.Referrer) == 0 {
+				continue
+			}
+			u, err := url.Parse(act.Referrer)
+			if err != nil || len(u.Host) == 0 || strings.EqualFold(u.Hostname(), a.Name) {
+				continue
+			}
+			counts[strings.ToLower(u.Hostname())]++
+		}
+	}
+	referrers := make([]ReferrerCount, 0, len(counts))
+	for host, views := range counts {
+		referrers = append(referrers, ReferrerCount{Host: host, Views: views})
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		if referrers[i].Views != referrers[j].Views {
+			return referrers[i].Views > referrers[j].Views
+		}
+		return referrers[i].Host < referrers[j].Host
+	})
+	if len(referrers) > n {
+		referrers = referrers[:n]
+	}
+	return referrers
+}
@@ -0,0 +1,94 @@
+package analytics
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxReturningLookback caps ReturningLookbackDays, each day of which is
+// loaded for every day shown.
+const maxReturningLookback = 90
+
+// Returning splits the visitors of a day into those new to the site and
+// those also seen in the Days before it, see ReturningLookbackDays. When the
+// visitor keys of different days can't be matched Unavailable says why and
+// the counts are zero.
+type Returning struct {
+	Days        int     `json:"days"`
+	New         int     `json:"new"`
+	Returning   int     `json:"returning"`
+	Percent     float64 `json:"percent"`
+	Unavailable string  `json:"unavailable,omitempty"`
+}
+
+// keyProbe is the IP unstableKeys hashes on two days.
+const keyProbe = "192.0.2.1"
+
+// unstableKeys tells why a visitor isn't stored under the same key every
+// day, "" if they are. Plain and truncated IPs are, so is a HashFunc
+// ignoring the date, which is found out by hashing the same IP on two days.
+func (a analytics) unstableKeys() string {
+	if a.hash == nil {
+		return ""
+	}
+	if a.salts != nil {
+		return "visitor keys are hashed with a new secret every day (RotatingSecret)"
+	}
+	if a.hash("2006-01-02", keyProbe, a.HashIPSecret) != a.hash("2006-01-03", keyProbe, a.HashIPSecret) {
+		return fmt.Sprintf("visitor keys are hashed with the date (%s), so a visitor has a new one every day", a.hashScheme)
+	}
+	return ""
+}
+
+// returning classifies the visitors of date by whether they were seen in the
+// ReturningLookbackDays before it. Anonymized days have no visitors left and
+// count as not visited.
+func (a analytics) returning(date time.Time) *Returning {
+	if a.returningDays == 0 {
+		return nil
+	}
+	r := &Returning{Days: a.returningDays}
+	if reason := a.unstableKeys(); len(reason) > 0 {
+		r.Unavailable = reason
+		return r
+	}
+	unseen := map[string]bool{}
+	for key := range a.loadDay(date) {
+		unseen[key] = true
+	}
+	total := len(unseen)
+	for i := 1; i <= a.returningDays && len(unseen) > 0; i++ {
+		d := date.AddDate(0, 0, -i)
+		if scheme := a.readSummary(d).Scheme; !a.sharesScheme(scheme) {
+			return &Returning{Days: a.returningDays, Unavailable: fmt.Sprintf("visitors of %s were keyed with %s, not %s", a.dayKey(d), scheme, a.hashScheme)}
+		}
+		data := a.loadDay(d)
+		for key := range unseen {
+			if _, ok := data[key]; ok {
+				delete(unseen, key)
+			}
+		}
+	}
+	r.New = len(unseen)
+	r.Returning = total - r.New
+	if total > 0 {
+		r.Percent = float64(r.Returning) * 100 / float64(total)
+	}
+	return r
+}
+
+// sharesScheme tells whether some visitors of a day recorded with scheme
+// were keyed like ours. Days written before schemes were recorded are
+// assumed to be.
+func (a analytics) sharesScheme(scheme string) bool {
+	if len(scheme) == 0 {
+		return true
+	}
+	for _, s := range strings.Split(scheme, mixedSchemes) {
+		if s == a.hashScheme {
+			return true
+		}
+	}
+	return false
+}
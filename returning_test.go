@@ -0,0 +1,102 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReturningVisitors(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	dir := recordedDay(t, AnalyticsConfiguration{}, day)
+	for _, tc := range []struct {
+		lookback, returning, new int
+	}{
+		{7, 1, 1},
+		{3, 1, 1},
+		{2, 0, 2},
+	} {
+		config := AnalyticsConfiguration{Directory: dir, ReturningLookbackDays: tc.lookback}
+		a := newTestAnalytics(t, config, WithClock(&movingClock{t: day.AddDate(0, 0, 3)}))
+		a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+		a.InsertRequest(visit("192.0.2.3:4000", "/a"))
+		dd, err := a.Stats(a.now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := dd.Returning
+		if r == nil || r.Unavailable != "" || r.Returning != tc.returning || r.New != tc.new || r.Days != tc.lookback {
+			t.Errorf("looking back %d days got %+v, want %d returning and %d new", tc.lookback, r, tc.returning, tc.new)
+		}
+		if want := float64(tc.returning) * 50; r != nil && r.Percent != want {
+			t.Errorf("looking back %d days got %.1f%% returning, want %.1f%%", tc.lookback, r.Percent, want)
+		}
+		if rng, _ := a.StatsRange(day, a.now()); rng.Returning != nil {
+			t.Errorf("a range got %+v", rng.Returning)
+		}
+	}
+
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	if dd, _ := a.Stats(a.now()); dd.Returning != nil {
+		t.Errorf("got %+v without ReturningLookbackDays", dd.Returning)
+	}
+}
+
+func TestReturningNeedsStableKeys(t *testing.T) {
+	ignoreDate := func(date, ip, secret string) string { return SHA256Hash("", ip, secret) }
+	for _, tc := range []struct {
+		config AnalyticsConfiguration
+		stable bool
+	}{
+		{AnalyticsConfiguration{IPAnonymization: AnonymizeTruncate}, true},
+		{AnalyticsConfiguration{HashIPSecret: "secret", HashFunc: ignoreDate, HashScheme: "stable"}, true},
+		{AnalyticsConfiguration{HashIPSecret: "secret"}, false},
+		{AnalyticsConfiguration{HashIPSecret: "secret", HashFunc: HMACHash, HashScheme: SchemeHMAC}, false},
+		{AnalyticsConfiguration{HashIPSecret: "secret", HashFunc: ignoreDate, HashScheme: "stable", RotatingSecret: true}, false},
+	} {
+		tc.config.ReturningLookbackDays = 7
+		a := newTestAnalytics(t, tc.config)
+		a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+		dd, err := a.Stats(a.now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r := dd.Returning; r == nil || (r.Unavailable == "") != tc.stable {
+			t.Errorf("%s got %+v, want it available: %v", a.hashScheme, r, tc.stable)
+		}
+		if tc.stable {
+			continue
+		}
+		rec := httptest.NewRecorder()
+		a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		if body := rec.Body.String(); !strings.Contains(body, "Returning visitors: n/a, "+dd.Returning.Unavailable) {
+			t.Errorf("%s: the dashboard doesn't explain why returning visitors aren't shown", a.hashScheme)
+		}
+	}
+}
+
+func TestReturningOtherSchemes(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	plain := func(date, ip, secret string) string { return ip }
+	dir := recordedDay(t, AnalyticsConfiguration{HashIPSecret: "secret", HashFunc: plain, HashScheme: "other"}, day)
+	a := newTestAnalytics(t, AnalyticsConfiguration{Directory: dir, ReturningLookbackDays: 7}, WithClock(&movingClock{t: day.AddDate(0, 0, 1)}))
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	dd, err := a.Stats(a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := dd.Returning; r == nil || !strings.Contains(r.Unavailable, "other") || r.Returning != 0 {
+		t.Errorf("got %+v, want it unavailable as %s was keyed with another scheme", r, a.dayKey(day))
+	}
+}
+
+func TestReturningConfig(t *testing.T) {
+	for _, days := range []int{-1, maxReturningLookback + 1} {
+		config := AnalyticsConfiguration{Name: "test", Directory: t.TempDir(), ReturningLookbackDays: days}
+		if _, err := NewAnalyticsWithError(config, discard); err == nil {
+			t.Errorf("ReturningLookbackDays %d was accepted", days)
+		}
+	}
+}
@@ -0,0 +1,359 @@
+package analytics
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// anonymizeInterval is how often the days past AnonymizeAfterDays are
+// looked for.
+const anonymizeInterval = time.Hour
+
+// detailExts are the extensions of a day's files keyed by visitor: the day
+// file, its bots and its dropped counts.
+var detailExts = []string{"", ".bots", ".dropped"}
+
+// anonymizedDay is all that's kept of a day once it's older than
+// AnonymizeAfterDays: its totals, with nothing about single visitors. URLs
+// are grouped as they were when the day was anonymized, Goals and Funnel
+// counted with the steps configured then.
+type anonymizedDay struct {
+	Sessions  int
+	PageViews int
+	Bytes     int64
+	Truncated int `json:",omitempty"`
+	Dropped   int `json:",omitempty"`
+	Hours     [24]int
+	URLs      map[string]map[string]URLStats
+	Clicks    []LinkClicks `json:",omitempty"`
+	Goals     []int        `json:",omitempty"`
+	Funnel    []int        `json:",omitempty"`
+}
+
+func (a analytics) anonymizedFileName(date time.Time) string {
+	return a.dayFileName(date) + ".aggregate"
+}
+
+// AnonymizeNow rewrites the days of every site older than AnonymizeAfterDays
+// into aggregates, deleting their visitors, and returns how many days were
+// rewritten. It runs every hour unless ManualFlush is set. Days that are
+// anonymized already are skipped, so running it again does nothing.
+func (a analytics) AnonymizeNow() (int, error) {
+	if a.anonymizeAfter == 0 {
+		return 0, errors.New("AnonymizeAfterDays isn't set")
+	}
+	if a.memoryOnly {
+		return 0, errors.New("nothing to anonymize with DisablePersistence")
+	}
+	n := 0
+	for _, s := range a.sites {
+		m, err := s.anonymizeOldDays()
+		n += m
+		if err != nil {
+			return n, fmt.Errorf("anonymizing %s: %w", s.Name, err)
+		}
+	}
+	return n, nil
+}
+
+// scheduleAnonymization runs AnonymizeNow now and every anonymizeInterval.
+func (a analytics) scheduleAnonymization() {
+	ticker := time.NewTicker(anonymizeInterval)
+	go func() {
+		defer ticker.Stop()
+		for {
+			if n, err := a.AnonymizeNow(); err != nil {
+				a.log.Error("%v", err)
+			} else if n > 0 {
+				a.log.Info("anonymized %d days", n)
+			}
+			select {
+			case <-ticker.C:
+			case <-a.quit:
+				return
+			}
+		}
+	}()
+}
+
+func (a analytics) anonymizeOldDays() (int, error) {
+	today, err := a.parseDay(a.today())
+	if err != nil {
+		return 0, err
+	}
+	cutoff := a.dayKey(today.AddDate(0, 0, -a.anonymizeAfter))
+	a.writeMux.Lock()
+	if err := a.evictOldDays(cutoff); err != nil {
+		a.writeMux.Unlock()
+		return 0, err
+	}
+	days, err := a.detailedDays(cutoff)
+	if err != nil {
+		a.writeMux.Unlock()
+		return 0, err
+	}
+	n := 0
+	removed := map[string]map[string]bool{}
+	for _, d := range days {
+		ok, bundled, err := a.anonymizeDay(d)
+		if err != nil {
+			a.writeMux.Unlock()
+			return n, fmt.Errorf("anonymizing %s: %w", a.dayKey(d), err)
+		}
+		if !ok {
+			continue
+		}
+		n++
+		if len(bundled) > 0 {
+			month := d.Format("2006-01")
+			if removed[month] == nil {
+				removed[month] = map[string]bool{}
+			}
+			for _, name := range bundled {
+				removed[month][name] = true
+			}
+		}
+	}
+	a.writeMux.Unlock()
+	// The visitors of archived days are left in their bundle until it's
+	// written again without them.
+	months := make([]string, 0, len(removed))
+	for month := range removed {
+		months = append(months, month)
+	}
+	sort.Strings(months)
+	for _, month := range months {
+		m, _ := time.ParseInLocation("2006-01", month, a.location)
+		if err := a.archive(m, removed[month]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// evictOldDays writes the days in memory before cutoff and drops them, so
+// they're anonymized from their files. Days recorded since the start stay in
+// memory otherwise, and nothing is recorded for days this old anymore. The
+// caller holds writeMux.
+func (a analytics) evictOldDays(cutoff string) error {
+	a.Mux.RLock()
+	var old []string
+	for ts := range a.openDays {
+		if ts < cutoff {
+			old = append(old, ts)
+		}
+	}
+	a.Mux.RUnlock()
+	for _, ts := range old {
+		if err := a.writeDays(ts); err != nil {
+			return err
+		}
+		a.Mux.Lock()
+		a.evictDays(ts)
+		a.Mux.Unlock()
+	}
+	return nil
+}
+
+// anonymizeDay writes the aggregate of date, unless it's there already from
+// an earlier run, and only then deletes the day's files keyed by visitor, so
+// the day is never lost or read half rewritten. It returns the names of the
+// files left in the month's bundle. Days in memory are skipped. The caller
+// holds writeMux.
+func (a analytics) anonymizeDay(date time.Time) (bool, []string, error) {
+	if a.isOpen(a.dayKey(date)) {
+		return false, nil, nil
+	}
+	if err := os.MkdirAll(a.dayDir(date), os.ModePerm); err != nil {
+		return false, nil, err
+	}
+	if !dayFileExists(a.anonymizedFileName(date)) {
+		// The summary keeps the numbers that aren't in the aggregate,
+		// like the sketch ranges estimate unique visitors with.
+		s := a.ownSummary(date)
+		f := a.newDayFold(date, a.readDropped(date))
+		if err := a.streamDayFile(a.dayFileName(date), f.add); err != nil && !os.IsNotExist(err) {
+			return false, nil, err
+		}
+		bs, err := json.Marshal(newAnonymizedDay(f.done(nil)))
+		if err != nil {
+			return false, nil, err
+		}
+		if err := a.writeSummary(date, s); err != nil {
+			return false, nil, err
+		}
+		if err := writeFile(a.anonymizedFileName(date), bs); err != nil {
+			return false, nil, err
+		}
+	}
+	var bundled []string
+	for _, ext := range detailExts {
+		fileName := a.dayFileName(date) + ext
+		if err := os.Remove(fileName); err != nil && !os.IsNotExist(err) {
+			return false, nil, err
+		}
+		if dayFileExists(fileName) {
+			_, name, _ := bundleEntry(fileName)
+			bundled = append(bundled, name)
+		}
+	}
+	a.invalidateDay(date)
+	return true, bundled, nil
+}
+
+func newAnonymizedDay(ag *aggregate) anonymizedDay {
+	d := anonymizedDay{
+		Sessions:  ag.sessions,
+		PageViews: ag.views,
+		Bytes:     ag.bytes,
+		Truncated: ag.truncated,
+		Dropped:   ag.dropped,
+		Hours:     ag.hours,
+		URLs:      make(map[string]map[string]URLStats, len(ag.urlHits)),
+		Goals:     ag.goals,
+		Funnel:    ag.funnel,
+	}
+	for group, urls := range ag.urlHits {
+		d.URLs[group] = make(map[string]URLStats, len(urls))
+		for u, stats := range urls {
+			d.URLs[group][u] = stats.URLStats
+		}
+	}
+	for l, n := range ag.clicks {
+		d.Clicks = append(d.Clicks, LinkClicks{Link: l, Clicks: n})
+	}
+	sort.Slice(d.Clicks, func(i, j int) bool {
+		if d.Clicks[i].Event != d.Clicks[j].Event {
+			return d.Clicks[i].Event < d.Clicks[j].Event
+		}
+		return d.Clicks[i].URL < d.Clicks[j].URL
+	})
+	return d
+}
+
+// anonymizedAggregate reads the aggregate of an anonymized day, reporting
+// whether date is one. Its visitors are gone, so it lists none.
+func (a analytics) anonymizedAggregate(date time.Time) (*aggregate, bool) {
+	bs, err := readDayFileBytes(a.anonymizedFileName(date))
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+	var d anonymizedDay
+	if err == nil {
+		err = json.Unmarshal(bs, &d)
+	}
+	if err != nil {
+		a.log.Error("reading anonymized day: %v", err)
+	}
+	ag := newAggregate()
+	ag.sessions = d.Sessions
+	ag.views = d.PageViews
+	ag.bytes = d.Bytes
+	ag.truncated = d.Truncated
+	ag.dropped = d.Dropped
+	ag.hours = d.Hours
+	for group, urls := range d.URLs {
+		ag.urlHits[group] = make(map[string]*urlCounter, len(urls))
+		for u, stats := range urls {
+			ag.urlHits[group][u] = &urlCounter{URLStats: stats}
+		}
+	}
+	for _, c := range d.Clicks {
+		ag.clicks[c.Link] += c.Clicks
+	}
+	// Goals and funnel steps configured since are counted as not reached.
+	ag.goals = make([]int, len(a.goals))
+	copy(ag.goals, d.Goals)
+	ag.funnel = make([]int, len(a.funnel))
+	copy(ag.funnel, d.Funnel)
+	ag.days = []DaySessions{{Date: a.dayKey(date), Sessions: d.Sessions}}
+	ag.anonymized = []string{a.dayKey(date)}
+	ag.sketch = a.storedSketch(date)
+	return ag, true
+}
+
+// isAnonymized tells whether the visitors of date were deleted by
+// AnonymizeAfterDays.
+func (a analytics) isAnonymized(date time.Time) bool {
+	return !a.memoryOnly && dayFileExists(a.anonymizedFileName(date))
+}
+
+// detailedDays returns the days of the site before cutoff that still have
+// files keyed by visitor, loose or archived, in order.
+func (a analytics) detailedDays(cutoff string) ([]time.Time, error) {
+	found := map[string]bool{}
+	note := func(name string) {
+		if ts, ok := a.detailDay(name); ok && ts < cutoff {
+			found[ts] = true
+		}
+	}
+	err := filepath.Walk(a.Directory, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name := info.Name()
+		if filepath.Ext(name) != ".zip" {
+			note(name)
+			return nil
+		}
+		month := strings.TrimSuffix(strings.TrimPrefix(name, a.Name), ".zip")
+		if !strings.HasPrefix(name, a.Name) || len(month) != len("2006-01") {
+			return nil
+		}
+		if _, err := time.Parse("2006-01", month); err != nil {
+			return nil
+		}
+		zr, err := zip.OpenReader(p)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", p, err)
+		}
+		defer zr.Close()
+		for _, zf := range zr.File {
+			note(path.Base(zf.Name))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	days := make([]time.Time, 0, len(found))
+	for ts := range found {
+		d, err := a.parseDay(ts)
+		if err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// detailDay returns the day a file of the site keyed by visitor belongs to,
+// by its name.
+func (a analytics) detailDay(name string) (string, bool) {
+	if !strings.HasPrefix(name, a.Name) {
+		return "", false
+	}
+	rest := name[len(a.Name):]
+	for _, ext := range detailExts {
+		ts := strings.TrimSuffix(rest, ext)
+		if len(ts)+len(ext) != len(rest) || len(ts) != len(dayLayout) {
+			continue
+		}
+		if _, err := time.Parse(dayLayout, ts); err == nil {
+			return ts, true
+		}
+	}
+	return "", false
+}
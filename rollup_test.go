@@ -0,0 +1,192 @@
+package analytics
+
+import (
+	"archive/zip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAnonymizeOldDays(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	config := AnalyticsConfiguration{TrackBots: true}
+	dir := recordedDay(t, config, day)
+	config.Directory = dir
+	config.AnonymizeAfterDays = 7
+	clock := &movingClock{t: day.AddDate(0, 0, 7)}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	if err := os.WriteFile(a.botFileName(day), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	before, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, to := day.AddDate(0, 0, -1), day.AddDate(0, 0, 1)
+	beforeRange, err := a.StatsRange(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := a.AnonymizeNow(); err != nil || n != 0 {
+		t.Fatalf("anonymized %d days (%v) %d days later, want none", n, err, a.anonymizeAfter)
+	}
+	clock.set(day.AddDate(0, 0, 8))
+	if n, err := a.AnonymizeNow(); err != nil || n != 1 {
+		t.Fatalf("anonymized %d days (%v), want 1", n, err)
+	}
+	for _, name := range []string{a.dayFileName(day), a.botFileName(day), a.droppedFileName(day)} {
+		if dayFileExists(name) {
+			t.Errorf("%s is left", name)
+		}
+	}
+
+	after, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.SessionCount != before.SessionCount || after.PageViews != before.PageViews || after.Bytes != before.Bytes {
+		t.Errorf("got %d sessions, %d views and %d bytes, want %d, %d and %d", after.SessionCount, after.PageViews, after.Bytes, before.SessionCount, before.PageViews, before.Bytes)
+	}
+	if !reflect.DeepEqual(after.URLCounts(), before.URLCounts()) {
+		t.Errorf("got URLs %v, want %v", after.URLCounts(), before.URLCounts())
+	}
+	if !reflect.DeepEqual(after.Hours, before.Hours) {
+		t.Errorf("got hours %v, want %v", after.Hours, before.Hours)
+	}
+	if len(after.Visitors) != 0 {
+		t.Errorf("listed visitors %v", after.Visitors)
+	}
+	if want := []string{a.dayKey(day)}; !reflect.DeepEqual(after.AnonymizedDays, want) {
+		t.Errorf("got anonymized days %v, want %v", after.AnonymizedDays, want)
+	}
+
+	afterRange, err := a.StatsRange(from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if afterRange.SessionCount != beforeRange.SessionCount || afterRange.UniqueVisitors != beforeRange.UniqueVisitors {
+		t.Errorf("range has %d sessions and %d unique visitors, want %d and %d", afterRange.SessionCount, afterRange.UniqueVisitors, beforeRange.SessionCount, beforeRange.UniqueVisitors)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?visitor=192.0.2.1:4000&date="+a.dayKey(day), nil))
+	if rec.Code != http.StatusGone {
+		t.Errorf("visitor detail answered %d, want %d", rec.Code, http.StatusGone)
+	}
+
+	if n, err := a.AnonymizeNow(); err != nil || n != 0 {
+		t.Errorf("anonymizing again rewrote %d days (%v), want none", n, err)
+	}
+}
+
+// TestAnonymizeRecordedDay anonymizes a day the same instance recorded and
+// still holds in memory, without it being flushed first.
+func TestAnonymizeRecordedDay(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	a := newTestAnalytics(t, AnalyticsConfiguration{AnonymizeAfterDays: 1}, WithClock(clock))
+	a.InsertRequest(visit("192.0.2.1:4000", "/"))
+	a.InsertRequest(visit("192.0.2.1:4000", "/about"))
+	a.InsertRequest(visit("192.0.2.2:4000", "/"))
+	clock.set(day.AddDate(0, 0, 2))
+	if n, err := a.AnonymizeNow(); err != nil || n != 1 {
+		t.Fatalf("anonymized %d days (%v), want 1", n, err)
+	}
+	if a.isOpen(a.dayKey(day)) {
+		t.Error("the day is still in memory")
+	}
+	if dayFileExists(a.dayFileName(day)) {
+		t.Error("the day file is left")
+	}
+	dd, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd.SessionCount != 2 || dd.PageViews != 3 || len(dd.Visitors) != 0 {
+		t.Errorf("got %d sessions, %d views and %d visitors, want 2, 3 and none", dd.SessionCount, dd.PageViews, len(dd.Visitors))
+	}
+}
+
+// TestAnonymizeResumes finishes a day whose aggregate was written but whose
+// day file wasn't deleted yet, reading the aggregate rather than writing it
+// again.
+func TestAnonymizeResumes(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	config := AnalyticsConfiguration{Directory: recordedDay(t, AnalyticsConfiguration{}, day), AnonymizeAfterDays: 1}
+	a := newTestAnalytics(t, config, WithClock(&movingClock{t: day.AddDate(0, 0, 2)}))
+	saved, err := os.ReadFile(a.dayFileName(day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := a.AnonymizeNow(); err != nil || n != 1 {
+		t.Fatalf("anonymized %d days (%v), want 1", n, err)
+	}
+	aggregate, err := os.ReadFile(a.anonymizedFileName(day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(a.dayFileName(day), saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := a.AnonymizeNow(); err != nil || n != 1 {
+		t.Fatalf("resuming anonymized %d days (%v), want 1", n, err)
+	}
+	if dayFileExists(a.dayFileName(day)) {
+		t.Error("the day file is left")
+	}
+	if again, _ := os.ReadFile(a.anonymizedFileName(day)); string(again) != string(aggregate) {
+		t.Errorf("the aggregate was rewritten: %s, was %s", again, aggregate)
+	}
+}
+
+func TestAnonymizeArchivedDay(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	config := AnalyticsConfiguration{Directory: recordedDay(t, AnalyticsConfiguration{}, day), AnonymizeAfterDays: 30}
+	a := newTestAnalytics(t, config, WithClock(&movingClock{t: day.AddDate(0, 2, 0)}))
+	if err := a.Archive(day); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := a.AnonymizeNow(); err != nil || n != 1 {
+		t.Fatalf("anonymized %d days (%v), want 1", n, err)
+	}
+	zr, err := zip.OpenReader(a.bundleFileName(day))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	names := map[string]bool{}
+	for _, f := range zr.File {
+		names[f.Name] = true
+	}
+	base := "15/" + a.Name + a.dayKey(day)
+	if names[base] || !names[base+".aggregate"] || !names[base+".summary"] {
+		t.Errorf("bundle holds %v, want the aggregate and summary but not the day file", names)
+	}
+	if _, err := os.Stat(a.dayDir(day)); !os.IsNotExist(err) {
+		t.Errorf("the day's directory is left: %v", err)
+	}
+	if dd, _ := a.Stats(day); dd.SessionCount != 3 || dd.PageViews != 3 || len(dd.Visitors) != 0 {
+		t.Errorf("got %d sessions, %d views and %d visitors, want 3, 3 and none", dd.SessionCount, dd.PageViews, len(dd.Visitors))
+	}
+}
+
+func TestAnonymizeConfig(t *testing.T) {
+	for _, config := range []AnalyticsConfiguration{
+		{AnonymizeAfterDays: -1},
+		{AnonymizeAfterDays: 30, AggregateNames: []string{"peer"}},
+	} {
+		config.Name = "test"
+		config.Directory = t.TempDir()
+		if _, err := NewAnalyticsWithError(config, discard); err == nil {
+			t.Errorf("%+v was accepted", config)
+		}
+	}
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	if _, err := a.AnonymizeNow(); err == nil {
+		t.Error("anonymized without AnonymizeAfterDays")
+	}
+}
@@ -0,0 +1,202 @@
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultRetentionDays is how many days the salts of RotatingSecret are kept
+// unless RetentionDays says otherwise.
+const defaultRetentionDays = 30
+
+// rotatingScheme is appended to the scheme of keys hashed with day salts.
+const rotatingScheme = "/rotating"
+
+// saltBytes is the size of a day's random salt.
+const saltBytes = 32
+
+// daySalts holds the random salt of each day with RotatingSecret. Salts are
+// written to dir, shared by every site, and deleted once their day is more
+// than retention days old, after which nobody can derive the day's secret,
+// not even with HashIPSecret. Without dir they're only kept in memory.
+type daySalts struct {
+	mu        sync.Mutex
+	dir       string
+	retention int
+	secrets   map[string]string
+}
+
+func newDaySalts(directory string, memoryOnly bool, retention int) *daySalts {
+	s := &daySalts{retention: retention, secrets: map[string]string{}}
+	if s.retention == 0 {
+		s.retention = defaultRetentionDays
+	}
+	if !memoryOnly {
+		s.dir = filepath.Join(directory, "salts")
+	}
+	return s
+}
+
+func (s *daySalts) fileName(ts string) string {
+	return filepath.Join(s.dir, ts+".salt")
+}
+
+// secret returns the secret the keys of day ts are hashed with, the HKDF of
+// base and the day's salt. With create a salt is made for a day that has
+// none, and the salts that are too old by then are deleted; if it can't be
+// written the error is returned along with a secret from a salt only kept in
+// memory, so keys are never derived from base alone. Without create ok is
+// false if the day has no salt.
+func (s *daySalts) secret(ts, base string, create bool) (secret string, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if secret, ok := s.secrets[ts]; ok {
+		return secret, true, nil
+	}
+	salt, err := s.read(ts)
+	if err != nil && !create {
+		return "", false, nil
+	}
+	if err != nil {
+		salt = make([]byte, saltBytes)
+		if _, rerr := rand.Read(salt); rerr != nil {
+			return "", false, fmt.Errorf("creating the salt of %s: %w", ts, rerr)
+		}
+		if os.IsNotExist(err) {
+			salt, err = s.write(ts, salt)
+		}
+		if perr := s.prune(ts); err == nil {
+			err = perr
+		}
+	}
+	secret = hex.EncodeToString(hkdf([]byte(base), salt, "go-web-analytics visitor keys "+ts))
+	s.secrets[ts] = secret
+	return secret, true, err
+}
+
+func (s *daySalts) read(ts string) ([]byte, error) {
+	if len(s.dir) == 0 {
+		return nil, os.ErrNotExist
+	}
+	salt, err := ioutil.ReadFile(s.fileName(ts))
+	if err != nil {
+		return nil, err
+	}
+	if len(salt) != saltBytes {
+		return nil, fmt.Errorf("the salt of %s has %d bytes, not %d", ts, len(salt), saltBytes)
+	}
+	return salt, nil
+}
+
+// write saves salt unless another instance sharing the directory saved one
+// for the day first, returning the one saved. The salt is linked into place
+// so it's only ever read whole.
+func (s *daySalts) write(ts string, salt []byte) ([]byte, error) {
+	if len(s.dir) == 0 {
+		return salt, nil
+	}
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return salt, err
+	}
+	f, err := ioutil.TempFile(s.dir, ts+".*.tmp")
+	if err != nil {
+		return salt, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(salt)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return salt, err
+	}
+	if err := os.Link(f.Name(), s.fileName(ts)); os.IsExist(err) {
+		return s.read(ts)
+	} else if err != nil {
+		return salt, err
+	}
+	return salt, nil
+}
+
+// prune deletes the salts of the days more than retention days before
+// today, a day key, from disk and memory. The caller holds mu.
+func (s *daySalts) prune(today string) error {
+	t, err := time.Parse(dayLayout, today)
+	if err != nil {
+		return err
+	}
+	cutoff := t.AddDate(0, 0, -s.retention).Format(dayLayout)
+	for ts := range s.secrets {
+		if ts < cutoff {
+			delete(s.secrets, ts)
+		}
+	}
+	if len(s.dir) == 0 {
+		return nil
+	}
+	infos, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		ts := strings.TrimSuffix(info.Name(), ".salt")
+		if len(ts) == len(dayLayout) && ts < cutoff {
+			if err := os.Remove(filepath.Join(s.dir, info.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hkdf is the first 32 bytes of the HKDF-SHA256 (RFC 5869) of secret with
+// salt and info.
+func hkdf(secret, salt []byte, info string) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte(info))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)
+}
+
+// daySecret is the secret the keys of day ts are hashed with, HashIPSecret
+// or, with RotatingSecret, the one derived from it and the day's salt. With
+// create a salt is made for a day without one, otherwise ok is false then.
+func (a analytics) daySecret(ts string, create bool) (string, bool) {
+	if a.salts == nil {
+		return a.HashIPSecret, true
+	}
+	secret, ok, err := a.salts.secret(ts, a.HashIPSecret, create)
+	if err != nil {
+		a.log.Error("salting visitor keys: %v", err)
+	}
+	return secret, ok
+}
+
+// pruneSalts deletes the salts that are too old, for days that pass
+// without a new salt being made.
+func (a analytics) pruneSalts() {
+	if a.salts == nil {
+		return
+	}
+	a.salts.mu.Lock()
+	defer a.salts.mu.Unlock()
+	if err := a.salts.prune(a.today()); err != nil {
+		a.log.Error("deleting old salts: %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package analytics
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHKDF checks the first block of RFC 5869's first test case.
+func TestHKDF(t *testing.T) {
+	ikm := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+	if got := hex.EncodeToString(hkdf(ikm, salt, string(info))); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestRotatingKeys(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	config := AnalyticsConfiguration{HashIPSecret: "secret", RotatingSecret: true, Directory: t.TempDir()}
+	a := newTestAnalytics(t, config, WithClock(&movingClock{t: day}))
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	ts := a.dayKey(day)
+	if views(a)[SHA256Hash(ts, "192.0.2.1:4000", "secret")] != nil {
+		t.Fatal("the key was hashed with HashIPSecret alone")
+	}
+	if len(views(a)) != 1 {
+		t.Fatalf("got %d visitors, want 1", len(views(a)))
+	}
+	if _, err := os.Stat(filepath.Join(config.Directory, "salts", ts+".salt")); err != nil {
+		t.Errorf("the salt wasn't saved: %v", err)
+	}
+	if a.hashScheme != SchemeSHA256+rotatingScheme {
+		t.Errorf("the scheme is %q", a.hashScheme)
+	}
+
+	// Another instance of the directory, or this one after a restart, keys
+	// the visitor alike.
+	b := newTestAnalytics(t, config, WithClock(&movingClock{t: day}))
+	if got, want := b.visitorKey(ts, "192.0.2.1:4000"), a.visitorKey(ts, "192.0.2.1:4000"); got != want {
+		t.Errorf("keyed %s, then %s", want, got)
+	}
+	// And differently with another secret.
+	config.HashIPSecret = "other"
+	c := newTestAnalytics(t, config, WithClock(&movingClock{t: day}))
+	if c.visitorKey(ts, "192.0.2.1:4000") == a.visitorKey(ts, "192.0.2.1:4000") {
+		t.Error("keys don't depend on HashIPSecret")
+	}
+}
+
+// TestSaltsDeleted checks a day's visitors can't be linked once its salt is
+// deleted, while its numbers stay.
+func TestSaltsDeleted(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{HashIPSecret: "secret", RotatingSecret: true, RetentionDays: 2}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	a.InsertRequest(visit("192.0.2.2:4000", "/a"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	ts := a.dayKey(day)
+	salt := filepath.Join(a.Directory, "salts", ts+".salt")
+
+	clock.set(day.AddDate(0, 0, 2))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(salt); err != nil {
+		t.Fatalf("the salt was deleted within RetentionDays: %v", err)
+	}
+	clock.set(day.AddDate(0, 0, 3))
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	if _, err := os.Stat(salt); !os.IsNotExist(err) {
+		t.Fatalf("the salt is still there: %v", err)
+	}
+	if _, ok := a.daySecret(ts, false); ok {
+		t.Error("the day's secret can still be derived")
+	}
+	if n, err := a.DeleteVisitor("192.0.2.1:4000", day, day); err != nil || n != 0 {
+		t.Errorf("deleted the visitor from %d days (%v), not linkable anymore", n, err)
+	}
+	if dd, err := a.Stats(day); err != nil || dd.SessionCount != 2 || dd.PageViews != 2 {
+		t.Errorf("got %d sessions and %d views (%v), want 2 and 2", dd.SessionCount, dd.PageViews, err)
+	}
+}
+
+func TestRotatingSecretConfig(t *testing.T) {
+	if _, _, err := visitorHash(AnalyticsConfiguration{RotatingSecret: true}); err == nil {
+		t.Error("RotatingSecret without HashIPSecret")
+	}
+	if _, _, err := visitorHash(AnalyticsConfiguration{HashIPSecret: "s", RotatingSecret: true, RetentionDays: -1}); err == nil {
+		t.Error("negative RetentionDays")
+	}
+}
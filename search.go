@@ -0,0 +1,166 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// topSearchTerms caps how many terms the "Search terms" table lists, the CSV
+// export has them all.
+const topSearchTerms = 100
+
+// maxSearchTermLength is how many characters of a search term are counted.
+const maxSearchTermLength = 100
+
+// siteSearchParams are the parameters of SiteSearchPaths holding the terms.
+var siteSearchParams = []string{"q", "query"}
+
+// searchEngines maps a label of the hosts of search engines that still put
+// the terms in their URLs, like google in www.google.co.uk, to the
+// parameter they are in.
+var searchEngines = map[string]string{
+	"google":     "q",
+	"bing":       "q",
+	"duckduckgo": "q",
+	"ecosia":     "q",
+	"qwant":      "q",
+	"ask":        "q",
+	"seznam":     "q",
+	"yahoo":      "p",
+	"yandex":     "text",
+	"baidu":      "wd",
+	"naver":      "query",
+	"startpage":  "query",
+}
+
+// SearchTerm is how often a term was searched for, on the site's own search
+// pages and on search engines referring visitors.
+type SearchTerm struct {
+	Term     string `json:"term"`
+	Count    int    `json:"count"`
+	Site     int    `json:"site"`
+	Referred int    `json:"referred"`
+}
+
+// searchCount counts a term by where it was searched.
+type searchCount struct {
+	site, referred int
+}
+
+// countSearches adds the terms of a page view to counts: those of the q or
+// query parameter on SiteSearchPaths and those of a search engine in its
+// referrer. Parameters in RedactQueryParams are skipped, like redacted values
+// of days stored before they were.
+func (a analytics) countSearches(counts map[string]searchCount, act Action) {
+	rc := a.tuning.load()
+	if len(act.Query) > 0 && a.siteSearch[act.Page] {
+		if values, err := url.ParseQuery(act.Query); err == nil {
+			for _, p := range siteSearchParams {
+				if rc.redacted(p) {
+					continue
+				}
+				for _, v := range values[p] {
+					if term := searchTerm(v); len(term) > 0 {
+						c := counts[term]
+						c.site++
+						counts[term] = c
+					}
+				}
+			}
+		}
+	}
+	if param := searchParam(act.Referrer); len(param) > 0 && !rc.redacted(param) {
+		if u, err := url.Parse(act.Referrer); err == nil {
+			if term := searchTerm(u.Query().Get(param)); len(term) > 0 {
+				c := counts[term]
+				c.referred++
+				counts[term] = c
+			}
+		}
+	}
+}
+
+// searchPaths is the set of SiteSearchPaths.
+func searchPaths(paths []string) map[string]bool {
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	return set
+}
+
+// searchParam returns the parameter holding the terms in the URL of a
+// search engine, "" if referrer isn't one.
+func searchParam(referrer string) string {
+	if !strings.Contains(referrer, "?") {
+		return ""
+	}
+	u, err := url.Parse(referrer)
+	if err != nil {
+		return ""
+	}
+	labels := strings.Split(strings.ToLower(u.Hostname()), ".")
+	// The last label is the top level domain.
+	for _, l := range labels[:len(labels)-1] {
+		if p, ok := searchEngines[l]; ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// searchTerm is how a searched value is counted: lowercased, with runs of
+// spaces collapsed and capped at maxSearchTermLength characters. Redacted
+// values count as none.
+func searchTerm(v string) string {
+	term := strings.ToLower(strings.Join(strings.Fields(strings.ToValidUTF8(v, "")), " "))
+	if term == redactedValue {
+		return ""
+	}
+	if utf8.RuneCountInString(term) > maxSearchTermLength {
+		term = strings.TrimSpace(string([]rune(term)[:maxSearchTermLength]))
+	}
+	return term
+}
+
+// rankSearches orders the terms by count, keeping the top n, all of them if
+// n is 0.
+func rankSearches(counts map[string]searchCount, n int) []SearchTerm {
+	ranked := make([]SearchTerm, 0, len(counts))
+	for term, c := range counts {
+		ranked = append(ranked, SearchTerm{Term: term, Count: c.site + c.referred, Site: c.site, Referred: c.referred})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Count != ranked[j].Count {
+			return ranked[i].Count > ranked[j].Count
+		}
+		return ranked[i].Term < ranked[j].Term
+	})
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+	return ranked
+}
+
+var searchExportHeader = []string{"term", "count", "site", "referred"}
+
+// exportSearches writes every search term of the days from through to as CSV.
+func (a analytics) exportSearches(w io.Writer, from, to time.Time) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(searchExportHeader); err != nil {
+		return err
+	}
+	for _, t := range rankSearches(a.aggregateRange(from, to).searches, 0) {
+		if err := cw.Write([]string{t.Term, strconv.Itoa(t.Count), strconv.Itoa(t.Site), strconv.Itoa(t.Referred)}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
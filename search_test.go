@@ -0,0 +1,98 @@
+package analytics
+
+import (
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// searched records a page view of target by a new visitor, referred by
+// referrer if it isn't empty.
+func searched(a *analytics, i int, target, referrer string) {
+	r := visit("192.0.2."+strconv.Itoa(i)+":4000", target)
+	if len(referrer) > 0 {
+		r.Header.Set("Referer", referrer)
+	}
+	a.InsertRequest(r)
+}
+
+func TestSearchTerms(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	config := AnalyticsConfiguration{Directory: t.TempDir(), SiteSearchPaths: []string{"/search"}, RedactQueryParams: []string{"query"}}
+	a := newTestAnalytics(t, config, WithClock(clock))
+	searched(a, 1, "/search?q=Go++Modules", "")
+	searched(a, 2, "/search?q=go%20modules&page=2", "")
+	searched(a, 3, "/search?query=someone@example.com", "")
+	searched(a, 4, "/docs?q=not+a+search", "")
+	searched(a, 5, "/", "https://www.google.co.uk/search?q=Go+Modules")
+	searched(a, 6, "/", "https://search.yahoo.com/search?p=vendoring")
+	searched(a, 7, "/", "https://yandex.ru/search/?text=%20Vendoring%20")
+	searched(a, 8, "/", "https://example.org/?q=not+an+engine")
+	searched(a, 9, "/", "https://duckduckgo.com/")
+	searched(a, 10, "/search?q="+strings.Repeat("x", 2*maxSearchTermLength), "")
+	want := []SearchTerm{
+		{Term: "go modules", Count: 3, Site: 2, Referred: 1},
+		{Term: "vendoring", Count: 2, Referred: 2},
+		{Term: strings.Repeat("x", maxSearchTermLength), Count: 1, Site: 1},
+	}
+	today, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(today.SearchTerms, want) {
+		t.Errorf("today got %+v, want %+v", today.SearchTerms, want)
+	}
+
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	a = newTestAnalytics(t, config, WithClock(clock))
+	saved, err := a.Stats(day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(saved.SearchTerms, want) {
+		t.Errorf("the saved day got %+v, want %+v", saved.SearchTerms, want)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?date="+a.dayKey(day), nil))
+	if !strings.Contains(rec.Body.String(), "<td class=\"tg-0lax\">go modules</td>") {
+		t.Error("the dashboard doesn't list the search terms")
+	}
+}
+
+func TestSearchTermsExport(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{SiteSearchPaths: []string{"/search"}})
+	for i := 0; i < topSearchTerms+5; i++ {
+		searched(a, 1, "/search?q=term"+strconv.Itoa(i), "")
+	}
+	searched(a, 2, "/search?q=term0", "")
+	dd, err := a.Stats(a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dd.SearchTerms) != topSearchTerms || dd.SearchTerms[0].Term != "term0" || dd.SearchTerms[0].Count != 2 {
+		t.Errorf("listed %d terms starting with %+v, want %d starting with term0 twice", len(dd.SearchTerms), dd.SearchTerms[0], topSearchTerms)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Export(rec, httptest.NewRequest(http.MethodGet, "/?searches=1&date="+a.today(), nil))
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "-searches.csv") {
+		t.Errorf("got Content-Disposition %q", cd)
+	}
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != topSearchTerms+6 || !reflect.DeepEqual(rows[0], searchExportHeader) || !reflect.DeepEqual(rows[1], []string{"term0", "2", "2", "0"}) {
+		t.Errorf("exported %d rows starting with %v, want every term", len(rows), rows[:2])
+	}
+}
@@ -0,0 +1,220 @@
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sessionCookie holds the signed expiry of a dashboard login.
+	sessionCookie = "analytics_session"
+	// defaultSessionSeconds is how long a login lasts when SessionSeconds
+	// isn't set.
+	defaultSessionSeconds = 24 * 60 * 60
+	// maxLoginFailures failed logins from one IP within loginWindow block
+	// further attempts from it until the window has passed.
+	maxLoginFailures = 5
+	loginWindow      = 15 * time.Minute
+)
+
+// sessionSecret is the key session cookies are signed with: SessionKey,
+// HashIPSecret, or a random key, which logs everyone out on restart.
+func sessionSecret(config AnalyticsConfiguration) ([]byte, error) {
+	if len(config.SessionKey) > 0 {
+		return []byte(config.SessionKey), nil
+	}
+	if len(config.HashIPSecret) > 0 {
+		return []byte(config.HashIPSecret), nil
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// sessionMAC signs the expiry of a session. The password is part of the
+// signature so changing it ends every session.
+func (a analytics) sessionMAC(expires string) []byte {
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(expires))
+	mac.Write([]byte{0})
+	mac.Write([]byte(a.Password))
+	mac.Write([]byte(a.passwordHash))
+	return mac.Sum(nil)
+}
+
+// validSession reports whether the request carries an unexpired session
+// cookie signed by us.
+func (a analytics) validSession(r *http.Request) bool {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	parts := strings.SplitN(c.Value, ".", 2)
+	if len(parts) != 2 {
+		return false
+	}
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || a.now().Unix() >= expires {
+		return false
+	}
+	sig, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(sig, a.sessionMAC(parts[0]))
+}
+
+// wantsHTML tells browsers, which get the login form, from API clients,
+// which get a 401 asking for Basic auth.
+func wantsHTML(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// loginPage is what the login form renders.
+type loginPage struct {
+	Error string
+	Nonce string
+}
+
+func (a analytics) loginForm(w http.ResponseWriter, status int, message string) {
+	page := loginPage{Error: message, Nonce: a.withCSP(w)}
+	var buf strings.Builder
+	if err := a.builtin.ExecuteTemplate(&buf, "login", page); err != nil {
+		a.log.Error("rendering login: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(nil)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(buf.String()))
+}
+
+// login checks the password posted by the login form and sets the session
+// cookie, redirecting back to the dashboard URL the form was posted to.
+func (a analytics) login(w http.ResponseWriter, r *http.Request) {
+	ip := clientIP(r)
+	now := a.now()
+	if !a.logins.allowed(ip, now) {
+		a.log.Warn("too many failed logins from %s", ip)
+		w.Header().Set("Retry-After", strconv.Itoa(int(loginWindow/time.Second)))
+		a.loginForm(w, http.StatusTooManyRequests, "Too many failed attempts, try again later.")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.log.Info("bad login form: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(nil)
+		return
+	}
+	if !a.checkPassword(r.PostForm.Get("password")) {
+		a.logins.fail(ip, now)
+		a.log.Info("failed login from %s", ip)
+		a.loginForm(w, http.StatusUnauthorized, "Wrong password.")
+		return
+	}
+	a.logins.reset(ip)
+
+	expires := now.Add(a.sessionLifetime)
+	value := strconv.FormatInt(expires.Unix(), 10)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    value + "." + hex.EncodeToString(a.sessionMAC(value)),
+		Path:     r.URL.Path,
+		Expires:  expires,
+		MaxAge:   int(a.sessionLifetime / time.Second),
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.Redirect(w, r, r.URL.RequestURI(), http.StatusSeeOther)
+}
+
+// logout clears the session cookie and goes back to the dashboard, which
+// shows the login form again.
+func (a analytics) logout(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     r.URL.Path,
+		MaxAge:   -1,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	u := *r.URL
+	q := u.Query()
+	q.Del("logout")
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.RequestURI(), http.StatusSeeOther)
+}
+
+// clientIP is the request's remote address without its port.
+func clientIP(r *http.Request) string {
+	return addrHost(r.RemoteAddr)
+}
+
+// addrHost strips the port off an address, leaving addresses without one as
+// they are.
+func addrHost(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+type loginFailures struct {
+	count int
+	first time.Time
+}
+
+// loginLimiter counts failed logins per IP over loginWindow.
+type loginLimiter struct {
+	mux      sync.Mutex
+	failures map[string]loginFailures
+}
+
+func newLoginLimiter() *loginLimiter {
+	return &loginLimiter{failures: map[string]loginFailures{}}
+}
+
+func (l *loginLimiter) allowed(ip string, now time.Time) bool {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	f, ok := l.failures[ip]
+	return !ok || now.Sub(f.first) >= loginWindow || f.count < maxLoginFailures
+}
+
+// fail records a failed login, forgetting the IPs whose window has passed
+// so the map doesn't grow with every address that ever failed.
+func (l *loginLimiter) fail(ip string, now time.Time) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for k, f := range l.failures {
+		if now.Sub(f.first) >= loginWindow {
+			delete(l.failures, k)
+		}
+	}
+	f, ok := l.failures[ip]
+	if !ok {
+		f.first = now
+	}
+	f.count++
+	l.failures[ip] = f
+}
+
+func (l *loginLimiter) reset(ip string) {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	delete(l.failures, ip)
+}
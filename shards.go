@@ -0,0 +1,163 @@
+package analytics
+
+import (
+	"hash/fnv"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// maxShards caps Shards, more only cost memory.
+const maxShards = 1 << 10
+
+// shard holds the visitors of the days in memory whose keys hash to it, so
+// inserts for different visitors don't wait on each other. Its fields are
+// guarded by mu, taken while holding Mux for reading, or by Mux alone when
+// it's held for writing. Days are opened for every shard at once under Mux,
+// see openDay.
+type shard struct {
+	mu sync.Mutex
+	// entries holds each day's actions by visitor and dropped how many
+	// actions of a visitor went over MaxActionsPerVisitorPerDay.
+	entries  map[string]map[string][]Action
+	dropped  map[string]map[string]int
+	sketches map[string]*sketch
+	counters todayCounters
+	// views holds each day's last page view by visitor, see duplicate.
+	views map[string]map[string]lastView
+}
+
+type shards []*shard
+
+// shardCount is Shards rounded up to a power of two, GOMAXPROCS if unset.
+func shardCount(n int) int {
+	if n <= 0 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	if n > maxShards {
+		n = maxShards
+	}
+	count := 1
+	for count < n {
+		count <<= 1
+	}
+	return count
+}
+
+func newShards(n int) shards {
+	ss := make(shards, shardCount(n))
+	for i := range ss {
+		ss[i] = &shard{
+			entries:  map[string]map[string][]Action{},
+			dropped:  map[string]map[string]int{},
+			sketches: map[string]*sketch{},
+			views:    map[string]map[string]lastView{},
+		}
+	}
+	return ss
+}
+
+// of returns the shard of the visitor with key.
+func (ss shards) of(key string) *shard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return ss[h.Sum32()&uint32(len(ss)-1)]
+}
+
+// openDay loads day ts from disk into memory. It may already have a file,
+// written before a restart or under a Timezone that started it earlier. The
+// caller holds Mux for writing.
+func (a analytics) openDay(ts string, date time.Time) {
+	entries := a.readSavedData(date)
+	dropped := a.readDropped(date)
+	for _, sh := range a.shards {
+		sh.entries[ts] = map[string][]Action{}
+		sh.dropped[ts] = map[string]int{}
+		sh.views[ts] = map[string]lastView{}
+		if sh.counters.day == ts {
+			sh.counters.day = ""
+		}
+	}
+	for k, actions := range entries {
+		a.shards.of(k).entries[ts][k] = actions
+	}
+	for k, n := range dropped {
+		a.shards.of(k).dropped[ts][k] = n
+	}
+	a.loadScheme(ts, date, entries)
+	a.loadSketch(ts, date, entries)
+	a.openDays[ts] = true
+}
+
+// closeDay drops day ts from memory. The caller holds Mux for writing.
+func (a analytics) closeDay(ts string) {
+	for _, sh := range a.shards {
+		delete(sh.entries, ts)
+		delete(sh.dropped, ts)
+		delete(sh.sketches, ts)
+		delete(sh.views, ts)
+	}
+	delete(a.openDays, ts)
+}
+
+// isOpen tells whether day ts is in memory: today, and the days before it
+// that were recorded since the start. What's in memory is complete, unlike
+// the day's file until the next flush.
+func (a analytics) isOpen(ts string) bool {
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	return a.openDays[ts]
+}
+
+// dayEntries copies the actions of day ts in memory from every shard, so
+// they can be aggregated and rendered without holding the locks. The action
+// slices are shared, but inserts only ever append past their end, so the
+// copy doesn't change.
+func (a analytics) dayEntries(ts string) map[string][]Action {
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	data := map[string][]Action{}
+	for _, sh := range a.shards {
+		sh.mu.Lock()
+		for k, actions := range sh.entries[ts] {
+			data[k] = actions
+		}
+		sh.mu.Unlock()
+	}
+	return data
+}
+
+// dayDropped copies how many actions of each visitor of day ts in memory
+// were dropped, from every shard.
+func (a analytics) dayDropped(ts string) map[string]int {
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	dropped := map[string]int{}
+	for _, sh := range a.shards {
+		sh.mu.Lock()
+		for k, n := range sh.dropped[ts] {
+			dropped[k] = n
+		}
+		sh.mu.Unlock()
+	}
+	return dropped
+}
+
+// sessionsToday is how many sessions, visitors who viewed a page, today had
+// so far.
+func (a analytics) sessionsToday() int {
+	ts := a.today()
+	a.Mux.RLock()
+	defer a.Mux.RUnlock()
+	n := 0
+	for _, sh := range a.shards {
+		sh.mu.Lock()
+		for _, actions := range sh.entries[ts] {
+			if hasPageView(actions) {
+				n++
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return n
+}
@@ -0,0 +1,124 @@
+package analytics
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestShardCount(t *testing.T) {
+	for _, tc := range []struct{ in, want int }{
+		{1, 1},
+		{2, 2},
+		{3, 4},
+		{5, 8},
+		{64, 64},
+		{maxShards + 1, maxShards},
+	} {
+		if got := shardCount(tc.in); got != tc.want {
+			t.Errorf("shardCount(%d) = %d, want %d", tc.in, got, tc.want)
+		}
+	}
+	if got, min := shardCount(0), runtime.GOMAXPROCS(0); got < min || got&(got-1) != 0 {
+		t.Errorf("shardCount(0) = %d, want a power of two of at least %d", got, min)
+	}
+}
+
+func TestShardsNegative(t *testing.T) {
+	if _, err := New("test", WithConfiguration(AnalyticsConfiguration{Shards: -1, ManualFlush: true})); err == nil {
+		t.Error("negative Shards were accepted")
+	}
+}
+
+// TestConcurrentInserts records from many goroutines while the dashboard is
+// rendered and the day written, which go test -race checks for races, and
+// compares the numbers with a single shard recording the same requests.
+func TestConcurrentInserts(t *testing.T) {
+	const visitors, views = 64, 20
+	for _, shards := range []int{1, 16} {
+		t.Run(fmt.Sprint(shards), func(t *testing.T) {
+			a := newTestAnalytics(t, AnalyticsConfiguration{Shards: shards})
+			var wg sync.WaitGroup
+			for v := 0; v < visitors; v++ {
+				wg.Add(1)
+				go func(v int) {
+					defer wg.Done()
+					for i := 0; i < views; i++ {
+						a.InsertRequest(visit(fmt.Sprintf("10.0.%d.%d:1234", v/256, v%256), fmt.Sprintf("/page/%d", i%5)))
+					}
+				}(v)
+			}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < 10; i++ {
+					a.Dashboard(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+					if err := a.Flush(); err != nil {
+						t.Error(err)
+					}
+				}
+			}()
+			wg.Wait()
+			<-done
+
+			dd, err := a.Stats(a.now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dd.SessionCount != visitors || dd.PageViews != visitors*views {
+				t.Errorf("got %d sessions and %d views, want %d and %d", dd.SessionCount, dd.PageViews, visitors, visitors*views)
+			}
+			if err := a.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(a.readSavedData(a.now())); n != visitors {
+				t.Errorf("wrote %d visitors, want %d", n, visitors)
+			}
+		})
+	}
+}
+
+// TestShardedDayReopened checks a day written by sharded instances is split
+// over the shards again when it's loaded, with a different number of them.
+func TestShardedDayReopened(t *testing.T) {
+	dir := t.TempDir()
+	a := newTestAnalytics(t, AnalyticsConfiguration{Directory: dir, Shards: 8})
+	for v := 0; v < 50; v++ {
+		a.InsertRequest(visit(fmt.Sprintf("10.0.0.%d:1234", v), "/"))
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	b := newTestAnalytics(t, AnalyticsConfiguration{Directory: dir, Shards: 2})
+	b.InsertRequest(visit("10.0.0.1:1234", "/again"))
+	dd, err := b.Stats(b.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dd.SessionCount != 50 || dd.PageViews != 51 {
+		t.Errorf("got %d sessions and %d views, want 50 and 51", dd.SessionCount, dd.PageViews)
+	}
+}
+
+func BenchmarkInsertRequestParallel(b *testing.B) {
+	for _, shards := range []int{1, 0} {
+		b.Run(fmt.Sprintf("shards=%d", shardCount(shards)), func(b *testing.B) {
+			a := newTestAnalytics(b, AnalyticsConfiguration{Shards: shards})
+			var n int64
+			var mu sync.Mutex
+			b.RunParallel(func(pb *testing.PB) {
+				mu.Lock()
+				n++
+				id := n
+				mu.Unlock()
+				i := 0
+				for pb.Next() {
+					a.InsertRequest(visit(fmt.Sprintf("10.%d.%d.1:1234", id, i%1000), "/"))
+					i++
+				}
+			})
+		})
+	}
+}
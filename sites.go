@@ -0,0 +1,82 @@
+package analytics
+
+import (
+	"net/http"
+	"sort"
+)
+
+// SiteResolver maps a request to the name of the site it belongs to. Names
+// that aren't the configured Name or one of Sites are counted towards Name.
+type SiteResolver func(r *http.Request) string
+
+// HostSite is a SiteResolver using the request's host without its port.
+func HostSite(r *http.Request) string {
+	host := r.Host
+	for i := len(host) - 1; i >= 0; i-- {
+		if host[i] == ':' {
+			return host[:i]
+		}
+		if host[i] == ']' {
+			break
+		}
+	}
+	return host
+}
+
+// addSite registers another site sharing the configuration of a but with its
+// own name, in memory data, caches and files. AggregateNames only apply to
+// the default site.
+func (a *analytics) addSite(name string) {
+	if _, ok := a.sites[name]; ok || len(name) == 0 {
+		return
+	}
+	s := *a
+	s.Name = name
+	s.rangeCache = newLRU(rangeCacheSize)
+	s.dayCache = newLRU(a.dayCache.max)
+	s.dataCache = newLRU(a.dataCache.max)
+	s.dataCache.maxBytes = a.dataCache.maxBytes
+	s.live = &liveWindow{}
+	s.shards = newShards(len(a.shards))
+	s.openDays = map[string]bool{}
+	s.BotEntries = map[string]map[string][]Action{}
+	s.botActions = map[string]int{}
+	s.clickMaps = map[string]map[string][]clickPoint{}
+	s.metrics = &siteMetrics{}
+	s.schemes = map[string]string{}
+	s.peers = nil
+	if a.rateLimit != nil {
+		s.rateLimit = newRateLimiter(int(a.rateLimit.perMinute))
+	}
+	if a.traffic != nil {
+		s.traffic = &trafficWindow{}
+	}
+	a.sites[name] = &s
+}
+
+// site returns the site called name, falling back to the default one.
+func (a analytics) site(name string) analytics {
+	if s, ok := a.sites[name]; ok {
+		return *s
+	}
+	if s, ok := a.sites[a.defaultSite]; ok {
+		return *s
+	}
+	return a
+}
+
+// siteNames lists every site, the default first and the rest by name. It's
+// empty when there's only the default site so the switcher isn't shown.
+func (a analytics) siteNames() []string {
+	if len(a.sites) < 2 {
+		return nil
+	}
+	names := make([]string, 0, len(a.sites))
+	for name := range a.sites {
+		if name != a.defaultSite {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append([]string{a.defaultSite}, names...)
+}
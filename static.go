@@ -0,0 +1,71 @@
+package analytics
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultStaticExtensions are the extensions IgnoreStaticAssets skips unless
+// StaticExtensions replaces them.
+var DefaultStaticExtensions = []string{
+	"css", "js", "map", "png", "jpg", "jpeg", "gif", "svg", "webp", "ico", "woff", "woff2", "ttf", "mp4", "pdf",
+}
+
+// validStaticExtensions returns the set of extensions IgnoreStaticAssets
+// skips, StaticExtensions or DefaultStaticExtensions with
+// AddStaticExtensions and without RemoveStaticExtensions, or nil if it's off.
+// Extensions are lowercased and may be given with or without their dot.
+func validStaticExtensions(config AnalyticsConfiguration) (map[string]bool, error) {
+	if !config.IgnoreStaticAssets {
+		if len(config.StaticExtensions) > 0 || len(config.AddStaticExtensions) > 0 || len(config.RemoveStaticExtensions) > 0 {
+			return nil, fmt.Errorf("StaticExtensions, AddStaticExtensions and RemoveStaticExtensions need IgnoreStaticAssets")
+		}
+		return nil, nil
+	}
+	base := config.StaticExtensions
+	if len(base) == 0 {
+		base = DefaultStaticExtensions
+	}
+	exts := map[string]bool{}
+	for _, list := range [][]string{base, config.AddStaticExtensions, config.RemoveStaticExtensions} {
+		for _, e := range list {
+			if _, err := staticExtension(e); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, list := range [][]string{base, config.AddStaticExtensions} {
+		for _, e := range list {
+			ext, _ := staticExtension(e)
+			exts[ext] = true
+		}
+	}
+	for _, e := range config.RemoveStaticExtensions {
+		ext, _ := staticExtension(e)
+		delete(exts, ext)
+	}
+	return exts, nil
+}
+
+// staticExtension normalizes an extension of the static asset settings.
+func staticExtension(e string) (string, error) {
+	ext := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(e), "."))
+	if len(ext) == 0 || strings.ContainsAny(ext, "./*") {
+		return "", fmt.Errorf("static extension %q must be a single extension like \"css\"", e)
+	}
+	return ext, nil
+}
+
+// staticAsset tells whether path ends in one of the extensions
+// IgnoreStaticAssets skips. It's a suffix check cheap enough to run before
+// anything is locked.
+func (a analytics) staticAsset(path string) bool {
+	if a.staticExts == nil {
+		return false
+	}
+	dot := strings.LastIndexByte(path, '.')
+	if dot < 0 || strings.IndexByte(path[dot:], '/') >= 0 {
+		return false
+	}
+	return a.staticExts[strings.ToLower(path[dot+1:])]
+}
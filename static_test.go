@@ -0,0 +1,57 @@
+package analytics
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestIgnoreStaticAssets(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{
+		Directory:              t.TempDir(),
+		IgnoreStaticAssets:     true,
+		AddStaticExtensions:    []string{".AVIF"},
+		RemoveStaticExtensions: []string{"pdf"},
+	})
+	for _, path := range []string{"/app.js", "/css/site.CSS", "/img/a.avif", "/fonts/x.woff2", "/report.pdf", "/", "/blog/v1.2/post", "/feed.xml"} {
+		a.InsertRequest(visit("192.0.2.1:4000", path))
+	}
+	var got []string
+	for _, act := range views(a)["192.0.2.1:4000"] {
+		got = append(got, act.Page)
+	}
+	want := []string{"/report.pdf", "/", "/blog/v1.2/post", "/feed.xml"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+	if a.ShouldTrack(visit("192.0.2.1:4000", "/logo.png")) {
+		t.Error("ShouldTrack counts a static asset")
+	}
+}
+
+func TestStaticExtensions(t *testing.T) {
+	exts, err := validStaticExtensions(AnalyticsConfiguration{IgnoreStaticAssets: true, StaticExtensions: []string{"css", ".JS"}, AddStaticExtensions: []string{"wasm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for e := range exts {
+		got = append(got, e)
+	}
+	sort.Strings(got)
+	if want := []string{"css", "js", "wasm"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if exts, err := validStaticExtensions(AnalyticsConfiguration{}); exts != nil || err != nil {
+		t.Errorf("off got %v, %v", exts, err)
+	}
+	for _, config := range []AnalyticsConfiguration{
+		{RemoveStaticExtensions: []string{"pdf"}},
+		{IgnoreStaticAssets: true, AddStaticExtensions: []string{"tar.gz"}},
+		{IgnoreStaticAssets: true, StaticExtensions: []string{""}},
+	} {
+		if _, err := validStaticExtensions(config); err == nil {
+			t.Errorf("%+v was accepted", config)
+		}
+	}
+}
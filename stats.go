@@ -0,0 +1,246 @@
+package analytics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// DashboardData is everything the dashboard shows for a day or a range of
+// days. It is what the dashboard template renders and what StatsJSON returns.
+type DashboardData struct {
+	// SessionCount is the number of unique visitors and PageViews the total
+	// number of page views they made.
+	SessionCount int   `json:"session_count"`
+	PageViews    int   `json:"page_views"`
+	Bytes        int64 `json:"bytes"`
+	// UniqueVisitors estimates how many different visitors a range had,
+	// where SessionCount adds up each day's. It's approximate, with a
+	// standard error of UniqueVisitorsError percent, see SketchPrecision.
+	// Both are zero for single days.
+	UniqueVisitors      int     `json:"unique_visitors,omitempty"`
+	UniqueVisitorsError float64 `json:"unique_visitors_error,omitempty"`
+	// Truncated is how many visitors hit MaxActionsPerVisitorPerDay and
+	// Dropped how many of their actions weren't recorded because of it.
+	Truncated int    `json:"truncated_visitors,omitempty"`
+	Dropped   int    `json:"dropped_actions,omitempty"`
+	Date      string `json:"date"`
+	// To is the last day of a range and empty for a single day.
+	To string `json:"to,omitempty"`
+	// Period is week or month when the range was picked with ?period=.
+	Period string `json:"period,omitempty"`
+	// Site is the site shown and Sites every configured site, listed only
+	// when there is more than one.
+	Site  string   `json:"site"`
+	Sites []string `json:"sites,omitempty"`
+	// LoggedIn is set when the request carried a session cookie, so the
+	// dashboard links to ?logout=1.
+	LoggedIn bool `json:"-"`
+	// Nonce is the Content-Security-Policy nonce of the page being rendered.
+	Nonce string `json:"-"`
+	// URLHits are ordered by total views, see URLGroup. They only list the
+	// URLs matching Filter, PerPage at a time, unlike the headline numbers.
+	URLHits []URLGroup `json:"url_hits"`
+	Filter  string     `json:"filter,omitempty"`
+	Page    int        `json:"page"`
+	PerPage int        `json:"per_page"`
+	// Sort and Order are how each group's URLs are ordered, see SortViews.
+	Sort     string               `json:"sort"`
+	Order    string               `json:"order"`
+	Latency  map[string]Latencies `json:"latency"`
+	Outbound []LinkClicks         `json:"outbound"`
+	Days     []DaySessions        `json:"days"`
+	Hours    []HourViews          `json:"hours"`
+	// Visitors are the most recently seen visitors, see VisitorSummary.
+	Visitors []VisitorSummary `json:"visitors"`
+	// Trend is the last 30 days up to the selected day or end of the range.
+	Trend []TrendDay `json:"trend"`
+	// SearchTerms are the most searched terms, on SiteSearchPaths and on the
+	// search engines referring visitors, see SearchTerm.
+	SearchTerms []SearchTerm `json:"search_terms,omitempty"`
+	// Goals are in the order they are configured, see GoalConfig.
+	Goals []GoalStats `json:"goals,omitempty"`
+	// Experiments compare the variants VariantFunc assigned visitors to.
+	Experiments []ExperimentStats `json:"experiments,omitempty"`
+	// Protocols are the TLS and HTTP versions sessions came over.
+	Protocols *Protocols `json:"protocols,omitempty"`
+	// ASNs are the autonomous systems with the most sessions, see ASNLookup.
+	ASNs []ASNCount `json:"asns,omitempty"`
+	// Funnel is how far visitors got through the configured Funnel steps.
+	Funnel []FunnelStep `json:"funnel,omitempty"`
+	// Comparison holds the changes against the previous period, see ?compare=.
+	Comparison Comparison `json:"comparison"`
+	// NoHistory is set with DisablePersistence when the range starts before
+	// today, whose numbers are the only ones kept.
+	NoHistory bool `json:"no_history,omitempty"`
+	// MixedHashDays are the days whose visitors were keyed with different
+	// HashSchemes, so a visitor seen under both is counted twice.
+	MixedHashDays []string `json:"mixed_hash_days,omitempty"`
+	// AnonymizedDays are the days AnonymizeAfterDays reduced to their
+	// totals, which list no visitors.
+	AnonymizedDays []string `json:"anonymized_days,omitempty"`
+	// Returning is how many of a single day's visitors were seen before,
+	// with ReturningLookbackDays.
+	Returning *Returning `json:"returning,omitempty"`
+	// Frequency is how many days of a range its visitors came on.
+	Frequency *Frequency `json:"frequency,omitempty"`
+	// Nodes break the days down by instance when AggregateNames are merged.
+	Nodes []NodeStats `json:"nodes,omitempty"`
+}
+
+// Comparison basis, selected with ?compare=. Day compares with the span of
+// the same length right before, week with the same days one week earlier.
+const (
+	CompareDay  = "day"
+	CompareWeek = "week"
+)
+
+// Comparison is how the selected day or range did against an earlier one.
+type Comparison struct {
+	Basis     string `json:"basis"`
+	Date      string `json:"date"`
+	To        string `json:"to,omitempty"`
+	Sessions  Delta  `json:"sessions"`
+	PageViews Delta  `json:"page_views"`
+}
+
+// Delta is the change of a number against its previous value. Percent is nil
+// when there was nothing to compare to.
+type Delta struct {
+	Previous int      `json:"previous"`
+	Change   int      `json:"change"`
+	Percent  *float64 `json:"percent,omitempty"`
+}
+
+func newDelta(current, previous int) Delta {
+	d := Delta{Previous: previous, Change: current - previous}
+	if previous != 0 {
+		p := float64(d.Change) * 100 / float64(previous)
+		d.Percent = &p
+	}
+	return d
+}
+
+// String formats the change with its sign, e.g. "+12 (+9.5%)".
+func (d Delta) String() string {
+	if d.Percent == nil {
+		return fmt.Sprintf("%+d (n/a)", d.Change)
+	}
+	return fmt.Sprintf("%+d (%+.1f%%)", d.Change, *d.Percent)
+}
+
+// URLCounts returns the view counts of URLHits keyed by group and URL, the
+// shape URLHits had before it became ordered.
+func (d DashboardData) URLCounts() map[string]map[string]int {
+	counts := make(map[string]map[string]int, len(d.URLHits))
+	for _, g := range d.URLHits {
+		counts[g.Group] = make(map[string]int, len(g.URLs))
+		for _, u := range g.URLs {
+			counts[g.Group][u.URL] = u.Views
+		}
+	}
+	return counts
+}
+
+// URLStats are the page views of a URL and how many unique visitors made
+// them. Visitor keys are per day, so over a range Visitors sums each day's
+// unique visitors.
+type URLStats struct {
+	Views    int   `json:"views"`
+	Visitors int   `json:"visitors"`
+	Bytes    int64 `json:"bytes"`
+}
+
+// URLGroup is one dashboard table, its URLs ordered by views. Total is how
+// many URLs match the filter and Hidden how many of them aren't on this page;
+// Prev and Next tell whether there are pages before and after it.
+type URLGroup struct {
+	Group string `json:"group"`
+	// Label is what the group is shown as, see GroupLabels, and empty if
+	// it's shown as it is.
+	Label  string   `json:"label,omitempty"`
+	Views  int      `json:"views"`
+	Bytes  int64    `json:"bytes"`
+	URLs   []URLHit `json:"urls"`
+	Total  int      `json:"total"`
+	Hidden int      `json:"hidden,omitempty"`
+	Prev   bool     `json:"prev,omitempty"`
+	Next   bool     `json:"next,omitempty"`
+	// Percent is the group's share of all page views.
+	Percent float64 `json:"percent"`
+	// Queries are the most common values of the parameters configured with
+	// QueryReports on the group's pages.
+	Queries []QueryCount `json:"queries,omitempty"`
+}
+
+// URLHit is a URL of a group. Percent is its share of all page views and
+// Cumulative that of it and every URL listed before it in the group, on
+// earlier pages too.
+type URLHit struct {
+	URL string `json:"url"`
+	URLStats
+	Percent    float64 `json:"percent"`
+	Cumulative float64 `json:"cumulative_percent"`
+	// RawPaths are the most viewed paths a PathTemplates URL was recorded
+	// for, with KeepRawPaths.
+	RawPaths []PathCount `json:"raw_paths,omitempty"`
+	// Engagement is reported for URLs with enough views pinged by the
+	// beacon script.
+	Engagement *Engagement `json:"engagement,omitempty"`
+}
+
+// Latencies are response time percentiles for a URL group. Groups without
+// any recorded durations (e.g. days written before Middleware was used) have
+// zero values.
+type Latencies struct {
+	P50 time.Duration `json:"p50_ns"`
+	P95 time.Duration `json:"p95_ns"`
+	P99 time.Duration `json:"p99_ns"`
+}
+
+type Link struct {
+	Event string `json:"event"`
+	URL   string `json:"url"`
+}
+
+type LinkClicks struct {
+	Link
+	Clicks int `json:"clicks"`
+}
+
+type DaySessions struct {
+	Date     string `json:"date"`
+	Sessions int    `json:"sessions"`
+}
+
+// TrendDay is one day of the trend chart. Percent is relative to the day with
+// the most visitors and drives the bar height.
+type TrendDay struct {
+	Date      string `json:"date"`
+	Sessions  int    `json:"sessions"`
+	PageViews int    `json:"page_views"`
+	Percent   int    `json:"percent"`
+}
+
+// HourViews is the number of page views in one hour of the day. Percent is
+// relative to the busiest hour and drives the bar width.
+type HourViews struct {
+	Hour    int `json:"hour"`
+	Views   int `json:"views"`
+	Percent int `json:"percent"`
+}
+
+// StatsJSON responds with the DashboardData the dashboard would render for
+// the same query, encoded as JSON.
+func (a analytics) StatsJSON(w http.ResponseWriter, r *http.Request) {
+	a = a.site(r.URL.Query().Get("site"))
+	dd, ok := a.dashboardData(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(dd); err != nil {
+		a.log.Info("writing stats: %v", err)
+	}
+}
@@ -0,0 +1,268 @@
+package analytics
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// VisitorExport is everything stored about a visitor, see ExportVisitor.
+// Fields tells, for each field of the days and their actions, what it holds
+// and whether it's stored hashed or in plaintext.
+type VisitorExport struct {
+	Visitor string             `json:"visitor"`
+	From    string             `json:"from"`
+	To      string             `json:"to"`
+	Fields  map[string]string  `json:"fields"`
+	Days    []VisitorExportDay `json:"days"`
+}
+
+// VisitorExportDay is what a site stored under one key of the visitor on a
+// day. A visitor can have several keys a day, one for each port they came
+// from.
+type VisitorExportDay struct {
+	Site       string   `json:"site"`
+	Date       string   `json:"date"`
+	Key        string   `json:"key"`
+	KeyScheme  string   `json:"key_scheme"`
+	Actions    []Action `json:"actions,omitempty"`
+	Dropped    int      `json:"dropped,omitempty"`
+	BotActions []Action `json:"bot_actions,omitempty"`
+}
+
+// visitorExportFields describes the fields of a VisitorExport.
+var visitorExportFields = map[string]string{
+	"key":         "the IP and port the requests came from in plaintext if key_scheme is " + SchemeNone + ", hashed from the date, IP, port and a secret otherwise; if key_scheme ends in " + truncatedScheme + " the IP's last octet, or last 80 bits for IPv6, is zeroed and the port dropped first",
+	"key_scheme":  "plaintext, how key was derived",
+	"actions":     "plaintext, the requests and clicks recorded under key",
+	"dropped":     "plaintext, how many more requests were only counted, over the daily limit",
+	"bot_actions": "plaintext, the requests recorded under key as bot traffic",
+	"Page":        "plaintext, the path requested",
+	"Query":       "plaintext, the query string, without redacted parameters",
+	"Event":       "plaintext, the kind of link clicked, outbound or download",
+	"Target":      "plaintext, the URL of the link clicked",
+	"Bytes":       "plaintext, the size of the response",
+	"Duration":    "plaintext, how long the response took in nanoseconds",
+	"Prefetch":    "plaintext, whether the browser prefetched the page",
+	"Referrer":    "plaintext, the page the request came from",
+	"RawPage":     "plaintext, the path requested before it was rewritten to Page",
+	"TraceID":     "plaintext, the trace the request was part of",
+	"Experiment":  "plaintext, the experiment the request took part in",
+	"Variant":     "plaintext, the variant of Experiment the visitor was assigned",
+	"Scroll":      "plaintext, how far down the page was scrolled, in percent",
+	"Seconds":     "plaintext, how long the page was open",
+	"TLS":         "plaintext, the TLS version of the visitor's first request of the day, none without TLS",
+	"Proto":       "plaintext, the HTTP version of the visitor's first request of the day",
+	"ASN":         "plaintext, the autonomous system number of the IP of the visitor's first request of the day",
+	"ASNOrg":      "plaintext, the organization of ASN",
+	"Hostname":    "plaintext, the reverse DNS name of the IP of the visitor's first request of the day",
+	"UserAgent":   "plaintext, the browser or bot, only kept for bot traffic",
+	"Timestamp":   "plaintext, when the request was recorded in Unix milliseconds",
+}
+
+// ExportVisitor returns everything a visitor recorded on the days from
+// through to, in every site, for subject access requests. The visitor is
+// given like to DeleteVisitor and their keys are found the same way. Days
+// without any of their actions are left out.
+func (a analytics) ExportVisitor(ipOrHash string, from, to time.Time) (VisitorExport, error) {
+	from, to, err := a.visitorRange(ipOrHash, from, to)
+	if err != nil {
+		return VisitorExport{}, err
+	}
+	e := VisitorExport{Visitor: ipOrHash, From: a.dayKey(from), To: a.dayKey(to), Fields: visitorExportFields, Days: []VisitorExportDay{}}
+	err = a.visitorDays(ipOrHash, from, to, func(day VisitorExportDay) error {
+		e.Days = append(e.Days, day)
+		return nil
+	})
+	return e, err
+}
+
+// visitorRange checks the arguments of DeleteVisitor and ExportVisitor,
+// returning the days they span.
+func (a analytics) visitorRange(ipOrHash string, from, to time.Time) (time.Time, time.Time, error) {
+	if len(ipOrHash) == 0 {
+		return from, to, errors.New("no visitor given")
+	}
+	from, err := a.parseDay(a.dayKey(from))
+	if err != nil {
+		return from, to, err
+	}
+	to, err = a.parseDay(a.dayKey(to))
+	if err != nil {
+		return from, to, err
+	}
+	if from.After(to) {
+		return from, to, fmt.Errorf("from date %s is after to date %s", from.Format(dayLayout), to.Format(dayLayout))
+	}
+	return from, to, nil
+}
+
+// visitorDays calls visit with what each site stored under each key of who
+// on the days from through to, site by site in the order of their names.
+func (a analytics) visitorDays(who string, from, to time.Time, visit func(VisitorExportDay) error) error {
+	names := make([]string, 0, len(a.sites))
+	for name := range a.sites {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s := a.sites[name]
+		for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+			days, err := s.visitorDay(who, d)
+			if err != nil {
+				return fmt.Errorf("exporting a visitor of %s: %w", name, err)
+			}
+			for _, day := range days {
+				if err := visit(day); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// visitorDay returns what was stored under each key of who on date, from
+// memory if the day is in it and from its files otherwise.
+func (a analytics) visitorDay(who string, date time.Time) ([]VisitorExportDay, error) {
+	ts := a.dayKey(date)
+	var entries, bots map[string][]Action
+	var dropped map[string]int
+	var scheme string
+	if a.isOpen(ts) {
+		entries, dropped = a.dayEntries(ts), a.dayDropped(ts)
+		a.Mux.RLock()
+		if a.BotEntries[ts] != nil {
+			bots = make(map[string][]Action, len(a.BotEntries[ts]))
+			for k, actions := range a.BotEntries[ts] {
+				bots[k] = actions
+			}
+		}
+		scheme = a.dayScheme(ts)
+		a.Mux.RUnlock()
+		// Bots are only loaded once one is recorded.
+		if bots == nil {
+			bots = a.readDayFile(a.botFileName(date))
+		}
+	} else if !a.memoryOnly {
+		saved, err := a.readSavedDay(date)
+		if err != nil {
+			return nil, err
+		}
+		if saved == nil && !dayFileExists(a.botFileName(date)) {
+			return nil, nil
+		}
+		entries, dropped = saved, a.readDropped(date)
+		bots = a.readDayFile(a.botFileName(date))
+		stored, _ := a.storedSummary(date)
+		scheme = stored.Scheme
+	}
+	if len(entries) == 0 && len(bots) == 0 {
+		return nil, nil
+	}
+	if len(scheme) == 0 {
+		scheme = a.hashScheme
+	}
+	match := a.visitorMatcher(ts, who)
+	byKey := map[string]*VisitorExportDay{}
+	day := func(key string) *VisitorExportDay {
+		d := byKey[key]
+		if d == nil {
+			d = &VisitorExportDay{Site: a.Name, Date: ts, Key: key, KeyScheme: scheme}
+			byKey[key] = d
+		}
+		return d
+	}
+	for k, actions := range entries {
+		if match(k) {
+			day(k).Actions = actions
+			day(k).Dropped = dropped[k]
+		}
+	}
+	for k, actions := range bots {
+		if match(k) {
+			day(k).BotActions = actions
+		}
+	}
+	days := make([]VisitorExportDay, 0, len(byKey))
+	for _, d := range byKey {
+		days = append(days, *d)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Key < days[j].Key })
+	return days, nil
+}
+
+// readSavedDay reads the day file of date, nil if there is none.
+func (a analytics) readSavedDay(date time.Time) (map[string][]Action, error) {
+	if !dayFileExists(a.dayFileName(date)) {
+		return nil, nil
+	}
+	entries, err := a.decodeDayFile(a.dayFileName(date))
+	if err != nil {
+		return nil, err
+	}
+	migrateKeys(entries)
+	return entries, nil
+}
+
+// SubjectAccess streams ExportVisitor's document as JSON, a day at a time.
+// It takes the form values "visitor" and optionally "from" and "to" like
+// Erase, as a GET or a POST, and is protected by the dashboard password.
+func (a analytics) SubjectAccess(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		a.log.Info("bad subject access request: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	visitor := r.Form.Get("visitor")
+	if len(visitor) == 0 {
+		http.Error(w, "visitor is required", http.StatusBadRequest)
+		return
+	}
+	from, to, ok := a.formRange(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": "visitor-" + a.dayKey(from) + "_" + a.dayKey(to) + ".json"}))
+	head, err := json.Marshal(VisitorExport{Visitor: visitor, From: a.dayKey(from), To: a.dayKey(to), Fields: visitorExportFields})
+	if err != nil {
+		a.log.Error("writing a subject access request: %v", err)
+		return
+	}
+	// The document up to the days, which follow one by one.
+	head = head[:len(head)-len(`null}`)]
+	w.Write(append(head, '['))
+	flusher, _ := w.(http.Flusher)
+	first := true
+	err = a.visitorDays(visitor, from, to, func(day VisitorExportDay) error {
+		bs, err := json.Marshal(day)
+		if err != nil {
+			return err
+		}
+		if !first {
+			w.Write([]byte{','})
+		}
+		first = false
+		if _, err := w.Write(bs); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		a.log.Error("writing a subject access request: %v", err)
+		return
+	}
+	w.Write([]byte("]}\n"))
+}
@@ -0,0 +1,86 @@
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportVisitor(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	config := AnalyticsConfiguration{HashIPSecret: "secret"}
+	config.Directory = recordedDay(t, config, day)
+	a := newTestAnalytics(t, config, WithClock(&movingClock{t: day.AddDate(0, 0, 1)}))
+	a.InsertRequest(visit("192.0.2.1:6000", "/c"))
+	a.InsertRequest(visit("192.0.2.2:6000", "/d"))
+
+	e, err := a.ExportVisitor("192.0.2.1", day.AddDate(0, 0, -1), day.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pages := map[string]string{}
+	for _, d := range e.Days {
+		if d.KeyScheme != SchemeSHA256 || d.Site != "test" {
+			t.Errorf("%s is keyed with %q in %q, want %q in test", d.Date, d.KeyScheme, d.Site, SchemeSHA256)
+		}
+		for _, act := range d.Actions {
+			pages[act.Page] = d.Date
+		}
+	}
+	want := map[string]string{"/a": "2027-01-15", "/b": "2027-01-15", "/c": "2027-01-16"}
+	if len(e.Days) != 3 || !reflect.DeepEqual(pages, want) {
+		t.Errorf("exported %d days with pages %v, want 3 with %v", len(e.Days), pages, want)
+	}
+
+	e, err = a.ExportVisitor("198.51.100.1", day, day)
+	if err != nil || len(e.Days) != 0 {
+		t.Errorf("exported %d days of a stranger (%v), want none", len(e.Days), err)
+	}
+}
+
+// TestExportVisitorFields checks every stored field of an action is
+// described.
+func TestExportVisitorFields(t *testing.T) {
+	typ := reflect.TypeOf(Action{})
+	for i := 0; i < typ.NumField(); i++ {
+		if _, ok := visitorExportFields[typ.Field(i).Name]; !ok {
+			t.Errorf("Action.%s isn't described", typ.Field(i).Name)
+		}
+	}
+}
+
+func TestSubjectAccess(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{Password: "pw"})
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	a.InsertRequest(visit("192.0.2.1:5000", "/b"))
+	access := func(target, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if len(password) > 0 {
+			r.SetBasicAuth("", password)
+		}
+		rec := httptest.NewRecorder()
+		a.SubjectAccess(rec, r)
+		return rec
+	}
+	if rec := access("/access?visitor=192.0.2.1", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("answered %d without the password, want 401", rec.Code)
+	}
+	if rec := access("/access", "pw"); rec.Code != http.StatusBadRequest {
+		t.Errorf("answered %d without a visitor, want 400", rec.Code)
+	}
+	rec := access("/access?visitor=192.0.2.1", "pw")
+	var got VisitorExport
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%v in %s", err, rec.Body)
+	}
+	want, err := a.ExportVisitor("192.0.2.1", a.now(), a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Days) != 2 || !reflect.DeepEqual(got, want) {
+		t.Errorf("served %+v, want %+v", got, want)
+	}
+}
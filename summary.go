@@ -0,0 +1,282 @@
+package analytics
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// trendDays is how many days the dashboard's trend chart covers.
+const trendDays = 30
+
+// summaryPages is how many of a day's most viewed pages its summary lists.
+const summaryPages = 20
+
+// daySummary holds the headline numbers of a day. It's written next to the
+// day file so views over many days don't decompress every day file. Hours
+// are the page views of each hour of the day, missing from summaries written
+// before they were added. Pages are the summaryPages most viewed pages, nil
+// in summaries written before they were added and empty, not nil, for days
+// without page views. Scheme is how the visitor keys were hashed, see
+// HashScheme, empty if unknown. Sketch is the marshaled sketch of the day's
+// visitors, KeySketch set if it was fed their keys rather than their IPs as
+// before format version 12.
+type daySummary struct {
+	Sessions  int
+	PageViews int
+	Hours     []int `json:",omitempty"`
+	Pages     []NamedCount
+	Scheme    string `json:",omitempty"`
+	Sketch    []byte `json:",omitempty"`
+	KeySketch bool   `json:",omitempty"`
+}
+
+// summarize counts hours in loc, the zone the day was recorded in.
+func summarize(data map[string][]Action, loc *time.Location) daySummary {
+	s := daySummary{Hours: make([]int, 24), Pages: topDayPages(data)}
+	for _, actions := range data {
+		if hasPageView(actions) {
+			s.Sessions++
+		}
+		for _, act := range actions {
+			if len(act.Event) == 0 {
+				s.PageViews++
+				if act.Timestamp > 0 {
+					s.Hours[time.UnixMilli(act.Timestamp).In(loc).Hour()]++
+				}
+			}
+		}
+	}
+	return s
+}
+
+// topDayPages ranks the pages of a day by views, keeping summaryPages.
+func topDayPages(data map[string][]Action) []NamedCount {
+	views := map[string]int{}
+	for _, actions := range data {
+		for _, act := range actions {
+			if len(act.Event) == 0 {
+				views[act.Page]++
+			}
+		}
+	}
+	return rankCounts(views, summaryPages)
+}
+
+func (a analytics) summaryFileName(date time.Time) string {
+	return a.dayFileName(date) + ".summary"
+}
+
+func (a analytics) writeSummary(date time.Time, s daySummary) error {
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFile(a.summaryFileName(date), bs)
+}
+
+// readSummary returns the summary of a day. With AggregateNames it's
+// summarized from the merged day, as visitors seen by several instances
+// can't be told apart in their summaries.
+func (a analytics) readSummary(date time.Time) daySummary {
+	if len(a.peers) == 0 {
+		return a.ownSummary(date)
+	}
+	s := summarize(a.loadDay(date), a.location)
+	if a.isToday(date) || a.isOpen(a.dayKey(date)) {
+		a.Mux.RLock()
+		s.Scheme = a.dayScheme(a.dayKey(date))
+		a.Mux.RUnlock()
+	} else {
+		stored, _ := a.storedSummary(date)
+		s.Scheme = stored.Scheme
+	}
+	return s
+}
+
+// ownSummary returns the summary of this instance's day. Days in memory are
+// summarized from it; summaries missing from disk, unreadable or lacking hours,
+// pages or a sketch, e.g. for days written before summaries had them, are
+// rebuilt from the day file and saved for next time.
+func (a analytics) ownSummary(date time.Time) daySummary {
+	if a.isToday(date) || a.isOpen(a.dayKey(date)) {
+		s := summarize(a.dayEntries(a.dayKey(date)), a.location)
+		a.Mux.RLock()
+		s.Scheme = a.dayScheme(a.dayKey(date))
+		a.Mux.RUnlock()
+		return s
+	}
+	s, ok := a.storedSummary(date)
+	if ok && len(s.Hours) == 24 && s.Pages != nil && unmarshalSketch(s.Sketch) != nil || a.memoryOnly {
+		return s
+	}
+	_, err := os.Stat(a.dayFileName(date))
+	if err != nil && !dayFileExists(a.dayFileName(date)) {
+		return s
+	}
+	scheme, sk, keyed := s.Scheme, s.Sketch, s.KeySketch
+	data := a.readSavedData(date)
+	s = summarize(data, a.location)
+	s.Scheme, s.Sketch, s.KeySketch = scheme, sk, keyed
+	if unmarshalSketch(sk) == nil {
+		s.Sketch, s.KeySketch = keysSketch(data, a.sketchPrecision).marshal(), true
+	}
+	// Archived days have no directory to save the summary to.
+	if err == nil {
+		if err := a.writeSummary(date, s); err != nil {
+			a.log.Error("writing summary: %v", err)
+		}
+	}
+	return s
+}
+
+// storedSummary reads the summary file of a day, reporting whether there is
+// a readable one.
+func (a analytics) storedSummary(date time.Time) (daySummary, bool) {
+	var s daySummary
+	if a.memoryOnly {
+		return s, false
+	}
+	bs, err := readDayFileBytes(a.summaryFileName(date))
+	if err != nil || json.Unmarshal(bs, &s) != nil {
+		return daySummary{}, false
+	}
+	return s, true
+}
+
+// monthIndex holds the headline numbers of the days of a month, keyed by
+// day. It's written next to the month's day directories when days are
+// written, so views over many days read one file per month rather than a
+// summary per day.
+type monthIndex map[string]indexDay
+
+type indexDay struct {
+	Sessions  int
+	PageViews int
+}
+
+// indexFileName is Directory/YYYY/MM/<Name>YYYY-MM.index.
+func (a analytics) indexFileName(month time.Time) string {
+	month = month.In(a.location)
+	return filepath.Join(a.Directory, month.Format("2006"), month.Format("01"), a.Name+month.Format("2006-01")+".index")
+}
+
+// readIndex reads the index of the month of date. A missing or unreadable
+// index is empty, its days are then read from their summaries.
+func (a analytics) readIndex(date time.Time) monthIndex {
+	idx := monthIndex{}
+	bs, err := ioutil.ReadFile(a.indexFileName(date))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.log.Warn("reading month index: %v", err)
+		}
+		return idx
+	}
+	if err := json.Unmarshal(bs, &idx); err != nil {
+		a.log.Warn("reading month index %s: %v", a.indexFileName(date), err)
+		return monthIndex{}
+	}
+	return idx
+}
+
+// updateIndex adds days to the indexes of their months. Unless replace,
+// days already in an index are kept, they may have been written since the
+// caller looked. The caller holds writeMux. Indexes are written through a
+// temporary file so a reader never sees half of one.
+func (a analytics) updateIndex(days map[string]indexDay, replace bool) error {
+	months := map[string][]string{}
+	for k := range days {
+		month := k[:len("2006-01")]
+		months[month] = append(months[month], k)
+	}
+	for _, keys := range months {
+		date, err := a.parseDay(keys[0])
+		if err != nil {
+			return err
+		}
+		idx := a.readIndex(date)
+		for _, k := range keys {
+			if _, ok := idx[k]; !ok || replace {
+				idx[k] = days[k]
+			}
+		}
+		bs, err := json.Marshal(idx)
+		if err != nil {
+			return err
+		}
+		name := a.indexFileName(date)
+		if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+			return err
+		}
+		if err := writeFile(name, bs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dayTotals returns the headline numbers of the days from through to. Days
+// before today are read from the month indexes; those missing from them,
+// e.g. written before there were indexes, are summarized and added if they
+// have visitors. Today,
+// memory-only sites and sites with AggregateNames use readSummary.
+func (a analytics) dayTotals(from, to time.Time) []indexDay {
+	var totals []indexDay
+	indexes := map[string]monthIndex{}
+	missing := map[string]indexDay{}
+	today := a.today()
+	for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+		key := a.dayKey(d)
+		if a.memoryOnly || len(a.peers) > 0 || key >= today {
+			s := a.readSummary(d)
+			totals = append(totals, indexDay{Sessions: s.Sessions, PageViews: s.PageViews})
+			continue
+		}
+		month := key[:len("2006-01")]
+		idx, ok := indexes[month]
+		if !ok {
+			idx = a.readIndex(d)
+			indexes[month] = idx
+		}
+		day, ok := idx[key]
+		if !ok {
+			s := a.ownSummary(d)
+			day = indexDay{Sessions: s.Sessions, PageViews: s.PageViews}
+			if day.Sessions > 0 {
+				missing[key] = day
+			}
+		}
+		totals = append(totals, day)
+	}
+	if len(missing) > 0 {
+		a.writeMux.Lock()
+		err := a.updateIndex(missing, false)
+		a.writeMux.Unlock()
+		if err != nil {
+			a.log.Error("writing month index: %v", err)
+		}
+	}
+	return totals
+}
+
+// trend returns the summaries of the trendDays days ending with last.
+func (a analytics) trend(last time.Time) []TrendDay {
+	days := make([]TrendDay, trendDays)
+	busiest := 0
+	first := last.AddDate(0, 0, 1-trendDays)
+	for i, s := range a.dayTotals(first, last) {
+		date := first.AddDate(0, 0, i)
+		days[i] = TrendDay{Date: a.dayKey(date), Sessions: s.Sessions, PageViews: s.PageViews}
+		if s.Sessions > busiest {
+			busiest = s.Sessions
+		}
+	}
+	if busiest > 0 {
+		for i := range days {
+			days[i].Percent = days[i].Sessions * 100 / busiest
+		}
+	}
+	return days
+}
@@ -0,0 +1,93 @@
+package analytics
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// defaultMaxAggregations is how many dashboard requests may aggregate days
+// that aren't cached at once unless MaxConcurrentAggregations says otherwise.
+const defaultMaxAggregations = 4
+
+// aggregationRetry is the Retry-After of dashboard requests turned away
+// because as many are aggregating already.
+const aggregationRetry = 5 * time.Second
+
+// newAggregationSlots returns the semaphore limiting aggregations to n at
+// once, nil if negative n means there's no limit.
+func newAggregationSlots(n int) chan struct{} {
+	if n < 0 {
+		return nil
+	}
+	if n == 0 {
+		n = defaultMaxAggregations
+	}
+	return make(chan struct{}, n)
+}
+
+// admit takes an aggregation slot for a request whose days aren't all
+// cached, answering 503 with Retry-After if none is free. Cached requests
+// are always let through. release gives the slot back.
+func (a analytics) admit(w http.ResponseWriter, cached bool) (release func(), ok bool) {
+	if cached || a.aggregations == nil {
+		return func() {}, true
+	}
+	select {
+	case a.aggregations <- struct{}{}:
+		return func() { <-a.aggregations }, true
+	default:
+		atomic.AddInt64(&a.metrics.busy, 1)
+		a.log.Warn("turning away a dashboard request, %d aggregations are running", cap(a.aggregations))
+		w.Header().Set("Retry-After", strconv.Itoa(int(aggregationRetry/time.Second)))
+		http.Error(w, "the dashboard is busy, try again shortly", http.StatusServiceUnavailable)
+		return nil, false
+	}
+}
+
+// admitView admits a dashboard view of the days from through to, compared
+// with the days r's query asks for.
+func (a analytics) admitView(w http.ResponseWriter, r *http.Request, from, to time.Time) (release func(), ok bool) {
+	cFrom, cTo := compareRange(from, to, r.URL.Query().Get("compare"))
+	return a.admit(w, a.rangeCached(from, to) && a.rangeCached(cFrom, cTo))
+}
+
+// rangeCached tells whether aggregating the days from through to only reads
+// caches and today's counters, following aggregateRange.
+func (a analytics) rangeCached(from, to time.Time) bool {
+	if a.dayKey(from) == a.dayKey(to) {
+		return a.dayCached(from)
+	}
+	today := a.today()
+	through := from.AddDate(0, 0, -1)
+	for d := from; !d.After(to) && a.dayKey(d) < today; d = d.AddDate(0, 0, 1) {
+		through = d
+	}
+	if !through.Before(from) {
+		if _, ok := a.rangeCache.get(a.dayKey(from) + "/" + a.dayKey(through)); !ok {
+			return false
+		}
+	}
+	for d := through.AddDate(0, 0, 1); !d.After(to); d = d.AddDate(0, 0, 1) {
+		if !a.dayCached(d) {
+			return false
+		}
+	}
+	return true
+}
+
+// dayCached tells whether dayAggregate would answer from memory: today's
+// counters, a day after today, which has no data, or the day cache.
+func (a analytics) dayCached(date time.Time) bool {
+	key, today := a.dayKey(date), a.today()
+	if key > today || key == today && len(a.peers) == 0 {
+		return true
+	}
+	c, ok := a.dayCache.get(key)
+	if !ok {
+		return false
+	}
+	cd := c.(cachedDay)
+	return cd.expires.IsZero() || a.now().Before(cd.expires)
+}
@@ -0,0 +1,128 @@
+package analytics
+
+import (
+	"bytes"
+	"compress/zlib"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestStreamDayMatchesLoaded checks aggregating a saved day as it's read
+// gives what aggregating it loaded does.
+func TestStreamDayMatchesLoaded(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	a := newTestAnalytics(t, counterConfig, WithClock(clock))
+	randomWorkload(a, 6, 3000)
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	streamed := a.streamDay(day)
+	loaded := a.aggregateDay(day, a.readSavedData(day), a.readDropped(day))
+	if got, want := streamed.report(urlView{}), loaded.report(urlView{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("streaming reports\n%+v\nloading\n%+v", got, want)
+	}
+	if !reflect.DeepEqual(streamed.goals, loaded.goals) || !reflect.DeepEqual(streamed.funnel, loaded.funnel) {
+		t.Errorf("streaming counts goals %v and funnel %v, loading %v and %v", streamed.goals, streamed.funnel, loaded.goals, loaded.funnel)
+	}
+	if streamed.sketch.estimate() != loaded.sketch.estimate() {
+		t.Errorf("streaming estimates %v visitors, loading %v", streamed.sketch.estimate(), loaded.sketch.estimate())
+	}
+	if _, ok := a.dataCache.get(a.dayKey(day)); ok {
+		t.Error("streaming loaded the day into the data cache")
+	}
+}
+
+func TestDecodeDay(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	a := newTestAnalytics(t, AnalyticsConfiguration{})
+	name := a.dayFileName(day)
+	if err := os.MkdirAll(a.dayDir(day), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		json     string
+		visitors int
+		fails    bool
+	}{
+		{`{}`, 0, false},
+		{`null`, 0, false},
+		{`{"a":[{"page":"/"}],"b":null}`, 2, false},
+		{`{"a":[{"page":"/"}],"b":`, 1, true},
+		{`[]`, 0, true},
+	} {
+		if err := writeFile(name, compress(t, tc.json)); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := a.decodeDayFile(name)
+		if len(entries) != tc.visitors || (err != nil) != tc.fails {
+			t.Errorf("%s: read %d visitors, %v, want %d, failing %v", tc.json, len(entries), err, tc.visitors, tc.fails)
+		}
+	}
+}
+
+// TestAggregationsLimited checks requests needing days that aren't cached
+// are turned away while MaxConcurrentAggregations are running, and cached
+// ones aren't.
+func TestAggregationsLimited(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	a := newTestAnalytics(t, AnalyticsConfiguration{MaxConcurrentAggregations: 1}, WithClock(clock))
+	a.InsertRequest(visit("192.0.2.1:1234", "/"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+	get := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+		rec := httptest.NewRecorder()
+		a.Dashboard(rec, r)
+		return rec
+	}
+
+	a.aggregations <- struct{}{}
+	if rec := get("date=2027-01-15"); rec.Code != http.StatusServiceUnavailable || len(rec.Header().Get("Retry-After")) == 0 {
+		t.Errorf("got %d with Retry-After %q while busy, want 503", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if a.metrics.busy != 1 {
+		t.Errorf("%d requests counted as turned away, want 1", a.metrics.busy)
+	}
+	<-a.aggregations
+
+	if rec := get("date=2027-01-15"); rec.Code != http.StatusOK {
+		t.Fatalf("got %d, want 200", rec.Code)
+	}
+	a.aggregations <- struct{}{}
+	defer func() { <-a.aggregations }()
+	if rec := get("date=2027-01-15"); rec.Code != http.StatusOK {
+		t.Errorf("the cached day got %d while busy, want 200", rec.Code)
+	}
+	// Today is counted as it's recorded and compared with the cached day.
+	if rec := get(""); rec.Code != http.StatusOK {
+		t.Errorf("today got %d while busy, want 200", rec.Code)
+	}
+}
+
+func TestAggregationsUnlimited(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{MaxConcurrentAggregations: -1})
+	if a.aggregations != nil {
+		t.Error("a negative MaxConcurrentAggregations limits aggregations")
+	}
+}
+
+func compress(t *testing.T, s string) []byte {
+	var b bytes.Buffer
+	w := zlib.NewWriter(&b)
+	if _, err := w.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
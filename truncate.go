@@ -0,0 +1,60 @@
+package analytics
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// defaultMaxActionsPerVisitor is how many actions a visitor may record a day
+// unless MaxActionsPerVisitorPerDay says otherwise.
+const defaultMaxActionsPerVisitor = 5000
+
+// droppedFileName is the file listing, per visitor, how many actions of the
+// day were over the cap and not recorded.
+func (a analytics) droppedFileName(td time.Time) string {
+	return a.dayFileName(td) + ".dropped"
+}
+
+func (a analytics) readDropped(td time.Time) map[string]int {
+	dropped := map[string]int{}
+	if a.memoryOnly {
+		return dropped
+	}
+	bs, err := readDayFileBytes(a.droppedFileName(td))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			a.log.Error("reading dropped actions: %v", err)
+		}
+		return dropped
+	}
+	if err := json.Unmarshal(bs, &dropped); err != nil {
+		a.log.Error("reading dropped actions: %v", err)
+	}
+	return dropped
+}
+
+func (a analytics) writeDropped(td time.Time, dropped map[string]int) error {
+	bs, err := json.Marshal(dropped)
+	if err != nil {
+		return err
+	}
+	return writeFile(a.droppedFileName(td), bs)
+}
+
+// loadDropped returns a copy of the dropped counts of the days in memory
+// from memory and any other day's from disk.
+func (a analytics) loadDropped(date time.Time) map[string]int {
+	if !a.isToday(date) && !a.isOpen(a.dayKey(date)) {
+		return a.readDropped(date)
+	}
+	return a.dayDropped(a.dayKey(date))
+}
+
+func copyDropped(dropped map[string]int) map[string]int {
+	c := make(map[string]int, len(dropped))
+	for k, n := range dropped {
+		c[k] = n
+	}
+	return c
+}
@@ -0,0 +1,157 @@
+package analytics
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// runtimeConfig holds the settings UpdateConfig can change. It's never
+// modified once stored, updates store a new one. blacklist is what's matched,
+// derived from the configured userAgents and disableBots and compiled into
+// matcher, ignore from ignorePaths and ignoreRules and spamDomains from
+// spamReferrers. generation counts the updates, it's part of the dashboard's
+// ETags so none outlives the settings it was computed with.
+type runtimeConfig struct {
+	generation   uint64
+	userAgents   []string
+	disableBots  bool
+	blacklist    []string
+	matcher      *uaMatcher
+	redactParams []string
+	maxActions   int
+	ignorePaths  []string
+	ignoreRules  []IgnoreRule
+	ignore       []IgnoreRule
+	// spamReferrers are the configured ReferrerSpamList, dropSpam is
+	// DropReferrerSpam.
+	spamReferrers []string
+	spamDomains   map[string]bool
+	dropSpam      bool
+}
+
+// tuning is the current runtimeConfig, shared by every site. Readers load it
+// once per request so they see either the old or the new settings.
+type tuning struct {
+	mu sync.Mutex
+	v  atomic.Value
+}
+
+func newTuning(config AnalyticsConfiguration) (*tuning, error) {
+	ignore, err := validIgnoreRules(config.IgnorePaths, config.IgnoreRules)
+	if err != nil {
+		return nil, err
+	}
+	t := &tuning{}
+	rc := runtimeConfig{
+		userAgents:    append([]string(nil), config.UserAgentBlackList...),
+		disableBots:   config.DisableBotFiltering,
+		blacklist:     normalizeBlacklist(blacklist(config)),
+		redactParams:  append([]string(nil), config.RedactQueryParams...),
+		maxActions:    maxActions(config.MaxActionsPerVisitorPerDay),
+		ignorePaths:   append([]string(nil), config.IgnorePaths...),
+		ignoreRules:   append([]IgnoreRule(nil), config.IgnoreRules...),
+		ignore:        ignore,
+		spamReferrers: append([]string(nil), config.ReferrerSpamList...),
+		spamDomains:   spamDomains(config.ReferrerSpamList),
+		dropSpam:      config.DropReferrerSpam,
+	}
+	rc.matcher = newUAMatcher(rc.blacklist)
+	t.v.Store(rc)
+	return t, nil
+}
+
+func (t *tuning) load() runtimeConfig {
+	return t.v.Load().(runtimeConfig)
+}
+
+// maxActions applies the default to MaxActionsPerVisitorPerDay.
+func maxActions(n int) int {
+	if n == 0 {
+		return defaultMaxActionsPerVisitor
+	}
+	return n
+}
+
+// ConfigUpdate holds the settings to change with UpdateConfig, nil fields are
+// left as they are. Name, Directory and HashIPSecret can't be changed while
+// running; setting them to anything but their current value is an error.
+type ConfigUpdate struct {
+	UserAgentBlackList         *[]string
+	DisableBotFiltering        *bool
+	RedactQueryParams          *[]string
+	MaxActionsPerVisitorPerDay *int
+	IgnorePaths                *[]string
+	IgnoreRules                *[]IgnoreRule
+	ReferrerSpamList           *[]string
+	DropReferrerSpam           *bool
+
+	Name         *string
+	Directory    *string
+	HashIPSecret *string
+}
+
+// UpdateConfig changes the settings of a running Analyzer for every site.
+// Requests recorded meanwhile use either the old or the new settings, never
+// a mix. Nothing changes if it returns an error.
+func (a analytics) UpdateConfig(u ConfigUpdate) error {
+	if u.Name != nil && *u.Name != a.defaultSite {
+		return fmt.Errorf("Name can't be changed while running")
+	}
+	if u.Directory != nil && *u.Directory != a.Directory {
+		return fmt.Errorf("Directory can't be changed while running")
+	}
+	if u.HashIPSecret != nil && *u.HashIPSecret != a.HashIPSecret {
+		return fmt.Errorf("HashIPSecret can't be changed while running")
+	}
+
+	a.tuning.mu.Lock()
+	defer a.tuning.mu.Unlock()
+	rc := a.tuning.load()
+	if u.UserAgentBlackList != nil {
+		rc.userAgents = append([]string(nil), *u.UserAgentBlackList...)
+	}
+	if u.DisableBotFiltering != nil {
+		rc.disableBots = *u.DisableBotFiltering
+	}
+	rc.blacklist = normalizeBlacklist(blacklist(AnalyticsConfiguration{UserAgentBlackList: rc.userAgents, DisableBotFiltering: rc.disableBots}))
+	rc.matcher = newUAMatcher(rc.blacklist)
+	if u.RedactQueryParams != nil {
+		rc.redactParams = append([]string(nil), *u.RedactQueryParams...)
+	}
+	if u.MaxActionsPerVisitorPerDay != nil {
+		rc.maxActions = maxActions(*u.MaxActionsPerVisitorPerDay)
+	}
+	if u.IgnorePaths != nil {
+		rc.ignorePaths = append([]string(nil), *u.IgnorePaths...)
+	}
+	if u.IgnoreRules != nil {
+		rc.ignoreRules = append([]IgnoreRule(nil), *u.IgnoreRules...)
+	}
+	if u.ReferrerSpamList != nil {
+		rc.spamReferrers = append([]string(nil), *u.ReferrerSpamList...)
+		rc.spamDomains = spamDomains(rc.spamReferrers)
+	}
+	if u.DropReferrerSpam != nil {
+		rc.dropSpam = *u.DropReferrerSpam
+	}
+	ignore, err := validIgnoreRules(rc.ignorePaths, rc.ignoreRules)
+	if err != nil {
+		return err
+	}
+	rc.ignore = ignore
+	rc.generation++
+	a.tuning.v.Store(rc)
+	if u.RedactQueryParams != nil {
+		// Today's counters and every cached aggregate counted query values
+		// with the old redactions.
+		for _, s := range a.sites {
+			s.Mux.Lock()
+			s.resetCounters()
+			s.Mux.Unlock()
+			s.purgeCaches()
+		}
+	}
+	a.log.Info("configuration updated")
+	return nil
+}
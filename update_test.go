@@ -0,0 +1,57 @@
+package analytics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRedactionPurgesCaches checks a past day read before RedactQueryParams
+// changed isn't served from the caches, nor revalidated with its old ETag.
+func TestRedactionPurgesCaches(t *testing.T) {
+	day := time.Date(2027, time.January, 15, 12, 0, 0, 0, time.UTC)
+	clock := &movingClock{t: day}
+	a := newTestAnalytics(t, AnalyticsConfiguration{
+		QueryReports: []QueryReport{{Param: "email"}},
+	}, WithClock(clock))
+	a.InsertRequest(visit("192.0.2.1:1234", "/signup?email=bob@x.com"))
+	if err := a.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	clock.set(day.AddDate(0, 0, 1))
+
+	reports := func() string {
+		t.Helper()
+		dd, err := a.Stats(day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bs, err := json.Marshal(dd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+	etag := func() string {
+		r := httptest.NewRequest(http.MethodGet, "/?date=2027-01-15", nil)
+		rec := httptest.NewRecorder()
+		a.Dashboard(rec, r)
+		return rec.Header().Get("ETag")
+	}
+	if !strings.Contains(reports(), "bob@x.com") {
+		t.Fatal("the query value isn't reported before redacting it")
+	}
+	before := etag()
+	if err := a.UpdateConfig(ConfigUpdate{RedactQueryParams: &[]string{"email"}}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(reports(), "bob@x.com") {
+		t.Error("the redacted value is still reported")
+	}
+	if after := etag(); len(before) == 0 || after == before {
+		t.Errorf("the ETag %q didn't change", before)
+	}
+}
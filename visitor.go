@@ -0,0 +1,162 @@
+package analytics
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// recentVisitors is how many visitors the dashboard's recent visitors table
+// lists.
+const recentVisitors = 20
+
+// VisitorSummary is a row of the recent visitors table. Visitor identifies
+// the visitor, see visitorID.
+type VisitorSummary struct {
+	Visitor  string `json:"visitor"`
+	Date     string `json:"date"`
+	LastSeen string `json:"last_seen"`
+	Actions  int    `json:"actions"`
+	// Dropped is how many more actions weren't recorded, see
+	// MaxActionsPerVisitorPerDay.
+	Dropped int `json:"dropped,omitempty"`
+
+	lastSeen int64
+}
+
+// VisitorData is what the visitor drill-down (?visitor=) renders.
+type VisitorData struct {
+	Visitor string          `json:"visitor"`
+	Date    string          `json:"date"`
+	Dropped int             `json:"dropped,omitempty"`
+	Actions []VisitorAction `json:"actions"`
+	Nonce   string          `json:"-"`
+}
+
+type VisitorAction struct {
+	Time     string `json:"time"`
+	Page     string `json:"page"`
+	Query    string `json:"query"`
+	Referrer string `json:"referrer"`
+	Event    string `json:"event,omitempty"`
+	Target   string `json:"target,omitempty"`
+	TraceID  string `json:"trace_id,omitempty"`
+}
+
+// visitorLabel shortens a visitor key for display, hex encoding keys that
+// aren't printable.
+func visitorLabel(key string) string {
+	if binaryKey(key) {
+		key = hex.EncodeToString([]byte(key))
+	}
+	if len(key) > 16 {
+		key = key[:16] + "…"
+	}
+	return key
+}
+
+// visitorID is how a visitor stored under key is shown and linked to. Keys
+// hashed with HashIPSecret or HashFunc are shown as they are, raw IPs only
+// as a MAC keyed with the session key, so they're never given away. Without
+// SessionKey that key is random, and the drill-down links of unhashed
+// visitors only work until a restart.
+func (a analytics) visitorID(key string) string {
+	if a.hash != nil {
+		return key
+	}
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(key))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// visitorIDs returns a copy of vs identifying its visitors by visitorID.
+func (a analytics) visitorIDs(vs []VisitorSummary) []VisitorSummary {
+	ids := make([]VisitorSummary, len(vs))
+	for i, v := range vs {
+		v.Visitor = a.visitorID(v.Visitor)
+		ids[i] = v
+	}
+	return ids
+}
+
+// findVisitor returns the key of the visitor with id in data.
+func (a analytics) findVisitor(data map[string][]Action, id string) (string, bool) {
+	if a.hash != nil {
+		_, ok := data[id]
+		return id, ok
+	}
+	for key := range data {
+		if hmac.Equal([]byte(a.visitorID(key)), []byte(id)) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// latestVisitors keeps the recentVisitors most recently seen visitors.
+func latestVisitors(vs []VisitorSummary) []VisitorSummary {
+	sort.Slice(vs, func(i, j int) bool {
+		if vs[i].lastSeen != vs[j].lastSeen {
+			return vs[i].lastSeen > vs[j].lastSeen
+		}
+		return vs[i].Visitor < vs[j].Visitor
+	})
+	if len(vs) > recentVisitors {
+		vs = vs[:recentVisitors]
+	}
+	return vs
+}
+
+func summarizeVisitor(visitor string, date time.Time, actions []Action) VisitorSummary {
+	v := VisitorSummary{Visitor: visitor, Date: date.Format(dayLayout), Actions: len(actions)}
+	for _, act := range actions {
+		if act.Timestamp > v.lastSeen {
+			v.lastSeen = act.Timestamp
+		}
+	}
+	if v.lastSeen > 0 {
+		v.LastSeen = time.UnixMilli(v.lastSeen).In(date.Location()).Format("15:04:05")
+	}
+	return v
+}
+
+// visitorDetail renders the actions of one visitor on the selected date, in
+// the order they happened.
+func (a analytics) visitorDetail(w http.ResponseWriter, r *http.Request) {
+	if !a.authorized(w, r) {
+		return
+	}
+	date, _, ok := a.requestRange(w, r)
+	if !ok {
+		return
+	}
+	visitor := r.URL.Query().Get("visitor")
+	data := a.loadDay(date)
+	key, ok := a.findVisitor(data, visitor)
+	if !ok && a.isAnonymized(date) {
+		http.Error(w, "the visitors of "+a.dayKey(date)+" were anonymized, only the day's totals are kept", http.StatusGone)
+		return
+	}
+	if !ok {
+		a.log.Info("unknown visitor %q on %s", visitor, a.dayKey(date))
+		http.NotFound(w, r)
+		return
+	}
+	actions := data[key]
+	actions = append([]Action(nil), actions...)
+	sort.SliceStable(actions, func(i, j int) bool { return actions[i].Timestamp < actions[j].Timestamp })
+
+	vd := VisitorData{Visitor: visitor, Date: a.dayKey(date), Dropped: a.loadDropped(date)[key], Actions: make([]VisitorAction, len(actions))}
+	for i, act := range actions {
+		va := VisitorAction{Page: act.Page, Query: act.Query, Referrer: act.Referrer, Event: act.Event, Target: act.Target, TraceID: act.TraceID}
+		if act.Timestamp > 0 {
+			va.Time = time.UnixMilli(act.Timestamp).In(date.Location()).Format("15:04:05")
+		}
+		vd.Actions[i] = va
+	}
+	vd.Nonce = a.withCSP(w)
+	a.render(w, "visitor", vd)
+}
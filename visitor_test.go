@@ -0,0 +1,70 @@
+package analytics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestVisitorIDsHideIPs(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{SessionKey: "session-key"})
+	a.InsertRequest(visit("192.0.2.1:4000", "/a"))
+	a.InsertRequest(visit("192.0.2.1:4000", "/b"))
+	dd, err := a.Stats(a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dd.Visitors) != 1 {
+		t.Fatalf("got %d recent visitors, want 1", len(dd.Visitors))
+	}
+	id := dd.Visitors[0].Visitor
+	if strings.Contains(id, "192.0.2.1") {
+		t.Fatalf("recent visitor %q shows the IP", id)
+	}
+	if id != a.visitorID("192.0.2.1:4000") {
+		t.Errorf("recent visitor %q isn't identified by visitorID", id)
+	}
+
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?visitor="+url.QueryEscape(id), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("drill-down answered %d", rec.Code)
+	}
+	body := rec.Body.String()
+	if strings.Contains(body, "192.0.2.1") {
+		t.Error("drill-down shows the IP")
+	}
+	if !strings.Contains(body, "/a") || !strings.Contains(body, "/b") {
+		t.Error("drill-down doesn't list the visitor's pages")
+	}
+
+	rec = httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?visitor=192.0.2.1:4000", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("drill-down by IP answered %d, want 404", rec.Code)
+	}
+}
+
+func TestVisitorIDsOfHashedKeys(t *testing.T) {
+	a := newTestAnalytics(t, AnalyticsConfiguration{HashIPSecret: "secret"})
+	key, _, _ := a.insert("192.0.2.1:4000", Action{Page: "/", Timestamp: a.now().UnixMilli()}, 0)
+	dd, err := a.Stats(a.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dd.Visitors) != 1 || dd.Visitors[0].Visitor != key {
+		t.Fatalf("got recent visitors %+v, want the stored key %q", dd.Visitors, key)
+	}
+	rec := httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?visitor="+key, nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("drill-down answered %d", rec.Code)
+	}
+	rec = httptest.NewRecorder()
+	a.Dashboard(rec, httptest.NewRequest(http.MethodGet, "/?visitor=unknown", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown visitor answered %d, want 404", rec.Code)
+	}
+}